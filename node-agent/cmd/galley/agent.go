@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// The k0s manifest deployer applies and reconciles every manifest in this
+// directory on each controller, so the agent survives deletes and upgrades.
+const (
+	galleyAgentManifestDir  = k0sDataDir + "/manifests/galley"
+	galleyAgentManifestFile = galleyAgentManifestDir + "/galley-agent.yaml"
+	galleyAgentImageRepo    = "ghcr.io/galley-run/galley-agent"
+)
+
+//go:embed manifests/galley-agent.yml
+var galleyAgentManifestTemplate string
+
+var (
+	flagAgentEngineId string
+	flagAgentImage    string
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Manage the in-cluster Galley agent",
+}
+
+var agentManifestsCmd = &cobra.Command{
+	Use:   "manifests",
+	Short: "Inspect the Galley agent manifests",
+}
+
+var agentManifestsRenderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Print the Galley agent manifest for this vessel engine",
+	Long: `Renders the Galley agent manifest with the values of this node, exactly as
+'galley controller join' places it in ` + galleyAgentManifestDir + `.
+
+Values default to the Galley config and can be overridden with flags
+(--platform-url sets the platform URL).`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		values := galleyAgentValues{
+			VesselEngineId: flagAgentEngineId,
+			PlatformURL:    getPlatformURL(),
+			Image:          flagAgentImage,
+		}
+		if values.VesselEngineId == "" {
+			config, err := loadConfig()
+			if err != nil {
+				return fmt.Errorf("failed to load Galley config: %w", err)
+			}
+			values.VesselEngineId = config.VesselEngineId
+		}
+		if values.Image == "" {
+			values.Image = galleyAgentImage(Version)
+		}
+
+		manifest, err := renderGalleyAgentManifest(values)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(string(manifest))
+		return nil
+	},
+}
+
+func init() {
+	agentManifestsRenderCmd.Flags().StringVar(&flagAgentEngineId, "engine-id", "", "Vessel engine ID (default: from Galley config)")
+	agentManifestsRenderCmd.Flags().StringVar(&flagAgentImage, "image", "", "Agent image (default: "+galleyAgentImageRepo+":<cli version>)")
+
+	agentManifestsCmd.AddCommand(agentManifestsRenderCmd)
+	agentCmd.AddCommand(agentManifestsCmd)
+}
+
+// galleyAgentValues are the per-engine values of the Galley agent manifest
+type galleyAgentValues struct {
+	VesselEngineId string
+	PlatformURL    string
+	Image          string
+}
+
+// galleyAgentImage returns the agent image matching the CLI version
+func galleyAgentImage(version string) string {
+	tag := version
+	if tag == "" || tag == "dev" {
+		tag = "latest"
+	}
+	return galleyAgentImageRepo + ":" + tag
+}
+
+// renderGalleyAgentManifest renders the embedded Galley agent manifest
+func renderGalleyAgentManifest(values galleyAgentValues) ([]byte, error) {
+	if values.VesselEngineId == "" {
+		return nil, fmt.Errorf("vessel engine ID is required to render the Galley agent manifest")
+	}
+
+	tmpl, err := template.New("galley-agent").Funcs(template.FuncMap{
+		// JSON strings are valid YAML scalars, whatever they contain
+		"quote": func(value string) (string, error) {
+			out, err := json.Marshal(value)
+			return string(out), err
+		},
+	}).Option("missingkey=error").Parse(galleyAgentManifestTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Galley agent manifest: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return nil, fmt.Errorf("failed to render Galley agent manifest: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// installGalleyAgentManifest places the rendered agent manifest in the k0s manifest deployer directory
+func installGalleyAgentManifest(vesselEngineId, platformURL string) error {
+	manifest, err := renderGalleyAgentManifest(galleyAgentValues{
+		VesselEngineId: vesselEngineId,
+		PlatformURL:    platformURL,
+		Image:          galleyAgentImage(Version),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := sysExec.MkdirAll(filepath.Dir(galleyAgentManifestFile), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", galleyAgentManifestDir, err)
+	}
+	// The manifest contains the engine secret, keep it root-only
+	if err := sysExec.WriteFile(galleyAgentManifestFile, manifest, 0600); err != nil {
+		return fmt.Errorf("failed to write Galley agent manifest: %w", err)
+	}
+	logFileWrite(galleyAgentManifestFile, "Installed Galley agent manifest for the k0s manifest deployer")
+
+	fmt.Printf("✓ Galley agent manifest installed in %s\n", galleyAgentManifestDir)
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestGalleyAgentImage(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+	}{
+		{version: "dev", want: galleyAgentImageRepo + ":latest"},
+		{version: "", want: galleyAgentImageRepo + ":latest"},
+		{version: "1.4.2", want: galleyAgentImageRepo + ":1.4.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := galleyAgentImage(tt.version); got != tt.want {
+				t.Errorf("galleyAgentImage(%q) = %s, want %s", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderGalleyAgentManifest(t *testing.T) {
+	manifest, err := renderGalleyAgentManifest(galleyAgentValues{
+		VesselEngineId: "00D8B226-C47C-46F2-981B-A81BE8EE4213",
+		PlatformURL:    "api.galley.run",
+		Image:          galleyAgentImage("1.4.2"),
+	})
+	if err != nil {
+		t.Fatalf("renderGalleyAgentManifest() error = %v", err)
+	}
+
+	for _, want := range []string{
+		`GALLEY_VESSEL_ENGINE_ID: "00D8B226-C47C-46F2-981B-A81BE8EE4213"`,
+		`value: "api.galley.run"`,
+		`image: "` + galleyAgentImageRepo + `:1.4.2"`,
+	} {
+		if !bytes.Contains(manifest, []byte(want)) {
+			t.Errorf("manifest should contain %s", want)
+		}
+	}
+
+	// Every document must be valid YAML with a kind
+	decoder := yaml.NewDecoder(bytes.NewReader(manifest))
+	var kinds []string
+	for {
+		var doc struct {
+			Kind string `yaml:"kind"`
+		}
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatalf("manifest is not valid YAML: %v", err)
+		}
+		kinds = append(kinds, doc.Kind)
+	}
+	if got := strings.Join(kinds, ","); got != "Namespace,ServiceAccount,Secret,Role,RoleBinding,Deployment,DaemonSet" {
+		t.Errorf("manifest kinds = %s", got)
+	}
+
+	if _, err := renderGalleyAgentManifest(galleyAgentValues{}); err == nil {
+		t.Error("renderGalleyAgentManifest() should require a vessel engine ID")
+	}
+}
@@ -1,5 +1,7 @@
 package main
 
+import "time"
+
 type VesselEngineNodeAttributes struct {
 	VesselEngineRegionID string `json:"vesselEngineRegionId"`
 	NodeType             string `json:"nodeType"`
@@ -10,6 +12,9 @@ type VesselEngineNodeAttributes struct {
 	Memory               string `json:"memory"`
 	Storage              string `json:"storage"`
 	Provisioning         bool   `json:"provisioning"`
+	// Labels and taints in Kubernetes notation, taints as key=value:Effect
+	Labels map[string]string `json:"labels,omitempty"`
+	Taints []string          `json:"taints,omitempty"`
 }
 
 type DataResource[T any] struct {
@@ -21,3 +26,35 @@ type DataResource[T any] struct {
 type VesselEngineNodeResponse struct {
 	Data DataResource[VesselEngineNodeAttributes] `json:"data"`
 }
+
+type VesselEngineNodesResponse struct {
+	Data []DataResource[VesselEngineNodeAttributes] `json:"data"`
+}
+
+// VesselEngineRegistryAttributes is a container registry mirror configuration,
+// shared between the nodes of a vessel engine through the platform
+type VesselEngineRegistryAttributes struct {
+	Host       string   `json:"host"`
+	Mirrors    []string `json:"mirrors,omitempty"`
+	CACert     string   `json:"caCert,omitempty"`
+	SkipVerify bool     `json:"skipVerify,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	Password   string   `json:"password,omitempty"`
+	// SealedPassword is the password sealed with the registry key of the cluster,
+	// the only form in which it leaves a node
+	SealedPassword string `json:"sealedPassword,omitempty"`
+}
+
+type VesselEngineRegistriesResponse struct {
+	Data []DataResource[VesselEngineRegistryAttributes] `json:"data"`
+}
+
+// VesselEngineNodeCertificateAttributes is the expiry of a certificate on a node,
+// reported so the platform can alert before the control plane breaks
+type VesselEngineNodeCertificateAttributes struct {
+	Path          string    `json:"path"`
+	Subject       string    `json:"subject"`
+	NotAfter      time.Time `json:"notAfter"`
+	DaysRemaining int       `json:"daysRemaining"`
+	Status        string    `json:"status"`
+}
@@ -0,0 +1,753 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// auditSkip is the status of a control that does not apply to this node, the
+// other statuses are the doctor statuses pass, warn and fail
+const auditSkip = "skip"
+
+const (
+	auditSeverityHigh   = "high"
+	auditSeverityMedium = "medium"
+	auditSeverityLow    = "low"
+)
+
+var (
+	flagAuditOutput string
+	flagAuditRole   string
+)
+
+var nodeAuditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Audit the hardening of this node without changing anything",
+	Long: `Checks the hardening 'galley node prepare' applies, plus additional CIS-style
+controls, without changing anything on this node:
+
+  - SSH: root login, password logins, MaxAuthTries and sshd_config permissions
+  - Accounts: locked root account, password aging and password quality
+  - Files: permissions of /etc/passwd, /etc/shadow, /etc/group and /etc/gshadow
+  - Services: FTP servers, fail2ban, automatic security updates, default target
+  - Network: unexpected listening ports and the host firewall
+  - Kernel: ASLR, core dumps of setuid programs, ICMP redirects, source routing
+    and SYN cookies
+
+Every control passes, warns or fails, and weighs by severity in a score from 0 to
+100. The command exits with an error when a control fails.
+
+Use --output json, junit or sarif to collect the report as evidence or in CI.`,
+	Example: `  galley node audit
+  galley node audit -o sarif > audit.sarif
+  galley node audit -o junit --role worker > audit.xml`,
+	Args: cobra.ExactArgs(0),
+	RunE: runNodeAudit,
+}
+
+func init() {
+	nodeAuditCmd.Flags().StringVarP(&flagAuditOutput, "output", "o", "text", "Output format: text, json, junit or sarif")
+	nodeAuditCmd.Flags().StringVar(&flagAuditRole, "role", "", "Node role for the port checks: controller, worker or controller+worker (default: from Galley config)")
+	nodeCmd.AddCommand(nodeAuditCmd)
+}
+
+// auditControl is a single hardening check of 'galley node audit'
+type auditControl struct {
+	ID       string
+	Category string
+	Title    string
+	Severity string
+	// File is the file the control checks, if any
+	File  string
+	check func(ctx context.Context) doctorResult
+}
+
+// auditResult is the outcome of a control
+type auditResult struct {
+	ID       string `json:"id"`
+	Category string `json:"category"`
+	Title    string `json:"title"`
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Status   string `json:"status"` // pass, warn, fail or skip
+	Message  string `json:"message"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// auditReport is the result of an audit of this node
+type auditReport struct {
+	Host        string        `json:"host"`
+	NodeType    string        `json:"node_type,omitempty"`
+	Version     string        `json:"version"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Score       int           `json:"score"`
+	Passed      int           `json:"passed"`
+	Warnings    int           `json:"warnings"`
+	Failed      int           `json:"failed"`
+	Skipped     int           `json:"skipped"`
+	Results     []auditResult `json:"results"`
+}
+
+func runNodeAudit(cobraCmd *cobra.Command, args []string) error {
+	switch flagAuditOutput {
+	case "text", "json", "junit", "sarif":
+	default:
+		return fmt.Errorf("invalid output format %q, expected text, json, junit or sarif", flagAuditOutput)
+	}
+
+	// The audit only reads, a dry-run executor keeps anything it calls from
+	// changing the system
+	previous := sysExec
+	sysExec = newDryRunExecutor(io.Discard)
+	defer func() { sysExec = previous }()
+
+	nodeType := flagAuditRole
+	switch nodeType {
+	case "":
+		if config, err := loadConfig(); err == nil {
+			nodeType = config.NodeType
+		}
+	case "controller", "worker", "controller+worker":
+	default:
+		return fmt.Errorf("invalid role %q, expected controller, worker or controller+worker", nodeType)
+	}
+
+	report := runAudit(cobraCmd.Context(), auditControls(nodeType))
+	report.Host, _ = os.Hostname()
+	report.NodeType = nodeType
+
+	var err error
+	switch flagAuditOutput {
+	case "json":
+		err = writeAuditJSON(os.Stdout, report)
+	case "junit":
+		err = writeAuditJUnit(os.Stdout, report)
+	case "sarif":
+		err = writeAuditSARIF(os.Stdout, report)
+	default:
+		printAuditReport(report)
+	}
+	if err != nil {
+		return err
+	}
+
+	if report.Failed > 0 {
+		// A failed control is not a usage error
+		cobraCmd.SilenceUsage = true
+		return fmt.Errorf("%d audit controls failed, score %d/100", report.Failed, report.Score)
+	}
+	return nil
+}
+
+// runAudit runs the controls and scores their results
+func runAudit(ctx context.Context, controls []auditControl) auditReport {
+	report := auditReport{Version: Version, GeneratedAt: time.Now().UTC()}
+	for _, control := range controls {
+		checkCtx, cancel := context.WithTimeout(ctx, doctorCheckTimeout)
+		finding := control.check(checkCtx)
+		cancel()
+
+		report.Results = append(report.Results, auditResult{
+			ID:       control.ID,
+			Category: control.Category,
+			Title:    control.Title,
+			Severity: control.Severity,
+			File:     control.File,
+			Status:   finding.Status,
+			Message:  finding.Message,
+			Hint:     finding.Hint,
+		})
+		switch finding.Status {
+		case doctorPass:
+			report.Passed++
+		case doctorWarn:
+			report.Warnings++
+		case doctorFail:
+			report.Failed++
+		default:
+			report.Skipped++
+		}
+	}
+	report.Score = auditScore(report.Results)
+	return report
+}
+
+// auditScore weighs the results by severity: a pass scores in full, a warning
+// half and a failure nothing. Skipped controls don't count.
+func auditScore(results []auditResult) int {
+	weights := map[string]float64{auditSeverityHigh: 3, auditSeverityMedium: 2, auditSeverityLow: 1}
+	var earned, total float64
+	for _, result := range results {
+		weight := weights[result.Severity]
+		switch result.Status {
+		case doctorPass:
+			earned += weight
+		case doctorWarn:
+			earned += weight / 2
+		case doctorFail:
+		default:
+			continue
+		}
+		total += weight
+	}
+	if total == 0 {
+		return 100
+	}
+	return int(math.Round(100 * earned / total))
+}
+
+func auditControls(nodeType string) []auditControl {
+	const sshdConfig = "/etc/ssh/sshd_config"
+
+	return []auditControl{
+		{ID: "ssh-root-login", Category: "ssh", Title: "SSH root login is disabled", Severity: auditSeverityHigh, File: sshdConfig,
+			check: func(ctx context.Context) doctorResult {
+				enabled, err := isSSHRootLoginEnabled()
+				switch {
+				case os.IsNotExist(err):
+					return doctorResult{Status: auditSkip, Message: "no SSH server configuration"}
+				case err != nil:
+					return doctorResult{Status: doctorWarn, Message: "could not read " + sshdConfig + ": " + err.Error()}
+				case enabled:
+					return doctorResult{Status: doctorFail, Message: "root can log in over SSH", Hint: "Set 'PermitRootLogin no' in " + sshdConfig + " and the files it includes from /etc/ssh/sshd_config.d, or run 'galley node prepare --redo ssh-config'"}
+				}
+				return doctorResult{Status: doctorPass, Message: "PermitRootLogin is no"}
+			}},
+		{ID: "ssh-password-auth", Category: "ssh", Title: "SSH password logins are disabled", Severity: auditSeverityHigh, File: sshdConfig,
+			check: func(ctx context.Context) doctorResult {
+				enabled, err := isPasswordAuthenticationEnabled()
+				switch {
+				case os.IsNotExist(err):
+					return doctorResult{Status: auditSkip, Message: "no SSH server configuration"}
+				case err != nil:
+					return doctorResult{Status: doctorWarn, Message: "could not read " + sshdConfig + ": " + err.Error()}
+				case enabled:
+					return doctorResult{Status: doctorFail, Message: "SSH accepts passwords", Hint: "Add an SSH key and set 'PasswordAuthentication no' in " + sshdConfig + " and the files it includes from /etc/ssh/sshd_config.d"}
+				}
+				return doctorResult{Status: doctorPass, Message: "PasswordAuthentication is no"}
+			}},
+		{ID: "ssh-max-auth-tries", Category: "ssh", Title: "SSH limits authentication attempts", Severity: auditSeverityLow, File: sshdConfig,
+			check: func(ctx context.Context) doctorResult {
+				content, err := readEffectiveSSHDConfig()
+				if err != nil {
+					return doctorResult{Status: auditSkip, Message: "no SSH server configuration"}
+				}
+				return evaluateSSHMaxAuthTries(content)
+			}},
+		{ID: "ssh-config-permissions", Category: "ssh", Title: "sshd_config is only writable by root", Severity: auditSeverityMedium, File: sshdConfig,
+			check: func(ctx context.Context) doctorResult {
+				return evaluateFilePermissions(sshdConfig, 0022)
+			}},
+		{ID: "root-locked", Category: "accounts", Title: "The root account is locked", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				locked, err := isRootAccountLocked()
+				switch {
+				case err != nil:
+					return doctorResult{Status: doctorWarn, Message: "could not check the root account: " + err.Error()}
+				case !locked:
+					return doctorResult{Status: doctorFail, Message: "the root account has a usable password", Hint: "Lock it with 'passwd -l root' once a sudo user can log in"}
+				}
+				return doctorResult{Status: doctorPass, Message: "root is locked"}
+			}},
+		{ID: "password-aging", Category: "accounts", Title: "Password aging and length follow the Galley policy", Severity: auditSeverityMedium, File: "/etc/login.defs",
+			check: func(ctx context.Context) doctorResult {
+				content, err := os.ReadFile("/etc/login.defs")
+				if err != nil {
+					return doctorResult{Status: doctorWarn, Message: "could not read /etc/login.defs: " + err.Error()}
+				}
+				if deviations := loginDefsDeviations(string(content)); len(deviations) > 0 {
+					return doctorResult{Status: doctorFail, Message: strings.Join(deviations, ", "), Hint: "Run 'galley node prepare --redo server-hardening' to apply the password policy"}
+				}
+				return doctorResult{Status: doctorPass, Message: "PASS_MAX_DAYS, PASS_MIN_DAYS, PASS_MIN_LEN and PASS_WARN_AGE are set"}
+			}},
+		{ID: "password-quality", Category: "accounts", Title: "PAM requires strong passwords", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				for _, file := range pamPasswordFiles {
+					content, err := os.ReadFile(file)
+					if err != nil {
+						continue
+					}
+					pwquality, _ := os.ReadFile("/etc/security/pwquality.conf")
+					return evaluatePAMPasswordQuality(file, string(content), string(pwquality))
+				}
+				return doctorResult{Status: auditSkip, Message: "no PAM password configuration found"}
+			}},
+		{ID: "account-file-permissions", Category: "accounts", Title: "Account files have safe permissions", Severity: auditSeverityHigh,
+			check: func(ctx context.Context) doctorResult {
+				// Anyone may read the account lists, but not the password hashes
+				for _, file := range []struct {
+					path string
+					deny os.FileMode
+				}{
+					{"/etc/passwd", 0022},
+					{"/etc/group", 0022},
+					{"/etc/shadow", 0027},
+					{"/etc/gshadow", 0027},
+				} {
+					if result := evaluateFilePermissions(file.path, file.deny); result.Status == doctorFail {
+						return result
+					}
+				}
+				return doctorResult{Status: doctorPass, Message: "passwd and group are only writable by root, shadow files are not readable by others"}
+			}},
+		{ID: "ftp-services", Category: "services", Title: "No FTP server is running", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				if active := activeFTPServices(); len(active) > 0 {
+					return doctorResult{Status: doctorFail, Message: "running: " + strings.Join(active, ", "), Hint: "FTP sends passwords in plain text, use SFTP and disable " + strings.Join(active, ", ")}
+				}
+				return doctorResult{Status: doctorPass, Message: "vsftpd, proftpd and pure-ftpd are not running"}
+			}},
+		{ID: "fail2ban", Category: "services", Title: "fail2ban bans brute force attempts", Severity: auditSeverityLow,
+			check: func(ctx context.Context) doctorResult {
+				if exec.CommandContext(ctx, "systemctl", "is-active", "--quiet", "fail2ban").Run() != nil {
+					return doctorResult{Status: doctorWarn, Message: "fail2ban is not running", Hint: "Install and start fail2ban, 'galley node prepare' offers to"}
+				}
+				return doctorResult{Status: doctorPass, Message: "fail2ban is running"}
+			}},
+		{ID: "automatic-updates", Category: "services", Title: "Security updates install automatically", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				if enabled, updater := automaticSecurityUpdatesEnabled(); enabled {
+					return doctorResult{Status: doctorPass, Message: updater + " is enabled"}
+				}
+				return doctorResult{Status: doctorWarn, Message: "no automatic security updates found", Hint: "Enable unattended-upgrades or dnf-automatic, 'galley node prepare' offers to"}
+			}},
+		{ID: "default-target", Category: "services", Title: "The node boots without a desktop", Severity: auditSeverityLow,
+			check: func(ctx context.Context) doctorResult {
+				output, err := exec.CommandContext(ctx, "systemctl", "get-default").Output()
+				if err != nil {
+					return doctorResult{Status: auditSkip, Message: "could not read the default systemd target"}
+				}
+				if target := strings.TrimSpace(string(output)); target != "multi-user.target" {
+					return doctorResult{Status: doctorWarn, Message: "default target is " + target, Hint: "Run 'systemctl set-default multi-user.target'"}
+				}
+				return doctorResult{Status: doctorPass, Message: "default target is multi-user.target"}
+			}},
+		{ID: "unexpected-ports", Category: "network", Title: "Only expected ports are listening", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				sockets, err := socketInventory(nodeType)
+				if err != nil {
+					return doctorResult{Status: doctorWarn, Message: "could not read the listening sockets: " + err.Error()}
+				}
+				return evaluateUnexpectedSockets(unexpectedSockets(sockets))
+			}},
+		{ID: "firewall", Category: "network", Title: "A host firewall is filtering traffic", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				state, err := loadFirewallState()
+				if err != nil {
+					return doctorResult{Status: doctorWarn, Message: "could not read the firewall state: " + err.Error()}
+				}
+				if state == nil {
+					if backend, err := detectFirewallBackend(); err == nil && firewallActive(backend) {
+						return doctorResult{Status: doctorPass, Message: backend + " is active, without Galley rules"}
+					}
+					return doctorResult{Status: doctorWarn, Message: "no host firewall is active", Hint: "Review the ruleset with 'galley node firewall apply --dry-run'"}
+				}
+				if !firewallActive(state.Backend) {
+					return doctorResult{Status: doctorFail, Message: "the Galley rules were applied with " + state.Backend + ", but it is not active", Hint: "Run 'galley node firewall apply' again"}
+				}
+				return doctorResult{Status: doctorPass, Message: fmt.Sprintf("%d Galley rules active with %s", len(state.Rules), state.Backend)}
+			}},
+		{ID: "kernel-hardening", Category: "kernel", Title: "Kernel hardening settings are in effect", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				return evaluateKernelHardening(func(key string) (string, error) {
+					value, err := os.ReadFile("/proc/sys/" + strings.ReplaceAll(key, ".", "/"))
+					return strings.TrimSpace(string(value)), err
+				})
+			}},
+	}
+}
+
+// sshdConfigValue returns the value of the first occurrence of a directive in an
+// sshd_config, sshd uses the first value it reads
+func sshdConfigValue(content, directive string) string {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && strings.EqualFold(fields[0], directive) {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+func evaluateSSHMaxAuthTries(content string) doctorResult {
+	value := sshdConfigValue(content, "MaxAuthTries")
+	if value == "" {
+		return doctorResult{Status: doctorWarn, Message: "MaxAuthTries is not set, sshd allows 6 attempts", Hint: "Set 'MaxAuthTries 4' in /etc/ssh/sshd_config"}
+	}
+	tries, err := strconv.Atoi(value)
+	if err != nil || tries > 4 {
+		return doctorResult{Status: doctorWarn, Message: "MaxAuthTries is " + value, Hint: "Set 'MaxAuthTries 4' in /etc/ssh/sshd_config"}
+	}
+	return doctorResult{Status: doctorPass, Message: "MaxAuthTries is " + value}
+}
+
+// evaluateFilePermissions fails when a file is not owned by root or has any of
+// the deny permission bits
+func evaluateFilePermissions(path string, deny os.FileMode) doctorResult {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return doctorResult{Status: auditSkip, Message: path + " does not exist"}
+	}
+	if err != nil {
+		return doctorResult{Status: doctorWarn, Message: "could not check " + path + ": " + err.Error()}
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 {
+		return doctorResult{Status: doctorFail, Message: fmt.Sprintf("%s is owned by uid %d", path, stat.Uid), Hint: "Run 'chown root " + path + "'"}
+	}
+	return evaluateFileMode(path, info.Mode().Perm(), deny)
+}
+
+func evaluateFileMode(path string, mode, deny os.FileMode) doctorResult {
+	if mode&deny != 0 {
+		return doctorResult{
+			Status:  doctorFail,
+			Message: fmt.Sprintf("%s has mode %04o", path, mode),
+			Hint:    fmt.Sprintf("Run 'chmod %04o %s'", mode&^deny, path),
+		}
+	}
+	return doctorResult{Status: doctorPass, Message: fmt.Sprintf("%s has mode %04o", path, mode)}
+}
+
+// loginDefsDeviations returns the login.defs settings that are weaker than
+// loginDefsPolicy
+func loginDefsDeviations(content string) []string {
+	current := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && !strings.HasPrefix(fields[0], "#") {
+			current[fields[0]] = fields[1]
+		}
+	}
+
+	var deviations []string
+	for _, setting := range []string{"PASS_MAX_DAYS", "PASS_MIN_DAYS", "PASS_MIN_LEN", "PASS_WARN_AGE"} {
+		want, _ := strconv.Atoi(loginDefsPolicy[setting])
+		value, set := current[setting]
+		got, err := strconv.Atoi(value)
+		// A maximum age is a ceiling, the other settings are a minimum
+		weaker := got < want
+		if setting == "PASS_MAX_DAYS" {
+			weaker = got > want
+		}
+		switch {
+		case !set:
+			deviations = append(deviations, setting+" is not set")
+		case err != nil || weaker:
+			deviations = append(deviations, fmt.Sprintf("%s is %s, want %d", setting, value, want))
+		}
+	}
+	return deviations
+}
+
+// evaluatePAMPasswordQuality checks the minimum length pam_pwquality or
+// pam_cracklib enforces, pam_pwquality also reads it from pwquality.conf
+func evaluatePAMPasswordQuality(file, content, pwqualityConf string) doctorResult {
+	want, _ := strconv.Atoi(loginDefsPolicy["PASS_MIN_LEN"])
+	hint := "Run 'galley node prepare --redo server-hardening' to apply the password policy"
+
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || fields[0] != "password" {
+			continue
+		}
+		module := slices.IndexFunc(fields, func(field string) bool {
+			return strings.HasSuffix(field, "pam_pwquality.so") || strings.HasSuffix(field, "pam_cracklib.so")
+		})
+		if module < 0 {
+			continue
+		}
+		name := filepath.Base(fields[module])
+
+		minlen := ""
+		for _, option := range fields[module+1:] {
+			if value, ok := strings.CutPrefix(option, "minlen="); ok {
+				minlen = value
+			}
+		}
+		if minlen == "" && name == "pam_pwquality.so" {
+			for _, line := range strings.Split(pwqualityConf, "\n") {
+				if key, value, ok := strings.Cut(line, "="); ok && strings.TrimSpace(key) == "minlen" {
+					minlen = strings.TrimSpace(value)
+				}
+			}
+		}
+
+		switch length, err := strconv.Atoi(minlen); {
+		case minlen == "":
+			return doctorResult{Status: doctorFail, Message: name + " in " + file + " sets no minimum length", Hint: hint}
+		case err != nil || length < want:
+			return doctorResult{Status: doctorFail, Message: fmt.Sprintf("%s requires %s characters, want %d", name, minlen, want), Hint: hint}
+		}
+		return doctorResult{Status: doctorPass, Message: fmt.Sprintf("%s requires %s characters", name, minlen)}
+	}
+	return doctorResult{Status: doctorFail, Message: file + " does not check password quality", Hint: hint}
+}
+
+func evaluateUnexpectedSockets(unexpected []listeningSocket) doctorResult {
+	if len(unexpected) == 0 {
+		return doctorResult{Status: doctorPass, Message: "no unexpected listening ports"}
+	}
+
+	status := doctorWarn
+	var ports []string
+	for _, socket := range unexpected {
+		if socket.Scope == socketScopePublic {
+			status = doctorFail
+		}
+		ports = append(ports, fmt.Sprintf("%s/%d (%s, %s)", socket.Protocol, socket.Port, socket.Scope, socketProcess(socket)))
+	}
+	return doctorResult{Status: status, Message: "unexpected: " + strings.Join(ports, ", "), Hint: "Review them with 'galley node ports' and close or firewall them"}
+}
+
+// kernelHardeningSysctls are the hardening sysctls and their wanted values. IP
+// forwarding and sending redirects are left out, Kubernetes nodes route traffic.
+var kernelHardeningSysctls = []struct {
+	key, want string
+}{
+	{"kernel.randomize_va_space", "2"},
+	{"fs.suid_dumpable", "0"},
+	{"net.ipv4.conf.all.accept_redirects", "0"},
+	{"net.ipv4.conf.all.accept_source_route", "0"},
+	{"net.ipv4.tcp_syncookies", "1"},
+}
+
+func evaluateKernelHardening(read func(key string) (string, error)) doctorResult {
+	var deviations []string
+	for _, sysctl := range kernelHardeningSysctls {
+		value, err := read(sysctl.key)
+		if err != nil {
+			continue
+		}
+		if value != sysctl.want {
+			deviations = append(deviations, fmt.Sprintf("%s = %s, want %s", sysctl.key, value, sysctl.want))
+		}
+	}
+	if len(deviations) > 0 {
+		return doctorResult{Status: doctorFail, Message: strings.Join(deviations, ", "), Hint: "Set them in a file in /etc/sysctl.d and run 'sysctl --system'"}
+	}
+	return doctorResult{Status: doctorPass, Message: "ASLR, setuid core dumps, redirects, source routing and SYN cookies are hardened"}
+}
+
+func printAuditReport(report auditReport) {
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	if report.NodeType != "" {
+		fmt.Printf("Galley Node Audit (%s, %s)\n", report.Host, report.NodeType)
+	} else {
+		fmt.Printf("Galley Node Audit (%s)\n", report.Host)
+	}
+	fmt.Println(strings.Repeat("=", 70))
+
+	category := ""
+	for _, result := range report.Results {
+		if result.Category != category {
+			category = result.Category
+			fmt.Printf("\n%s\n", strings.ToUpper(category))
+		}
+		icon := "✓"
+		switch result.Status {
+		case doctorWarn:
+			icon = "⚠️ "
+		case doctorFail:
+			icon = "✗"
+		case auditSkip:
+			icon = "-"
+		}
+		fmt.Printf("%s [%s] %s: %s\n", icon, result.Severity, result.ID, result.Message)
+		if result.Hint != "" && (result.Status == doctorWarn || result.Status == doctorFail) {
+			fmt.Printf("   💡 %s\n", result.Hint)
+		}
+	}
+
+	fmt.Println("\n" + strings.Repeat("-", 70))
+	fmt.Printf("%d passed, %d warnings, %d failed, %d skipped\n", report.Passed, report.Warnings, report.Failed, report.Skipped)
+	fmt.Printf("Score: %d/100\n", report.Score)
+	fmt.Println(strings.Repeat("=", 70))
+}
+
+func writeAuditJSON(w io.Writer, report auditReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Hostname   string          `xml:"hostname,attr,omitempty"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// writeAuditJUnit writes the report as a JUnit test suite with a test case per
+// control. Warnings pass, with the warning in the output of the test case.
+func writeAuditJUnit(w io.Writer, report auditReport) error {
+	suite := junitTestSuite{
+		Name:      "galley node audit",
+		Hostname:  report.Host,
+		Timestamp: report.GeneratedAt.Format(time.RFC3339),
+		Tests:     len(report.Results),
+		Failures:  report.Failed,
+		Skipped:   report.Skipped,
+		Properties: []junitProperty{
+			{"score", strconv.Itoa(report.Score)},
+			{"node_type", report.NodeType},
+			{"galley_version", report.Version},
+		},
+	}
+	for _, result := range report.Results {
+		testCase := junitTestCase{ClassName: "galley.audit." + result.Category, Name: result.ID + ": " + result.Title}
+		switch result.Status {
+		case doctorFail:
+			testCase.Failure = &junitMessage{Message: result.Message, Type: result.Severity, Text: result.Hint}
+		case auditSkip:
+			testCase.Skipped = &junitMessage{Message: result.Message}
+		default:
+			testCase.SystemOut = result.Status + ": " + result.Message
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{
+		Name:     "galley",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Suites:   []junitTestSuite{suite},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s%s\n", xml.Header, data)
+	return err
+}
+
+// writeAuditSARIF writes the report as a SARIF 2.1.0 log with a rule per control
+// and a result for every control, passed controls included
+func writeAuditSARIF(w io.Writer, report auditReport) error {
+	// GitHub code scanning ranks results by security-severity
+	securitySeverity := map[string]string{auditSeverityHigh: "8.0", auditSeverityMedium: "5.0", auditSeverityLow: "3.0"}
+
+	rules := []map[string]interface{}{}
+	results := []map[string]interface{}{}
+	for i, result := range report.Results {
+		level := "warning"
+		if result.Severity == auditSeverityHigh {
+			level = "error"
+		}
+		rules = append(rules, map[string]interface{}{
+			"id":                   result.ID,
+			"name":                 result.ID,
+			"shortDescription":     map[string]string{"text": result.Title},
+			"defaultConfiguration": map[string]string{"level": level},
+			"properties": map[string]interface{}{
+				"security-severity": securitySeverity[result.Severity],
+				"tags":              []string{"security", result.Category},
+			},
+		})
+
+		sarifResult := map[string]interface{}{
+			"ruleId":    result.ID,
+			"ruleIndex": i,
+			"message":   map[string]string{"text": result.Message},
+		}
+		switch result.Status {
+		case doctorFail:
+			sarifResult["kind"], sarifResult["level"] = "fail", "error"
+		case doctorWarn:
+			sarifResult["kind"], sarifResult["level"] = "fail", "warning"
+		case auditSkip:
+			sarifResult["kind"], sarifResult["level"] = "notApplicable", "none"
+		default:
+			sarifResult["kind"], sarifResult["level"] = "pass", "none"
+		}
+		if result.Hint != "" && result.Status != doctorPass {
+			sarifResult["message"] = map[string]string{"text": result.Message + ". " + result.Hint}
+		}
+		if result.File != "" {
+			sarifResult["locations"] = []map[string]interface{}{{
+				"physicalLocation": map[string]interface{}{
+					"artifactLocation": map[string]string{"uri": "file://" + result.File},
+				},
+			}}
+		}
+		results = append(results, sarifResult)
+	}
+
+	log := map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]interface{}{{
+			"tool": map[string]interface{}{
+				"driver": map[string]interface{}{
+					"name":    "galley node audit",
+					"version": report.Version,
+					"rules":   rules,
+				},
+			},
+			"invocations": []map[string]interface{}{{
+				"executionSuccessful": true,
+				"endTimeUtc":          report.GeneratedAt.Format(time.RFC3339),
+				"machine":             report.Host,
+			}},
+			"properties": map[string]interface{}{
+				"score":     report.Score,
+				"node_type": report.NodeType,
+			},
+			"results": results,
+		}},
+	}
+	data, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
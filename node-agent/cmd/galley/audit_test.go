@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []auditResult
+		want    int
+	}{
+		{name: "nothing checked", want: 100},
+		{name: "all pass", results: []auditResult{{Severity: auditSeverityHigh, Status: doctorPass}, {Severity: auditSeverityLow, Status: doctorPass}}, want: 100},
+		{name: "high failure", results: []auditResult{{Severity: auditSeverityHigh, Status: doctorFail}, {Severity: auditSeverityLow, Status: doctorPass}}, want: 25},
+		{name: "warning and skip", results: []auditResult{{Severity: auditSeverityMedium, Status: doctorWarn}, {Severity: auditSeverityHigh, Status: auditSkip}}, want: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auditScore(tt.results); got != tt.want {
+				t.Errorf("auditScore() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSHConfigChecks(t *testing.T) {
+	tests := []struct {
+		name         string
+		content      string
+		rootLogin    bool
+		passwordAuth bool
+		maxAuthTries string
+	}{
+		{name: "defaults", content: "# PermitRootLogin no\n", rootLogin: true, passwordAuth: true, maxAuthTries: doctorWarn},
+		{name: "hardened", content: "PermitRootLogin no\nPasswordAuthentication no\nMaxAuthTries 3\n", maxAuthTries: doctorPass},
+		{name: "keys only root", content: "PermitRootLogin prohibit-password\nmaxauthtries 10\n", rootLogin: true, passwordAuth: true, maxAuthTries: doctorWarn},
+		{name: "sshd -T output", content: "permitrootlogin no\npasswordauthentication no\nmaxauthtries 4\n", maxAuthTries: doctorPass},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sshRootLoginEnabled(tt.content); got != tt.rootLogin {
+				t.Errorf("sshRootLoginEnabled() = %v, want %v", got, tt.rootLogin)
+			}
+			if got := sshPasswordAuthenticationEnabled(tt.content); got != tt.passwordAuth {
+				t.Errorf("sshPasswordAuthenticationEnabled() = %v, want %v", got, tt.passwordAuth)
+			}
+			if got := evaluateSSHMaxAuthTries(tt.content); got.Status != tt.maxAuthTries {
+				t.Errorf("evaluateSSHMaxAuthTries() = %+v, want %s", got, tt.maxAuthTries)
+			}
+		})
+	}
+}
+
+func TestExpandSSHDConfig(t *testing.T) {
+	files := map[string]string{
+		"/etc/ssh/sshd_config.d/50-cloud-init.conf": "PasswordAuthentication yes\n",
+		"/etc/ssh/sshd_config.d/60-galley.conf":     "PasswordAuthentication no\nPermitRootLogin no\n",
+		"/etc/ssh/extra.conf":                       "MaxAuthTries 3\n",
+	}
+	read := func(path string) ([]byte, error) {
+		content, ok := files[path]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(content), nil
+	}
+	glob := func(pattern string) ([]string, error) {
+		var matches []string
+		for path := range files {
+			if ok, _ := filepath.Match(pattern, path); ok {
+				matches = append(matches, path)
+			}
+		}
+		return matches, nil
+	}
+
+	content := expandSSHDConfig("Include /etc/ssh/sshd_config.d/*.conf\nInclude extra.conf\nPasswordAuthentication no\nMatch User backup\n  MaxAuthTries 10\n", "/etc/ssh", read, glob, 0)
+	if !sshPasswordAuthenticationEnabled(content) {
+		t.Errorf("PasswordAuthentication from 50-cloud-init.conf was not applied:\n%s", content)
+	}
+	if sshRootLoginEnabled(content) {
+		t.Errorf("PermitRootLogin from 60-galley.conf was not applied:\n%s", content)
+	}
+	if got := sshdConfigValue(content, "MaxAuthTries"); got != "3" {
+		t.Errorf("MaxAuthTries = %q, want the value from extra.conf and not the Match block", got)
+	}
+}
+
+func TestPasswdStatusLocked(t *testing.T) {
+	tests := map[string]bool{
+		"root L 2024-01-15 0 99999 7 -1\n": true,
+		"root LK 2024-01-15 0 99999 7 -1":  true,
+		"root P 2024-01-15 0 99999 7 -1":   false,
+		"root NP 2024-01-15 0 99999 7 -1":  false,
+		"":                                 false,
+	}
+	for status, want := range tests {
+		if got := passwdStatusLocked(status); got != want {
+			t.Errorf("passwdStatusLocked(%q) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestLoginDefsDeviations(t *testing.T) {
+	hardened := "PASS_MAX_DAYS\t90\nPASS_MIN_DAYS\t1\nPASS_MIN_LEN\t14\nPASS_WARN_AGE\t7\n"
+	if deviations := loginDefsDeviations(hardened); len(deviations) != 0 {
+		t.Errorf("loginDefsDeviations() of the Galley policy = %v", deviations)
+	}
+
+	stricter := "PASS_MAX_DAYS 30\nPASS_MIN_DAYS 2\nPASS_MIN_LEN 16\nPASS_WARN_AGE 14\n"
+	if deviations := loginDefsDeviations(stricter); len(deviations) != 0 {
+		t.Errorf("loginDefsDeviations() of a stricter policy = %v", deviations)
+	}
+
+	deviations := loginDefsDeviations("# PASS_MIN_LEN 14\nPASS_MAX_DAYS 99999\nPASS_MIN_DAYS 0\nPASS_WARN_AGE 7\n")
+	want := []string{"PASS_MAX_DAYS is 99999, want 90", "PASS_MIN_DAYS is 0, want 1", "PASS_MIN_LEN is not set"}
+	if strings.Join(deviations, "|") != strings.Join(want, "|") {
+		t.Errorf("loginDefsDeviations() = %v, want %v", deviations, want)
+	}
+}
+
+func TestEvaluatePAMPasswordQuality(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		pwquality string
+		want      string
+	}{
+		{name: "galley policy", content: "password    requisite     pam_pwquality.so retry=3 minlen=14 dcredit=-1\n", want: doctorPass},
+		{name: "short", content: "password requisite pam_cracklib.so retry=3 minlen=8\n", want: doctorFail},
+		{name: "pwquality.conf", content: "password [success=1 default=ignore] pam_pwquality.so retry=3\n", pwquality: "# minlen = 8\nminlen = 15\n", want: doctorPass},
+		{name: "no minlen", content: "password requisite pam_pwquality.so retry=3\n", want: doctorFail},
+		{name: "no quality module", content: "password [success=1 default=ignore] pam_unix.so obscure yescrypt\n", want: doctorFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evaluatePAMPasswordQuality("/etc/pam.d/common-password", tt.content, tt.pwquality); got.Status != tt.want {
+				t.Errorf("evaluatePAMPasswordQuality() = %+v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateFileMode(t *testing.T) {
+	if got := evaluateFileMode("/etc/shadow", 0640, 0027); got.Status != doctorPass {
+		t.Errorf("evaluateFileMode(0640) = %+v, want pass", got)
+	}
+	got := evaluateFileMode("/etc/shadow", 0644, 0027)
+	if got.Status != doctorFail || got.Hint != "Run 'chmod 0640 /etc/shadow'" {
+		t.Errorf("evaluateFileMode(0644) = %+v, want a failure with a chmod hint", got)
+	}
+}
+
+func TestEvaluateKernelHardening(t *testing.T) {
+	values := map[string]string{
+		"kernel.randomize_va_space":          "2",
+		"fs.suid_dumpable":                   "2",
+		"net.ipv4.conf.all.accept_redirects": "0",
+		"net.ipv4.tcp_syncookies":            "1",
+	}
+	got := evaluateKernelHardening(func(key string) (string, error) {
+		value, ok := values[key]
+		if !ok {
+			return "", errors.New("not found")
+		}
+		return value, nil
+	})
+	if got.Status != doctorFail || got.Message != "fs.suid_dumpable = 2, want 0" {
+		t.Errorf("evaluateKernelHardening() = %+v", got)
+	}
+}
+
+func TestEvaluateUnexpectedSockets(t *testing.T) {
+	private := listeningSocket{Protocol: "tcp", Port: 8080, Scope: socketScopePrivate, Process: "python3", PID: 42}
+	public := listeningSocket{Protocol: "tcp", Port: 21, Scope: socketScopePublic}
+
+	if got := evaluateUnexpectedSockets(nil); got.Status != doctorPass {
+		t.Errorf("no unexpected sockets = %+v, want pass", got)
+	}
+	if got := evaluateUnexpectedSockets([]listeningSocket{private}); got.Status != doctorWarn {
+		t.Errorf("private socket = %+v, want warn", got)
+	}
+	if got := evaluateUnexpectedSockets([]listeningSocket{private, public}); got.Status != doctorFail {
+		t.Errorf("public socket = %+v, want fail", got)
+	}
+}
+
+func testAuditReport() auditReport {
+	controls := []auditControl{
+		{ID: "ssh-root-login", Category: "ssh", Title: "SSH root login is disabled", Severity: auditSeverityHigh, File: "/etc/ssh/sshd_config",
+			check: func(ctx context.Context) doctorResult {
+				return doctorResult{Status: doctorFail, Message: "root can log in over SSH", Hint: "Set 'PermitRootLogin no'"}
+			}},
+		{ID: "fail2ban", Category: "services", Title: "fail2ban bans brute force attempts", Severity: auditSeverityLow,
+			check: func(ctx context.Context) doctorResult {
+				return doctorResult{Status: doctorWarn, Message: "fail2ban is not running"}
+			}},
+		{ID: "password-quality", Category: "accounts", Title: "PAM requires strong passwords", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				return doctorResult{Status: auditSkip, Message: "no PAM password configuration found"}
+			}},
+		{ID: "ftp-services", Category: "services", Title: "No FTP server is running", Severity: auditSeverityMedium,
+			check: func(ctx context.Context) doctorResult {
+				return doctorResult{Status: doctorPass, Message: "no FTP"}
+			}},
+	}
+	report := runAudit(context.Background(), controls)
+	report.Host = "node-1"
+	report.GeneratedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return report
+}
+
+func TestRunAudit(t *testing.T) {
+	report := testAuditReport()
+	if report.Passed != 1 || report.Warnings != 1 || report.Failed != 1 || report.Skipped != 1 {
+		t.Errorf("counts = %d passed, %d warnings, %d failed, %d skipped", report.Passed, report.Warnings, report.Failed, report.Skipped)
+	}
+	// Half of the low warning and the medium pass out of 3 + 1 + 2
+	if report.Score != 42 {
+		t.Errorf("score = %d, want 42", report.Score)
+	}
+}
+
+func TestWriteAuditJUnit(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeAuditJUnit(&out, testAuditReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(out.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, out.String())
+	}
+	if suites.Tests != 4 || suites.Failures != 1 || suites.Skipped != 1 || len(suites.Suites) != 1 {
+		t.Fatalf("testsuites = %+v", suites)
+	}
+	cases := suites.Suites[0].Cases
+	if cases[0].Failure == nil || cases[0].Failure.Message != "root can log in over SSH" || cases[0].ClassName != "galley.audit.ssh" {
+		t.Errorf("failed control = %+v", cases[0])
+	}
+	if cases[1].Failure != nil || cases[1].SystemOut != "warn: fail2ban is not running" {
+		t.Errorf("warning control = %+v", cases[1])
+	}
+	if cases[2].Skipped == nil {
+		t.Errorf("skipped control = %+v", cases[2])
+	}
+}
+
+func TestWriteAuditSARIF(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeAuditSARIF(&out, testAuditReport()); err != nil {
+		t.Fatal(err)
+	}
+
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				RuleIndex int    `json:"ruleIndex"`
+				Kind      string `json:"kind"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("invalid SARIF: %v", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("SARIF log = %+v", log)
+	}
+
+	run := log.Runs[0]
+	want := []struct{ kind, level string }{{"fail", "error"}, {"fail", "warning"}, {"notApplicable", "none"}, {"pass", "none"}}
+	if len(run.Results) != len(want) {
+		t.Fatalf("results = %+v", run.Results)
+	}
+	for i, result := range run.Results {
+		if result.Kind != want[i].kind || result.Level != want[i].level {
+			t.Errorf("result %s = %s/%s, want %s/%s", result.RuleID, result.Kind, result.Level, want[i].kind, want[i].level)
+		}
+		if run.Tool.Driver.Rules[result.RuleIndex].ID != result.RuleID {
+			t.Errorf("result %s points to rule %d", result.RuleID, result.RuleIndex)
+		}
+	}
+	if len(run.Results[0].Locations) != 1 || run.Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI != "file:///etc/ssh/sshd_config" {
+		t.Errorf("locations of %s = %+v", run.Results[0].RuleID, run.Results[0].Locations)
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	galleyK0sBackupDir    = "/var/lib/galley/k0s-backups"
+	galleyBackupKeyFile   = "/var/lib/galley/backup.key"
+	galleyBackupUnit      = "galley-k0s-backup.service"
+	galleyBackupTimer     = "galley-k0s-backup.timer"
+	k0sBackupFilePrefix   = "galley-k0s-backup-"
+	k0sBackupTimeLayout   = "20060102T150405Z"
+	k0sBackupEncExtension = ".enc"
+)
+
+// backupEncryptionMagic marks archives encrypted by Galley (AES-256-GCM)
+var backupEncryptionMagic = []byte("GALLEYENC1")
+
+var (
+	flagBackupDir           string
+	flagBackupKeep          int
+	flagBackupMaxAge        time.Duration
+	flagBackupEncrypt       bool
+	flagBackupKeyFile       string
+	flagBackupSchedule      string
+	flagBackupS3Endpoint    string
+	flagBackupS3Bucket      string
+	flagBackupS3Region      string
+	flagBackupS3Prefix      string
+	flagBackupS3AccessKey   string
+	flagBackupS3SecretFile  string
+	flagRestoreNodeType     string
+	flagRestoreForce        bool
+	flagBackupScheduleClear bool
+)
+
+var controllerBackupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Back up the k0s control plane",
+	Long: `Creates a timestamped backup of the k0s control plane with 'k0s backup'.
+
+This command will:
+  - Run 'k0s backup' and store the archive in the backup directory
+  - Optionally encrypt the archive with a local key (--encrypt), which has to be
+    copied off the node when it is created
+  - Optionally upload the archive to an S3-compatible endpoint (--s3-endpoint)
+  - Remove old backups according to --keep and --max-age, locally and in S3
+
+Use --schedule to install a systemd timer that runs this backup with the same flags.`,
+	Args: cobra.ExactArgs(0),
+	RunE: runControllerBackup,
+}
+
+var controllerRestoreCmd = &cobra.Command{
+	Use:   "restore <archive>",
+	Short: "Restore the k0s control plane from a backup",
+	Long: `Restores a k0s control plane from a backup created with 'galley controller backup'.
+
+The archive can be a local file or, when S3 flags are given, an object key in the bucket.
+Encrypted archives are decrypted with the backup key, on a new node pass the copy
+made when the key was created with --key-file.
+
+Run this on a prepared node where k0s is installed but not running. The restored
+k0s configuration is written to /etc/k0s/k0s.yaml, after which the controller is
+installed and started.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runControllerRestore,
+}
+
+func init() {
+	for _, c := range []*cobra.Command{controllerBackupCmd, controllerRestoreCmd} {
+		c.Flags().StringVar(&flagBackupKeyFile, "key-file", galleyBackupKeyFile, "Local encryption key file")
+		c.Flags().StringVar(&flagBackupS3Endpoint, "s3-endpoint", "", "S3-compatible endpoint, e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000")
+		c.Flags().StringVar(&flagBackupS3Bucket, "s3-bucket", "", "S3 bucket name")
+		c.Flags().StringVar(&flagBackupS3Region, "s3-region", "us-east-1", "S3 region")
+		c.Flags().StringVar(&flagBackupS3Prefix, "s3-prefix", "k0s/", "Object key prefix for backups")
+		c.Flags().StringVar(&flagBackupS3AccessKey, "s3-access-key", "", "S3 access key ID")
+		c.Flags().StringVar(&flagBackupS3SecretFile, "s3-secret-key-file", "", "File containing the S3 secret access key")
+	}
+
+	controllerBackupCmd.Flags().StringVar(&flagBackupDir, "dir", galleyK0sBackupDir, "Directory to store backups in")
+	controllerBackupCmd.Flags().IntVar(&flagBackupKeep, "keep", 7, "Number of backups to keep, locally and in S3 (0 keeps all)")
+	controllerBackupCmd.Flags().DurationVar(&flagBackupMaxAge, "max-age", 0, "Remove backups older than this, locally and in S3, e.g. 720h (0 disables)")
+	controllerBackupCmd.Flags().BoolVar(&flagBackupEncrypt, "encrypt", false, "Encrypt the backup with the local key (created if missing)")
+	controllerBackupCmd.Flags().StringVar(&flagBackupSchedule, "schedule", "", "Install a systemd timer instead of backing up now, e.g. daily or '*-*-* 02:00:00'")
+	controllerBackupCmd.Flags().BoolVar(&flagBackupScheduleClear, "unschedule", false, "Remove the scheduled backup timer")
+
+	controllerRestoreCmd.Flags().StringVar(&flagRestoreNodeType, "node-type", "controller", "Node type to install after restoring (controller or controller+worker)")
+	controllerRestoreCmd.Flags().BoolVar(&flagRestoreForce, "force", false, "Restore even when k0s appears to be running")
+	controllerRestoreCmd.Flags().DurationVar(&flagReadyTimeout, "ready-timeout", defaultK0sReadyTimeout, "How long to wait for k0s to become ready")
+
+	controllerCmd.AddCommand(controllerBackupCmd)
+	controllerCmd.AddCommand(controllerRestoreCmd)
+}
+
+func runControllerBackup(cmd *cobra.Command, args []string) error {
+	if flagBackupScheduleClear {
+		return removeBackupSchedule()
+	}
+	if flagBackupSchedule != "" {
+		return installBackupSchedule(flagBackupSchedule)
+	}
+
+	// The backup only exists once k0s made it, so a dry run plans the whole backup
+	if dryRunPlan("Back up k0s to %s (encrypt=%t, keep=%d, max-age=%s)", flagBackupDir, flagBackupEncrypt, flagBackupKeep, flagBackupMaxAge) {
+		if flagBackupS3Endpoint != "" {
+			dryRunPlan("Upload the backup to %s/%s/%s", flagBackupS3Endpoint, flagBackupS3Bucket, flagBackupS3Prefix)
+		}
+		return nil
+	}
+
+	if _, err := exec.LookPath("k0s"); err != nil {
+		return fmt.Errorf("k0s is not installed on this node")
+	}
+
+	logAction("Starting k0s backup", map[string]string{
+		"dir":     flagBackupDir,
+		"encrypt": fmt.Sprintf("%t", flagBackupEncrypt),
+	})
+
+	tmpDir, err := os.MkdirTemp("", "galley-backup-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ctx := context.Background()
+	if err := runCommandWithContext(ctx, "k0s", "backup", "--save-path", tmpDir); err != nil {
+		return fmt.Errorf("k0s backup failed: %w", err)
+	}
+
+	archives, err := filepath.Glob(filepath.Join(tmpDir, "*.tar.gz"))
+	if err != nil || len(archives) == 0 {
+		return fmt.Errorf("k0s backup did not produce an archive in %s", tmpDir)
+	}
+
+	data, err := os.ReadFile(archives[0])
+	if err != nil {
+		return fmt.Errorf("failed to read backup archive: %w", err)
+	}
+
+	name := backupFileName(time.Now())
+	if flagBackupEncrypt {
+		key, err := loadOrCreateBackupKey(flagBackupKeyFile)
+		if err != nil {
+			return err
+		}
+		data, err = encryptBackup(key, data)
+		if err != nil {
+			return err
+		}
+		name += k0sBackupEncExtension
+	}
+
+	if err := sysExec.MkdirAll(flagBackupDir, 0700); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	backupPath := filepath.Join(flagBackupDir, name)
+	// Backups hold the cluster CA and etcd keys
+	addSecretFile(backupPath)
+	if err := sysExec.WriteFile(backupPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	logFileWrite(backupPath, "k0s control plane backup")
+	fmt.Printf("✓ Backup written to %s (%d bytes)\n", backupPath, len(data))
+
+	if flagBackupS3Endpoint != "" {
+		client, err := backupS3Client()
+		if err != nil {
+			return err
+		}
+		objectKey := flagBackupS3Prefix + name
+		if err := client.putObject(ctx, objectKey, data); err != nil {
+			return err
+		}
+		logAction("Uploaded k0s backup", map[string]string{
+			"endpoint": flagBackupS3Endpoint,
+			"bucket":   flagBackupS3Bucket,
+			"key":      objectKey,
+		})
+		fmt.Printf("✓ Backup uploaded to %s/%s\n", flagBackupS3Bucket, objectKey)
+
+		if err := pruneS3Backups(ctx, client, flagBackupS3Prefix, flagBackupKeep, flagBackupMaxAge, time.Now()); err != nil {
+			fmt.Printf("⚠️  Warning: failed to remove old backups from S3: %v\n", err)
+		}
+	}
+
+	return pruneBackups(flagBackupDir, flagBackupKeep, flagBackupMaxAge, time.Now())
+}
+
+func runControllerRestore(cmd *cobra.Command, args []string) error {
+	source := args[0]
+
+	if _, err := exec.LookPath("k0s"); err != nil {
+		return fmt.Errorf("k0s is not installed. Please run 'galley node prepare' first")
+	}
+
+	if err := exec.Command("k0s", "status").Run(); err == nil && !flagRestoreForce {
+		return fmt.Errorf("k0s is running on this node, restore on a fresh node or run 'galley node leave' first (use --force to restore anyway)")
+	}
+
+	logAction("Starting k0s restore", map[string]string{
+		"source":    source,
+		"node_type": flagRestoreNodeType,
+	})
+
+	data, err := readBackupSource(source)
+	if err != nil {
+		return err
+	}
+
+	if isEncryptedBackup(data) {
+		key, err := readBackupKey(flagBackupKeyFile)
+		if err != nil {
+			return err
+		}
+		data, err = decryptBackup(key, data)
+		if err != nil {
+			return err
+		}
+		fmt.Println("✓ Backup decrypted")
+	}
+
+	tmpDir, err := os.MkdirTemp("", "galley-restore-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := filepath.Join(tmpDir, "k0s_backup.tar.gz")
+	addSecretFile(archivePath)
+	if err := sysExec.WriteFile(archivePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write backup archive: %w", err)
+	}
+
+	if err := sysExec.MkdirAll(k0sConfigDir, 0755); err != nil {
+		return fmt.Errorf("failed to create k0s config directory: %w", err)
+	}
+
+	ctx := context.Background()
+	if err := runCommandWithContext(ctx, "k0s", "restore", archivePath, "--config-out", k0sConfigFile); err != nil {
+		return fmt.Errorf("k0s restore failed: %w", err)
+	}
+	logFileWrite(k0sConfigFile, "k0s configuration restored from backup")
+	fmt.Println("✓ k0s control plane restored")
+
+	if err := installK0sController(flagRestoreNodeType, "", nil); err != nil {
+		return fmt.Errorf("failed to install k0s controller: %w", err)
+	}
+
+	if err := startK0sService(flagRestoreNodeType, flagReadyTimeout); err != nil {
+		return fmt.Errorf("failed to start k0s service: %w", err)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load Galley config: %w", err)
+	}
+	if err := setConfigValue(config, "node_type", flagRestoreNodeType); err != nil {
+		return err
+	}
+	if err := saveConfig(config); err != nil {
+		return fmt.Errorf("failed to save Galley config: %w", err)
+	}
+
+	logAction("k0s restore completed", map[string]string{
+		"source": source,
+		"status": "success",
+	})
+
+	fmt.Println("\n" + strings.Repeat("=", 60))
+	fmt.Println("✓ Control plane restored successfully!")
+	fmt.Println(strings.Repeat("=", 60))
+	return nil
+}
+
+// readBackupSource reads a backup from a local file or, when S3 is configured, from the bucket
+func readBackupSource(source string) ([]byte, error) {
+	if _, err := os.Stat(source); err == nil {
+		return os.ReadFile(source)
+	}
+
+	if flagBackupS3Endpoint == "" {
+		return nil, fmt.Errorf("backup %s not found", source)
+	}
+
+	client, err := backupS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	objectKey := source
+	if !strings.HasPrefix(objectKey, flagBackupS3Prefix) {
+		objectKey = flagBackupS3Prefix + objectKey
+	}
+
+	fmt.Printf("Downloading %s/%s...\n", flagBackupS3Bucket, objectKey)
+	return client.getObject(context.Background(), objectKey)
+}
+
+func backupS3Client() (*s3Client, error) {
+	secretKey := ""
+	if flagBackupS3SecretFile != "" {
+		secret, err := readSecretFile(flagBackupS3SecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secretKey = secret
+	}
+	return newS3Client(flagBackupS3Endpoint, flagBackupS3Bucket, flagBackupS3Region, flagBackupS3AccessKey, secretKey)
+}
+
+// backupFileName returns the archive name for a backup taken at the given time
+func backupFileName(at time.Time) string {
+	return k0sBackupFilePrefix + at.UTC().Format(k0sBackupTimeLayout) + ".tar.gz"
+}
+
+// parseBackupTime returns the time a backup was taken from its file name
+func parseBackupTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, k0sBackupFilePrefix) {
+		return time.Time{}, false
+	}
+	stamp := strings.TrimPrefix(name, k0sBackupFilePrefix)
+	stamp = strings.TrimSuffix(stamp, k0sBackupEncExtension)
+	stamp = strings.TrimSuffix(stamp, ".tar.gz")
+
+	at, err := time.Parse(k0sBackupTimeLayout, stamp)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// selectBackupsToPrune returns the backups that fall outside the retention policy.
+// The newest keep backups are retained; backups older than maxAge are always removed.
+func selectBackupsToPrune(names []string, keep int, maxAge time.Duration, now time.Time) []string {
+	type backup struct {
+		name string
+		at   time.Time
+	}
+
+	var backups []backup
+	for _, name := range names {
+		if at, ok := parseBackupTime(name); ok {
+			backups = append(backups, backup{name: name, at: at})
+		}
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].at.After(backups[j].at)
+	})
+
+	var prune []string
+	for i, b := range backups {
+		tooMany := keep > 0 && i >= keep
+		tooOld := maxAge > 0 && now.Sub(b.at) > maxAge
+		if tooMany || tooOld {
+			prune = append(prune, b.name)
+		}
+	}
+	return prune
+}
+
+func pruneBackups(dir string, keep int, maxAge time.Duration, now time.Time) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+
+	for _, name := range selectBackupsToPrune(names, keep, maxAge, now) {
+		path := filepath.Join(dir, name)
+		// Pruning frees the space, so the archive is not backed up
+		if err := removeWithoutBackup(path); err != nil {
+			fmt.Printf("⚠️  Warning: failed to remove old backup %s: %v\n", path, err)
+			continue
+		}
+		logAction("Removed old k0s backup", map[string]string{"file": path})
+		fmt.Printf("✓ Removed old backup %s\n", name)
+	}
+
+	return nil
+}
+
+// pruneS3Backups applies the retention policy to the backups directly under prefix
+func pruneS3Backups(ctx context.Context, client *s3Client, prefix string, keep int, maxAge time.Duration, now time.Time) error {
+	keys, err := client.listObjects(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, key := range keys {
+		if name := strings.TrimPrefix(key, prefix); !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range selectBackupsToPrune(names, keep, maxAge, now) {
+		objectKey := prefix + name
+		if err := client.deleteObject(ctx, objectKey); err != nil {
+			fmt.Printf("⚠️  Warning: failed to remove old backup %s/%s: %v\n", client.Bucket, objectKey, err)
+			continue
+		}
+		logAction("Removed old k0s backup from S3", map[string]string{
+			"bucket": client.Bucket,
+			"key":    objectKey,
+		})
+		fmt.Printf("✓ Removed old backup %s/%s\n", client.Bucket, objectKey)
+	}
+	return nil
+}
+
+// loadOrCreateBackupKey returns the local backup key, generating it on first use
+func loadOrCreateBackupKey(path string) ([]byte, error) {
+	if _, err := os.Stat(path); err == nil {
+		return readBackupKey(path)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate backup key: %w", err)
+	}
+
+	if err := sysExec.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create backup key directory: %w", err)
+	}
+	addSecretFile(path)
+	if err := sysExec.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write backup key: %w", err)
+	}
+	logFileWrite(path, "Backup encryption key")
+
+	fmt.Printf("✓ Created backup encryption key at %s\n", path)
+	fmt.Println("⚠️  The key only exists on this node, losing the node makes every encrypted backup unreadable.")
+	fmt.Printf("💡 Copy it off the node now, e.g. into a password manager: sudo cat %s\n", path)
+	fmt.Println("   Restore on another node with: galley controller restore <archive> --key-file <file>")
+	return key, nil
+}
+
+func readBackupKey(path string) ([]byte, error) {
+	encoded, err := readSecretFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("backup key %s is invalid, expected 32 hex-encoded bytes", path)
+	}
+	return key, nil
+}
+
+func isEncryptedBackup(data []byte) bool {
+	return bytes.HasPrefix(data, backupEncryptionMagic)
+}
+
+// encryptBackup encrypts data with AES-256-GCM: magic || nonce || ciphertext
+func encryptBackup(key, data []byte) ([]byte, error) {
+	gcm, err := newBackupCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	out := append([]byte{}, backupEncryptionMagic...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, backupEncryptionMagic), nil
+}
+
+func decryptBackup(key, data []byte) ([]byte, error) {
+	gcm, err := newBackupCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isEncryptedBackup(data) {
+		return nil, fmt.Errorf("backup is not encrypted by Galley")
+	}
+	data = data[len(backupEncryptionMagic):]
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted backup is truncated")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+
+	plain, err := gcm.Open(nil, nonce, ciphertext, backupEncryptionMagic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup (wrong key or corrupted archive): %w", err)
+	}
+	return plain, nil
+}
+
+func newBackupCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid backup key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupScheduleArgs returns the 'galley controller backup' arguments the timer runs with
+func backupScheduleArgs() []string {
+	args := []string{
+		"controller", "backup", "--skip-update-check",
+		"--dir", flagBackupDir,
+		"--keep", strconv.Itoa(flagBackupKeep),
+	}
+	if flagBackupMaxAge > 0 {
+		args = append(args, "--max-age", flagBackupMaxAge.String())
+	}
+	if flagBackupEncrypt {
+		args = append(args, "--encrypt", "--key-file", flagBackupKeyFile)
+	}
+	if flagBackupS3Endpoint != "" {
+		args = append(args,
+			"--s3-endpoint", flagBackupS3Endpoint,
+			"--s3-bucket", flagBackupS3Bucket,
+			"--s3-region", flagBackupS3Region,
+			"--s3-prefix", flagBackupS3Prefix,
+			"--s3-access-key", flagBackupS3AccessKey,
+			"--s3-secret-key-file", flagBackupS3SecretFile,
+		)
+	}
+	return args
+}
+
+// installBackupSchedule writes and enables a systemd timer that runs the backup
+func installBackupSchedule(schedule string) error {
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	// Create the key now, so it can be copied off the node before the first backup
+	if flagBackupEncrypt {
+		if _, err := loadOrCreateBackupKey(flagBackupKeyFile); err != nil {
+			return err
+		}
+	}
+
+	quoted := []string{execPath}
+	for _, arg := range backupScheduleArgs() {
+		if strings.ContainsAny(arg, " \t'\"") {
+			arg = strconv.Quote(arg)
+		}
+		quoted = append(quoted, arg)
+	}
+
+	service := fmt.Sprintf(`[Unit]
+Description=Back up the k0s control plane (Galley)
+After=k0scontroller.service
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, strings.Join(quoted, " "))
+
+	timer := fmt.Sprintf(`[Unit]
+Description=Schedule k0s control plane backups (Galley)
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+RandomizedDelaySec=10m
+
+[Install]
+WantedBy=timers.target
+`, schedule)
+
+	servicePath := filepath.Join("/etc/systemd/system", galleyBackupUnit)
+	if err := sysExec.WriteFile(servicePath, []byte(service), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", servicePath, err)
+	}
+	logFileWrite(servicePath, "Scheduled k0s backup service")
+
+	timerPath := filepath.Join("/etc/systemd/system", galleyBackupTimer)
+	if err := sysExec.WriteFile(timerPath, []byte(timer), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", timerPath, err)
+	}
+	logFileWrite(timerPath, "Scheduled k0s backup timer")
+
+	ctx := context.Background()
+	if err := runCommandWithContext(ctx, "systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := runCommandWithContext(ctx, "systemctl", "enable", "--now", galleyBackupTimer); err != nil {
+		return fmt.Errorf("failed to enable backup timer: %w", err)
+	}
+	logServiceChange(galleyBackupTimer, "enabled")
+
+	fmt.Printf("✓ Scheduled k0s backups (%s)\n", schedule)
+	fmt.Printf("  Check the schedule with: systemctl list-timers %s\n", galleyBackupTimer)
+	return nil
+}
+
+// removeBackupSchedule disables and removes the backup timer
+func removeBackupSchedule() error {
+	ctx := context.Background()
+	if err := runCommandWithContext(ctx, "systemctl", "disable", "--now", galleyBackupTimer); err != nil {
+		fmt.Printf("⚠️  Warning: failed to disable %s: %v\n", galleyBackupTimer, err)
+	} else {
+		logServiceChange(galleyBackupTimer, "disabled")
+	}
+
+	for _, unit := range []string{galleyBackupTimer, galleyBackupUnit} {
+		path := filepath.Join("/etc/systemd/system", unit)
+		if err := sysExec.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	if err := runCommandWithContext(ctx, "systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ Scheduled k0s backups removed")
+	return nil
+}
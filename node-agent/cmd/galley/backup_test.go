@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackupFileName(t *testing.T) {
+	at := time.Date(2026, 10, 19, 17, 30, 5, 0, time.UTC)
+	name := backupFileName(at)
+
+	if name != "galley-k0s-backup-20261019T173005Z.tar.gz" {
+		t.Errorf("backupFileName() = %s", name)
+	}
+
+	for _, candidate := range []string{name, name + k0sBackupEncExtension} {
+		parsed, ok := parseBackupTime(candidate)
+		if !ok || !parsed.Equal(at) {
+			t.Errorf("parseBackupTime(%s) = %v, %v", candidate, parsed, ok)
+		}
+	}
+
+	if _, ok := parseBackupTime("k0s_backup_2026.tar.gz"); ok {
+		t.Error("parseBackupTime() should ignore files not created by Galley")
+	}
+}
+
+func TestSelectBackupsToPrune(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for day := 0; day < 5; day++ {
+		names = append(names, backupFileName(now.Add(-time.Duration(day)*24*time.Hour)))
+	}
+	names = append(names, "unrelated.txt")
+
+	tests := []struct {
+		name   string
+		keep   int
+		maxAge time.Duration
+		want   int
+	}{
+		{name: "keep three", keep: 3, want: 2},
+		{name: "keep all", keep: 0, want: 0},
+		{name: "max age two days", keep: 0, maxAge: 48 * time.Hour, want: 2},
+		{name: "keep and max age", keep: 2, maxAge: 72 * time.Hour, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectBackupsToPrune(names, tt.keep, tt.maxAge, now)
+			if len(got) != tt.want {
+				t.Errorf("selectBackupsToPrune() = %v, want %d backups", got, tt.want)
+			}
+			for _, name := range got {
+				if name == names[0] {
+					t.Error("selectBackupsToPrune() should never prune the newest backup")
+				}
+			}
+		})
+	}
+}
+
+func TestBackupEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	plain := []byte("k0s backup archive contents")
+
+	encrypted, err := encryptBackup(key, plain)
+	if err != nil {
+		t.Fatalf("encryptBackup() error = %v", err)
+	}
+	if !isEncryptedBackup(encrypted) {
+		t.Error("encrypted backup should start with the Galley magic")
+	}
+	if bytes.Contains(encrypted, plain) {
+		t.Error("encrypted backup should not contain the plain text")
+	}
+
+	decrypted, err := decryptBackup(key, encrypted)
+	if err != nil {
+		t.Fatalf("decryptBackup() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plain) {
+		t.Errorf("decryptBackup() = %q, want %q", decrypted, plain)
+	}
+
+	wrongKey := bytes.Repeat([]byte{0x24}, 32)
+	if _, err := decryptBackup(wrongKey, encrypted); err == nil {
+		t.Error("decryptBackup() should fail with the wrong key")
+	}
+
+	if _, err := decryptBackup(key, plain); err == nil {
+		t.Error("decryptBackup() should fail on an unencrypted archive")
+	}
+}
+
+func TestBackupKeyFile(t *testing.T) {
+	path := t.TempDir() + "/backup.key"
+
+	key, err := loadOrCreateBackupKey(path)
+	if err != nil {
+		t.Fatalf("loadOrCreateBackupKey() error = %v", err)
+	}
+
+	again, err := loadOrCreateBackupKey(path)
+	if err != nil {
+		t.Fatalf("loadOrCreateBackupKey() second call error = %v", err)
+	}
+	if !bytes.Equal(key, again) {
+		t.Error("loadOrCreateBackupKey() should reuse the existing key")
+	}
+}
+
+// TestS3ClientStandIn uploads and downloads against a local S3 stand-in
+func TestS3ClientStandIn(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/") || !strings.Contains(auth, "/eu-west-1/s3/aws4_request") {
+			http.Error(w, "bad authorization: "+auth, http.StatusForbidden)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(body) {
+				http.Error(w, "payload hash mismatch", http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = body
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			if r.URL.Query().Get("list-type") == "2" {
+				// One key per page, so listing has to follow the continuation token
+				bucket := r.URL.Path + "/"
+				var keys []string
+				for path := range objects {
+					if key := strings.TrimPrefix(path, bucket); strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+						keys = append(keys, key)
+					}
+				}
+				sort.Strings(keys)
+				start := 0
+				if token := r.URL.Query().Get("continuation-token"); token != "" {
+					start, _ = strconv.Atoi(token)
+				}
+				fmt.Fprint(w, "<ListBucketResult>")
+				if start < len(keys) {
+					fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", keys[start])
+				}
+				if start+1 < len(keys) {
+					fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", start+1)
+				}
+				fmt.Fprint(w, "</ListBucketResult>")
+				return
+			}
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	client, err := newS3Client(server.URL, "backups", "eu-west-1", "access", "secret")
+	if err != nil {
+		t.Fatalf("newS3Client() error = %v", err)
+	}
+
+	ctx := context.Background()
+	if err := client.putObject(ctx, "k0s/backup.tar.gz", []byte("archive")); err != nil {
+		t.Fatalf("putObject() error = %v", err)
+	}
+	if _, ok := objects["/backups/k0s/backup.tar.gz"]; !ok {
+		t.Errorf("putObject() stored objects %v, want path-style key", objects)
+	}
+
+	data, err := client.getObject(ctx, "k0s/backup.tar.gz")
+	if err != nil {
+		t.Fatalf("getObject() error = %v", err)
+	}
+	if string(data) != "archive" {
+		t.Errorf("getObject() = %q, want %q", data, "archive")
+	}
+
+	if _, err := client.getObject(ctx, "k0s/missing.tar.gz"); err == nil {
+		t.Error("getObject() should fail for a missing object")
+	}
+
+	// Retention applies to the bucket as well, other objects are left alone
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	for _, key := range []string{
+		"k0s/" + backupFileName(now.Add(-72*time.Hour)),
+		"k0s/" + backupFileName(now.Add(-48*time.Hour)) + k0sBackupEncExtension,
+		"k0s/" + backupFileName(now.Add(-24*time.Hour)),
+		"k0s/nested/" + backupFileName(now.Add(-96*time.Hour)),
+	} {
+		if err := client.putObject(ctx, key, []byte("archive")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pruneS3Backups(ctx, client, "k0s/", 2, 0, now); err != nil {
+		t.Fatalf("pruneS3Backups() error = %v", err)
+	}
+	keys, err := client.listObjects(ctx, "k0s/")
+	if err != nil {
+		t.Fatalf("listObjects() error = %v", err)
+	}
+	want := []string{
+		"k0s/" + backupFileName(now.Add(-48*time.Hour)) + k0sBackupEncExtension,
+		"k0s/" + backupFileName(now.Add(-24*time.Hour)),
+		"k0s/backup.tar.gz",
+		"k0s/nested/" + backupFileName(now.Add(-96*time.Hour)),
+	}
+	sort.Strings(want)
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("objects after pruning = %v, want %v", keys, want)
+	}
+}
+
+func TestNewS3ClientValidation(t *testing.T) {
+	if _, err := newS3Client("", "bucket", "", "a", "b"); err == nil {
+		t.Error("newS3Client() should require an endpoint")
+	}
+	if _, err := newS3Client("minio:9000", "bucket", "", "", ""); err == nil {
+		t.Error("newS3Client() should require credentials")
+	}
+
+	client, err := newS3Client("minio:9000", "bucket", "", "a", "b")
+	if err != nil {
+		t.Fatalf("newS3Client() error = %v", err)
+	}
+	if client.Endpoint != "https://minio:9000" || client.Region != "us-east-1" {
+		t.Errorf("newS3Client() = %s, %s", client.Endpoint, client.Region)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	k0sPKIDir            = k0sDataDir + "/pki"
+	k0sKubeletPKIDir     = k0sDataDir + "/kubelet/pki"
+	galleyCertsRotateDir = galleyStateDir + "/pki-rotated"
+	galleyCertsUnit      = "galley-certs-check.service"
+	galleyCertsTimer     = "galley-certs-check.timer"
+)
+
+// Status of a certificate against the expiry thresholds
+const (
+	certStatusOK       = "ok"
+	certStatusWarning  = "warning"
+	certStatusCritical = "critical"
+	certStatusExpired  = "expired"
+)
+
+var (
+	flagCertsWarnDays     int
+	flagCertsCriticalDays int
+	flagCertsOutput       string
+	flagCertsReport       bool
+	flagCertsToken        string
+	flagCertsTokenFile    string
+	flagCertsSchedule     string
+	flagCertsUnschedule   bool
+)
+
+var controllerCertsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Check and rotate the k0s certificates",
+}
+
+var controllerCertsCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Report the expiry of the k0s and kubelet certificates",
+	Long: `Parses every certificate in ` + k0sPKIDir + ` and ` + k0sKubeletPKIDir + ` and
+reports its subject, SANs and the days until it expires.
+
+Exits with an error when a certificate expired or expires within --critical-days.
+With --report the result is sent to the Galley platform, and --schedule installs a
+systemd timer that does so on a schedule.`,
+	Example: `  galley controller certs check
+  galley controller certs check --warn-days 60 -o json
+  galley controller certs check --schedule daily --token-file /etc/galley/node-token`,
+	Args: cobra.ExactArgs(0),
+	RunE: runControllerCertsCheck,
+}
+
+var controllerCertsRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Renew the k0s certificates signed by the cluster CAs",
+	Long: `Renews the k0s control plane certificates on this controller. k0s creates missing
+certificates when it starts, so the certificates (not the CAs and the service
+account keys) are moved to ` + galleyCertsRotateDir + ` and k0s is restarted.
+
+Kubelets renew their client certificates themselves. Rotate one controller at a
+time, the API stays available through the other controllers.`,
+	Args: cobra.ExactArgs(0),
+	RunE: runControllerCertsRotate,
+}
+
+func init() {
+	controllerCertsCheckCmd.Flags().IntVar(&flagCertsWarnDays, "warn-days", 30, "Warn about certificates that expire within this many days")
+	controllerCertsCheckCmd.Flags().IntVar(&flagCertsCriticalDays, "critical-days", 7, "Fail on certificates that expire within this many days")
+	controllerCertsCheckCmd.Flags().StringVarP(&flagCertsOutput, "output", "o", "table", "Output format: table or json")
+	controllerCertsCheckCmd.Flags().BoolVar(&flagCertsReport, "report", false, "Report the certificate expiry to the Galley platform")
+	controllerCertsCheckCmd.Flags().StringVar(&flagCertsToken, "token", "", "Node token from the Galley web interface")
+	controllerCertsCheckCmd.Flags().StringVar(&flagCertsTokenFile, "token-file", "", "File containing the node token")
+	controllerCertsCheckCmd.Flags().StringVar(&flagCertsSchedule, "schedule", "", "Install a systemd timer that reports the expiry, e.g. daily")
+	controllerCertsCheckCmd.Flags().BoolVar(&flagCertsUnschedule, "unschedule", false, "Remove the certificate check timer")
+	controllerCertsRotateCmd.Flags().DurationVar(&flagReadyTimeout, "ready-timeout", defaultK0sReadyTimeout, "How long to wait for k0s to become ready")
+
+	controllerCertsCmd.AddCommand(controllerCertsCheckCmd)
+	controllerCertsCmd.AddCommand(controllerCertsRotateCmd)
+	controllerCmd.AddCommand(controllerCertsCmd)
+}
+
+// certificateInfo describes a certificate found on the node
+type certificateInfo struct {
+	Path          string    `json:"path"`
+	Subject       string    `json:"subject"`
+	Issuer        string    `json:"issuer"`
+	SANs          []string  `json:"sans,omitempty"`
+	IsCA          bool      `json:"isCA"`
+	NotAfter      time.Time `json:"notAfter"`
+	DaysRemaining int       `json:"daysRemaining"`
+	Status        string    `json:"status"`
+}
+
+// readCertificates parses the certificates of every .crt and .pem file under the
+// directories. Files with keys only are skipped, a .pem file can hold both.
+func readCertificates(dirs ...string) ([]certificateInfo, error) {
+	var certs []certificateInfo
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) && path == dir {
+					return filepath.SkipDir
+				}
+				return err
+			}
+			if entry.IsDir() || (filepath.Ext(path) != ".crt" && filepath.Ext(path) != ".pem") {
+				return nil
+			}
+			// kubelet-client-current.pem links to the latest rotated certificate
+			if entry.Type()&fs.ModeSymlink != 0 {
+				if target, err := filepath.EvalSymlinks(path); err == nil && strings.HasPrefix(target, dir) {
+					return nil
+				}
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			parsed, err := parseCertificates(path, content)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, parsed...)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificates in %s: %w", dir, err)
+		}
+	}
+	return certs, nil
+}
+
+// parseCertificates returns the certificates in PEM content
+func parseCertificates(path string, content []byte) ([]certificateInfo, error) {
+	var certs []certificateInfo
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %s: %w", path, err)
+		}
+		info := certificateInfo{
+			Path:     path,
+			Subject:  cert.Subject.String(),
+			Issuer:   cert.Issuer.String(),
+			SANs:     append([]string{}, cert.DNSNames...),
+			IsCA:     cert.IsCA,
+			NotAfter: cert.NotAfter,
+		}
+		for _, ip := range cert.IPAddresses {
+			info.SANs = append(info.SANs, ip.String())
+		}
+		certs = append(certs, info)
+	}
+	return certs, nil
+}
+
+// classifyCertificates sets the days remaining and the status of each certificate,
+// and sorts them by expiry
+func classifyCertificates(certs []certificateInfo, now time.Time, warnDays, criticalDays int) {
+	for i := range certs {
+		remaining := certs[i].NotAfter.Sub(now)
+		certs[i].DaysRemaining = int(remaining.Hours() / 24)
+		switch {
+		case remaining <= 0:
+			certs[i].Status = certStatusExpired
+		case certs[i].DaysRemaining < criticalDays:
+			certs[i].Status = certStatusCritical
+		case certs[i].DaysRemaining < warnDays:
+			certs[i].Status = certStatusWarning
+		default:
+			certs[i].Status = certStatusOK
+		}
+	}
+
+	sort.SliceStable(certs, func(i, j int) bool {
+		return certs[i].NotAfter.Before(certs[j].NotAfter)
+	})
+}
+
+func countCertificates(certs []certificateInfo, statuses ...string) int {
+	count := 0
+	for _, cert := range certs {
+		for _, status := range statuses {
+			if cert.Status == status {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// certsToken returns the node token from --token or --token-file
+func certsToken() (string, error) {
+	if flagCertsToken != "" {
+		return flagCertsToken, nil
+	}
+	if flagCertsTokenFile == "" {
+		return "", fmt.Errorf("--token or --token-file is required to report to the platform")
+	}
+	content, err := os.ReadFile(flagCertsTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", flagCertsTokenFile)
+	}
+	return token, nil
+}
+
+func runControllerCertsCheck(cmd *cobra.Command, args []string) error {
+	if flagCertsUnschedule {
+		return removeCertsSchedule()
+	}
+	if flagCertsSchedule != "" {
+		if flagCertsTokenFile == "" {
+			return fmt.Errorf("--schedule needs --token-file, the token is not stored in the systemd unit")
+		}
+		if _, err := certsToken(); err != nil {
+			return err
+		}
+		return installCertsSchedule(flagCertsSchedule)
+	}
+
+	if flagCertsOutput != "table" && flagCertsOutput != "json" {
+		return fmt.Errorf("invalid output format %q, expected table or json", flagCertsOutput)
+	}
+	if flagCertsCriticalDays > flagCertsWarnDays {
+		return fmt.Errorf("--critical-days (%d) can't be more than --warn-days (%d)", flagCertsCriticalDays, flagCertsWarnDays)
+	}
+
+	certs, err := readCertificates(k0sPKIDir, k0sKubeletPKIDir)
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificates found in %s, is this a k0s controller?", k0sPKIDir)
+	}
+	classifyCertificates(certs, time.Now(), flagCertsWarnDays, flagCertsCriticalDays)
+
+	if flagCertsOutput == "json" {
+		data, err := json.MarshalIndent(certs, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		printCertificates(certs)
+	}
+
+	failing := countCertificates(certs, certStatusExpired, certStatusCritical)
+	warnings := countCertificates(certs, certStatusWarning)
+	logAction("Checked certificate expiry", map[string]string{
+		"certificates": strconv.Itoa(len(certs)),
+		"failing":      strconv.Itoa(failing),
+		"warnings":     strconv.Itoa(warnings),
+	})
+
+	if flagCertsReport {
+		token, err := certsToken()
+		if err != nil {
+			return err
+		}
+		if err := reportGalleyCertificates(getPlatformURL(), token, certs); err != nil {
+			return fmt.Errorf("failed to report certificates to the platform: %w", err)
+		}
+	}
+
+	if failing > 0 {
+		return fmt.Errorf("%d certificate(s) expired or expire within %d days, renew them with 'galley controller certs rotate'", failing, flagCertsCriticalDays)
+	}
+	return nil
+}
+
+func printCertificates(certs []certificateInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DAYS\tSTATUS\tSUBJECT\tSANS\tPATH")
+	for _, cert := range certs {
+		sans := strings.Join(cert.SANs, ",")
+		if len(sans) > 40 {
+			sans = sans[:37] + "..."
+		}
+		if sans == "" {
+			sans = "-"
+		}
+		subject := cert.Subject
+		if cert.IsCA {
+			subject += " (CA)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", cert.DaysRemaining, cert.Status, subject, sans, cert.Path)
+	}
+	_ = w.Flush()
+
+	fmt.Println()
+	switch {
+	case countCertificates(certs, certStatusExpired, certStatusCritical) > 0:
+		fmt.Println("✗ Certificates expired or about to expire")
+		fmt.Println("💡 Renew them with: galley controller certs rotate")
+	case countCertificates(certs, certStatusWarning) > 0:
+		fmt.Println("⚠️  Certificates expire soon")
+		fmt.Println("💡 Renew them with: galley controller certs rotate")
+	default:
+		fmt.Printf("✓ All %d certificates are valid for at least %d more days\n", len(certs), certs[0].DaysRemaining)
+	}
+}
+
+// rotatableCertificates returns the certificate and key files k0s recreates on start:
+// everything in the pki directory except the CAs and the service account key pair
+func rotatableCertificates(pkiDir string) ([]string, error) {
+	certs, err := readCertificates(pkiDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, cert := range certs {
+		if cert.IsCA || filepath.Ext(cert.Path) != ".crt" {
+			continue
+		}
+		files = append(files, cert.Path)
+		key := strings.TrimSuffix(cert.Path, ".crt") + ".key"
+		if _, err := os.Stat(key); err == nil {
+			files = append(files, key)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func runControllerCertsRotate(cmd *cobra.Command, args []string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load Galley config: %w", err)
+	}
+	if !strings.HasPrefix(config.NodeType, "controller") {
+		return fmt.Errorf("certificates can only be rotated on a controller")
+	}
+
+	files, err := rotatableCertificates(k0sPKIDir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no certificates to rotate in %s", k0sPKIDir)
+	}
+
+	backupDir := filepath.Join(galleyCertsRotateDir, time.Now().UTC().Format("20060102T150405Z"))
+	service := k0sServiceName(config.NodeType)
+
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Println("Certificate Rotation")
+	fmt.Println(strings.Repeat("=", 70))
+
+	ctx := context.Background()
+	if err := runCommandWithContext(ctx, "systemctl", "stop", service); err != nil {
+		return fmt.Errorf("failed to stop %s: %w", service, err)
+	}
+	logServiceChange(service, "stopped")
+
+	for _, file := range files {
+		target := filepath.Join(backupDir, strings.TrimPrefix(file, k0sPKIDir))
+		if err := sysExec.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+		}
+		if err := sysExec.Rename(file, target); err != nil {
+			return fmt.Errorf("failed to move %s: %w (start k0s again with 'systemctl start %s')", file, err, service)
+		}
+	}
+	logAction("Moved certificates for rotation", map[string]string{
+		"files":      strconv.Itoa(len(files)),
+		"backup_dir": backupDir,
+	})
+	fmt.Printf("✓ Moved %d certificate files to %s\n", len(files), backupDir)
+
+	if err := runCommandWithContext(ctx, "systemctl", "start", service); err != nil {
+		return fmt.Errorf("failed to start %s: %w", service, err)
+	}
+	logServiceChange(service, "started")
+
+	if err := waitForK0sReady(config.NodeType, flagReadyTimeout); err != nil {
+		fmt.Printf("\n💡 The previous certificates are in %s, move them back to %s to restore them\n", backupDir, k0sPKIDir)
+		return fmt.Errorf("k0s did not become ready after the rotation: %w", err)
+	}
+	if flagDryRun {
+		return nil
+	}
+
+	certs, err := readCertificates(k0sPKIDir)
+	if err != nil {
+		return err
+	}
+	classifyCertificates(certs, time.Now(), 0, 0)
+	for _, cert := range certs {
+		// Sorted by expiry, the first certificate that is not a CA expires first
+		if !cert.IsCA {
+			fmt.Printf("✓ Certificates renewed, the first one now expires in %d days\n", cert.DaysRemaining)
+			break
+		}
+	}
+	fmt.Println("💡 Rotate the other controllers one at a time")
+	return nil
+}
+
+// installCertsSchedule writes and enables a systemd timer that reports the certificate expiry
+func installCertsSchedule(schedule string) error {
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	args := []string{
+		execPath, "controller", "certs", "check", "--skip-update-check", "--report",
+		"--token-file", flagCertsTokenFile,
+		"--warn-days", strconv.Itoa(flagCertsWarnDays),
+		"--critical-days", strconv.Itoa(flagCertsCriticalDays),
+	}
+	if flagPlatformURL != "" {
+		args = append(args, "--platform-url", flagPlatformURL)
+	}
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t'\"") {
+			args[i] = strconv.Quote(arg)
+		}
+	}
+
+	service := fmt.Sprintf(`[Unit]
+Description=Report k0s certificate expiry to Galley
+After=k0scontroller.service network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, strings.Join(args, " "))
+
+	timer := fmt.Sprintf(`[Unit]
+Description=Check k0s certificate expiry (Galley)
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+RandomizedDelaySec=1h
+
+[Install]
+WantedBy=timers.target
+`, schedule)
+
+	for _, unit := range []struct{ name, content string }{{galleyCertsUnit, service}, {galleyCertsTimer, timer}} {
+		path := filepath.Join("/etc/systemd/system", unit.name)
+		if err := sysExec.WriteFile(path, []byte(unit.content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		logFileWrite(path, "Scheduled certificate expiry check")
+	}
+
+	ctx := context.Background()
+	if err := runCommandWithContext(ctx, "systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := runCommandWithContext(ctx, "systemctl", "enable", "--now", galleyCertsTimer); err != nil {
+		return fmt.Errorf("failed to enable certificate check timer: %w", err)
+	}
+	logServiceChange(galleyCertsTimer, "enabled")
+
+	fmt.Printf("✓ Scheduled certificate expiry reports (%s)\n", schedule)
+	fmt.Printf("  Check the last run with: journalctl -u %s\n", galleyCertsUnit)
+	return nil
+}
+
+// removeCertsSchedule disables and removes the certificate check timer
+func removeCertsSchedule() error {
+	ctx := context.Background()
+	if err := runCommandWithContext(ctx, "systemctl", "disable", "--now", galleyCertsTimer); err != nil {
+		fmt.Printf("⚠️  Warning: failed to disable %s: %v\n", galleyCertsTimer, err)
+	} else {
+		logServiceChange(galleyCertsTimer, "disabled")
+	}
+
+	for _, unit := range []string{galleyCertsTimer, galleyCertsUnit} {
+		path := filepath.Join("/etc/systemd/system", unit)
+		if err := sysExec.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	if err := runCommandWithContext(ctx, "systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+
+	fmt.Println("✓ Scheduled certificate expiry reports removed")
+	return nil
+}
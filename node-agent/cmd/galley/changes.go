@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// galleyBackupsDir holds the original content of every file Galley changed. The
+// content is stored once under objects/ by its SHA-256, the manifest records the
+// changes in the order they were made.
+const galleyBackupsDir = galleyStateDir + "/backups"
+
+const (
+	fileChangeCreate = "create"
+	fileChangeModify = "modify"
+	fileChangeRemove = "remove"
+)
+
+var (
+	flagChangesOutput string
+	flagChangesStep   string
+	flagRollbackStep  string
+	flagRollbackAll   bool
+	flagRollbackYes   bool
+)
+
+// changeStep is what the changes of this run are recorded under: the prepare step
+// that is running, or else the command. Nothing is recorded outside a command.
+var changeStep string
+
+// fileChanges records the changes of the system executor
+var fileChanges = changeStore{dir: galleyBackupsDir}
+
+var nodeChangesCmd = &cobra.Command{
+	Use:   "changes",
+	Short: "Show the system files Galley changed",
+}
+
+var nodeChangesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the system files Galley changed, with a backup of each original",
+	Long: `Lists every system file Galley created, modified or removed on this node, with
+the prepare step or command that changed it.
+
+The original content of each file is kept in ` + galleyBackupsDir + `, restore it
+with 'galley node rollback'. Data directories like ` + k0sDataDir + ` are not
+backed up, apart from the k0s certificates and the Galley agent manifest.
+
+Use --output json for machine-readable output.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		if flagChangesOutput != "table" && flagChangesOutput != "json" {
+			return fmt.Errorf("invalid output format %q, expected table or json", flagChangesOutput)
+		}
+
+		changes, err := fileChanges.load()
+		if err != nil {
+			return fmt.Errorf("failed to read the change manifest: %w", err)
+		}
+		if flagChangesStep != "" {
+			changes = selectFileChanges(changes, flagChangesStep, true)
+		}
+
+		if flagChangesOutput == "json" {
+			data, err := json.MarshalIndent(changes, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+
+		if len(changes) == 0 {
+			fmt.Println("No changes recorded")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCHANGED\tSTEP\tACTION\tPATH\tSTATUS")
+		for _, change := range changes {
+			status := "active"
+			if change.RolledBackAt != nil {
+				status = "rolled back"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", change.ID, change.ChangedAt.UTC().Format(time.RFC3339), change.Step, change.Action, change.Path, status)
+		}
+		return w.Flush()
+	},
+}
+
+var nodeRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Restore system files Galley changed to their original content",
+	Long: `Restores the files changed by a prepare step or command (--step), or all files
+Galley changed (--all), see 'galley node changes list'.
+
+This command will:
+  - Restore every file to its content, mode and owner before the first change,
+    newest change first
+  - Remove files Galley created
+  - Reload the services that read the restored files (ssh, sysctl, systemd, fail2ban)
+
+A rollback is recorded as a change too, so it can be rolled back itself.`,
+	Args: cobra.ExactArgs(0),
+	RunE: runNodeRollback,
+}
+
+func init() {
+	nodeChangesListCmd.Flags().StringVarP(&flagChangesOutput, "output", "o", "table", "Output format: table or json")
+	nodeChangesListCmd.Flags().StringVar(&flagChangesStep, "step", "", "Only list the changes of this prepare step or command")
+	nodeRollbackCmd.Flags().StringVar(&flagRollbackStep, "step", "", "Roll back the changes of this prepare step or command, e.g. server-hardening")
+	nodeRollbackCmd.Flags().BoolVar(&flagRollbackAll, "all", false, "Roll back all changes")
+	nodeRollbackCmd.Flags().BoolVarP(&flagRollbackYes, "yes", "y", false, "Skip the confirmation prompt")
+	nodeChangesCmd.AddCommand(nodeChangesListCmd)
+	nodeCmd.AddCommand(nodeChangesCmd)
+	nodeCmd.AddCommand(nodeRollbackCmd)
+}
+
+func runNodeRollback(cobraCmd *cobra.Command, args []string) error {
+	if flagRollbackAll == (flagRollbackStep != "") {
+		return fmt.Errorf("use either --step or --all")
+	}
+
+	changes, err := fileChanges.load()
+	if err != nil {
+		return fmt.Errorf("failed to read the change manifest: %w", err)
+	}
+	selected := selectFileChanges(changes, flagRollbackStep, false)
+	if len(selected) == 0 {
+		if flagRollbackAll {
+			fmt.Println("No changes to roll back")
+			return nil
+		}
+		return fmt.Errorf("no changes to roll back for step %q, see 'galley node changes list'", flagRollbackStep)
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Println("Roll Back System Files")
+	fmt.Println(strings.Repeat("=", 70))
+	var paths []string
+	for _, change := range selected {
+		if !slices.Contains(paths, change.Path) {
+			paths = append(paths, change.Path)
+		}
+	}
+	for _, path := range paths {
+		fmt.Printf("  - %s\n", path)
+	}
+
+	if !flagRollbackYes && !flagDryRun {
+		fmt.Print("\nDo you want to restore these files? [y/N]: ")
+		response, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		response = strings.TrimSpace(strings.ToLower(response))
+		if response != "y" && response != "yes" {
+			fmt.Println("Rollback cancelled.")
+			return nil
+		}
+	}
+
+	// Newest first, so every file ends up as it was before its first change
+	for i := len(selected) - 1; i >= 0; i-- {
+		change := selected[i]
+		if err := fileChanges.restore(change); err != nil {
+			return fmt.Errorf("failed to restore %s (change %d): %w", change.Path, change.ID, err)
+		}
+		if err := fileChanges.markRolledBack(change.ID, time.Now()); err != nil {
+			return fmt.Errorf("failed to update the change manifest: %w", err)
+		}
+		logFileWrite(change.Path, fmt.Sprintf("Rolled back change %d", change.ID))
+	}
+	fmt.Printf("✓ Restored %d file(s)\n", len(paths))
+
+	for _, reload := range rollbackReloads(paths) {
+		if err := runRollbackReload(reload); err != nil {
+			fmt.Printf("⚠️  Warning: failed to reload %s: %v\n", reload, err)
+		}
+	}
+
+	step := flagRollbackStep
+	if flagRollbackAll {
+		step = "all"
+	}
+	logAction("Rolled back system files", map[string]string{
+		"step":  step,
+		"files": fmt.Sprintf("%d", len(paths)),
+	})
+
+	if slices.ContainsFunc(paths, func(path string) bool {
+		return strings.HasPrefix(path, "/etc/ssh/") || strings.HasPrefix(path, "/etc/pam.d/")
+	}) {
+		fmt.Println("\n💡 Keep this session open and check that you can log in from a new one.")
+	}
+	if slices.ContainsFunc(paths, func(path string) bool { return strings.HasPrefix(path, "/etc/k0s/") }) {
+		fmt.Println("💡 Restart k0s to use the restored k0s files.")
+	}
+	return nil
+}
+
+// selectFileChanges returns the changes of a step, or of all steps when step is
+// empty, leaving out rolled back changes unless withRolledBack is set
+func selectFileChanges(changes []fileChange, step string, withRolledBack bool) []fileChange {
+	var selected []fileChange
+	for _, change := range changes {
+		if step != "" && change.Step != step {
+			continue
+		}
+		if change.RolledBackAt != nil && !withRolledBack {
+			continue
+		}
+		selected = append(selected, change)
+	}
+	return selected
+}
+
+// rollbackReloads returns the services to reload after restoring paths
+func rollbackReloads(paths []string) []string {
+	var reloads []string
+	add := func(reload string) {
+		if !slices.Contains(reloads, reload) {
+			reloads = append(reloads, reload)
+		}
+	}
+	for _, path := range paths {
+		switch {
+		case strings.HasPrefix(path, "/etc/systemd/"), path == "/etc/fstab":
+			add("systemd")
+		case strings.HasPrefix(path, "/etc/modules-load.d/"):
+			add("modules")
+		case strings.HasPrefix(path, "/etc/sysctl.d/"):
+			add("sysctl")
+		case path == "/etc/ssh/sshd_config", strings.HasPrefix(path, "/etc/ssh/sshd_config.d/"):
+			add("ssh")
+		case strings.HasPrefix(path, "/etc/fail2ban/"):
+			add("fail2ban")
+		}
+	}
+	return reloads
+}
+
+func runRollbackReload(reload string) error {
+	ctx := context.Background()
+	switch reload {
+	case "systemd":
+		return runCommandWithContext(ctx, "systemctl", "daemon-reload")
+	case "modules":
+		return runCommandWithContext(ctx, "systemctl", "restart", "systemd-modules-load")
+	case "sysctl":
+		return runCommandWithContext(ctx, "sysctl", "--system")
+	case "ssh":
+		// Don't reload sshd with a configuration it can't start with
+		if err := runCommandWithContext(ctx, "sshd", "-t"); err != nil {
+			return fmt.Errorf("SSH configuration validation failed: %w", err)
+		}
+		return restartSSHService()
+	case "fail2ban":
+		if err := runCommandWithContext(ctx, "systemctl", "restart", "fail2ban"); err != nil {
+			return err
+		}
+		logServiceChange("fail2ban", "restarted")
+		return nil
+	}
+	return fmt.Errorf("unknown reload %q", reload)
+}
+
+// fileChange is a change Galley made to a system file. Hash is the original
+// content in the object store, empty for a file Galley created.
+type fileChange struct {
+	ID           int         `json:"id"`
+	Path         string      `json:"path"`
+	Action       string      `json:"action"`
+	Step         string      `json:"step"`
+	Hash         string      `json:"hash,omitempty"`
+	Mode         os.FileMode `json:"mode,omitempty"`
+	Owner        *fileOwner  `json:"owner,omitempty"`
+	ChangedAt    time.Time   `json:"changed_at"`
+	RolledBackAt *time.Time  `json:"rolled_back_at,omitempty"`
+}
+
+// fileOwner is the user and group a file belonged to before a change
+type fileOwner struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// changeStore keeps the backups of the files Galley changed. It writes directly,
+// not through sysExec, because it is what sysExec records changes with.
+type changeStore struct {
+	dir string
+}
+
+func (s changeStore) manifestPath() string {
+	return filepath.Join(s.dir, "manifest.json")
+}
+
+func (s changeStore) objectPath(hash string) string {
+	return filepath.Join(s.dir, "objects", hash)
+}
+
+// untrackedDirs hold data rather than configuration, like etcd and container
+// images. Removing them is not backed up, which could fill the disk, except for
+// the certificates and manifests Galley manages in trackedDataDirs.
+var (
+	untrackedDirs   = []string{k0sDataDir, "/run/k0s", "/var/lib/containerd"}
+	trackedDataDirs = []string{k0sPKIDir, galleyAgentManifestDir}
+)
+
+// tracks reports whether changes to path are backed up. Galley's own state and
+// its config are not system files.
+func (s changeStore) tracks(path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	for _, dir := range []string{galleyStateDir, s.dir} {
+		if withinDir(path, dir) {
+			return false
+		}
+	}
+	if configPath, err := getConfigPath(); err == nil && path == configPath {
+		return false
+	}
+	if slices.ContainsFunc(untrackedDirs, func(dir string) bool { return withinDir(path, dir) }) {
+		return slices.ContainsFunc(trackedDataDirs, func(dir string) bool { return withinDir(path, dir) })
+	}
+	return true
+}
+
+// tracksWithin reports whether changes to any path in dir are backed up
+func (s changeStore) tracksWithin(dir string) bool {
+	return s.tracks(dir) || slices.ContainsFunc(trackedDataDirs, func(tracked string) bool {
+		return strings.HasPrefix(tracked, dir+"/")
+	})
+}
+
+func withinDir(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// record backs up path before it is written with data, or removed when data is
+// nil. A write that does not change the file is not recorded.
+func (s changeStore) record(path string, data []byte) error {
+	change, err := s.prepare(path, data)
+	if err != nil || change == nil {
+		return err
+	}
+	return s.add(*change)
+}
+
+// prepare backs up the original content of path and returns the change to add to
+// the manifest, or nil when there is nothing to record
+func (s changeStore) prepare(path string, data []byte) (*fileChange, error) {
+	if changeStep == "" || !s.tracks(path) {
+		return nil, nil
+	}
+
+	change := fileChange{Path: path, Step: changeStep, ChangedAt: time.Now().UTC()}
+	info, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		if data == nil {
+			return nil, nil
+		}
+		change.Action = fileChangeCreate
+	case err != nil:
+		return nil, err
+	case !info.Mode().IsRegular():
+		// Directories and links are not backed up
+		return nil, nil
+	default:
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil && bytes.Equal(original, data) {
+			return nil, nil
+		}
+		if change.Hash, err = s.store(original); err != nil {
+			return nil, err
+		}
+		change.Mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			change.Owner = &fileOwner{UID: int(stat.Uid), GID: int(stat.Gid)}
+		}
+		change.Action = fileChangeModify
+		if data == nil {
+			change.Action = fileChangeRemove
+		}
+	}
+	return &change, nil
+}
+
+// add appends changes to the manifest in a single write
+func (s changeStore) add(changes ...fileChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	recorded, err := s.load()
+	if err != nil {
+		return err
+	}
+	id := 0
+	if len(recorded) > 0 {
+		id = recorded[len(recorded)-1].ID
+	}
+	for _, change := range changes {
+		id++
+		change.ID = id
+		recorded = append(recorded, change)
+	}
+	return s.save(recorded)
+}
+
+// store adds content to the object store and returns its hash
+func (s changeStore) store(content []byte) (string, error) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	path := s.objectPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil
+	}
+
+	// Backups hold keys and password policies, only root may read them
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0600); err != nil {
+		return "", err
+	}
+	return hash, os.Rename(tmp, path)
+}
+
+// restore puts the file of a change back as it was before the change
+func (s changeStore) restore(change fileChange) error {
+	if change.Action == fileChangeCreate {
+		if err := sysExec.Remove(change.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	original, err := os.ReadFile(s.objectPath(change.Hash))
+	if err != nil {
+		return fmt.Errorf("backup of %s is missing: %w", change.Path, err)
+	}
+	if err := sysExec.MkdirAll(filepath.Dir(change.Path), 0755); err != nil {
+		return err
+	}
+	if err := sysExec.WriteFile(change.Path, original, change.Mode); err != nil {
+		return err
+	}
+	// WriteFile only sets the mode of new files, and the owner of none
+	if err := sysExec.Chmod(change.Path, change.Mode); err != nil {
+		return err
+	}
+	if change.Owner != nil {
+		return sysExec.Chown(change.Path, change.Owner.UID, change.Owner.GID)
+	}
+	return nil
+}
+
+func (s changeStore) markRolledBack(id int, at time.Time) error {
+	if flagDryRun {
+		return nil
+	}
+	changes, err := s.load()
+	if err != nil {
+		return err
+	}
+	at = at.UTC()
+	for i := range changes {
+		if changes[i].ID == id {
+			changes[i].RolledBackAt = &at
+		}
+	}
+	return s.save(changes)
+}
+
+func (s changeStore) load() ([]fileChange, error) {
+	data, err := os.ReadFile(s.manifestPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var changes []fileChange
+	if err := json.Unmarshal(data, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (s changeStore) save(changes []fileChange) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Replace the manifest in one step, a partial manifest loses the backups
+	tmp := s.manifestPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.manifestPath())
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestChangeStoreRecordAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	store := changeStore{dir: filepath.Join(dir, "backups")}
+	oldChanges, oldStep := fileChanges, changeStep
+	fileChanges, changeStep = store, "server-hardening"
+	defer func() { fileChanges, changeStep = oldChanges, oldStep }()
+
+	modified := filepath.Join(dir, "login.defs")
+	if err := os.WriteFile(modified, []byte("PASS_MAX_DAYS 99999\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	created := filepath.Join(dir, "pwquality.conf")
+
+	if err := sysExec.WriteFile(modified, []byte("PASS_MAX_DAYS 90\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysExec.WriteFile(modified, []byte("PASS_MAX_DAYS 90\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysExec.WriteFile(created, []byte("minlen = 12\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("recorded %d changes, want 2 (an unchanged write is not a change): %+v", len(changes), changes)
+	}
+	if changes[0].Action != fileChangeModify || changes[0].Mode != 0640 || changes[0].Hash == "" || changes[0].Step != "server-hardening" {
+		t.Errorf("change of an existing file = %+v", changes[0])
+	}
+	if changes[1].Action != fileChangeCreate || changes[1].Hash != "" || changes[1].ID != 2 {
+		t.Errorf("change of a new file = %+v", changes[1])
+	}
+
+	for i := len(changes) - 1; i >= 0; i-- {
+		if err := store.restore(changes[i]); err != nil {
+			t.Fatalf("restore(%d) error = %v", changes[i].ID, err)
+		}
+	}
+	data, err := os.ReadFile(modified)
+	if err != nil || string(data) != "PASS_MAX_DAYS 99999\n" {
+		t.Errorf("restored %s = %q, %v", modified, data, err)
+	}
+	if info, err := os.Stat(modified); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("restored %s should have mode 0640: %v, %v", modified, info, err)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("restore should remove the created %s", created)
+	}
+
+	// The rollback itself is recorded
+	changes, err = store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := []string{}
+	for _, change := range changes {
+		actions = append(actions, change.Action)
+	}
+	if want := []string{fileChangeModify, fileChangeCreate, fileChangeRemove, fileChangeModify}; !slices.Equal(actions, want) {
+		t.Errorf("recorded actions %v, want %v", actions, want)
+	}
+	// Both versions of login.defs and the created file
+	objects, err := os.ReadDir(filepath.Join(store.dir, "objects"))
+	if err != nil || len(objects) != 3 {
+		t.Errorf("object store holds %d objects, want 3: %v", len(objects), err)
+	}
+	if hash, err := store.store([]byte("PASS_MAX_DAYS 99999\n")); err != nil || hash != changes[0].Hash {
+		t.Errorf("store() of known content = %s, %v, want %s", hash, err, changes[0].Hash)
+	}
+
+	if err := store.markRolledBack(1, changes[0].ChangedAt); err != nil {
+		t.Fatal(err)
+	}
+	changes, _ = store.load()
+	if selected := selectFileChanges(changes, "server-hardening", false); len(selected) != 3 || selected[0].ID != 2 {
+		t.Errorf("selectFileChanges() = %+v, want changes 2 to 4", selected)
+	}
+}
+
+func TestChangeStoreRenameAndRemoveAll(t *testing.T) {
+	dir := t.TempDir()
+	store := changeStore{dir: filepath.Join(dir, "backups")}
+	oldChanges, oldStep := fileChanges, changeStep
+	fileChanges, changeStep = store, "node registry remove"
+	defer func() { fileChanges, changeStep = oldChanges, oldStep }()
+
+	source := filepath.Join(dir, "hosts.toml")
+	destination := filepath.Join(dir, "hosts.toml.old")
+	tree := filepath.Join(dir, "certs.d", "harbor.example.com")
+	removed := filepath.Join(tree, "ca.crt")
+	for path, content := range map[string]string{source: "server = \"a\"\n", destination: "server = \"b\"\n"} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(tree, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(removed, []byte("certificate\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sysExec.Rename(source, destination); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysExec.RemoveAll(filepath.Join(dir, "certs.d")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysExec.RemoveAll(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("RemoveAll() of a missing path error = %v", err)
+	}
+
+	changes, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, change := range changes {
+		got[change.Path] = change.Action
+		if change.Owner == nil || change.Owner.UID != os.Getuid() || change.Owner.GID != os.Getgid() {
+			t.Errorf("change of %s should record the owner, got %+v", change.Path, change.Owner)
+		}
+	}
+	want := map[string]string{destination: fileChangeModify, source: fileChangeRemove, removed: fileChangeRemove}
+	if len(changes) != len(want) {
+		t.Errorf("recorded %+v, want %v", changes, want)
+	}
+	for path, action := range want {
+		if got[path] != action {
+			t.Errorf("change of %s = %q, want %q", path, got[path], action)
+		}
+	}
+
+	// Rolling back puts the source, destination and removed tree back
+	for i := len(changes) - 1; i >= 0; i-- {
+		if err := store.restore(changes[i]); err != nil {
+			t.Fatalf("restore(%d) error = %v", changes[i].ID, err)
+		}
+	}
+	for path, content := range map[string]string{source: "server = \"a\"\n", destination: "server = \"b\"\n", removed: "certificate\n"} {
+		if data, err := os.ReadFile(path); err != nil || string(data) != content {
+			t.Errorf("restored %s = %q, %v, want %q", path, data, err, content)
+		}
+	}
+}
+
+func TestChangeStoreTracksWithin(t *testing.T) {
+	store := changeStore{dir: galleyBackupsDir}
+	tests := map[string]bool{
+		k0sDataDir:                        true,
+		k0sDataDir + "/containerd":        false,
+		k0sDataDir + "/manifests":         true,
+		"/run/k0s":                        false,
+		"/etc/containerd/certs.d/ghcr.io": true,
+	}
+	for dir, want := range tests {
+		if got := store.tracksWithin(dir); got != want {
+			t.Errorf("tracksWithin(%q) = %v, want %v", dir, got, want)
+		}
+	}
+}
+
+func TestChangeStoreTracks(t *testing.T) {
+	store := changeStore{dir: galleyBackupsDir}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/etc/ssh/sshd_config", true},
+		{"/etc/pam.d/common-password", true},
+		{galleyProgressFile, false},
+		{galleyBackupsDir + "/manifest.json", false},
+		{k0sDataDir + "/db/member/snap/db", false},
+		{"/run/k0s/status.sock", false},
+		{k0sPKIDir + "/ca.crt", true},
+		{galleyAgentManifestFile, true},
+		{"relative.conf", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := store.tracks(tt.path); got != tt.want {
+				t.Errorf("tracks(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollbackReloads(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{name: "pam only", paths: []string{"/etc/pam.d/common-password", "/etc/login.defs"}, want: nil},
+		{name: "ssh", paths: []string{"/etc/ssh/sshd_config", "/etc/ssh/sshd_config.d/50-galley.conf"}, want: []string{"ssh"}},
+		{
+			name:  "kernel and units",
+			paths: []string{"/etc/sysctl.d/90-galley-k8s.conf", "/etc/systemd/system/galley-firewall.service", "/etc/fstab", "/etc/modules-load.d/galley.conf"},
+			want:  []string{"sysctl", "systemd", "modules"},
+		},
+		{name: "fail2ban", paths: []string{"/etc/fail2ban/jail.local"}, want: []string{"fail2ban"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rollbackReloads(tt.paths); !slices.Equal(got, tt.want) {
+				t.Errorf("rollbackReloads() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
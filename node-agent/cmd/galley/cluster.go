@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	galleyAgentNamespace  = "galley"
+	galleyAgentDeployment = "galley-agent"
+	kubectlTimeout        = 30 * time.Second
+)
+
+// Namespaces whose pods are part of the platform, not of the workloads
+var clusterSystemNamespaces = []string{"kube-system", galleyAgentNamespace}
+
+var (
+	flagClusterOutput string
+	flagClusterToken  string
+)
+
+var clusterCmd = &cobra.Command{
+	Use:   "cluster",
+	Short: "Inspect the Kubernetes cluster",
+}
+
+var clusterStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show an overview of the nodes, control plane and Galley agent",
+	Long: `Shows the state of the cluster as seen from this controller: the nodes with
+their roles, readiness, versions, labels and taints, the health of the control
+plane components, system pods that are not running and the Galley agent.
+
+With --token the nodes are compared with the nodes of the vessel engine in the
+Galley platform, to find nodes that are missing on either side.`,
+	Example: `  galley cluster status
+  galley cluster status --token <node-token> -o json`,
+	Args: cobra.ExactArgs(0),
+	RunE: runClusterStatus,
+}
+
+func init() {
+	clusterStatusCmd.Flags().StringVarP(&flagClusterOutput, "output", "o", "table", "Output format: table or json")
+	clusterStatusCmd.Flags().StringVar(&flagClusterToken, "token", "", "Node token from the Galley web interface, to compare with the platform")
+	clusterCmd.AddCommand(clusterStatusCmd)
+}
+
+// clusterStatus is the overview printed by 'galley cluster status'
+type clusterStatus struct {
+	K0sVersion    string             `json:"k0sVersion,omitempty"`
+	ServerVersion string             `json:"serverVersion,omitempty"`
+	Nodes         []clusterNode      `json:"nodes"`
+	ControlPlane  []componentHealth  `json:"controlPlane"`
+	UnhealthyPods []podProblem       `json:"unhealthyPods"`
+	Agent         *agentStatus       `json:"agent"`
+	Platform      *platformNodeCheck `json:"platform,omitempty"`
+}
+
+// clusterNode is a Kubernetes node
+type clusterNode struct {
+	Name           string            `json:"name"`
+	Roles          []string          `json:"roles"`
+	Ready          bool              `json:"ready"`
+	ReadyMessage   string            `json:"readyMessage,omitempty"`
+	KubeletVersion string            `json:"kubeletVersion"`
+	Runtime        string            `json:"containerRuntime"`
+	Labels         map[string]string `json:"labels"`
+	Taints         []string          `json:"taints"`
+	GalleyNodeID   string            `json:"galleyNodeId,omitempty"`
+}
+
+// componentHealth is the health of a control plane component
+type componentHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message,omitempty"`
+}
+
+// podProblem is a system pod that is not running or not ready
+type podProblem struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Node      string `json:"node,omitempty"`
+	Phase     string `json:"phase"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// agentStatus is the state of the Galley agent deployment
+type agentStatus struct {
+	Installed bool   `json:"installed"`
+	Image     string `json:"image,omitempty"`
+	Replicas  int    `json:"replicas"`
+	Ready     int    `json:"ready"`
+	Message   string `json:"message,omitempty"`
+}
+
+// platformNodeCheck compares the cluster nodes with the nodes in the Galley platform
+type platformNodeCheck struct {
+	Checked    bool              `json:"checked"`
+	Error      string            `json:"error,omitempty"`
+	Mismatches []clusterMismatch `json:"mismatches"`
+}
+
+// clusterMismatch is a node that differs between the cluster and the platform
+type clusterMismatch struct {
+	Node    string `json:"node"`
+	Problem string `json:"problem"`
+}
+
+// kubectlJSON runs a k0s kubectl command and decodes its JSON output
+func kubectlJSON(ctx context.Context, out interface{}, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, kubectlTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "k0s", append([]string{"kubectl"}, args...)...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("kubectl %s: %s", strings.Join(args, " "), firstLine(string(exitErr.Stderr), err))
+		}
+		return fmt.Errorf("kubectl %s: %w", strings.Join(args, " "), err)
+	}
+	if err := json.Unmarshal(output, out); err != nil {
+		return fmt.Errorf("failed to parse kubectl %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// parseClusterNodes reads the nodes of a 'kubectl get nodes -o json' list
+func parseClusterNodes(list kubeList) []clusterNode {
+	var nodes []clusterNode
+	for _, item := range list.Items {
+		var node struct {
+			kubeNode
+			Status struct {
+				Conditions []struct {
+					Type    string `json:"type"`
+					Status  string `json:"status"`
+					Reason  string `json:"reason"`
+					Message string `json:"message"`
+				} `json:"conditions"`
+				NodeInfo struct {
+					KubeletVersion          string `json:"kubeletVersion"`
+					ContainerRuntimeVersion string `json:"containerRuntimeVersion"`
+				} `json:"nodeInfo"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal(item, &node); err != nil {
+			continue
+		}
+
+		result := clusterNode{
+			Name:           node.Metadata.Name,
+			Roles:          []string{},
+			KubeletVersion: node.Status.NodeInfo.KubeletVersion,
+			Runtime:        node.Status.NodeInfo.ContainerRuntimeVersion,
+			Labels:         node.Metadata.Labels,
+			Taints:         []string{},
+			GalleyNodeID:   node.Metadata.Labels[nodeIDLabel],
+			ReadyMessage:   "node has no Ready condition yet",
+		}
+		for label := range node.Metadata.Labels {
+			if role, ok := strings.CutPrefix(label, "node-role.kubernetes.io/"); ok && role != "" {
+				result.Roles = append(result.Roles, role)
+			}
+		}
+		sort.Strings(result.Roles)
+		for _, taint := range node.Spec.Taints {
+			result.Taints = append(result.Taints, taint.String())
+		}
+		for _, condition := range node.Status.Conditions {
+			if condition.Type != "Ready" {
+				continue
+			}
+			result.Ready = condition.Status == "True"
+			result.ReadyMessage = ""
+			if !result.Ready {
+				result.ReadyMessage = condition.Message
+				if result.ReadyMessage == "" {
+					result.ReadyMessage = condition.Reason
+				}
+			}
+		}
+		nodes = append(nodes, result)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+// kubeList is a Kubernetes list with items decoded later
+type kubeList struct {
+	Items []json.RawMessage `json:"items"`
+}
+
+// parseReadyz reads the checks of the API server's /readyz?verbose output, lines
+// like "[+]etcd ok" and "[-]informer-sync failed: reason withheld"
+func parseReadyz(output string) []componentHealth {
+	var checks []componentHealth
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < 4 || line[0] != '[' || line[2] != ']' {
+			continue
+		}
+		name, message, _ := strings.Cut(line[3:], " ")
+		check := componentHealth{Name: "apiserver/" + name, Healthy: line[1] == '+'}
+		if !check.Healthy {
+			check.Message = message
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// leaseHealth reports whether the leader election lease of a component was renewed in time
+func leaseHealth(name string, data []byte, now time.Time) componentHealth {
+	var lease struct {
+		Spec struct {
+			HolderIdentity       string    `json:"holderIdentity"`
+			LeaseDurationSeconds int       `json:"leaseDurationSeconds"`
+			RenewTime            time.Time `json:"renewTime"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return componentHealth{Name: name, Message: fmt.Sprintf("failed to parse lease: %v", err)}
+	}
+
+	holder, _, _ := strings.Cut(lease.Spec.HolderIdentity, "_")
+	duration := time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second
+	if duration == 0 {
+		duration = 15 * time.Second
+	}
+	if age := now.Sub(lease.Spec.RenewTime); age > 2*duration {
+		return componentHealth{Name: name, Message: fmt.Sprintf("no leader, lease of %s expired %s ago", holder, age.Round(time.Second))}
+	}
+	return componentHealth{Name: name, Healthy: true, Message: "leader " + holder}
+}
+
+// unhealthyPods returns the pods that are not running with all containers ready,
+// completed pods of jobs are fine
+func unhealthyPods(list kubeList) []podProblem {
+	problems := []podProblem{}
+	for _, item := range list.Items {
+		var pod struct {
+			Metadata struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec struct {
+				NodeName string `json:"nodeName"`
+			} `json:"spec"`
+			Status struct {
+				Phase             string `json:"phase"`
+				Reason            string `json:"reason"`
+				ContainerStatuses []struct {
+					Name  string `json:"name"`
+					Ready bool   `json:"ready"`
+					State struct {
+						Waiting *struct {
+							Reason string `json:"reason"`
+						} `json:"waiting"`
+						Terminated *struct {
+							Reason string `json:"reason"`
+						} `json:"terminated"`
+					} `json:"state"`
+				} `json:"containerStatuses"`
+			} `json:"status"`
+		}
+		if err := json.Unmarshal(item, &pod); err != nil {
+			continue
+		}
+
+		problem := podProblem{
+			Namespace: pod.Metadata.Namespace,
+			Name:      pod.Metadata.Name,
+			Node:      pod.Spec.NodeName,
+			Phase:     pod.Status.Phase,
+			Reason:    pod.Status.Reason,
+		}
+		switch pod.Status.Phase {
+		case "Succeeded":
+			continue
+		case "Running":
+			healthy := true
+			for _, container := range pod.Status.ContainerStatuses {
+				if container.Ready {
+					continue
+				}
+				healthy = false
+				switch {
+				case container.State.Waiting != nil:
+					problem.Reason = container.Name + ": " + container.State.Waiting.Reason
+				case container.State.Terminated != nil:
+					problem.Reason = container.Name + ": " + container.State.Terminated.Reason
+				default:
+					problem.Reason = container.Name + ": not ready"
+				}
+				break
+			}
+			if healthy {
+				continue
+			}
+		default:
+			for _, container := range pod.Status.ContainerStatuses {
+				if container.State.Waiting != nil && container.State.Waiting.Reason != "" {
+					problem.Reason = container.Name + ": " + container.State.Waiting.Reason
+					break
+				}
+			}
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// parseAgentDeployment reads the state of the Galley agent deployment
+func parseAgentDeployment(data []byte) *agentStatus {
+	var deployment struct {
+		Spec struct {
+			Replicas *int `json:"replicas"`
+			Template struct {
+				Spec struct {
+					Containers []struct {
+						Image string `json:"image"`
+					} `json:"containers"`
+				} `json:"spec"`
+			} `json:"template"`
+		} `json:"spec"`
+		Status struct {
+			ReadyReplicas int `json:"readyReplicas"`
+			Conditions    []struct {
+				Type    string `json:"type"`
+				Status  string `json:"status"`
+				Message string `json:"message"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(data, &deployment); err != nil {
+		return &agentStatus{Message: fmt.Sprintf("failed to parse deployment: %v", err)}
+	}
+
+	status := &agentStatus{Installed: true, Replicas: 1, Ready: deployment.Status.ReadyReplicas}
+	if deployment.Spec.Replicas != nil {
+		status.Replicas = *deployment.Spec.Replicas
+	}
+	if containers := deployment.Spec.Template.Spec.Containers; len(containers) > 0 {
+		status.Image = containers[0].Image
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Status != "True" && condition.Message != "" {
+			status.Message = condition.Message
+		}
+	}
+	return status
+}
+
+// crossReferenceNodes compares the cluster nodes with the nodes of the vessel engine
+// in the platform. Nodes match on the Galley node ID label, or else on the Kubernetes
+// node name the platform name registers as.
+// Controllers without a kubelet are not Kubernetes nodes.
+func crossReferenceNodes(nodes []clusterNode, records []DataResource[VesselEngineNodeAttributes]) []clusterMismatch {
+	mismatches := []clusterMismatch{}
+	matched := map[string]bool{}
+
+	for _, node := range nodes {
+		var record *DataResource[VesselEngineNodeAttributes]
+		for i := range records {
+			if (node.GalleyNodeID != "" && records[i].ID == node.GalleyNodeID) ||
+				(node.GalleyNodeID == "" && kubernetesNodeName(records[i].Attributes.Name) == node.Name) {
+				record = &records[i]
+				break
+			}
+		}
+		if record == nil {
+			problem := "in the cluster but unknown to Galley"
+			if node.GalleyNodeID != "" {
+				problem = fmt.Sprintf("in the cluster but Galley node %s does not exist", node.GalleyNodeID)
+			}
+			mismatches = append(mismatches, clusterMismatch{Node: node.Name, Problem: problem})
+			continue
+		}
+		matched[record.ID] = true
+
+		controlPlane := false
+		for _, role := range node.Roles {
+			if role == "control-plane" {
+				controlPlane = true
+			}
+		}
+		switch nodeType := record.Attributes.NodeType; {
+		case nodeType == "worker" && controlPlane:
+			mismatches = append(mismatches, clusterMismatch{Node: node.Name, Problem: "a worker in Galley but a controller in the cluster"})
+		case nodeType == "controller":
+			mismatches = append(mismatches, clusterMismatch{Node: node.Name, Problem: "a controller without workloads in Galley but it runs a kubelet"})
+		}
+	}
+
+	for _, record := range records {
+		if matched[record.ID] || record.Attributes.NodeType == "controller" {
+			continue
+		}
+		name := record.Attributes.Name
+		if name == "" {
+			name = record.ID
+		}
+		problem := "in Galley but not in the cluster"
+		if record.Attributes.Provisioning {
+			problem += " (still provisioning)"
+		}
+		mismatches = append(mismatches, clusterMismatch{Node: name, Problem: problem})
+	}
+	return mismatches
+}
+
+// gatherClusterStatus collects the cluster overview with kubectl on this controller
+func gatherClusterStatus(ctx context.Context) (*clusterStatus, error) {
+	status := &clusterStatus{ControlPlane: []componentHealth{}}
+
+	var nodes kubeList
+	if err := kubectlJSON(ctx, &nodes, "get", "nodes", "-o", "json"); err != nil {
+		return nil, fmt.Errorf("failed to list nodes (run this on a controller): %w", err)
+	}
+	status.Nodes = parseClusterNodes(nodes)
+	if status.Nodes == nil {
+		status.Nodes = []clusterNode{}
+	}
+
+	if version, err := getK0sVersion(); err == nil {
+		status.K0sVersion = version
+	}
+	var version struct {
+		ServerVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"serverVersion"`
+	}
+	if err := kubectlJSON(ctx, &version, "version", "-o", "json"); err == nil {
+		status.ServerVersion = version.ServerVersion.GitVersion
+	}
+
+	readyzCtx, cancel := context.WithTimeout(ctx, kubectlTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(readyzCtx, "k0s", "kubectl", "get", "--raw=/readyz?verbose").CombinedOutput()
+	if checks := parseReadyz(string(output)); len(checks) > 0 {
+		status.ControlPlane = append(status.ControlPlane, checks...)
+	} else if err != nil {
+		status.ControlPlane = append(status.ControlPlane, componentHealth{Name: "apiserver", Message: firstLine(string(output), err)})
+	}
+	for _, component := range []string{"kube-scheduler", "kube-controller-manager"} {
+		leaseCtx, cancel := context.WithTimeout(ctx, kubectlTimeout)
+		data, err := exec.CommandContext(leaseCtx, "k0s", "kubectl", "-n", "kube-system", "get", "lease", component, "-o", "json").Output()
+		cancel()
+		if err != nil {
+			status.ControlPlane = append(status.ControlPlane, componentHealth{Name: component, Message: "no leader election lease"})
+			continue
+		}
+		status.ControlPlane = append(status.ControlPlane, leaseHealth(component, data, time.Now()))
+	}
+
+	status.UnhealthyPods = []podProblem{}
+	for _, namespace := range clusterSystemNamespaces {
+		var pods kubeList
+		if err := kubectlJSON(ctx, &pods, "-n", namespace, "get", "pods", "-o", "json"); err != nil {
+			return nil, err
+		}
+		status.UnhealthyPods = append(status.UnhealthyPods, unhealthyPods(pods)...)
+	}
+
+	agentCtx, cancelAgent := context.WithTimeout(ctx, kubectlTimeout)
+	defer cancelAgent()
+	data, err := exec.CommandContext(agentCtx, "k0s", "kubectl", "-n", galleyAgentNamespace, "get", "deployment", galleyAgentDeployment, "-o", "json").Output()
+	if err != nil {
+		status.Agent = &agentStatus{Message: "deployment " + galleyAgentNamespace + "/" + galleyAgentDeployment + " not found"}
+	} else {
+		status.Agent = parseAgentDeployment(data)
+	}
+
+	return status, nil
+}
+
+func runClusterStatus(cmd *cobra.Command, args []string) error {
+	if flagClusterOutput != "table" && flagClusterOutput != "json" {
+		return fmt.Errorf("invalid output format %q, expected table or json", flagClusterOutput)
+	}
+
+	status, err := gatherClusterStatus(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	if flagClusterToken != "" {
+		status.Platform = &platformNodeCheck{Mismatches: []clusterMismatch{}}
+		records, err := getGalleyEngineNodes(getPlatformURL(), flagClusterToken)
+		if err != nil {
+			status.Platform.Error = err.Error()
+		} else {
+			status.Platform.Checked = true
+			status.Platform.Mismatches = crossReferenceNodes(status.Nodes, records)
+		}
+	}
+
+	if flagClusterOutput == "json" {
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	printClusterStatus(status)
+	return nil
+}
+
+func printClusterStatus(status *clusterStatus) {
+	fmt.Println(strings.Repeat("=", 70))
+	fmt.Printf("Cluster Status (Kubernetes %s, k0s %s)\n", valueOrDash(status.ServerVersion), valueOrDash(status.K0sVersion))
+	fmt.Println(strings.Repeat("=", 70))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tROLES\tREADY\tKUBELET\tLABELS\tTAINTS")
+	for _, node := range status.Nodes {
+		roles := strings.Join(node.Roles, ",")
+		ready := "Ready"
+		if !node.Ready {
+			ready = "NotReady"
+		}
+		taints := strings.Join(node.Taints, ",")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", node.Name, valueOrDash(roles), ready, node.KubeletVersion, len(node.Labels), valueOrDash(taints))
+	}
+	_ = w.Flush()
+	for _, node := range status.Nodes {
+		if !node.Ready {
+			fmt.Printf("✗ %s is not ready: %s\n", node.Name, node.ReadyMessage)
+		}
+		if status.ServerVersion != "" && node.KubeletVersion != "" && node.KubeletVersion != status.ServerVersion {
+			fmt.Printf("⚠️  %s runs kubelet %s, the API server is %s\n", node.Name, node.KubeletVersion, status.ServerVersion)
+		}
+	}
+
+	fmt.Println("\nControl plane:")
+	unhealthy := 0
+	for _, component := range status.ControlPlane {
+		if !component.Healthy {
+			unhealthy++
+			fmt.Printf("  ✗ %s: %s\n", component.Name, component.Message)
+		} else if !strings.HasPrefix(component.Name, "apiserver/") {
+			fmt.Printf("  ✓ %s (%s)\n", component.Name, component.Message)
+		}
+	}
+	if unhealthy == 0 {
+		fmt.Println("  ✓ API server ready")
+	}
+
+	fmt.Println("\nSystem pods:")
+	if len(status.UnhealthyPods) == 0 {
+		fmt.Printf("  ✓ All pods in %s are running\n", strings.Join(clusterSystemNamespaces, " and "))
+	}
+	for _, pod := range status.UnhealthyPods {
+		fmt.Printf("  ✗ %s/%s on %s: %s %s\n", pod.Namespace, pod.Name, valueOrDash(pod.Node), pod.Phase, pod.Reason)
+	}
+
+	fmt.Println("\nGalley agent:")
+	switch agent := status.Agent; {
+	case !agent.Installed:
+		fmt.Printf("  ✗ %s\n", agent.Message)
+	case agent.Ready < agent.Replicas:
+		fmt.Printf("  ✗ %d/%d ready (%s) %s\n", agent.Ready, agent.Replicas, agent.Image, agent.Message)
+	default:
+		fmt.Printf("  ✓ %d/%d ready (%s)\n", agent.Ready, agent.Replicas, agent.Image)
+	}
+
+	fmt.Println("\nGalley platform:")
+	switch platform := status.Platform; {
+	case platform == nil:
+		fmt.Println("  💡 Compare the nodes with the platform with --token")
+	case !platform.Checked:
+		fmt.Printf("  ✗ Failed to fetch the nodes from the platform: %s\n", platform.Error)
+	case len(platform.Mismatches) == 0:
+		fmt.Println("  ✓ All nodes match the platform")
+	default:
+		for _, mismatch := range platform.Mismatches {
+			fmt.Printf("  ⚠️  %s is %s\n", mismatch.Node, mismatch.Problem)
+		}
+	}
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
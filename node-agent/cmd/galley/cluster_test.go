@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKubeList(t *testing.T, items string) kubeList {
+	t.Helper()
+	var list kubeList
+	if err := json.Unmarshal([]byte(`{"items":[`+items+`]}`), &list); err != nil {
+		t.Fatalf("invalid test list: %v", err)
+	}
+	return list
+}
+
+func TestParseClusterNodes(t *testing.T) {
+	list := testKubeList(t, `
+{"metadata":{"name":"worker-2","labels":{"galley.run/node-id":"n2"}},
+ "spec":{"taints":[{"key":"dedicated","value":"gpu","effect":"NoSchedule"}]},
+ "status":{"conditions":[{"type":"Ready","status":"False","reason":"KubeletNotReady","message":"container runtime network not ready"}],
+  "nodeInfo":{"kubeletVersion":"v1.30.2+k0s","containerRuntimeVersion":"containerd://1.7.18"}}},
+{"metadata":{"name":"controller-1","labels":{"node-role.kubernetes.io/control-plane":"true"}},
+ "status":{"conditions":[{"type":"Ready","status":"True"}],"nodeInfo":{"kubeletVersion":"v1.30.2+k0s"}}}`)
+
+	nodes := parseClusterNodes(list)
+	if len(nodes) != 2 || nodes[0].Name != "controller-1" {
+		t.Fatalf("parseClusterNodes() = %+v, want 2 nodes sorted by name", nodes)
+	}
+
+	controller, worker := nodes[0], nodes[1]
+	if !controller.Ready || len(controller.Roles) != 1 || controller.Roles[0] != "control-plane" {
+		t.Errorf("controller = %+v", controller)
+	}
+	if worker.Ready || worker.ReadyMessage != "container runtime network not ready" {
+		t.Errorf("worker readiness = %v %q", worker.Ready, worker.ReadyMessage)
+	}
+	if worker.GalleyNodeID != "n2" || len(worker.Taints) != 1 || worker.Taints[0] != "dedicated=gpu:NoSchedule" {
+		t.Errorf("worker = %+v", worker)
+	}
+	if worker.KubeletVersion != "v1.30.2+k0s" || worker.Runtime != "containerd://1.7.18" {
+		t.Errorf("worker versions = %s %s", worker.KubeletVersion, worker.Runtime)
+	}
+}
+
+func TestParseReadyz(t *testing.T) {
+	checks := parseReadyz(`[+]ping ok
+[+]etcd ok
+[-]poststarthook/rbac/bootstrap-roles failed: reason withheld
+readyz check failed`)
+
+	if len(checks) != 3 {
+		t.Fatalf("parseReadyz() = %+v, want 3 checks", checks)
+	}
+	if !checks[1].Healthy || checks[1].Name != "apiserver/etcd" {
+		t.Errorf("etcd check = %+v", checks[1])
+	}
+	if checks[2].Healthy || checks[2].Message != "failed: reason withheld" {
+		t.Errorf("failing check = %+v", checks[2])
+	}
+}
+
+func TestLeaseHealth(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	lease := func(renewed time.Time) []byte {
+		return []byte(`{"spec":{"holderIdentity":"controller-1_3f2a","leaseDurationSeconds":15,"renewTime":"` + renewed.Format(time.RFC3339Nano) + `"}}`)
+	}
+
+	if health := leaseHealth("kube-scheduler", lease(now.Add(-5*time.Second)), now); !health.Healthy || health.Message != "leader controller-1" {
+		t.Errorf("fresh lease = %+v", health)
+	}
+	if health := leaseHealth("kube-scheduler", lease(now.Add(-5*time.Minute)), now); health.Healthy {
+		t.Errorf("expired lease = %+v, want unhealthy", health)
+	}
+}
+
+func TestUnhealthyPods(t *testing.T) {
+	list := testKubeList(t, `
+{"metadata":{"name":"coredns-1","namespace":"kube-system"},"spec":{"nodeName":"w1"},
+ "status":{"phase":"Running","containerStatuses":[{"name":"coredns","ready":true,"state":{"running":{}}}]}},
+{"metadata":{"name":"kube-router-1","namespace":"kube-system"},"spec":{"nodeName":"w2"},
+ "status":{"phase":"Running","containerStatuses":[{"name":"kube-router","ready":false,"state":{"waiting":{"reason":"CrashLoopBackOff"}}}]}},
+{"metadata":{"name":"metrics-server-1","namespace":"kube-system"},
+ "status":{"phase":"Pending","reason":"Unschedulable"}},
+{"metadata":{"name":"job-1","namespace":"kube-system"},"status":{"phase":"Succeeded"}}`)
+
+	problems := unhealthyPods(list)
+	if len(problems) != 2 {
+		t.Fatalf("unhealthyPods() = %+v, want 2", problems)
+	}
+	if problems[0].Name != "kube-router-1" || problems[0].Reason != "kube-router: CrashLoopBackOff" {
+		t.Errorf("crashing pod = %+v", problems[0])
+	}
+	if problems[1].Phase != "Pending" || problems[1].Reason != "Unschedulable" {
+		t.Errorf("pending pod = %+v", problems[1])
+	}
+}
+
+func TestParseAgentDeployment(t *testing.T) {
+	agent := parseAgentDeployment([]byte(`{"spec":{"replicas":1,"template":{"spec":{"containers":[{"image":"ghcr.io/galley-run/galley-agent:1.2.0"}]}}},
+"status":{"conditions":[{"type":"Available","status":"False","message":"Deployment does not have minimum availability."}]}}`))
+
+	if !agent.Installed || agent.Ready != 0 || agent.Replicas != 1 || agent.Image != "ghcr.io/galley-run/galley-agent:1.2.0" {
+		t.Errorf("parseAgentDeployment() = %+v", agent)
+	}
+	if !strings.Contains(agent.Message, "minimum availability") {
+		t.Errorf("message = %q", agent.Message)
+	}
+}
+
+func TestCrossReferenceNodes(t *testing.T) {
+	nodes := []clusterNode{
+		{Name: "worker-1", GalleyNodeID: "n1"},
+		{Name: "worker-2"},
+		{Name: "stray"},
+		{Name: "worker-4", GalleyNodeID: "n4", Roles: []string{"control-plane"}},
+		{Name: "worker-5", GalleyNodeID: "gone"},
+		{Name: "web-node-6"},
+	}
+	record := func(id, name, nodeType string) DataResource[VesselEngineNodeAttributes] {
+		return DataResource[VesselEngineNodeAttributes]{ID: id, Attributes: VesselEngineNodeAttributes{Name: name, NodeType: nodeType}}
+	}
+	records := []DataResource[VesselEngineNodeAttributes]{
+		record("n1", "renamed", "worker"),
+		record("n2", "worker-2", "worker"),
+		record("n3", "worker-3", "worker"),
+		record("n4", "worker-4", "worker"),
+		record("n6", "Web Node 6", "worker"),
+		record("c1", "controller-1", "controller"),
+	}
+
+	got := map[string]string{}
+	for _, mismatch := range crossReferenceNodes(nodes, records) {
+		got[mismatch.Node] = mismatch.Problem
+	}
+
+	want := map[string]string{
+		"stray":    "in the cluster but unknown to Galley",
+		"worker-3": "in Galley but not in the cluster",
+		"worker-4": "a worker in Galley but a controller in the cluster",
+		"worker-5": "in the cluster but Galley node gone does not exist",
+	}
+	if len(got) != len(want) {
+		t.Errorf("crossReferenceNodes() = %v, want %v", got, want)
+	}
+	for node, problem := range want {
+		if got[node] != problem {
+			t.Errorf("%s: got %q, want %q", node, got[node], problem)
+		}
+	}
+}
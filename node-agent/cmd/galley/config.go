@@ -15,6 +15,8 @@ type Config struct {
 	ClientURL      string `yaml:"client_url"`
 	VesselEngineId string `yaml:"vessel_engine_id"`
 	NodeType       string `yaml:"node_type"`
+	IPAddress      string `yaml:"ip_address,omitempty"`
+	NodeName       string `yaml:"node_name,omitempty"`
 }
 
 var configCmd = &cobra.Command{
@@ -73,7 +75,8 @@ func loadConfig() (*Config, error) {
 	}
 
 	// If config doesn't exist, create it with defaults
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+	data, err := sysExec.ReadFile(configPath)
+	if os.IsNotExist(err) {
 		defaultConfig := getDefaultConfig()
 		if err := saveConfig(defaultConfig); err != nil {
 			// If we can't save, just return defaults without error
@@ -81,8 +84,6 @@ func loadConfig() (*Config, error) {
 		}
 		return defaultConfig, nil
 	}
-
-	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
@@ -103,7 +104,7 @@ func saveConfig(config *Config) error {
 
 	// Create directory if it doesn't exist
 	configDir := filepath.Dir(configPath)
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+	if err := sysExec.MkdirAll(configDir, 0755); err != nil {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
@@ -112,7 +113,7 @@ func saveConfig(config *Config) error {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := sysExec.WriteFile(configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
 
@@ -140,6 +141,10 @@ func getConfigValue(config *Config, key string) (string, error) {
 		return config.VesselEngineId, nil
 	case "node_type":
 		return config.NodeType, nil
+	case "ip_address":
+		return config.IPAddress, nil
+	case "node_name":
+		return config.NodeName, nil
 	default:
 		return "", fmt.Errorf("unknown config key: %s (available: download_base, platform_url, client_url)", key)
 	}
@@ -158,6 +163,10 @@ func setConfigValue(config *Config, key, value string) error {
 		config.VesselEngineId = value
 	case "node_type":
 		config.NodeType = value
+	case "ip_address":
+		config.IPAddress = value
+	case "node_name":
+		config.NodeName = value
 	default:
 		return fmt.Errorf("unknown config key: %s (available: download_base, platform_url, client_url)", key)
 	}
@@ -213,6 +222,8 @@ func runConfigList(cmd *cobra.Command, args []string) error {
 	fmt.Printf("client_url: %s\n", config.ClientURL)
 	fmt.Printf("vessel_engine_id: %s\n", config.VesselEngineId)
 	fmt.Printf("node_type: %s\n", config.NodeType)
+	fmt.Printf("ip_address: %s\n", config.IPAddress)
+	fmt.Printf("node_name: %s\n", config.NodeName)
 	return nil
 }
 
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const controllerProbeTimeout = 5 * time.Second
+
+// workerControllerPorts are the controller ports a worker connects to
+var workerControllerPorts = []k0sPort{
+	{6443, "Kubernetes API"},
+	{8132, "konnectivity"},
+	{9443, "k0s API"},
+}
+
+// connectivityResult is the outcome of probing one controller port
+type connectivityResult struct {
+	Port        int
+	Description string
+	Stage       string // tcp or tls, where the probe failed
+	Err         error
+}
+
+// checkControllerConnectivity tests TCP reachability and a TLS handshake against the
+// cluster CA for every controller port, using the address in the k0s join token
+func checkControllerConnectivity(ctx context.Context, k0sToken string) (string, []connectivityResult, error) {
+	kubeconfig, err := parseK0sJoinToken(k0sToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	serverURL, err := url.Parse(kubeconfig.server())
+	if err != nil || serverURL.Hostname() == "" {
+		return "", nil, fmt.Errorf("k0s token has an invalid server address %q", kubeconfig.server())
+	}
+	host := serverURL.Hostname()
+
+	caPEM, err := kubeconfig.caCertificate()
+	if err != nil {
+		return "", nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return "", nil, fmt.Errorf("k0s token contains an invalid CA certificate")
+	}
+
+	var results []connectivityResult
+	for _, port := range workerControllerPorts {
+		result := connectivityResult{Port: port.Port, Description: port.Description}
+		result.Stage, result.Err = probeControllerPort(ctx, host, port.Port, pool)
+		results = append(results, result)
+	}
+	return host, results, nil
+}
+
+// probeControllerPort connects to host:port and verifies the TLS certificate against pool.
+// It returns the stage that failed (tcp or tls) with the error.
+func probeControllerPort(ctx context.Context, host string, port int, pool *x509.CertPool) (string, error) {
+	dialer := &net.Dialer{Timeout: controllerProbeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return "tcp", err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(controllerProbeTimeout)); err != nil {
+		return "tcp", err
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		RootCAs:    pool,
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return "tls", err
+	}
+	return "", nil
+}
+
+// verifyControllerConnectivity prints per-port results and fails with firewall
+// guidance when a controller port can't be reached
+func verifyControllerConnectivity(ctx context.Context, k0sToken string) error {
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Println("Controller Connectivity")
+	fmt.Println(strings.Repeat("=", 70))
+
+	host, results, err := checkControllerConnectivity(ctx, k0sToken)
+	if err != nil {
+		return fmt.Errorf("failed to read the controller address from the join token: %w", err)
+	}
+
+	var blocked, untrusted []string
+	for _, result := range results {
+		switch {
+		case result.Err == nil:
+			fmt.Printf("✓ %s:%d (%s) is reachable and trusted\n", host, result.Port, result.Description)
+		case result.Stage == "tcp":
+			fmt.Printf("✗ %s:%d (%s) is unreachable: %v\n", host, result.Port, result.Description, connectivityErrorReason(result.Err))
+			blocked = append(blocked, strconv.Itoa(result.Port))
+		default:
+			fmt.Printf("✗ %s:%d (%s) TLS handshake failed: %v\n", host, result.Port, result.Description, result.Err)
+			untrusted = append(untrusted, strconv.Itoa(result.Port))
+		}
+	}
+
+	logAction("Checked controller connectivity", map[string]string{
+		"controller": host,
+		"blocked":    strings.Join(blocked, ","),
+		"untrusted":  strings.Join(untrusted, ","),
+	})
+
+	if len(blocked) == 0 && len(untrusted) == 0 {
+		return nil
+	}
+
+	if len(blocked) > 0 {
+		fmt.Printf("\n💡 Open TCP ports %s on the controller %s for this node, for example:\n", strings.Join(blocked, ", "), host)
+		for _, port := range blocked {
+			fmt.Printf("   sudo ufw allow from <this node's IP> to any port %s proto tcp\n", port)
+		}
+		fmt.Println("   Also check cloud security groups and network firewalls between the nodes.")
+	}
+	if len(untrusted) > 0 {
+		fmt.Printf("\n💡 Ports %s answered with a certificate not signed by the cluster CA.\n", strings.Join(untrusted, ", "))
+		fmt.Println("   Check for a proxy or another service on the controller address, or create a new token.")
+	}
+
+	return fmt.Errorf("controller %s is not reachable on all required ports, rerun with --skip-connectivity-check to join anyway", host)
+}
+
+// connectivityErrorReason turns dial errors into a short reason
+func connectivityErrorReason(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "connection timed out (likely dropped by a firewall)"
+	case strings.Contains(err.Error(), "connection refused"):
+		return "connection refused (nothing listening or rejected by a firewall)"
+	case strings.Contains(err.Error(), "no route to host"):
+		return "no route to host"
+	}
+	return err.Error()
+}
+
+// controllerRouteIP returns the local address this node reaches the controller of
+// a k0s token from, the address the other controllers see it on
+func controllerRouteIP(k0sToken string) (string, error) {
+	kubeconfig, err := parseK0sJoinToken(k0sToken)
+	if err != nil {
+		return "", err
+	}
+	serverURL, err := url.Parse(kubeconfig.server())
+	if err != nil || serverURL.Hostname() == "" {
+		return "", fmt.Errorf("k0s token has no valid API server address %q", kubeconfig.server())
+	}
+
+	// Connecting a UDP socket sends nothing, it only selects the route
+	conn, err := net.Dial("udp", net.JoinHostPort(serverURL.Hostname(), "6443"))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
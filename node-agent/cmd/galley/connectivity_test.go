@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProbeControllerPort(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	serverURL, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(serverURL.Port())
+
+	trusted := x509.NewCertPool()
+	trusted.AddCert(server.Certificate())
+
+	ctx := context.Background()
+
+	t.Run("trusted", func(t *testing.T) {
+		if stage, err := probeControllerPort(ctx, "127.0.0.1", port, trusted); err != nil {
+			t.Errorf("probeControllerPort() = %s, %v", stage, err)
+		}
+	})
+
+	t.Run("untrusted CA", func(t *testing.T) {
+		other := x509.NewCertPool()
+		other.AppendCertsFromPEM(testCACertificate(t))
+		if stage, err := probeControllerPort(ctx, "127.0.0.1", port, other); err == nil || stage != "tls" {
+			t.Errorf("probeControllerPort() = %s, %v, want a tls failure", stage, err)
+		}
+	})
+
+	t.Run("closed port", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		closedPort := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		if stage, err := probeControllerPort(ctx, "127.0.0.1", closedPort, trusted); err == nil || stage != "tcp" {
+			t.Errorf("probeControllerPort() = %s, %v, want a tcp failure", stage, err)
+		}
+	})
+}
+
+func TestCheckControllerConnectivity(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	host, results, err := checkControllerConnectivity(context.Background(), testK0sToken(t, "https://127.0.0.1:6443", caPEM))
+	if err != nil {
+		t.Fatalf("checkControllerConnectivity() error = %v", err)
+	}
+	if host != "127.0.0.1" {
+		t.Errorf("checkControllerConnectivity() host = %s", host)
+	}
+	if len(results) != len(workerControllerPorts) {
+		t.Fatalf("checkControllerConnectivity() returned %d results", len(results))
+	}
+	for _, result := range results {
+		if result.Description == "" {
+			t.Errorf("result for port %d has no description", result.Port)
+		}
+	}
+
+	if _, _, err := checkControllerConnectivity(context.Background(), "not-a-token"); err == nil {
+		t.Error("checkControllerConnectivity() should reject an invalid k0s token")
+	}
+}
+
+func TestControllerRouteIP(t *testing.T) {
+	ip, err := controllerRouteIP(testK0sToken(t, "https://127.0.0.1:6443", testCACertificate(t)))
+	if err != nil || ip != "127.0.0.1" {
+		t.Errorf("controllerRouteIP() = %q, %v, want 127.0.0.1", ip, err)
+	}
+	if _, err := controllerRouteIP("not-a-token"); err == nil {
+		t.Error("controllerRouteIP() should fail on an invalid k0s token")
+	}
+}
+
+func TestConnectivityErrorReason(t *testing.T) {
+	if got := connectivityErrorReason(errors.New("dial tcp 10.0.0.1:6443: connect: connection refused")); !strings.Contains(got, "refused") {
+		t.Errorf("connectivityErrorReason() = %s", got)
+	}
+	if got := connectivityErrorReason(&net.DNSError{IsTimeout: true}); !strings.Contains(got, "timed out") {
+		t.Errorf("connectivityErrorReason() = %s", got)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os/exec"
@@ -16,6 +18,11 @@ var controllerCmd = &cobra.Command{
 	Short: "Setup and manage the controller node",
 }
 
+var (
+	flagControllerVIP                string
+	flagControllerInviteEnableWorker bool
+)
+
 // extractJWTSubject extracts the subject claim from a JWT token
 func extractJWTSubject(token string) (string, error) {
 	parts := strings.Split(token, ".")
@@ -45,17 +52,31 @@ var controllerJoinCmd = &cobra.Command{
 
 Prerequisites:
   - Node must be prepared first with 'galley node prepare'
-  - Token from Galley web interface
+  - Token from Galley web interface for the first controller, or a token and CA
+    hash (--ca-cert-hash) from 'galley controller invite' to add an additional
+    controller
 
 This command will:
-  - Fetch node configuration from Galley platform
+  - Fetch node configuration from Galley platform (first controller)
+  - Run the 'galley doctor' pre-flight checks
+  - Optionally enable control plane load balancing with a virtual IP (--vip),
+    additional controllers take the VIP and VRRP auth pass from their join token
+  - Register controller+worker nodes with the platform node name, labels and taints
+  - Add worker profiles with kubelet resource reservations per node size
+  - Apply the container registry configuration from the token (additional controllers)
   - Install k0s as a controller
+  - Place the Galley agent manifest in the k0s manifest deployer directory
   - Start the k0s service
   - Generate worker join tokens`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cobraCmd *cobra.Command, args []string) error {
 		token := args[0]
 
+		// Tokens from 'galley controller invite' join an existing control plane
+		if !isPlatformToken(token) {
+			return runAdditionalControllerJoin(cobraCmd.Context(), token)
+		}
+
 		vesselEngineNodeId, err := extractJWTSubject(token)
 		if err != nil {
 			return err
@@ -67,11 +88,6 @@ This command will:
 
 		platformURL := getPlatformURL()
 
-		if flagDryRun {
-			fmt.Printf("[dry-run] controller join %s, platform=%s\n", token, platformURL)
-			return nil
-		}
-
 		// Verify k0s is installed (should be from node prepare)
 		if _, err := exec.LookPath("k0s"); err != nil {
 			return fmt.Errorf("k0s is not installed. Please run 'galley node prepare' first")
@@ -86,7 +102,22 @@ This command will:
 		nodeType := node.Attributes.NodeType
 		vesselEngineId := node.Attributes.VesselEngineID
 
+		if err := runJoinPreflight(cobraCmd.Context(), nodeType); err != nil {
+			return err
+		}
+
+		if err := configureControllerVIP(nil); err != nil {
+			return err
+		}
+
+		if err := configureWorkerProfiles(k0sConfigFile); err != nil {
+			return err
+		}
+
 		config, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load Galley config: %w", err)
+		}
 		if err := setConfigValue(config, "vessel_engine_id", vesselEngineId); err != nil {
 			return fmt.Errorf("failed to save vessel_engine_id %w in Galley config", err)
 		}
@@ -99,16 +130,36 @@ This command will:
 		logAction("NodeType saved in Galley config", map[string]string{
 			"node_type": nodeType,
 		})
+		// Used as the API server address of kubeconfigs created on this node
+		if err := setConfigValue(config, "ip_address", node.Attributes.IPAddress); err != nil {
+			return fmt.Errorf("failed to save ip_address %w in Galley config", err)
+		}
+
+		meta, warnings := nodeMetadataFromAttributes(vesselEngineNodeId, node.Attributes)
+		for _, warning := range warnings {
+			fmt.Printf("⚠️  %s\n", warning)
+		}
+		nodeArgs, err := joinNodeArgs(config, nodeType, meta)
+		if err != nil {
+			return err
+		}
+		if err := saveConfig(config); err != nil {
+			return fmt.Errorf("failed to save Galley config: %w", err)
+		}
 
 		log.Printf("Joining cluster as: %s", nodeType)
 
 		// Install k0s controller
-		if err := installK0sController(nodeType); err != nil {
+		if err := installK0sController(nodeType, "", nodeArgs); err != nil {
 			return fmt.Errorf("failed to install k0s controller: %w", err)
 		}
 
+		if err := installGalleyAgentManifest(vesselEngineId, platformURL); err != nil {
+			return fmt.Errorf("failed to install Galley agent manifest: %w", err)
+		}
+
 		// Start k0s service
-		if err := startK0sService(); err != nil {
+		if err := startK0sService(nodeType, flagReadyTimeout); err != nil {
 			return fmt.Errorf("failed to start k0s service: %w", err)
 		}
 
@@ -122,7 +173,7 @@ This command will:
 			"status":    "success",
 		})
 
-		if err := markGalleyNodeReady(platformURL, vesselEngineNodeId, token); err != nil {
+		if err := markGalleyNodeReady(platformURL, vesselEngineNodeId, token, node.Attributes.IPAddress); err != nil {
 			return fmt.Errorf("failed to mark node as ready in Galley: %w", err)
 		}
 
@@ -136,15 +187,19 @@ This command will:
 
 		if nodeType == "controller" {
 			// Generate worker token and display join instructions
-			workerToken, err := generateWorkerToken(flagInviteExpiry)
-			if err != nil {
+			workerToken, claims, err := createJoinToken(vesselEngineId, "worker", flagInviteExpiry, token, nil)
+			if errors.Is(err, errDryRunToken) {
+				// A dry run has no token to show
+			} else if err != nil {
 				log.Printf("Warning: Failed to generate worker token: %v", err)
 				log.Println("You can manually create a token later with: k0s token create --role worker")
 			} else {
-				displayWorkerJoinInstructions(vesselEngineId, workerToken)
+				displayWorkerJoinInstructions(workerToken, claims)
 			}
 		}
 
+		fmt.Println("💡 For a highly available control plane, add more controllers with: galley controller invite")
+
 		fmt.Printf("\n💡 All actions taken by Galley CLI are logged and can be viewed via: galley logs\n")
 		fmt.Printf("   Log file location: %s\n\n", getLogPath())
 
@@ -152,7 +207,233 @@ This command will:
 	},
 }
 
+var controllerInviteCmd = &cobra.Command{
+	Use:   "invite",
+	Short: "Create join token for additional controllers to join your control plane",
+	Long: `Creates a join token so a new node can join your Galley cluster as an additional controller.
+
+Prerequisites:
+  - This node must be a running controller
+  - The new node must be prepared first with 'galley node prepare'
+
+This command will:
+  - Generate a controller join token with the given expiry, carrying the
+    control plane load balancing settings and platform URL of this controller
+  - Include the node name, labels and taints of the platform node of --node-token
+  - Record the token, and send the records to the Galley platform (with --token)
+  - Show the command to run on the new controller`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		config, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load Galley config: %w", err)
+		}
+
+		if !strings.HasPrefix(config.NodeType, "controller") {
+			return fmt.Errorf("controller invites can only be created on a controller node (node type: %q)", config.NodeType)
+		}
+
+		nodeType := "controller"
+		if flagControllerInviteEnableWorker {
+			nodeType = "controller+worker"
+		}
+
+		cplb, err := readControlPlaneLoadBalancing(k0sConfigFile)
+		if err != nil {
+			log.Printf("Warning: Failed to read control plane load balancing settings: %v", err)
+		}
+		if cplb == nil {
+			fmt.Println("⚠️  Control plane load balancing is not enabled on this controller.")
+			fmt.Println("   Workers will only know the address of the first controller.")
+		}
+
+		var node *joinTokenNode
+		if flagInviteNodeToken != "" {
+			if node, err = fetchJoinTokenNode(getPlatformURL(), flagInviteNodeToken, config.VesselEngineId); err != nil {
+				return err
+			}
+		}
+
+		controllerToken, claims, err := createJoinToken(config.VesselEngineId, nodeType, flagInviteExpiry, flagTokensPlatformToken, node)
+		if errors.Is(err, errDryRunToken) {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Warning: Failed to generate controller token: %v", err)
+			log.Println("You can manually create a token later with: k0s token create --role controller")
+			return nil
+		}
+
+		displayControllerJoinInstructions(controllerToken, claims)
+		return nil
+	},
+}
+
+// isPlatformToken reports whether the token is a Galley platform JWT (as opposed
+// to a controller invite token created by 'galley controller invite')
+func isPlatformToken(token string) bool {
+	return !strings.HasPrefix(token, joinTokenPrefix) && strings.Count(token, ".") == 2
+}
+
+// configureControllerVIP enables control plane load balancing when --vip is set, or
+// with the settings of the control plane an additional controller joins. Those come
+// from its join token, so the shared VRRP auth pass never appears on a command line.
+func configureControllerVIP(shared *cplbSettings) error {
+	settings := cplbSettings{VirtualIP: flagControllerVIP}
+	if shared != nil {
+		if flagControllerVIP != "" && flagControllerVIP != shared.VirtualIP {
+			return fmt.Errorf("--vip %s does not match the virtual IP %s of the control plane", flagControllerVIP, shared.VirtualIP)
+		}
+		settings = *shared
+	}
+	if settings.VirtualIP == "" {
+		return nil
+	}
+
+	if err := configureControlPlaneLoadBalancing(k0sConfigFile, settings); err != nil {
+		return fmt.Errorf("failed to configure control plane load balancing: %w", err)
+	}
+	return nil
+}
+
+// runAdditionalControllerJoin joins this node to an existing control plane
+func runAdditionalControllerJoin(ctx context.Context, token string) error {
+	claims, err := decodeControllerToken(token, flagJoinCACertHash)
+	if err != nil {
+		return err
+	}
+	vesselEngineId, nodeType := claims.VesselEngineId, claims.Role
+
+	logAction("Starting additional controller join", map[string]string{
+		"vessel_engine_id": vesselEngineId,
+		"node_type":        nodeType,
+	})
+
+	// Verify k0s is installed (should be from node prepare)
+	if _, err := exec.LookPath("k0s"); err != nil {
+		return fmt.Errorf("k0s is not installed. Please run 'galley node prepare' first")
+	}
+
+	if err := runJoinPreflight(ctx, nodeType); err != nil {
+		return err
+	}
+
+	if err := configureControllerVIP(claims.CPLB); err != nil {
+		return err
+	}
+
+	// Every controller needs the same worker profiles in its config
+	if err := configureWorkerProfiles(k0sConfigFile); err != nil {
+		return err
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load Galley config: %w", err)
+	}
+	if err := setConfigValue(config, "vessel_engine_id", vesselEngineId); err != nil {
+		return fmt.Errorf("failed to save vessel_engine_id %w in Galley config", err)
+	}
+	if err := setConfigValue(config, "node_type", nodeType); err != nil {
+		return fmt.Errorf("failed to save node_type %w in Galley config", err)
+	}
+	// The token carries the platform of the controller that created it
+	platformURL := joinTokenPlatformURL(claims)
+	if platformURL != getPlatformURL() {
+		if err := setConfigValue(config, "platform_url", platformURL); err != nil {
+			return fmt.Errorf("failed to save platform_url %w in Galley config", err)
+		}
+	}
+	// The platform node of a --node-token knows the public IP address of this node,
+	// otherwise use the address the other controllers reach it on
+	var platformNode *VesselEngineNodeAttributes
+	var nodeID string
+	if flagJoinNodeToken != "" {
+		if nodeID, platformNode, err = fetchPlatformNode(platformURL, flagJoinNodeToken); err != nil {
+			return err
+		}
+	}
+	ipAddress := ""
+	if platformNode != nil {
+		ipAddress = platformNode.IPAddress
+	}
+	if ipAddress == "" {
+		if ipAddress, err = controllerRouteIP(claims.K0sToken); err != nil {
+			fmt.Printf("⚠️  Could not determine the IP address of this controller: %v\n", err)
+		}
+	}
+	// Used as the API server address of kubeconfigs created on this node
+	if err := setConfigValue(config, "ip_address", ipAddress); err != nil {
+		return fmt.Errorf("failed to save ip_address %w in Galley config", err)
+	}
+
+	var nodeArgs []string
+	if nodeType != "controller" {
+		if nodeArgs, err = joinNodeArgs(config, nodeType, joinNodeMetadata(claims, nodeID, platformNode)); err != nil {
+			return err
+		}
+	}
+	if err := saveConfig(config); err != nil {
+		return fmt.Errorf("failed to save Galley config: %w", err)
+	}
+	logAction("Controller settings saved in Galley config", map[string]string{
+		"vessel_engine_id": vesselEngineId,
+		"node_type":        nodeType,
+	})
+
+	if err := applyJoinTokenRegistries(claims); err != nil {
+		return err
+	}
+
+	log.Printf("Joining control plane as: %s", nodeType)
+
+	if err := installK0sController(nodeType, claims.K0sToken, nodeArgs); err != nil {
+		return fmt.Errorf("failed to install k0s controller: %w", err)
+	}
+
+	// Every controller runs the manifest deployer, so each needs the same manifests
+	if err := installGalleyAgentManifest(vesselEngineId, platformURL); err != nil {
+		return fmt.Errorf("failed to install Galley agent manifest: %w", err)
+	}
+
+	if err := startK0sService(nodeType, flagReadyTimeout); err != nil {
+		return fmt.Errorf("failed to start k0s service: %w", err)
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 60))
+	fmt.Println("✓ Controller joined the control plane successfully!")
+	fmt.Printf("Node type: %s\n", nodeType)
+	fmt.Println(strings.Repeat("=", 60))
+
+	logAction("Additional controller join completed", map[string]string{
+		"node_type": nodeType,
+		"status":    "success",
+	})
+
+	if platformNode != nil {
+		if err := markGalleyNodeReady(platformURL, nodeID, flagJoinNodeToken, ipAddress); err != nil {
+			return fmt.Errorf("failed to mark node as ready in Galley: %w", err)
+		}
+		fmt.Println("✓ Controller marked as ready in Galley")
+	}
+
+	fmt.Printf("\n💡 All actions taken by Galley CLI are logged and can be viewed via: galley logs\n")
+	fmt.Printf("   Log file location: %s\n\n", getLogPath())
+
+	return nil
+}
+
 func init() {
 	controllerCmd.AddCommand(controllerJoinCmd)
+	controllerCmd.AddCommand(controllerInviteCmd)
 	controllerJoinCmd.Flags().StringVar(&flagInviteExpiry, "expiry", "1h", "K0s token expiry time, e.g. 10m or 1h")
+	controllerJoinCmd.Flags().DurationVar(&flagReadyTimeout, "ready-timeout", defaultK0sReadyTimeout, "How long to wait for k0s to become ready")
+	controllerJoinCmd.Flags().StringVar(&flagJoinNodeToken, "node-token", "", "Node token from the Galley web interface, reports an additional controller ready with its IP address and sets the node name, labels and taints of a controller+worker")
+	controllerJoinCmd.Flags().StringVar(&flagJoinCACertHash, "ca-cert-hash", "", "Hash of the cluster CA (sha256:<hex>) as shown by 'galley controller invite', for additional controllers")
+	controllerJoinCmd.Flags().BoolVar(&flagSkipDoctor, "skip-doctor", false, "Continue when pre-flight checks fail")
+	controllerJoinCmd.Flags().StringVar(&flagControllerVIP, "vip", "", "Enable control plane load balancing with this virtual IP in CIDR notation, e.g. 10.0.0.100/24")
+	controllerInviteCmd.Flags().StringVar(&flagInviteExpiry, "expiry", "1h", "K0s token expiry time, e.g. 10m or 1h")
+	controllerInviteCmd.Flags().StringVar(&flagTokensPlatformToken, "token", "", "Node token from the Galley web interface, shows the invitation in the platform")
+	controllerInviteCmd.Flags().StringVar(&flagInviteNodeToken, "node-token", "", "Node token from the Galley web interface of the node to invite, adds its name, labels and taints to the token")
+	controllerInviteCmd.Flags().BoolVar(&flagControllerInviteEnableWorker, "enable-worker", false, "Let the new controller also run workloads (controller+worker)")
 }
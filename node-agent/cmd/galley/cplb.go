@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Keepalived only uses the first 8 characters of a VRRP auth pass
+const cplbAuthPassLength = 8
+
+// cplbSettings describes the k0s control plane load balancing (keepalived VIP) setup.
+// Every controller in the cluster must use the same settings.
+type cplbSettings struct {
+	VirtualIP string `json:"vip"` // VIP in CIDR notation, e.g. 10.0.0.100/24
+	AuthPass  string `json:"authPass"`
+}
+
+// address returns the VIP without its prefix length
+func (s cplbSettings) address() string {
+	if ip, _, err := net.ParseCIDR(s.VirtualIP); err == nil {
+		return ip.String()
+	}
+	return s.VirtualIP
+}
+
+func (s cplbSettings) validate() error {
+	if _, _, err := net.ParseCIDR(s.VirtualIP); err != nil {
+		return fmt.Errorf("invalid virtual IP %q, expected CIDR notation like 10.0.0.100/24", s.VirtualIP)
+	}
+	if len(s.AuthPass) > cplbAuthPassLength {
+		return fmt.Errorf("VRRP auth pass must be at most %d characters", cplbAuthPassLength)
+	}
+	return nil
+}
+
+// configureControlPlaneLoadBalancing enables CPLB with a keepalived VIP in the k0s
+// config file. The VRRP auth pass is generated when empty and only ever written to
+// the k0s config, never shown.
+func configureControlPlaneLoadBalancing(configPath string, settings cplbSettings) error {
+	if settings.AuthPass == "" {
+		pass, err := generateCPLBAuthPass()
+		if err != nil {
+			return err
+		}
+		settings.AuthPass = pass
+	}
+	if err := settings.validate(); err != nil {
+		return err
+	}
+
+	content, err := sysExec.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read k0s config: %w", err)
+	}
+
+	updated, err := applyCPLBToK0sConfig(content, settings)
+	if err != nil {
+		return err
+	}
+
+	if err := sysExec.WriteFile(configPath, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write k0s config: %w", err)
+	}
+	logFileWrite(configPath, "Enabled k0s control plane load balancing (VIP "+settings.VirtualIP+")")
+
+	fmt.Printf("✓ Control plane load balancing enabled with virtual IP %s\n", settings.VirtualIP)
+	return nil
+}
+
+// applyCPLBToK0sConfig returns the k0s config with CPLB enabled and the VIP used as
+// the API external address, preserving the order of the existing configuration.
+func applyCPLBToK0sConfig(content []byte, settings cplbSettings) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse k0s config: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("k0s config is empty")
+	}
+
+	root := doc.Content[0]
+	spec := yamlMappingChild(root, "spec")
+	api := yamlMappingChild(spec, "api")
+	network := yamlMappingChild(spec, "network")
+
+	vip := settings.address()
+
+	yamlSetScalar(api, "externalAddress", vip)
+
+	sans := yamlChild(api, "sans")
+	if sans == nil || sans.Kind != yaml.SequenceNode {
+		sans = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		yamlSet(api, "sans", sans)
+	}
+	if !yamlSequenceContains(sans, vip) {
+		sans.Content = append(sans.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: vip})
+	}
+
+	cplb := map[string]interface{}{
+		"enabled": true,
+		"type":    "Keepalived",
+		"keepalived": map[string]interface{}{
+			"vrrpInstances": []map[string]interface{}{
+				{
+					"virtualIPs": []string{settings.VirtualIP},
+					"authPass":   settings.AuthPass,
+				},
+			},
+			"virtualServers": []map[string]interface{}{
+				{"ipAddress": vip},
+			},
+		},
+	}
+	var cplbNode yaml.Node
+	if err := cplbNode.Encode(cplb); err != nil {
+		return nil, fmt.Errorf("failed to encode control plane load balancing config: %w", err)
+	}
+	yamlSet(network, "controlPlaneLoadBalancing", &cplbNode)
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal k0s config: %w", err)
+	}
+	return out, nil
+}
+
+// readControlPlaneLoadBalancing returns the CPLB settings from a k0s config, if enabled
+func readControlPlaneLoadBalancing(configPath string) (*cplbSettings, error) {
+	content, err := sysExec.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Spec struct {
+			Network struct {
+				ControlPlaneLoadBalancing struct {
+					Enabled    bool `yaml:"enabled"`
+					Keepalived struct {
+						VRRPInstances []struct {
+							VirtualIPs []string `yaml:"virtualIPs"`
+							AuthPass   string   `yaml:"authPass"`
+						} `yaml:"vrrpInstances"`
+					} `yaml:"keepalived"`
+				} `yaml:"controlPlaneLoadBalancing"`
+			} `yaml:"network"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse k0s config: %w", err)
+	}
+
+	cplb := config.Spec.Network.ControlPlaneLoadBalancing
+	if !cplb.Enabled || len(cplb.Keepalived.VRRPInstances) == 0 || len(cplb.Keepalived.VRRPInstances[0].VirtualIPs) == 0 {
+		return nil, nil
+	}
+
+	instance := cplb.Keepalived.VRRPInstances[0]
+	return &cplbSettings{
+		VirtualIP: instance.VirtualIPs[0],
+		AuthPass:  instance.AuthPass,
+	}, nil
+}
+
+func generateCPLBAuthPass() (string, error) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	var b strings.Builder
+	for i := 0; i < cplbAuthPassLength; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", fmt.Errorf("failed to generate VRRP auth pass: %w", err)
+		}
+		b.WriteByte(alphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// yamlChild returns the value node for key in a mapping node, or nil
+func yamlChild(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlMappingChild returns the mapping stored under key, creating it when missing
+func yamlMappingChild(mapping *yaml.Node, key string) *yaml.Node {
+	child := yamlChild(mapping, key)
+	if child == nil || child.Kind != yaml.MappingNode {
+		child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		yamlSet(mapping, key, child)
+	}
+	return child
+}
+
+// yamlSet stores value under key in a mapping node, replacing an existing value
+func yamlSet(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		value,
+	)
+}
+
+func yamlSetScalar(mapping *yaml.Node, key, value string) {
+	yamlSet(mapping, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+func yamlSequenceContains(sequence *yaml.Node, value string) bool {
+	for _, item := range sequence.Content {
+		if item.Value == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const dataDiskLabel = "k0s-data"
+
+var (
+	flagDataDisk           string
+	flagDataDiskFilesystem string
+	flagDataDiskForce      bool
+)
+
+// blockDevice is a device in the output of 'lsblk --json'
+type blockDevice struct {
+	Name       string        `json:"name"`
+	Path       string        `json:"path"`
+	Type       string        `json:"type"`
+	FSType     *string       `json:"fstype"`
+	PTType     *string       `json:"pttype"`
+	Mountpoint *string       `json:"mountpoint"`
+	Children   []blockDevice `json:"children"`
+}
+
+// prepareDataDisk partitions and formats a dedicated disk and mounts it at the k0s
+// data directory, so k0s and containerd store their data on it
+func prepareDataDisk(device, filesystem string, force bool) error {
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Println("Data Disk")
+	fmt.Println(strings.Repeat("=", 70))
+
+	if filesystem != "ext4" && filesystem != "xfs" {
+		return fmt.Errorf("unsupported filesystem %q, expected ext4 or xfs", filesystem)
+	}
+	if _, err := exec.LookPath("mkfs." + filesystem); err != nil {
+		return fmt.Errorf("mkfs.%s is not installed", filesystem)
+	}
+
+	info, err := os.Stat(device)
+	if err != nil {
+		return fmt.Errorf("data disk %s not found: %w", device, err)
+	}
+	if info.Mode()&os.ModeDevice == 0 {
+		return fmt.Errorf("%s is not a block device", device)
+	}
+
+	if mounted, err := isMountPoint(k0sDataDir); err != nil {
+		return err
+	} else if mounted {
+		return fmt.Errorf("%s is already a mount point, remove it from /etc/fstab and unmount it first", k0sDataDir)
+	}
+	if entries, err := os.ReadDir(k0sDataDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s already contains data, a data disk can only be added before k0s runs", k0sDataDir)
+	}
+
+	// Links like /dev/disk/by-id/... name partitions differently, work on the kernel device
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", device, err)
+	}
+	disk, err := lsblkDevice(resolved)
+	if err != nil {
+		return err
+	}
+	if disk.Type != "disk" {
+		return fmt.Errorf("%s is a %s, expected a whole disk", device, disk.Type)
+	}
+
+	inUse, contents := inspectDataDisk(disk)
+	if len(inUse) > 0 {
+		return fmt.Errorf("%s is in use (mounted at %s), refusing to format it", device, strings.Join(inUse, ", "))
+	}
+	if len(contents) > 0 && !force {
+		return fmt.Errorf("%s is not empty (%s), use --data-disk-force to erase it", device, strings.Join(contents, ", "))
+	}
+
+	// Check the fstab before anything is erased
+	fstab, err := sysExec.ReadFile(fstabFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", fstabFile, err)
+	}
+	if err := checkFstabMount(string(fstab), k0sDataDir); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	if len(contents) > 0 {
+		fmt.Printf("⚠️  Erasing %s (%s) because of --data-disk-force\n", device, strings.Join(contents, ", "))
+		if err := runCommandWithContext(ctx, "wipefs", "--all", resolved); err != nil {
+			return fmt.Errorf("failed to erase %s: %w", device, err)
+		}
+	}
+
+	// One GPT partition spanning the whole disk
+	if err := sysExec.RunWithInput(ctx, "label: gpt\n,,L\n", "sfdisk", "--quiet", resolved); err != nil {
+		return fmt.Errorf("failed to partition %s: %w", device, err)
+	}
+
+	partition, err := waitForPartition(ctx, resolved)
+	if err != nil {
+		return err
+	}
+
+	mkfsArgs := []string{"-L", dataDiskLabel, partition}
+	if filesystem == "ext4" {
+		mkfsArgs = append([]string{"-F"}, mkfsArgs...)
+	} else {
+		mkfsArgs = append([]string{"-f"}, mkfsArgs...)
+	}
+	if err := runCommandWithContext(ctx, "mkfs."+filesystem, mkfsArgs...); err != nil {
+		return fmt.Errorf("failed to format %s: %w", partition, err)
+	}
+
+	// A dry run formats nothing, the fstab entry shows where the UUID goes
+	uuid := "<UUID of " + partition + ">"
+	if !flagDryRun {
+		uuidOutput, err := exec.Command("blkid", "-s", "UUID", "-o", "value", partition).Output()
+		uuid = strings.TrimSpace(string(uuidOutput))
+		if err != nil || uuid == "" {
+			return fmt.Errorf("failed to read the filesystem UUID of %s", partition)
+		}
+	}
+
+	updated, err := addFstabMount(string(fstab), dataDiskFstabEntry(uuid, filesystem))
+	if err != nil {
+		return err
+	}
+	if err := writeSystemFile(fstabFile, updated, "Mount data disk "+device+" at "+k0sDataDir); err != nil {
+		return err
+	}
+
+	if err := sysExec.MkdirAll(k0sDataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", k0sDataDir, err)
+	}
+	// systemd generates mount units from fstab
+	if err := runCommandWithContext(ctx, "systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+	if err := runCommandWithContext(ctx, "mount", k0sDataDir); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", k0sDataDir, err)
+	}
+	if mounted, err := isMountPoint(k0sDataDir); !flagDryRun && (err != nil || !mounted) {
+		return fmt.Errorf("%s is not mounted after mounting the data disk", k0sDataDir)
+	}
+
+	logAction("Prepared data disk", map[string]string{
+		"device":     device,
+		"partition":  partition,
+		"filesystem": filesystem,
+		"uuid":       uuid,
+		"mountpoint": k0sDataDir,
+	})
+	fmt.Printf("✓ Data disk %s formatted as %s and mounted at %s (UUID=%s)\n", device, filesystem, k0sDataDir, uuid)
+	return nil
+}
+
+// inspectDataDisk returns where the disk or its partitions are mounted, and what
+// it contains (partition table, partitions, filesystems)
+func inspectDataDisk(disk blockDevice) ([]string, []string) {
+	var inUse, contents []string
+	if disk.PTType != nil && *disk.PTType != "" {
+		contents = append(contents, *disk.PTType+" partition table")
+	}
+
+	var walk func(device blockDevice)
+	walk = func(device blockDevice) {
+		if device.Mountpoint != nil && *device.Mountpoint != "" {
+			inUse = append(inUse, *device.Mountpoint)
+		}
+		if device.FSType != nil && *device.FSType != "" {
+			contents = append(contents, fmt.Sprintf("%s filesystem on %s", *device.FSType, device.Name))
+		}
+		for _, child := range device.Children {
+			if child.Type == "part" {
+				contents = append(contents, "partition "+child.Name)
+			}
+			walk(child)
+		}
+	}
+	walk(disk)
+	return inUse, contents
+}
+
+// lsblkDevice returns a block device with its partitions
+func lsblkDevice(device string) (blockDevice, error) {
+	output, err := exec.Command("lsblk", "--json", "-o", "NAME,PATH,TYPE,FSTYPE,PTTYPE,MOUNTPOINT", device).Output()
+	if err != nil {
+		return blockDevice{}, fmt.Errorf("failed to inspect %s: %w", device, err)
+	}
+	var lsblk struct {
+		BlockDevices []blockDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(output, &lsblk); err != nil || len(lsblk.BlockDevices) != 1 {
+		return blockDevice{}, fmt.Errorf("failed to parse lsblk output for %s", device)
+	}
+	return lsblk.BlockDevices[0], nil
+}
+
+// firstPartition returns the path of the first partition of a disk, or an empty
+// string when it has none
+func firstPartition(disk blockDevice) string {
+	for _, child := range disk.Children {
+		if child.Type == "part" && child.Path != "" {
+			return child.Path
+		}
+	}
+	return ""
+}
+
+// partitionPath returns the kernel device path of a partition, disks whose name
+// ends in a digit (nvme0n1, mmcblk0) use a p separator. Only used to show the
+// partition in a dry run, a real run asks lsblk.
+func partitionPath(device string, number int) string {
+	if last := rune(device[len(device)-1]); unicode.IsDigit(last) {
+		return fmt.Sprintf("%sp%d", device, number)
+	}
+	return fmt.Sprintf("%s%d", device, number)
+}
+
+func dataDiskFstabEntry(uuid, filesystem string) string {
+	pass := "2"
+	if filesystem == "xfs" {
+		// xfs is checked at mount time, fsck.xfs does nothing
+		pass = "0"
+	}
+	return fmt.Sprintf("UUID=%s %s %s defaults,noatime 0 %s", uuid, k0sDataDir, filesystem, pass)
+}
+
+// checkFstabMount fails when the mount point already has an fstab entry
+func checkFstabMount(content, mountpoint string) error {
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && !strings.HasPrefix(fields[0], "#") && fields[1] == mountpoint {
+			return fmt.Errorf("%s already has an entry for %s: %s", fstabFile, mountpoint, strings.TrimSpace(line))
+		}
+	}
+	return nil
+}
+
+// addFstabMount appends an fstab entry, refusing when its mount point already has one
+func addFstabMount(content, entry string) (string, error) {
+	if err := checkFstabMount(content, strings.Fields(entry)[1]); err != nil {
+		return "", err
+	}
+
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + "# Galley data disk\n" + entry + "\n", nil
+}
+
+// isMountPoint reports whether path is the mount point of a filesystem
+func isMountPoint(path string) (bool, error) {
+	err := exec.Command("findmnt", "--mountpoint", path).Run()
+	if err == nil {
+		return true, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check mount point %s: %w", path, err)
+}
+
+// waitForPartition waits until udev created the device node of the new partition
+// of a disk and returns its path
+func waitForPartition(ctx context.Context, device string) (string, error) {
+	if flagDryRun {
+		return partitionPath(device, 1), nil
+	}
+	_ = exec.CommandContext(ctx, "udevadm", "settle").Run()
+	for i := 0; i < 20; i++ {
+		if disk, err := lsblkDevice(device); err == nil {
+			if partition := firstPartition(disk); partition != "" {
+				if _, err := os.Stat(partition); err == nil {
+					return partition, nil
+				}
+			}
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return "", fmt.Errorf("the partition of %s did not appear", device)
+}
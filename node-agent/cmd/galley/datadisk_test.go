@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestInspectDataDisk(t *testing.T) {
+	tests := []struct {
+		name         string
+		lsblk        string
+		wantInUse    int
+		wantContents int
+	}{
+		{
+			name:  "empty disk",
+			lsblk: `{"name":"nvme0n1","path":"/dev/nvme0n1","type":"disk","fstype":null,"pttype":null,"mountpoint":null}`,
+		},
+		{
+			name:         "filesystem on the whole disk",
+			lsblk:        `{"name":"sdb","path":"/dev/sdb","type":"disk","fstype":"xfs","pttype":null,"mountpoint":null}`,
+			wantContents: 1,
+		},
+		{
+			name: "partitioned disk",
+			lsblk: `{"name":"sdb","path":"/dev/sdb","type":"disk","fstype":null,"pttype":"gpt","mountpoint":null,"children":[
+				{"name":"sdb1","path":"/dev/sdb1","type":"part","fstype":"ext4","pttype":"gpt","mountpoint":null}]}`,
+			wantContents: 3,
+		},
+		{
+			name: "mounted partition",
+			lsblk: `{"name":"sda","path":"/dev/sda","type":"disk","fstype":null,"pttype":"gpt","mountpoint":null,"children":[
+				{"name":"sda1","path":"/dev/sda1","type":"part","fstype":"ext4","pttype":"gpt","mountpoint":"/"}]}`,
+			wantInUse:    1,
+			wantContents: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var disk blockDevice
+			if err := json.Unmarshal([]byte(tt.lsblk), &disk); err != nil {
+				t.Fatalf("invalid test data: %v", err)
+			}
+			inUse, contents := inspectDataDisk(disk)
+			if len(inUse) != tt.wantInUse || len(contents) != tt.wantContents {
+				t.Errorf("inspectDataDisk() = %v, %v", inUse, contents)
+			}
+		})
+	}
+}
+
+func TestPartitionPath(t *testing.T) {
+	tests := map[string]string{
+		"/dev/sdb":     "/dev/sdb1",
+		"/dev/vdc":     "/dev/vdc1",
+		"/dev/nvme0n1": "/dev/nvme0n1p1",
+		"/dev/mmcblk0": "/dev/mmcblk0p1",
+	}
+	for device, want := range tests {
+		if got := partitionPath(device, 1); got != want {
+			t.Errorf("partitionPath(%s) = %s, want %s", device, got, want)
+		}
+	}
+}
+
+func TestFirstPartition(t *testing.T) {
+	var disk blockDevice
+	lsblk := `{"name":"nvme1n1","path":"/dev/nvme1n1","type":"disk","pttype":"gpt","children":[
+		{"name":"nvme1n1p1","path":"/dev/nvme1n1p1","type":"part"}
+	]}`
+	if err := json.Unmarshal([]byte(lsblk), &disk); err != nil {
+		t.Fatal(err)
+	}
+	if got := firstPartition(disk); got != "/dev/nvme1n1p1" {
+		t.Errorf("firstPartition() = %q, want /dev/nvme1n1p1", got)
+	}
+	if got := firstPartition(blockDevice{Name: "sdb", Type: "disk"}); got != "" {
+		t.Errorf("firstPartition() of a disk without partitions = %q", got)
+	}
+}
+
+func TestAddFstabMount(t *testing.T) {
+	fstab := "UUID=1234 / ext4 defaults 0 1"
+	entry := dataDiskFstabEntry("abcd", "xfs")
+	if entry != "UUID=abcd /var/lib/k0s xfs defaults,noatime 0 0" {
+		t.Errorf("dataDiskFstabEntry() = %s", entry)
+	}
+
+	updated, err := addFstabMount(fstab, entry)
+	if err != nil {
+		t.Fatalf("addFstabMount() failed: %v", err)
+	}
+	if !strings.HasPrefix(updated, fstab+"\n") || !strings.HasSuffix(updated, entry+"\n") {
+		t.Errorf("addFstabMount() = %q", updated)
+	}
+
+	if _, err := addFstabMount(updated, dataDiskFstabEntry("efgh", "ext4")); err == nil {
+		t.Error("addFstabMount() should refuse a second entry for the same mount point")
+	}
+	if err := checkFstabMount("#UUID=1234 /var/lib/k0s ext4 defaults 0 2\n", k0sDataDir); err != nil {
+		t.Errorf("checkFstabMount() should ignore commented entries: %v", err)
+	}
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/spf13/cobra"
+)
+
+const (
+	doctorPass = "pass"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+
+	doctorCheckTimeout = 10 * time.Second
+
+	// k0s needs room for images, etcd and container logs
+	doctorDiskFailBytes = 2 << 30
+	doctorDiskWarnBytes = 20 << 30
+)
+
+var (
+	flagDoctorRole   string
+	flagDoctorOutput string
+	flagSkipDoctor   bool
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check whether this node is ready for and healthy in your cluster",
+	Long: `Runs pre-flight and health checks for this node: kernel and modules, cgroups,
+swap, time sync, DNS, disk space, ports, 'k0s sysinfo', the k0s service and
+Galley platform reachability.
+
+Every check reports pass, warn or fail with a hint on how to fix it. The same
+checks run automatically at the start of 'galley controller join' and
+'galley worker join'.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		if flagDoctorOutput != "text" && flagDoctorOutput != "json" {
+			return fmt.Errorf("invalid output format %q, expected text or json", flagDoctorOutput)
+		}
+
+		role := flagDoctorRole
+		if role == "" {
+			if config, err := loadConfig(); err == nil && config.NodeType != "" {
+				role = config.NodeType
+			} else {
+				role = "worker"
+			}
+		}
+
+		results := runDoctorChecks(cobraCmd.Context(), role)
+
+		if flagDoctorOutput == "json" {
+			data, err := json.MarshalIndent(results, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+		} else {
+			printDoctorResults(role, results)
+		}
+
+		if countDoctorResults(results, doctorFail) > 0 {
+			// A failed check is not a usage error
+			cobraCmd.SilenceUsage = true
+			return fmt.Errorf("%d doctor checks failed", countDoctorResults(results, doctorFail))
+		}
+		return nil
+	},
+}
+
+func init() {
+	doctorCmd.Flags().StringVar(&flagDoctorRole, "role", "", "Node role to check for: controller, controller+worker or worker (default: from Galley config)")
+	doctorCmd.Flags().StringVarP(&flagDoctorOutput, "output", "o", "text", "Output format: text or json")
+}
+
+// doctorResult is the outcome of a single doctor check
+type doctorResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // pass, warn or fail
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+type doctorCheck struct {
+	name string
+	run  func(ctx context.Context) doctorResult
+}
+
+// k0sPort is a port k0s needs on a node
+type k0sPort struct {
+	Port        int
+	Description string
+}
+
+// k0sRequiredPorts returns the TCP ports k0s listens on for the given node type
+func k0sRequiredPorts(nodeType string) []k0sPort {
+	var ports []k0sPort
+	if strings.HasPrefix(nodeType, "controller") {
+		ports = append(ports,
+			k0sPort{2380, "etcd peers"},
+			k0sPort{6443, "Kubernetes API"},
+			k0sPort{8132, "konnectivity"},
+			k0sPort{9443, "k0s API"},
+		)
+	}
+	if nodeType == "worker" || nodeType == "controller+worker" {
+		ports = append(ports,
+			k0sPort{179, "kube-router BGP"},
+			k0sPort{10250, "kubelet"},
+		)
+	}
+	return ports
+}
+
+// runJoinPreflight runs the doctor checks before joining and stops on failures
+// unless --skip-doctor is set
+func runJoinPreflight(ctx context.Context, nodeType string) error {
+	results := runDoctorChecks(ctx, nodeType)
+	printDoctorResults(nodeType, results)
+
+	failed := countDoctorResults(results, doctorFail)
+	logAction("Join pre-flight checks completed", map[string]string{
+		"node_type": nodeType,
+		"failed":    strconv.Itoa(failed),
+		"warnings":  strconv.Itoa(countDoctorResults(results, doctorWarn)),
+	})
+
+	if failed == 0 {
+		return nil
+	}
+	if flagSkipDoctor {
+		fmt.Printf("⚠️  %d pre-flight checks failed, continuing because --skip-doctor is set\n", failed)
+		return nil
+	}
+	return fmt.Errorf("%d pre-flight checks failed, fix them or rerun with --skip-doctor", failed)
+}
+
+func runDoctorChecks(ctx context.Context, nodeType string) []doctorResult {
+	var results []doctorResult
+	for _, check := range doctorChecks(nodeType) {
+		checkCtx, cancel := context.WithTimeout(ctx, doctorCheckTimeout)
+		result := check.run(checkCtx)
+		cancel()
+		result.Name = check.name
+		results = append(results, result)
+	}
+	return results
+}
+
+func doctorChecks(nodeType string) []doctorCheck {
+	joined := isK0sServiceActive(nodeType)
+
+	return []doctorCheck{
+		{name: "kernel version", run: func(ctx context.Context) doctorResult {
+			release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+			if err != nil {
+				return doctorResult{Status: doctorWarn, Message: "could not read the kernel version: " + err.Error()}
+			}
+			return evaluateKernelVersion(strings.TrimSpace(string(release)))
+		}},
+		{name: "kernel modules", run: func(ctx context.Context) doctorResult {
+			var missing []string
+			for _, module := range []string{"overlay", "br_netfilter"} {
+				if !kernelModuleAvailable(module) {
+					missing = append(missing, module)
+				}
+			}
+			if len(missing) > 0 {
+				return doctorResult{
+					Status:  doctorFail,
+					Message: "not loaded: " + strings.Join(missing, ", "),
+					Hint:    "Load them with 'modprobe " + strings.Join(missing, " && modprobe ") + "' and add them to /etc/modules-load.d",
+				}
+			}
+			return doctorResult{Status: doctorPass, Message: "overlay and br_netfilter are available"}
+		}},
+		{name: "cgroup v2", run: func(ctx context.Context) doctorResult {
+			if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+				return doctorResult{
+					Status:  doctorWarn,
+					Message: "the unified cgroup v2 hierarchy is not mounted",
+					Hint:    "Boot with systemd.unified_cgroup_hierarchy=1, cgroup v1 support is deprecated in Kubernetes",
+				}
+			}
+			return doctorResult{Status: doctorPass, Message: "cgroup v2 is enabled"}
+		}},
+		{name: "swap", run: func(ctx context.Context) doctorResult {
+			swaps, err := os.ReadFile("/proc/swaps")
+			if err != nil {
+				return doctorResult{Status: doctorWarn, Message: "could not read /proc/swaps: " + err.Error()}
+			}
+			return evaluateSwaps(string(swaps), nodeType)
+		}},
+		{name: "time sync", run: func(ctx context.Context) doctorResult {
+			output, err := exec.CommandContext(ctx, "timedatectl", "show", "--property=NTPSynchronized", "--value").Output()
+			if err != nil {
+				return doctorResult{Status: doctorWarn, Message: "could not determine the time sync status", Hint: "Make sure chrony or systemd-timesyncd keeps the clock in sync"}
+			}
+			if strings.TrimSpace(string(output)) != "yes" {
+				return doctorResult{
+					Status:  doctorWarn,
+					Message: "the system clock is not synchronized",
+					Hint:    "Enable time sync with 'timedatectl set-ntp true', clock skew breaks certificates and etcd",
+				}
+			}
+			return doctorResult{Status: doctorPass, Message: "the system clock is synchronized"}
+		}},
+		{name: "platform DNS", run: func(ctx context.Context) doctorResult {
+			host := platformHost(getPlatformURL())
+			addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+			if err != nil || len(addrs) == 0 {
+				return doctorResult{Status: doctorFail, Message: "could not resolve " + host, Hint: "Check /etc/resolv.conf and your DNS servers"}
+			}
+			return doctorResult{Status: doctorPass, Message: host + " resolves to " + strings.Join(addrs, ", ")}
+		}},
+		{name: "platform reachability", run: func(ctx context.Context) doctorResult {
+			host := platformHost(getPlatformURL())
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/", nil)
+			if err != nil {
+				return doctorResult{Status: doctorFail, Message: err.Error()}
+			}
+			req.Header.Set("User-Agent", "Galley Node Agent")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				status := doctorFail
+				if nodeType == "worker" {
+					status = doctorWarn
+				}
+				return doctorResult{Status: status, Message: "could not reach https://" + host + ": " + err.Error(), Hint: "Allow outgoing HTTPS (443) to the Galley platform"}
+			}
+			resp.Body.Close()
+			return doctorResult{Status: doctorPass, Message: "https://" + host + " is reachable"}
+		}},
+		{name: "disk space", run: func(ctx context.Context) doctorResult {
+			path := existingParent(k0sDataDir)
+			usage, err := disk.Usage(path)
+			if err != nil {
+				return doctorResult{Status: doctorWarn, Message: "could not determine free disk space: " + err.Error()}
+			}
+			return evaluateDiskFree(k0sDataDir, usage.Free)
+		}},
+		{name: "ports", run: func(ctx context.Context) doctorResult {
+			if joined {
+				return doctorResult{Status: doctorPass, Message: "k0s is running and owns its ports"}
+			}
+			var inUse []string
+			for _, port := range k0sRequiredPorts(nodeType) {
+				listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port.Port))
+				if err != nil {
+					inUse = append(inUse, fmt.Sprintf("%d (%s)", port.Port, port.Description))
+					continue
+				}
+				listener.Close()
+			}
+			if len(inUse) > 0 {
+				return doctorResult{
+					Status:  doctorFail,
+					Message: "already in use: " + strings.Join(inUse, ", "),
+					Hint:    "Stop the services using these ports, 'ss -tlnp' shows which process owns them",
+				}
+			}
+			return doctorResult{Status: doctorPass, Message: "all ports k0s needs are free"}
+		}},
+		{name: "k0s sysinfo", run: func(ctx context.Context) doctorResult {
+			if _, err := exec.LookPath("k0s"); err != nil {
+				return doctorResult{Status: doctorWarn, Message: "k0s is not installed", Hint: "Run 'galley node prepare' first"}
+			}
+			// k0s sysinfo exits non-zero when a requirement is rejected, the output tells which
+			output, _ := exec.CommandContext(ctx, "k0s", "sysinfo").CombinedOutput()
+			return evaluateK0sSysinfo(string(output))
+		}},
+		{name: "k0s service", run: func(ctx context.Context) doctorResult {
+			service := k0sServiceName(nodeType)
+			if joined {
+				return doctorResult{Status: doctorPass, Message: service + " is active"}
+			}
+			if _, err := os.Stat("/etc/systemd/system/" + service + ".service"); err != nil {
+				return doctorResult{Status: doctorPass, Message: service + " is not installed yet"}
+			}
+			return doctorResult{
+				Status:  doctorFail,
+				Message: service + " is installed but not active",
+				Hint:    "Check 'journalctl -u " + service + "' and start it with 'systemctl start " + service + "'",
+			}
+		}},
+	}
+}
+
+func printDoctorResults(nodeType string, results []doctorResult) {
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Printf("Galley Doctor (%s)\n", nodeType)
+	fmt.Println(strings.Repeat("=", 70))
+
+	for _, result := range results {
+		icon := "✓"
+		switch result.Status {
+		case doctorWarn:
+			icon = "⚠️ "
+		case doctorFail:
+			icon = "✗"
+		}
+		fmt.Printf("%s %s: %s\n", icon, result.Name, result.Message)
+		if result.Hint != "" && result.Status != doctorPass {
+			fmt.Printf("   💡 %s\n", result.Hint)
+		}
+	}
+
+	fmt.Println(strings.Repeat("-", 70))
+	fmt.Printf("%d passed, %d warnings, %d failed\n",
+		countDoctorResults(results, doctorPass), countDoctorResults(results, doctorWarn), countDoctorResults(results, doctorFail))
+	fmt.Println(strings.Repeat("=", 70))
+}
+
+func countDoctorResults(results []doctorResult, status string) int {
+	count := 0
+	for _, result := range results {
+		if result.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+// evaluateKernelVersion checks a kernel release like 5.15.0-91-generic
+func evaluateKernelVersion(release string) doctorResult {
+	major, minor, ok := parseKernelVersion(release)
+	switch {
+	case !ok:
+		return doctorResult{Status: doctorWarn, Message: "could not parse kernel version " + release}
+	case major < 3 || (major == 3 && minor < 10):
+		return doctorResult{Status: doctorFail, Message: "kernel " + release + " is too old, k0s needs 3.10 or newer", Hint: "Upgrade to a supported distribution release"}
+	case major < 4 || (major == 4 && minor < 19):
+		return doctorResult{Status: doctorWarn, Message: "kernel " + release + " is old, 4.19 or newer is recommended", Hint: "Upgrade the kernel for cgroup v2 and eBPF support"}
+	}
+	return doctorResult{Status: doctorPass, Message: "kernel " + release}
+}
+
+func parseKernelVersion(release string) (int, int, bool) {
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minorDigits := strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' })
+	minor, err := strconv.Atoi(minorDigits)
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// nodeRunsKubelet reports whether a node of this type runs a kubelet. A node that
+// has not joined yet may become a worker, so it counts as one.
+func nodeRunsKubelet(nodeType string) bool {
+	return nodeType != "controller"
+}
+
+// evaluateSwaps checks the contents of /proc/swaps, kubelet refuses to run with swap
+// by default. Controllers without a worker run no kubelet, so swap is fine there.
+func evaluateSwaps(content, nodeType string) doctorResult {
+	var devices []string
+	for i, line := range strings.Split(strings.TrimSpace(content), "\n") {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) == 0 {
+			continue
+		}
+		devices = append(devices, fields[0])
+	}
+	if len(devices) > 0 && !nodeRunsKubelet(nodeType) {
+		return doctorResult{Status: doctorPass, Message: "swap is enabled on " + strings.Join(devices, ", ") + ", a controller runs no kubelet"}
+	}
+	if len(devices) > 0 {
+		return doctorResult{
+			Status:  doctorFail,
+			Message: "swap is enabled on " + strings.Join(devices, ", "),
+			Hint:    "Disable it with 'swapoff -a' and remove swap entries from /etc/fstab",
+		}
+	}
+	return doctorResult{Status: doctorPass, Message: "swap is disabled"}
+}
+
+func evaluateDiskFree(path string, free uint64) doctorResult {
+	message := fmt.Sprintf("%.1f GiB free for %s", float64(free)/(1<<30), path)
+	switch {
+	case free < doctorDiskFailBytes:
+		return doctorResult{Status: doctorFail, Message: message, Hint: "Free up disk space or mount a data disk on " + path}
+	case free < doctorDiskWarnBytes:
+		return doctorResult{Status: doctorWarn, Message: message, Hint: "At least 20 GiB is recommended for images and logs"}
+	}
+	return doctorResult{Status: doctorPass, Message: message}
+}
+
+var k0sSysinfoStatus = regexp.MustCompile(`^\s*(.+?)\s*\((pass|warning|rejected|error)\)\s*$`)
+
+// evaluateK0sSysinfo summarises the output of 'k0s sysinfo'
+func evaluateK0sSysinfo(output string) doctorResult {
+	var rejected, warnings []string
+	for _, line := range strings.Split(output, "\n") {
+		match := k0sSysinfoStatus.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		switch match[2] {
+		case "rejected", "error":
+			rejected = append(rejected, match[1])
+		case "warning":
+			warnings = append(warnings, match[1])
+		}
+	}
+
+	switch {
+	case len(rejected) > 0:
+		return doctorResult{Status: doctorFail, Message: "rejected: " + strings.Join(rejected, "; "), Hint: "Run 'k0s sysinfo' for details"}
+	case len(warnings) > 0:
+		return doctorResult{Status: doctorWarn, Message: "warnings: " + strings.Join(warnings, "; "), Hint: "Run 'k0s sysinfo' for details"}
+	case strings.TrimSpace(output) == "":
+		return doctorResult{Status: doctorWarn, Message: "k0s sysinfo returned no output"}
+	}
+	return doctorResult{Status: doctorPass, Message: "all k0s system requirements are met"}
+}
+
+// kernelModuleAvailable reports whether a module is loaded or built into the kernel
+func kernelModuleAvailable(module string) bool {
+	if _, err := os.Stat("/sys/module/" + module); err == nil {
+		return true
+	}
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return false
+	}
+	builtin, err := os.ReadFile(filepath.Join("/lib/modules", strings.TrimSpace(string(release)), "modules.builtin"))
+	if err != nil {
+		return false
+	}
+	return moduleListed(string(builtin), module)
+}
+
+// moduleListed reports whether a modules.builtin list contains the module
+func moduleListed(list, module string) bool {
+	for _, line := range strings.Split(list, "\n") {
+		name := strings.TrimSuffix(filepath.Base(strings.TrimSpace(line)), ".ko")
+		if strings.ReplaceAll(name, "-", "_") == module {
+			return true
+		}
+	}
+	return false
+}
+
+func isK0sServiceActive(nodeType string) bool {
+	return exec.Command("systemctl", "is-active", "--quiet", k0sServiceName(nodeType)).Run() == nil
+}
+
+// platformHost returns the host name of a platform URL like api.galley.run or https://api.galley.run/
+func platformHost(platformURL string) string {
+	host := platformURL
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.IndexAny(host, "/?"); i >= 0 {
+		host = host[:i]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host
+}
+
+// existingParent returns path or its closest existing parent directory
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
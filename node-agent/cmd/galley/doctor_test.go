@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEvaluateKernelVersion(t *testing.T) {
+	tests := []struct {
+		release string
+		want    string
+	}{
+		{release: "6.8.0-45-generic", want: doctorPass},
+		{release: "5.15.0-91-generic", want: doctorPass},
+		{release: "4.19.0-26-amd64", want: doctorPass},
+		{release: "4.18.0-553.el8_10.x86_64", want: doctorWarn},
+		{release: "3.10.0-1160.el7.x86_64", want: doctorWarn},
+		{release: "2.6.32", want: doctorFail},
+		{release: "garbage", want: doctorWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.release, func(t *testing.T) {
+			if got := evaluateKernelVersion(tt.release); got.Status != tt.want {
+				t.Errorf("evaluateKernelVersion(%s) = %+v, want %s", tt.release, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSwaps(t *testing.T) {
+	header := "Filename\t\t\t\tType\t\tSize\t\tUsed\t\tPriority\n"
+
+	if got := evaluateSwaps(header, "worker"); got.Status != doctorPass {
+		t.Errorf("evaluateSwaps(no swap) = %+v", got)
+	}
+
+	swapFile := header + "/swap.img\tfile\t\t4194300\t\t0\t\t-2\n"
+	for _, nodeType := range []string{"worker", "controller+worker", ""} {
+		if got := evaluateSwaps(swapFile, nodeType); got.Status != doctorFail || got.Hint == "" {
+			t.Errorf("evaluateSwaps(swap file, %q) = %+v", nodeType, got)
+		}
+	}
+	// A controller without a worker runs no kubelet
+	if got := evaluateSwaps(swapFile, "controller"); got.Status != doctorPass {
+		t.Errorf("evaluateSwaps(swap file, controller) = %+v", got)
+	}
+}
+
+func TestEvaluateDiskFree(t *testing.T) {
+	tests := []struct {
+		name string
+		free uint64
+		want string
+	}{
+		{name: "plenty", free: 100 << 30, want: doctorPass},
+		{name: "low", free: 10 << 30, want: doctorWarn},
+		{name: "full", free: 1 << 30, want: doctorFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evaluateDiskFree(k0sDataDir, tt.free); got.Status != tt.want {
+				t.Errorf("evaluateDiskFree(%d) = %+v, want %s", tt.free, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateK0sSysinfo(t *testing.T) {
+	pass := `Total memory: 7.7 GiB (pass)
+Disk space available for /var/lib/k0s: 50.2 GiB (pass)
+Operating system: Linux (pass)
+  Linux kernel release: 6.8.0-45-generic (pass)
+  Cgroup version: 2 (pass)
+    CONFIG_CGROUPS: Control Group support (built-in)
+`
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{name: "all pass", output: pass, want: doctorPass},
+		{name: "warning", output: pass + "  AppArmor: unavailable (warning)\n", want: doctorWarn},
+		{name: "rejected", output: pass + "Total memory: 512 MiB (rejected)\n", want: doctorFail},
+		{name: "no output", output: "", want: doctorWarn},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evaluateK0sSysinfo(tt.output); got.Status != tt.want {
+				t.Errorf("evaluateK0sSysinfo() = %+v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestK0sRequiredPorts(t *testing.T) {
+	tests := []struct {
+		nodeType string
+		want     int
+	}{
+		{nodeType: "controller", want: 4},
+		{nodeType: "worker", want: 2},
+		{nodeType: "controller+worker", want: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.nodeType, func(t *testing.T) {
+			if got := k0sRequiredPorts(tt.nodeType); len(got) != tt.want {
+				t.Errorf("k0sRequiredPorts(%s) = %v, want %d ports", tt.nodeType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuleListed(t *testing.T) {
+	builtin := "kernel/fs/overlayfs/overlay.ko\nkernel/net/bridge/br_netfilter.ko\n"
+	if !moduleListed(builtin, "overlay") || !moduleListed(builtin, "br_netfilter") {
+		t.Error("moduleListed() should find built-in modules")
+	}
+	if moduleListed(builtin, "nf_conntrack") {
+		t.Error("moduleListed() should not find missing modules")
+	}
+}
+
+func TestPlatformHost(t *testing.T) {
+	tests := map[string]string{
+		"api.galley.run":              "api.galley.run",
+		"https://api.galley.run/v1":   "api.galley.run",
+		"api.galley.dev:8443":         "api.galley.dev",
+		"http://localhost:8080?debug": "localhost",
+	}
+
+	for input, want := range tests {
+		if got := platformHost(input); got != want {
+			t.Errorf("platformHost(%s) = %s, want %s", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,772 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// k0s runs etcd with client access on loopback only, authenticated with the
+// certificate the API server uses
+const (
+	etcdDefaultEndpoint = "https://127.0.0.1:2379"
+	etcdCACert          = k0sPKIDir + "/etcd/ca.crt"
+	etcdClientCert      = k0sPKIDir + "/apiserver-etcd-client.crt"
+	etcdClientKey       = k0sPKIDir + "/apiserver-etcd-client.key"
+	etcdRequestTimeout  = 30 * time.Second
+	etcdDefragTimeout   = 5 * time.Minute
+)
+
+var (
+	flagEtcdEndpoint    string
+	flagEtcdOutput      string
+	flagEtcdHealthWait  time.Duration
+	flagEtcdRemoveForce bool
+)
+
+var controllerEtcdCmd = &cobra.Command{
+	Use:   "etcd",
+	Short: "Inspect and maintain the etcd cluster of the control plane",
+	Long: `Talks to the etcd member k0s runs on this controller, with the client
+certificate of the API server. Only available for clusters that store their
+state in etcd (the default for k0s), not for kine.`,
+}
+
+var controllerEtcdStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the health, leader, database size and alarms of etcd",
+	Args:  cobra.ExactArgs(0),
+	RunE:  runControllerEtcdStatus,
+}
+
+var controllerEtcdMembersCmd = &cobra.Command{
+	Use:   "members",
+	Short: "List the etcd members",
+	Args:  cobra.ExactArgs(0),
+	RunE:  runControllerEtcdMembers,
+}
+
+var controllerEtcdDefragCmd = &cobra.Command{
+	Use:   "defrag",
+	Short: "Defragment the etcd members one at a time",
+	Long: `Defragments etcd members to give the space of deleted revisions back to the
+file system. A member blocks while it is defragmented, so members are done one at
+a time, the leader last, and each member has to be healthy again before the next.
+
+k0s only exposes etcd on loopback, members without a reachable client URL have to
+be defragmented on their own controller.`,
+	Args: cobra.ExactArgs(0),
+	RunE: runControllerEtcdDefrag,
+}
+
+var controllerEtcdSnapshotCmd = &cobra.Command{
+	Use:   "snapshot <file>",
+	Short: "Save an etcd snapshot of the cluster state",
+	Long: `Saves a point-in-time snapshot of the etcd database to a file and verifies its
+checksum. Use 'galley controller backup' for full control plane backups, which
+also include the k0s certificates and configuration.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runControllerEtcdSnapshot,
+}
+
+var controllerEtcdRemoveCmd = &cobra.Command{
+	Use:   "remove <member>",
+	Short: "Remove a dead controller from etcd",
+	Long: `Removes an etcd member by name, ID or peer address, to replace a controller that
+is gone for good. Removing a running controller breaks it, use 'galley node leave'
+on the controller itself when it is still reachable.`,
+	Example: `  galley controller etcd remove controller-2
+  galley controller etcd remove 10.0.0.12`,
+	Args: cobra.ExactArgs(1),
+	RunE: runControllerEtcdRemove,
+}
+
+func init() {
+	controllerEtcdCmd.PersistentFlags().StringVar(&flagEtcdEndpoint, "endpoint", etcdDefaultEndpoint, "etcd client URL of this controller")
+	for _, cmd := range []*cobra.Command{controllerEtcdStatusCmd, controllerEtcdMembersCmd} {
+		cmd.Flags().StringVarP(&flagEtcdOutput, "output", "o", "table", "Output format: table or json")
+	}
+	controllerEtcdDefragCmd.Flags().DurationVar(&flagEtcdHealthWait, "health-timeout", 2*time.Minute, "How long to wait for a member to be healthy after defragmenting it")
+	controllerEtcdRemoveCmd.Flags().BoolVar(&flagEtcdRemoveForce, "force", false, "Remove the member even when it looks healthy")
+
+	controllerEtcdCmd.AddCommand(controllerEtcdStatusCmd)
+	controllerEtcdCmd.AddCommand(controllerEtcdMembersCmd)
+	controllerEtcdCmd.AddCommand(controllerEtcdDefragCmd)
+	controllerEtcdCmd.AddCommand(controllerEtcdSnapshotCmd)
+	controllerEtcdCmd.AddCommand(controllerEtcdRemoveCmd)
+	controllerCmd.AddCommand(controllerEtcdCmd)
+}
+
+// etcdMember is a member of the etcd cluster, as returned by the gRPC gateway.
+// The gateway encodes 64-bit integers as strings.
+type etcdMember struct {
+	ID         uint64   `json:"ID,string"`
+	Name       string   `json:"name"`
+	PeerURLs   []string `json:"peerURLs"`
+	ClientURLs []string `json:"clientURLs"`
+	IsLearner  bool     `json:"isLearner,omitempty"`
+}
+
+// etcdMemberStatus is the maintenance status of one member
+type etcdMemberStatus struct {
+	Header struct {
+		ClusterID uint64 `json:"cluster_id,string"`
+		MemberID  uint64 `json:"member_id,string"`
+		Revision  int64  `json:"revision,string"`
+	} `json:"header"`
+	Version     string   `json:"version"`
+	DBSize      int64    `json:"dbSize,string"`
+	DBSizeInUse int64    `json:"dbSizeInUse,string"`
+	Leader      uint64   `json:"leader,string"`
+	RaftIndex   uint64   `json:"raftIndex,string"`
+	RaftTerm    uint64   `json:"raftTerm,string"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// etcdAlarm is an active alarm, like NOSPACE when the database reached its quota
+type etcdAlarm struct {
+	MemberID uint64 `json:"memberID,string"`
+	Alarm    string `json:"alarm"`
+}
+
+// etcdClient calls the etcd v3 JSON gateway of one endpoint
+type etcdClient struct {
+	endpoint string
+	http     *http.Client
+}
+
+// newEtcdClient returns a client that authenticates with the k0s etcd client certificate
+func newEtcdClient(endpoint string) (*etcdClient, error) {
+	caPEM, err := os.ReadFile(etcdCACert)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the etcd CA (is this a controller using etcd?): %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", etcdCACert)
+	}
+	cert, err := tls.LoadX509KeyPair(etcdClientCert, etcdClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the etcd client certificate: %w", err)
+	}
+
+	return &etcdClient{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		http: &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					RootCAs:      pool,
+					Certificates: []tls.Certificate{cert},
+					MinVersion:   tls.VersionTLS12,
+				},
+			},
+		},
+	}, nil
+}
+
+// withEndpoint returns a client for another member with the same credentials
+func (c *etcdClient) withEndpoint(endpoint string) *etcdClient {
+	return &etcdClient{endpoint: strings.TrimSuffix(endpoint, "/"), http: c.http}
+}
+
+// call posts a request to a gateway path and decodes the response
+func (c *etcdClient) call(ctx context.Context, path string, request, response interface{}) error {
+	body, err := c.post(ctx, path, request)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(body)
+
+	if response == nil {
+		_, err = io.Copy(io.Discard, body)
+		return err
+	}
+	if err := json.NewDecoder(body).Decode(response); err != nil {
+		return fmt.Errorf("failed to parse etcd response of %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *etcdClient) post(ctx context.Context, path string, request interface{}) (io.ReadCloser, error) {
+	if request == nil {
+		request = map[string]interface{}{}
+	}
+	jsonBody, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+path, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach etcd at %s: %w", c.endpoint, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("etcd %s returned %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp.Body, nil
+}
+
+func (c *etcdClient) members(ctx context.Context) ([]etcdMember, error) {
+	var response struct {
+		Members []etcdMember `json:"members"`
+	}
+	if err := c.call(ctx, "/v3/cluster/member/list", nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Members, nil
+}
+
+func (c *etcdClient) status(ctx context.Context) (*etcdMemberStatus, error) {
+	var status etcdMemberStatus
+	if err := c.call(ctx, "/v3/maintenance/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *etcdClient) alarms(ctx context.Context) ([]etcdAlarm, error) {
+	var response struct {
+		Alarms []etcdAlarm `json:"alarms"`
+	}
+	if err := c.call(ctx, "/v3/maintenance/alarm", map[string]string{"action": "GET"}, &response); err != nil {
+		return nil, err
+	}
+	return response.Alarms, nil
+}
+
+func (c *etcdClient) defragment(ctx context.Context) error {
+	return c.call(ctx, "/v3/maintenance/defragment", nil, nil)
+}
+
+// healthy reports whether the member serves linearizable requests, which needs a leader
+func (c *etcdClient) healthy(ctx context.Context) error {
+	// A quorum read of a key that does not exist, "health" in base64
+	return c.call(ctx, "/v3/kv/range", map[string]string{"key": "aGVhbHRo"}, nil)
+}
+
+// snapshot streams the database of the member into w and returns its size
+func (c *etcdClient) snapshot(ctx context.Context, w io.Writer) (int64, error) {
+	body, err := c.post(ctx, "/v3/maintenance/snapshot", nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(body)
+
+	return readEtcdSnapshotStream(body, w)
+}
+
+// readEtcdSnapshotStream writes the blobs of a streamed gateway snapshot response,
+// one {"result": {...}} object per chunk, into w
+func readEtcdSnapshotStream(r io.Reader, w io.Writer) (int64, error) {
+	decoder := json.NewDecoder(r)
+	var written int64
+	for {
+		var chunk struct {
+			Result *struct {
+				RemainingBytes uint64 `json:"remaining_bytes,string"`
+				Blob           []byte `json:"blob"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := decoder.Decode(&chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return written, fmt.Errorf("failed to read snapshot stream: %w", err)
+		}
+		if chunk.Error != nil {
+			return written, fmt.Errorf("etcd snapshot failed: %s", chunk.Error.Message)
+		}
+		if chunk.Result == nil {
+			continue
+		}
+		n, err := w.Write(chunk.Result.Blob)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if chunk.Result.RemainingBytes == 0 {
+			break
+		}
+	}
+	return written, nil
+}
+
+// verifyEtcdSnapshot checks the SHA-256 etcd appends to a snapshot
+func verifyEtcdSnapshot(data []byte) error {
+	if len(data) <= sha256.Size {
+		return fmt.Errorf("snapshot is too small (%d bytes)", len(data))
+	}
+	db, checksum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	sum := sha256.Sum256(db)
+	if !bytes.Equal(sum[:], checksum) {
+		return fmt.Errorf("snapshot checksum does not match")
+	}
+	return nil
+}
+
+// etcdStorageType returns the storage type of the k0s config, etcd when not set
+func etcdStorageType(configPath string) string {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return "etcd"
+	}
+	var config struct {
+		Spec struct {
+			Storage struct {
+				Type string `yaml:"type"`
+			} `yaml:"storage"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil || config.Spec.Storage.Type == "" {
+		return "etcd"
+	}
+	return config.Spec.Storage.Type
+}
+
+// localEtcdClient returns a client for the etcd member of this controller
+func localEtcdClient() (*etcdClient, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Galley config: %w", err)
+	}
+	if config.NodeType != "" && !strings.HasPrefix(config.NodeType, "controller") {
+		return nil, fmt.Errorf("etcd runs on controllers, this node is a %s", config.NodeType)
+	}
+	if storage := etcdStorageType(k0sConfigFile); storage != "etcd" {
+		return nil, fmt.Errorf("this cluster stores its state in %s, not etcd", storage)
+	}
+	return newEtcdClient(flagEtcdEndpoint)
+}
+
+// etcdMemberName returns the name of a member ID, or the ID in hex when unknown
+func etcdMemberName(members []etcdMember, id uint64) string {
+	for _, member := range members {
+		if member.ID == id {
+			if member.Name != "" {
+				return member.Name
+			}
+			break
+		}
+	}
+	return strconv.FormatUint(id, 16)
+}
+
+// findEtcdMember finds a member by name, hex or decimal ID, or peer address
+func findEtcdMember(members []etcdMember, query string) (*etcdMember, error) {
+	for i, member := range members {
+		if member.Name == query || strconv.FormatUint(member.ID, 16) == strings.ToLower(query) || strconv.FormatUint(member.ID, 10) == query {
+			return &members[i], nil
+		}
+		for _, peerURL := range member.PeerURLs {
+			if u, err := url.Parse(peerURL); err == nil && (u.Hostname() == query || u.Host == query || peerURL == query) {
+				return &members[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no etcd member %q, list them with 'galley controller etcd members'", query)
+}
+
+// etcdDefragOrder returns the members in defragmentation order: followers first,
+// the leader last, so leadership moves at most once
+func etcdDefragOrder(members []etcdMember, leader uint64) []etcdMember {
+	ordered := append([]etcdMember{}, members...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].ID != leader && ordered[j].ID == leader
+	})
+	return ordered
+}
+
+// reachableClientURL returns a client URL of the member this controller can reach.
+// k0s binds client URLs to loopback, which only reaches the local member.
+func reachableClientURL(member etcdMember, localID uint64, localEndpoint string) string {
+	if member.ID == localID {
+		return localEndpoint
+	}
+	for _, clientURL := range member.ClientURLs {
+		u, err := url.Parse(clientURL)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(u.Hostname()); (ip != nil && ip.IsLoopback()) || u.Hostname() == "localhost" {
+			continue
+		}
+		return clientURL
+	}
+	return ""
+}
+
+func formatEtcdSize(size int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+}
+
+func runControllerEtcdStatus(cmd *cobra.Command, args []string) error {
+	if flagEtcdOutput != "table" && flagEtcdOutput != "json" {
+		return fmt.Errorf("invalid output format %q, expected table or json", flagEtcdOutput)
+	}
+
+	client, err := localEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	status, err := client.status(ctx)
+	if err != nil {
+		return err
+	}
+	members, err := client.members(ctx)
+	if err != nil {
+		return err
+	}
+	alarms, err := client.alarms(ctx)
+	if err != nil {
+		return err
+	}
+	healthErr := client.healthy(ctx)
+
+	if flagEtcdOutput == "json" {
+		result := struct {
+			Healthy     bool        `json:"healthy"`
+			Version     string      `json:"version"`
+			Member      string      `json:"member"`
+			Leader      string      `json:"leader"`
+			Members     int         `json:"members"`
+			DBSize      int64       `json:"dbSize"`
+			DBSizeInUse int64       `json:"dbSizeInUse"`
+			Revision    int64       `json:"revision"`
+			RaftTerm    uint64      `json:"raftTerm"`
+			Alarms      []etcdAlarm `json:"alarms"`
+			Errors      []string    `json:"errors,omitempty"`
+		}{
+			Healthy:     healthErr == nil && len(alarms) == 0,
+			Version:     status.Version,
+			Member:      etcdMemberName(members, status.Header.MemberID),
+			Leader:      etcdMemberName(members, status.Leader),
+			Members:     len(members),
+			DBSize:      status.DBSize,
+			DBSizeInUse: status.DBSizeInUse,
+			Revision:    status.Header.Revision,
+			RaftTerm:    status.RaftTerm,
+			Alarms:      append([]etcdAlarm{}, alarms...),
+			Errors:      status.Errors,
+		}
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("Member:    %s (etcd %s)\n", etcdMemberName(members, status.Header.MemberID), status.Version)
+	fmt.Printf("Leader:    %s\n", etcdMemberName(members, status.Leader))
+	fmt.Printf("Members:   %d\n", len(members))
+	fmt.Printf("Database:  %s, %s in use\n", formatEtcdSize(status.DBSize), formatEtcdSize(status.DBSizeInUse))
+	fmt.Printf("Revision:  %d (raft term %d)\n\n", status.Header.Revision, status.RaftTerm)
+
+	if healthErr != nil {
+		fmt.Printf("✗ etcd is not healthy: %v\n", healthErr)
+	} else {
+		fmt.Println("✓ etcd is healthy")
+	}
+	for _, msg := range status.Errors {
+		fmt.Printf("✗ %s\n", msg)
+	}
+	for _, alarm := range alarms {
+		fmt.Printf("✗ Alarm %s on %s\n", alarm.Alarm, etcdMemberName(members, alarm.MemberID))
+	}
+	if status.DBSize > 0 && status.DBSizeInUse < status.DBSize/2 {
+		fmt.Println("💡 More than half of the database is free space, reclaim it with: galley controller etcd defrag")
+	}
+
+	if healthErr != nil || len(alarms) > 0 {
+		return fmt.Errorf("etcd is not healthy")
+	}
+	return nil
+}
+
+func runControllerEtcdMembers(cmd *cobra.Command, args []string) error {
+	if flagEtcdOutput != "table" && flagEtcdOutput != "json" {
+		return fmt.Errorf("invalid output format %q, expected table or json", flagEtcdOutput)
+	}
+
+	client, err := localEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	members, err := client.members(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := client.status(ctx)
+	if err != nil {
+		return err
+	}
+
+	if flagEtcdOutput == "json" {
+		data, err := json.MarshalIndent(members, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPEER URLS\tROLE")
+	for _, member := range members {
+		role := "follower"
+		switch {
+		case member.ID == status.Leader:
+			role = "leader"
+		case member.IsLearner:
+			role = "learner"
+		}
+		if member.ID == status.Header.MemberID {
+			role += " (this controller)"
+		}
+		fmt.Fprintf(w, "%x\t%s\t%s\t%s\n", member.ID, member.Name, strings.Join(member.PeerURLs, ","), role)
+	}
+	return w.Flush()
+}
+
+func runControllerEtcdDefrag(cmd *cobra.Command, args []string) error {
+	client, err := localEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	statusCtx, cancel := context.WithTimeout(ctx, etcdRequestTimeout)
+	defer cancel()
+	status, err := client.status(statusCtx)
+	if err != nil {
+		return err
+	}
+	members, err := client.members(statusCtx)
+	if err != nil {
+		return err
+	}
+	if err := client.healthy(statusCtx); err != nil {
+		return fmt.Errorf("etcd is not healthy, not defragmenting: %w", err)
+	}
+
+	var skipped []string
+	for _, member := range etcdDefragOrder(members, status.Leader) {
+		endpoint := reachableClientURL(member, status.Header.MemberID, flagEtcdEndpoint)
+		if endpoint == "" {
+			skipped = append(skipped, member.Name)
+			continue
+		}
+		if dryRunPlan("Defragment %s (%s) and wait until it is healthy", member.Name, endpoint) {
+			continue
+		}
+		if err := defragEtcdMember(ctx, client.withEndpoint(endpoint), member.Name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range skipped {
+		fmt.Printf("⚠️  %s has no client URL reachable from here, run 'galley controller etcd defrag' on that controller\n", name)
+	}
+	return nil
+}
+
+// defragEtcdMember defragments one member and waits until it is healthy again
+func defragEtcdMember(ctx context.Context, client *etcdClient, name string) error {
+	before, err := client.status(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Defragmenting %s (%s)...\n", name, formatEtcdSize(before.DBSize))
+	defragCtx, cancel := context.WithTimeout(ctx, etcdDefragTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := client.defragment(defragCtx); err != nil {
+		return fmt.Errorf("failed to defragment %s: %w", name, err)
+	}
+
+	deadline := time.Now().Add(flagEtcdHealthWait)
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := client.healthy(checkCtx)
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is not healthy after defragmenting, stopping: %w", name, err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	after, err := client.status(ctx)
+	if err != nil {
+		return err
+	}
+	logAction("Defragmented etcd member", map[string]string{
+		"member":  name,
+		"before":  strconv.FormatInt(before.DBSize, 10),
+		"after":   strconv.FormatInt(after.DBSize, 10),
+		"seconds": strconv.Itoa(int(time.Since(start).Seconds())),
+	})
+	fmt.Printf("✓ %s defragmented: %s → %s (took %s)\n", name, formatEtcdSize(before.DBSize), formatEtcdSize(after.DBSize), time.Since(start).Round(time.Second))
+	return nil
+}
+
+func runControllerEtcdSnapshot(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	client, err := localEtcdClient()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	if dryRunPlan("Save an etcd snapshot to %s", path) {
+		return nil
+	}
+
+	// Write to a temporary file so a failed snapshot never looks complete
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".etcd-snapshot-")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := tmp.Chmod(0600); err != nil {
+		return err
+	}
+
+	size, err := client.snapshot(context.Background(), tmp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if err := verifyEtcdSnapshot(data); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	logFileWrite(path, "etcd snapshot")
+	fmt.Printf("✓ etcd snapshot saved to %s (%s, checksum verified)\n", path, formatEtcdSize(size))
+	return nil
+}
+
+func runControllerEtcdRemove(cmd *cobra.Command, args []string) error {
+	client, err := localEtcdClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), etcdRequestTimeout)
+	defer cancel()
+
+	members, err := client.members(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := client.status(ctx)
+	if err != nil {
+		return err
+	}
+
+	member, err := findEtcdMember(members, args[0])
+	if err != nil {
+		return err
+	}
+	if member.ID == status.Header.MemberID {
+		return fmt.Errorf("%s is this controller, use 'galley node leave' to remove it", member.Name)
+	}
+	if len(member.PeerURLs) == 0 {
+		return fmt.Errorf("%s has no peer URL", member.Name)
+	}
+	peer, err := url.Parse(member.PeerURLs[0])
+	if err != nil {
+		return fmt.Errorf("invalid peer URL %s: %w", member.PeerURLs[0], err)
+	}
+
+	// A member that still answers is not dead, removing it breaks that controller
+	if endpoint := reachableClientURL(*member, status.Header.MemberID, flagEtcdEndpoint); endpoint != "" && !flagEtcdRemoveForce {
+		checkCtx, cancelCheck := context.WithTimeout(ctx, 5*time.Second)
+		defer cancelCheck()
+		if client.withEndpoint(endpoint).healthy(checkCtx) == nil {
+			return fmt.Errorf("%s is healthy, run 'galley node leave' on it instead (use --force to remove it anyway)", member.Name)
+		}
+	}
+	if err := checkEtcdRemoveQuorum(len(members)); err != nil {
+		return err
+	}
+
+	if err := runCommandWithContext(ctx, "k0s", "etcd", "leave", "--peer-address", peer.Hostname()); err != nil {
+		return fmt.Errorf("failed to remove %s from etcd: %w", member.Name, err)
+	}
+
+	logAction("Removed etcd member", map[string]string{
+		"member": member.Name,
+		"id":     strconv.FormatUint(member.ID, 16),
+		"peer":   peer.Hostname(),
+	})
+	fmt.Printf("✓ Removed %s from etcd (%d member(s) remaining)\n", member.Name, len(members)-1)
+	fmt.Println("💡 Add a replacement controller with: galley controller invite")
+	return nil
+}
+
+// checkEtcdRemoveQuorum refuses to remove a member from a cluster of one, and warns
+// when the remaining cluster has no fault tolerance
+func checkEtcdRemoveQuorum(members int) error {
+	switch {
+	case members <= 1:
+		return fmt.Errorf("etcd has a single member, it can't be removed")
+	case members == 2:
+		fmt.Println("⚠️  Only one controller will remain, the control plane will have no fault tolerance.")
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEtcdGatewayResponses(t *testing.T) {
+	var members struct {
+		Members []etcdMember `json:"members"`
+	}
+	memberList := `{"header":{"cluster_id":"14841639068965178418","member_id":"10276657743932975437","raft_term":"2"},
+"members":[{"ID":"10276657743932975437","name":"controller-1","peerURLs":["https://10.0.0.11:2380"],"clientURLs":["https://127.0.0.1:2379"]},
+{"ID":"2345","name":"controller-2","peerURLs":["https://10.0.0.12:2380"],"clientURLs":["https://127.0.0.1:2379"],"isLearner":true}]}`
+	if err := json.Unmarshal([]byte(memberList), &members); err != nil {
+		t.Fatalf("failed to decode member list: %v", err)
+	}
+	if len(members.Members) != 2 || members.Members[0].ID != 10276657743932975437 || !members.Members[1].IsLearner {
+		t.Errorf("members = %+v", members.Members)
+	}
+
+	var status etcdMemberStatus
+	statusJSON := `{"header":{"cluster_id":"1","member_id":"10276657743932975437","revision":"1234","raft_term":"2"},
+"version":"3.5.13","dbSize":"24576","leader":"2345","raftIndex":"4","raftTerm":"2","raftAppliedIndex":"4","dbSizeInUse":"16384"}`
+	if err := json.Unmarshal([]byte(statusJSON), &status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if status.Header.MemberID != 10276657743932975437 || status.Header.Revision != 1234 || status.DBSize != 24576 || status.DBSizeInUse != 16384 {
+		t.Errorf("status = %+v", status)
+	}
+	if got := etcdMemberName(members.Members, status.Leader); got != "controller-2" {
+		t.Errorf("leader = %s, want controller-2", got)
+	}
+	if got := etcdMemberName(members.Members, 255); got != "ff" {
+		t.Errorf("unknown member = %s, want ff", got)
+	}
+}
+
+func TestReadEtcdSnapshotStream(t *testing.T) {
+	db := []byte("bolt database contents")
+	sum := sha256.Sum256(db)
+	snapshot := append(append([]byte{}, db...), sum[:]...)
+
+	var stream strings.Builder
+	for i, chunk := range [][]byte{snapshot[:10], snapshot[10:]} {
+		remaining := "0"
+		if i == 0 {
+			remaining = "1"
+		}
+		stream.WriteString(`{"result":{"remaining_bytes":"` + remaining + `","blob":"` + base64.StdEncoding.EncodeToString(chunk) + `"}}` + "\n")
+	}
+
+	var out bytes.Buffer
+	n, err := readEtcdSnapshotStream(strings.NewReader(stream.String()), &out)
+	if err != nil {
+		t.Fatalf("readEtcdSnapshotStream() error = %v", err)
+	}
+	if n != int64(len(snapshot)) || !bytes.Equal(out.Bytes(), snapshot) {
+		t.Errorf("readEtcdSnapshotStream() wrote %d bytes, want %d", n, len(snapshot))
+	}
+	if err := verifyEtcdSnapshot(out.Bytes()); err != nil {
+		t.Errorf("verifyEtcdSnapshot() error = %v", err)
+	}
+
+	corrupt := append([]byte{}, snapshot...)
+	corrupt[0] ^= 0xff
+	if err := verifyEtcdSnapshot(corrupt); err == nil {
+		t.Error("verifyEtcdSnapshot() should reject a corrupt snapshot")
+	}
+
+	if _, err := readEtcdSnapshotStream(strings.NewReader(`{"error":{"message":"no leader"}}`), &out); err == nil || !strings.Contains(err.Error(), "no leader") {
+		t.Errorf("readEtcdSnapshotStream() error = %v, want the stream error", err)
+	}
+}
+
+func TestFindEtcdMember(t *testing.T) {
+	members := []etcdMember{
+		{ID: 0xabc, Name: "controller-1", PeerURLs: []string{"https://10.0.0.11:2380"}},
+		{ID: 0xdef, Name: "controller-2", PeerURLs: []string{"https://10.0.0.12:2380"}},
+	}
+
+	for _, query := range []string{"controller-2", "def", "DEF", "3567", "10.0.0.12", "10.0.0.12:2380", "https://10.0.0.12:2380"} {
+		member, err := findEtcdMember(members, query)
+		if err != nil || member.Name != "controller-2" {
+			t.Errorf("findEtcdMember(%q) = %v, %v", query, member, err)
+		}
+	}
+	if _, err := findEtcdMember(members, "controller-3"); err == nil {
+		t.Error("findEtcdMember() should fail for an unknown member")
+	}
+}
+
+func TestEtcdDefragOrder(t *testing.T) {
+	members := []etcdMember{{ID: 1, Name: "a"}, {ID: 2, Name: "b"}, {ID: 3, Name: "c"}}
+	ordered := etcdDefragOrder(members, 1)
+	if ordered[0].Name != "b" || ordered[1].Name != "c" || ordered[2].Name != "a" {
+		t.Errorf("etcdDefragOrder() = %v, want the leader last", ordered)
+	}
+	if members[0].Name != "a" {
+		t.Error("etcdDefragOrder() should not reorder its input")
+	}
+}
+
+func TestReachableClientURL(t *testing.T) {
+	local := etcdMember{ID: 1, ClientURLs: []string{"https://127.0.0.1:2379"}}
+	loopback := etcdMember{ID: 2, ClientURLs: []string{"https://127.0.0.1:2379", "https://localhost:2379"}}
+	remote := etcdMember{ID: 3, ClientURLs: []string{"https://127.0.0.1:2379", "https://10.0.0.13:2379"}}
+
+	if got := reachableClientURL(local, 1, etcdDefaultEndpoint); got != etcdDefaultEndpoint {
+		t.Errorf("local member = %q", got)
+	}
+	if got := reachableClientURL(loopback, 1, etcdDefaultEndpoint); got != "" {
+		t.Errorf("loopback member = %q, want none", got)
+	}
+	if got := reachableClientURL(remote, 1, etcdDefaultEndpoint); got != "https://10.0.0.13:2379" {
+		t.Errorf("remote member = %q", got)
+	}
+}
+
+func TestEtcdStorageType(t *testing.T) {
+	dir := t.TempDir()
+	kine := filepath.Join(dir, "kine.yaml")
+	if err := os.WriteFile(kine, []byte("spec:\n  storage:\n    type: kine\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := etcdStorageType(kine); got != "kine" {
+		t.Errorf("etcdStorageType() = %s, want kine", got)
+	}
+	if got := etcdStorageType(filepath.Join(dir, "missing.yaml")); got != "etcd" {
+		t.Errorf("etcdStorageType() = %s, want etcd by default", got)
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// executor makes the changes Galley applies to this system. Every command that
+// changes the system, every file write and every service change goes through it,
+// so --dry-run can show a complete plan instead. Commands that only read state
+// run directly. The system executor backs up every file before it writes or
+// removes it, see changeStore.
+type executor interface {
+	// Run runs a command with its output streamed to the terminal
+	Run(ctx context.Context, name string, args ...string) error
+	// RunWithInput runs a command with input on stdin
+	RunWithInput(ctx context.Context, input string, name string, args ...string) error
+	// Output runs a command and returns its stdout, a dry run returns no output
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+	// Interactive runs a command attached to the terminal, like an editor
+	Interactive(name string, args ...string) error
+
+	// ReadFile reads a file as changed by this run
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Chmod(path string, perm os.FileMode) error
+	Chown(path string, uid, gid int) error
+	Rename(oldPath, newPath string) error
+	Remove(path string) error
+	RemoveAll(path string) error
+}
+
+// sysExec is the executor of this run, set to a dry-run executor for --dry-run
+var sysExec executor = systemExecutor{}
+
+// useDryRunExecutor switches to the dry-run executor when --dry-run is set
+func useDryRunExecutor() {
+	if flagDryRun {
+		sysExec = newDryRunExecutor(os.Stdout)
+	}
+}
+
+// printDryRunSummary closes the plan of a dry run
+func printDryRunSummary() {
+	if plan, ok := sysExec.(*dryRunExecutor); ok {
+		plan.summary()
+	}
+}
+
+// dryRunPlan adds a change the executor can't make to the plan of a dry run, like
+// a platform request or waiting for a service, and reports whether this is one
+func dryRunPlan(format string, args ...interface{}) bool {
+	plan, ok := sysExec.(*dryRunExecutor)
+	if ok {
+		plan.plan(format, args...)
+	}
+	return ok
+}
+
+// systemExecutor applies changes to this system and logs them
+type systemExecutor struct{}
+
+func (systemExecutor) Run(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Log the command execution
+	logCommand(name, args)
+
+	if err := cmd.Run(); err != nil {
+		logError(fmt.Sprintf("command: %s %v", name, args), err)
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+
+	return nil
+}
+
+func (systemExecutor) RunWithInput(ctx context.Context, input string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	logCommand(name, args)
+
+	if err := cmd.Run(); err != nil {
+		logError(fmt.Sprintf("command: %s %v", name, args), err)
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+
+	return nil
+}
+
+func (systemExecutor) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+
+	logCommand(name, args)
+
+	output, err := cmd.Output()
+	if err != nil {
+		logError(fmt.Sprintf("command: %s %v", name, args), err)
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return output, fmt.Errorf("%s failed: %w: %s", name, err, message)
+		}
+		return output, fmt.Errorf("%s failed: %w", name, err)
+	}
+
+	return output, nil
+}
+
+func (systemExecutor) Interactive(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	logCommand(name, args)
+
+	return cmd.Run()
+}
+
+func (systemExecutor) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (systemExecutor) WriteFile(path string, data []byte, perm os.FileMode) error {
+	if err := fileChanges.record(path, data); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return os.WriteFile(path, data, perm)
+}
+
+func (systemExecutor) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (systemExecutor) Chmod(path string, perm os.FileMode) error {
+	return os.Chmod(path, perm)
+}
+
+func (systemExecutor) Chown(path string, uid, gid int) error {
+	return os.Chown(path, uid, gid)
+}
+
+func (systemExecutor) Rename(oldPath, newPath string) error {
+	// A moved file is written at the destination and removed at the source
+	if data, err := os.ReadFile(oldPath); err == nil {
+		written, err := fileChanges.prepare(newPath, data)
+		if err != nil {
+			return fmt.Errorf("failed to back up %s: %w", newPath, err)
+		}
+		removed, err := fileChanges.prepare(oldPath, nil)
+		if err != nil {
+			return fmt.Errorf("failed to back up %s: %w", oldPath, err)
+		}
+		var changes []fileChange
+		for _, change := range []*fileChange{written, removed} {
+			if change != nil {
+				changes = append(changes, *change)
+			}
+		}
+		if err := fileChanges.add(changes...); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", oldPath, err)
+		}
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+func (systemExecutor) Remove(path string) error {
+	if err := fileChanges.record(path, nil); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return os.Remove(path)
+}
+
+func (systemExecutor) RemoveAll(path string) error {
+	// Only the configuration in a tree is backed up, data directories are skipped
+	var changes []fileChange
+	err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if !fileChanges.tracksWithin(file) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		change, err := fileChanges.prepare(file, nil)
+		if err != nil {
+			return fmt.Errorf("failed to back up %s: %w", file, err)
+		}
+		if change != nil {
+			changes = append(changes, *change)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := fileChanges.add(changes...); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	return os.RemoveAll(path)
+}
+
+// removeWithoutBackup removes a file Galley made itself, like an old backup
+// archive, without keeping a copy in the change store
+func removeWithoutBackup(path string) error {
+	if _, ok := sysExec.(systemExecutor); ok {
+		return os.Remove(path)
+	}
+	return sysExec.Remove(path)
+}
+
+// dryRunExecutor prints the changes of a run as a numbered plan, with a unified
+// diff for every file write. It remembers the planned files, so later steps read
+// and diff against the content earlier steps would have written.
+type dryRunExecutor struct {
+	out     io.Writer
+	changes int
+	// files holds the planned content, nil for a planned removal
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+func newDryRunExecutor(out io.Writer) *dryRunExecutor {
+	return &dryRunExecutor{out: out, files: map[string][]byte{}, dirs: map[string]bool{}}
+}
+
+func (d *dryRunExecutor) plan(format string, args ...interface{}) {
+	d.changes++
+	fmt.Fprintf(d.out, "[dry-run] %d. %s\n", d.changes, fmt.Sprintf(format, args...))
+}
+
+func (d *dryRunExecutor) summary() {
+	if d.changes == 0 {
+		fmt.Fprintln(d.out, "[dry-run] No changes planned")
+		return
+	}
+	noun := "changes"
+	if d.changes == 1 {
+		noun = "change"
+	}
+	fmt.Fprintf(d.out, "[dry-run] %d %s planned, nothing was changed on this system\n", d.changes, noun)
+}
+
+func (d *dryRunExecutor) Run(ctx context.Context, name string, args ...string) error {
+	d.plan("Run: %s", shellJoin(append([]string{name}, args...)))
+	return nil
+}
+
+func (d *dryRunExecutor) RunWithInput(ctx context.Context, input string, name string, args ...string) error {
+	d.plan("Run: %s <<EOF\n%sEOF", shellJoin(append([]string{name}, args...)), input)
+	return nil
+}
+
+func (d *dryRunExecutor) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	d.plan("Run: %s", shellJoin(append([]string{name}, args...)))
+	return nil, nil
+}
+
+func (d *dryRunExecutor) Interactive(name string, args ...string) error {
+	d.plan("Open: %s", shellJoin(append([]string{name}, args...)))
+	return nil
+}
+
+func (d *dryRunExecutor) ReadFile(path string) ([]byte, error) {
+	if data, planned := d.files[path]; planned {
+		if data == nil {
+			return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+		}
+		return data, nil
+	}
+	return os.ReadFile(path)
+}
+
+func (d *dryRunExecutor) exists(path string) bool {
+	if data, planned := d.files[path]; planned {
+		return data != nil
+	}
+	if d.dirs[path] {
+		return true
+	}
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// secretFiles are the files with tokens, keys or credentials, a dry run never shows
+// their contents. Patterns use filepath.Match syntax.
+var secretFiles = []string{
+	k0sTokenFile,
+	k0sDataDir + "/pki/*",
+	galleyAgentManifestFile,
+	galleyRegistriesFile,
+	galleyRegistryKeyFile,
+	filepath.Join(galleyRegistryHostsDir, "*", "hosts.toml"),
+	"/root/.kube/config",
+	"/home/*/.kube/config",
+}
+
+// addSecretFile adds a file that is only known at run time, like a kubeconfig
+// --output or a backup, to secretFiles
+func addSecretFile(path string) {
+	secretFiles = append(secretFiles, path)
+}
+
+func isSecretFile(path string) bool {
+	for _, pattern := range secretFiles {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// secretValue matches settings with a secret value in files that are otherwise
+// shown, like the VRRP auth pass in the k0s config
+var secretValue = regexp.MustCompile(`(?m)^([\s-]*authPass:\s*).+$`)
+
+// redactSecretValues hides the secret settings of a file in a dry run diff
+func redactSecretValues(content string) string {
+	return secretValue.ReplaceAllString(content, "${1}<redacted>")
+}
+
+func (d *dryRunExecutor) WriteFile(path string, data []byte, perm os.FileMode) error {
+	current, err := d.ReadFile(path)
+	exists := err == nil
+	if exists && bytes.Equal(current, data) {
+		fmt.Fprintf(d.out, "[dry-run] %s is unchanged\n", path)
+		return nil
+	}
+
+	action := "Update"
+	if !exists {
+		action = "Create"
+	}
+	// Keep tokens, keys and credentials off the terminal
+	if isSecretFile(path) {
+		d.plan("%s %s (mode %04o, contents hidden)", action, path, perm)
+	} else {
+		d.plan("%s %s (mode %04o)", action, path, perm)
+		fmt.Fprint(d.out, unifiedDiff(path, redactSecretValues(string(current)), redactSecretValues(string(data))))
+	}
+
+	d.files[path] = append([]byte{}, data...)
+	return nil
+}
+
+func (d *dryRunExecutor) MkdirAll(path string, perm os.FileMode) error {
+	if d.exists(path) {
+		return nil
+	}
+	d.plan("Create directory %s (mode %04o)", path, perm)
+	d.dirs[path] = true
+	return nil
+}
+
+func (d *dryRunExecutor) Chmod(path string, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm() == perm {
+		return nil
+	}
+	d.plan("Change mode of %s to %04o", path, perm)
+	return nil
+}
+
+func (d *dryRunExecutor) Chown(path string, uid, gid int) error {
+	d.plan("Change owner of %s to %d:%d", path, uid, gid)
+	return nil
+}
+
+func (d *dryRunExecutor) Rename(oldPath, newPath string) error {
+	if !d.exists(oldPath) {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: os.ErrNotExist}
+	}
+	d.plan("Move %s to %s", oldPath, newPath)
+	if data, err := d.ReadFile(oldPath); err == nil {
+		d.files[newPath] = data
+	}
+	d.files[oldPath] = nil
+	return nil
+}
+
+func (d *dryRunExecutor) Remove(path string) error {
+	if !d.exists(path) {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	d.plan("Remove %s", path)
+	d.files[path] = nil
+	return nil
+}
+
+func (d *dryRunExecutor) RemoveAll(path string) error {
+	if !d.exists(path) {
+		return nil
+	}
+	d.plan("Remove %s and everything in it", path)
+	d.files[path] = nil
+	return nil
+}
+
+// unifiedDiff returns the changes from old to new as a unified diff with three
+// lines of context
+func unifiedDiff(path, old, new string) string {
+	type diffLine struct {
+		kind     byte
+		text     string
+		old, new int // lines before this one
+	}
+
+	a, b := splitLines(old), splitLines(new)
+	if len(a)*len(b) > 4_000_000 {
+		return fmt.Sprintf("(diff of %d to %d lines not shown)\n", len(a), len(b))
+	}
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i], i, j})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, diffLine{'-', a[i], i, j})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	const context = 3
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", path, path)
+	for next := 0; next < len(lines); {
+		for next < len(lines) && lines[next].kind == ' ' {
+			next++
+		}
+		if next == len(lines) {
+			break
+		}
+
+		// A hunk runs until the unchanged lines between two changes exceed
+		// twice the context
+		start, end := max(next-context, 0), next
+		for {
+			for end < len(lines) && lines[end].kind != ' ' {
+				end++
+			}
+			unchanged := end
+			for unchanged < len(lines) && lines[unchanged].kind == ' ' {
+				unchanged++
+			}
+			if unchanged < len(lines) && unchanged-end <= 2*context {
+				end = unchanged
+				continue
+			}
+			end = min(end+context, len(lines))
+			break
+		}
+
+		oldCount, newCount := 0, 0
+		for _, line := range lines[start:end] {
+			if line.kind != '+' {
+				oldCount++
+			}
+			if line.kind != '-' {
+				newCount++
+			}
+		}
+		oldStart, newStart := lines[start].old+1, lines[start].new+1
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+		for _, line := range lines[start:end] {
+			fmt.Fprintf(&out, "%c%s\n", line.kind, line.text)
+		}
+		next = end
+	}
+	return out.String()
+}
+
+// splitLines splits content into lines without their line endings
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
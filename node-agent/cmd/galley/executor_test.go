@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{
+			name: "new file",
+			new:  "a\nb\n",
+			want: "--- f\n+++ f\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "changed line",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "--- f\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny\n",
+			want: "--- f\n+++ f\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+		{
+			name: "unchanged",
+			old:  "a\n",
+			new:  "a\n",
+			want: "--- f\n+++ f\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("f", tt.old, tt.new); got != tt.want {
+				t.Errorf("unifiedDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDryRunExecutor(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.conf")
+	if err := os.WriteFile(existing, []byte("a\nb\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "token")
+	oldSecrets := secretFiles
+	addSecretFile(secret)
+	defer func() { secretFiles = oldSecrets }()
+	// Root-only files that hold no secret, like sshd_config, still show their diff
+	private := filepath.Join(dir, "sshd_config")
+
+	var out bytes.Buffer
+	plan := newDryRunExecutor(&out)
+	if err := plan.WriteFile(existing, []byte("a\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.WriteFile(secret, []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.WriteFile(existing, []byte("a\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.WriteFile(private, []byte("PermitRootLogin no\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Run(context.Background(), "systemctl", "restart", "ssh"); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Remove(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("Remove() of a missing file error = %v, want not exist", err)
+	}
+	if err := plan.Remove(secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plan.ReadFile(secret); !os.IsNotExist(err) {
+		t.Errorf("ReadFile() of a planned removal error = %v, want not exist", err)
+	}
+	plan.summary()
+
+	got := out.String()
+	for _, want := range []string{
+		"[dry-run] 1. Update " + existing + " (mode 0644)\n",
+		"-b\n+c\n",
+		"[dry-run] 2. Create " + secret + " (mode 0600, contents hidden)\n",
+		"[dry-run] " + existing + " is unchanged\n",
+		"[dry-run] 3. Create " + private + " (mode 0600)\n",
+		"+PermitRootLogin no\n",
+		"[dry-run] 4. Run: systemctl restart ssh\n",
+		"[dry-run] 5. Remove " + secret + "\n",
+		"[dry-run] 5 changes planned, nothing was changed on this system\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("plan is missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "hunter2") {
+		t.Errorf("plan shows the contents of a secret file:\n%s", got)
+	}
+
+	if data, err := os.ReadFile(existing); err != nil || string(data) != "a\nb\n" {
+		t.Errorf("dry run changed %s: %q, %v", existing, data, err)
+	}
+	if _, err := os.Stat(secret); !os.IsNotExist(err) {
+		t.Errorf("dry run created %s", secret)
+	}
+}
+
+func TestIsSecretFile(t *testing.T) {
+	for _, path := range []string{k0sTokenFile, k0sCAKeyFile, galleyRegistryHostsDir + "/harbor.example.com:5000/hosts.toml", "/home/ops/.kube/config"} {
+		if !isSecretFile(path) {
+			t.Errorf("%s should be a secret file", path)
+		}
+	}
+	for _, path := range []string{"/etc/ssh/sshd_config", k0sConfigFile, galleyRegistryHostsDir + "/harbor.example.com/ca.crt"} {
+		if isSecretFile(path) {
+			t.Errorf("%s should not be a secret file", path)
+		}
+	}
+}
+
+func TestRedactSecretValues(t *testing.T) {
+	got := redactSecretValues("vrrpInstances:\n  - authPass: s3cret12\n    virtualIPs: [10.0.0.100/24]\n")
+	if strings.Contains(got, "s3cret12") || !strings.Contains(got, "authPass: <redacted>") || !strings.Contains(got, "virtualIPs") {
+		t.Errorf("redactSecretValues() = %q", got)
+	}
+}
+
+func TestDryRunPlan(t *testing.T) {
+	oldExec := sysExec
+	defer func() { sysExec = oldExec }()
+
+	sysExec = systemExecutor{}
+	if dryRunPlan("Wait for %s", "k0s") {
+		t.Error("dryRunPlan() = true outside a dry run")
+	}
+
+	var out bytes.Buffer
+	sysExec = newDryRunExecutor(&out)
+	if !dryRunPlan("Wait for %s", "k0s") {
+		t.Error("dryRunPlan() = false in a dry run")
+	}
+	if got, want := out.String(), "[dry-run] 1. Wait for k0s\n"; got != want {
+		t.Errorf("plan = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,851 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	galleyFirewallFile    = galleyStateDir + "/firewall.json"
+	galleyNftRulesetFile  = "/etc/galley/firewall.nft"
+	galleyFirewallUnit    = "galley-firewall.service"
+	galleyNftTable        = "galley"
+	firewallRuleComment   = "galley"
+	defaultPodCIDR        = "10.244.0.0/16"
+	defaultServiceCIDR    = "10.96.0.0/12"
+	nodePortRangeStart    = 30000
+	nodePortRangeEnd      = 32767
+	firewallBackendUfw    = "ufw"
+	firewallBackendFwd    = "firewalld"
+	firewallBackendNft    = "nftables"
+	defaultSSHPort        = 22
+	firewallAnySourceFlag = "any"
+	// Keepalived elects the CPLB VIP owner with VRRP, IP protocol 112
+	vrrpProtocol    = "vrrp"
+	ufwDefaultsFile = "/etc/default/ufw"
+)
+
+// privateNetworks are the default sources for cluster traffic between nodes
+var privateNetworks = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+var (
+	flagFirewallRole              string
+	flagFirewallBackend           string
+	flagFirewallSSHSources        []string
+	flagFirewallClusterSources    []string
+	flagFirewallNodePortSources   []string
+	flagFirewallControllerSources []string
+	flagFirewallForce             bool
+	flagFirewallOutput            string
+)
+
+var nodeFirewallCmd = &cobra.Command{
+	Use:   "firewall",
+	Short: "Manage the host firewall for the node's role",
+	Long: `Manages a Galley ruleset in the host firewall (ufw, firewalld or nftables)
+that only opens the ports this node needs for its role:
+
+  - SSH, for all nodes
+  - 2380, 6443, 8132 and 9443 for controllers
+  - 10250, the CNI ports and the NodePort range for workers
+  - VRRP from the other controllers, when control plane load balancing is enabled
+  - All traffic from the pod and service networks of the cluster
+
+Cluster ports only accept traffic from --cluster-source (private networks by
+default). All other incoming traffic is dropped.`,
+}
+
+var nodeFirewallStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the firewall backend and the Galley rules",
+	Args:  cobra.ExactArgs(0),
+	RunE:  runNodeFirewallStatus,
+}
+
+var nodeFirewallApplyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Apply the Galley firewall ruleset for this node's role",
+	Long: `Applies the Galley ruleset to the detected firewall and enables it.
+
+Before anything changes, the current SSH session is checked against the ruleset,
+so applying it can't lock you out. Use --dry-run to print the exact rules.`,
+	Example: `  galley node firewall apply --dry-run
+  galley node firewall apply --ssh-source 203.0.113.0/24 --cluster-source 10.0.0.0/16`,
+	Args: cobra.ExactArgs(0),
+	RunE: runNodeFirewallApply,
+}
+
+var nodeFirewallResetCmd = &cobra.Command{
+	Use:   "reset",
+	Short: "Remove the Galley firewall rules",
+	Long: `Removes the rules Galley added to the host firewall. The firewall itself is
+left enabled with the default policy it had before Galley applied its rules (ufw),
+with its own default policy (firewalld) or removed completely (nftables, where
+Galley owns the whole ruleset).`,
+	Args: cobra.ExactArgs(0),
+	RunE: runNodeFirewallReset,
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{nodeFirewallStatusCmd, nodeFirewallApplyCmd} {
+		cmd.Flags().StringVar(&flagFirewallRole, "role", "", "Node role: controller, worker or controller+worker (default: from Galley config)")
+		cmd.Flags().StringVar(&flagFirewallBackend, "backend", "", "Firewall to use: ufw, firewalld or nftables (default: detected)")
+	}
+	nodeFirewallApplyCmd.Flags().StringArrayVar(&flagFirewallSSHSources, "ssh-source", []string{firewallAnySourceFlag}, "CIDR allowed to connect over SSH, can be repeated")
+	nodeFirewallApplyCmd.Flags().StringArrayVar(&flagFirewallClusterSources, "cluster-source", privateNetworks, "CIDR of the other nodes, allowed on the cluster ports, can be repeated")
+	nodeFirewallApplyCmd.Flags().StringArrayVar(&flagFirewallNodePortSources, "nodeport-source", []string{firewallAnySourceFlag}, "CIDR allowed on the NodePort range, can be repeated")
+	nodeFirewallApplyCmd.Flags().StringArrayVar(&flagFirewallControllerSources, "controller-source", nil, "CIDR of the controllers, allowed to send VRRP for the control plane VIP, can be repeated (default: --cluster-source)")
+	nodeFirewallApplyCmd.Flags().BoolVar(&flagFirewallForce, "force", false, "Apply even when the ruleset would block the current SSH session")
+	nodeFirewallStatusCmd.Flags().StringVarP(&flagFirewallOutput, "output", "o", "text", "Output format: text or json")
+
+	nodeFirewallCmd.AddCommand(nodeFirewallStatusCmd)
+	nodeFirewallCmd.AddCommand(nodeFirewallApplyCmd)
+	nodeFirewallCmd.AddCommand(nodeFirewallResetCmd)
+	nodeCmd.AddCommand(nodeFirewallCmd)
+}
+
+// firewallRule allows incoming traffic from a source. An empty protocol allows all
+// traffic from the source, an empty source allows any address. Protocols other than
+// tcp and udp have no port.
+type firewallRule struct {
+	Protocol    string `json:"protocol,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	EndPort     int    `json:"endPort,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Description string `json:"description"`
+}
+
+// ports returns the port or port range in start:end notation
+func (r firewallRule) ports(separator string) string {
+	if r.EndPort > 0 {
+		return fmt.Sprintf("%d%s%d", r.Port, separator, r.EndPort)
+	}
+	return strconv.Itoa(r.Port)
+}
+
+// allows reports whether the rule accepts traffic from ip to a port
+func (r firewallRule) allows(ip net.IP, protocol string, port int) bool {
+	if r.Protocol != "" && r.Protocol != protocol {
+		return false
+	}
+	if r.Port > 0 && port != r.Port && (r.EndPort == 0 || port < r.Port || port > r.EndPort) {
+		return false
+	}
+	if r.Source == "" {
+		return true
+	}
+	_, network, err := net.ParseCIDR(r.Source)
+	return err == nil && network.Contains(ip)
+}
+
+// firewallOptions are the inputs of the Galley ruleset
+type firewallOptions struct {
+	NodeType        string
+	SSHPort         int
+	SSHSources      []string
+	ClusterSources  []string
+	NodePortSources []string
+	PodCIDR         string
+	ServiceCIDR     string
+	CNI             string
+	// CPLB is set when keepalived runs a control plane VIP on this controller
+	CPLB              bool
+	ControllerSources []string
+}
+
+// firewallState records the rules Galley applied, so they can be removed exactly
+type firewallState struct {
+	Backend   string         `json:"backend"`
+	NodeType  string         `json:"nodeType"`
+	Rules     []firewallRule `json:"rules"`
+	AppliedAt time.Time      `json:"appliedAt"`
+	// UfwDefaultIncoming is the ufw default incoming policy before the first apply
+	UfwDefaultIncoming string `json:"ufwDefaultIncoming,omitempty"`
+}
+
+// firewallRules returns the Galley ruleset for a node role
+func firewallRules(opts firewallOptions) []firewallRule {
+	var rules []firewallRule
+	add := func(protocol string, port, endPort int, sources []string, description string) {
+		for _, source := range sources {
+			rules = append(rules, firewallRule{Protocol: protocol, Port: port, EndPort: endPort, Source: source, Description: description})
+		}
+	}
+
+	add("tcp", opts.SSHPort, 0, opts.SSHSources, "SSH")
+
+	for _, port := range k0sRequiredPorts(opts.NodeType) {
+		if port.Port == 179 {
+			// Depends on the CNI, added below
+			continue
+		}
+		add("tcp", port.Port, 0, opts.ClusterSources, port.Description)
+	}
+
+	if opts.CPLB && strings.HasPrefix(opts.NodeType, "controller") {
+		// The controllers elect the VIP owner with VRRP, without it every
+		// controller claims the VIP
+		add(vrrpProtocol, 0, 0, opts.ControllerSources, "Keepalived VRRP")
+	}
+
+	runsKubelet := opts.NodeType == "worker" || opts.NodeType == "controller+worker"
+	if runsKubelet {
+		switch opts.CNI {
+		case "calico":
+			add("tcp", 179, 0, opts.ClusterSources, "Calico BGP")
+			add("udp", 4789, 0, opts.ClusterSources, "Calico VXLAN")
+		case "custom":
+		default:
+			add("tcp", 179, 0, opts.ClusterSources, "kube-router BGP")
+		}
+		add("tcp", nodePortRangeStart, nodePortRangeEnd, opts.NodePortSources, "NodePort services")
+		add("udp", nodePortRangeStart, nodePortRangeEnd, opts.NodePortSources, "NodePort services")
+	}
+
+	// Pods and services talk to the host directly, e.g. for DNS and metrics
+	add("", 0, 0, []string{opts.PodCIDR, opts.ServiceCIDR}, "Cluster networks")
+	return rules
+}
+
+// checkSSHLockout returns an error when the rules would not allow the SSH session
+// the command runs in
+func checkSSHLockout(rules []firewallRule, sshConnection string) error {
+	if sshConnection == "" {
+		return nil
+	}
+	// client_ip client_port server_ip server_port
+	fields := strings.Fields(sshConnection)
+	if len(fields) != 4 {
+		return nil
+	}
+	clientIP := net.ParseIP(fields[0])
+	serverPort, err := strconv.Atoi(fields[3])
+	if clientIP == nil || err != nil {
+		return nil
+	}
+
+	for _, rule := range rules {
+		if rule.allows(clientIP, "tcp", serverPort) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the ruleset would block your SSH session from %s to port %d, add --ssh-source %s/32 or use --force", clientIP, serverPort, clientIP)
+}
+
+// currentSSHConnection returns SSH_CONNECTION of this process or the closest parent
+// that has it, sudo removes it from the environment
+func currentSSHConnection() string {
+	if value := os.Getenv("SSH_CONNECTION"); value != "" {
+		return value
+	}
+
+	pid := os.Getppid()
+	for i := 0; i < 10 && pid > 1; i++ {
+		if environ, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid)); err == nil {
+			for _, entry := range strings.Split(string(environ), "\x00") {
+				if value, ok := strings.CutPrefix(entry, "SSH_CONNECTION="); ok {
+					return value
+				}
+			}
+		}
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			break
+		}
+		// pid (comm) state ppid ..., comm can contain spaces
+		fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+		if len(fields) < 2 {
+			break
+		}
+		if pid, err = strconv.Atoi(fields[1]); err != nil {
+			break
+		}
+	}
+	return ""
+}
+
+// sshdPort returns the first Port of the SSH daemon configuration
+func sshdPort() int {
+	content, err := sysExec.ReadFile("/etc/ssh/sshd_config")
+	if err != nil {
+		return defaultSSHPort
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.EqualFold(fields[0], "Port") {
+			if port, err := strconv.Atoi(fields[1]); err == nil {
+				return port
+			}
+		}
+	}
+	return defaultSSHPort
+}
+
+// readK0sNetwork returns the pod CIDR, service CIDR and CNI provider from the k0s config
+func readK0sNetwork(configPath string) (string, string, string) {
+	podCIDR, serviceCIDR, provider := defaultPodCIDR, defaultServiceCIDR, "kuberouter"
+
+	content, err := sysExec.ReadFile(configPath)
+	if err != nil {
+		return podCIDR, serviceCIDR, provider
+	}
+	var config struct {
+		Spec struct {
+			Network struct {
+				PodCIDR     string `yaml:"podCIDR"`
+				ServiceCIDR string `yaml:"serviceCIDR"`
+				Provider    string `yaml:"provider"`
+			} `yaml:"network"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return podCIDR, serviceCIDR, provider
+	}
+
+	network := config.Spec.Network
+	if network.PodCIDR != "" {
+		podCIDR = network.PodCIDR
+	}
+	if network.ServiceCIDR != "" {
+		serviceCIDR = network.ServiceCIDR
+	}
+	if network.Provider != "" {
+		provider = network.Provider
+	}
+	return podCIDR, serviceCIDR, provider
+}
+
+// parseFirewallSources validates CIDR flags, "any" allows every address
+func parseFirewallSources(flag string, values []string) ([]string, error) {
+	var sources []string
+	for _, value := range values {
+		if value == firewallAnySourceFlag {
+			return []string{""}, nil
+		}
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return nil, fmt.Errorf("invalid --%s %q, expected a CIDR like 10.0.0.0/16 or %s", flag, value, firewallAnySourceFlag)
+		}
+		sources = append(sources, value)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("--%s needs at least one CIDR", flag)
+	}
+	return sources, nil
+}
+
+// detectFirewallBackend returns the firewall that manages this host
+func detectFirewallBackend() (string, error) {
+	if exec.Command("firewall-cmd", "--state").Run() == nil {
+		return firewallBackendFwd, nil
+	}
+	if _, err := exec.LookPath("ufw"); err == nil {
+		return firewallBackendUfw, nil
+	}
+	if _, err := exec.LookPath("nft"); err == nil {
+		return firewallBackendNft, nil
+	}
+	return "", fmt.Errorf("no supported firewall found, install ufw, firewalld or nftables")
+}
+
+func firewallBackend() (string, error) {
+	switch flagFirewallBackend {
+	case "":
+		return detectFirewallBackend()
+	case firewallBackendUfw, firewallBackendFwd, firewallBackendNft:
+		return flagFirewallBackend, nil
+	}
+	return "", fmt.Errorf("invalid --backend %q, expected ufw, firewalld or nftables", flagFirewallBackend)
+}
+
+func firewallNodeType() (string, error) {
+	nodeType := flagFirewallRole
+	if nodeType == "" {
+		config, err := loadConfig()
+		if err != nil {
+			return "", fmt.Errorf("failed to load Galley config: %w", err)
+		}
+		nodeType = config.NodeType
+	}
+	switch nodeType {
+	case "controller", "worker", "controller+worker":
+		return nodeType, nil
+	case "":
+		return "", fmt.Errorf("this node has not joined a cluster yet, use --role to select its role")
+	}
+	return "", fmt.Errorf("invalid role %q, expected controller, worker or controller+worker", nodeType)
+}
+
+// ufwRuleArgs returns the ufw arguments of a rule, after 'ufw allow' or 'ufw delete allow'
+func ufwRuleArgs(rule firewallRule) []string {
+	var args []string
+	if rule.Protocol != "" {
+		args = append(args, "proto", rule.Protocol)
+	}
+	source := rule.Source
+	if source == "" {
+		source = "any"
+	}
+	args = append(args, "from", source, "to", "any")
+	if rule.Port > 0 {
+		args = append(args, "port", rule.ports(":"))
+	}
+	return append(args, "comment", firewallRuleComment+": "+rule.Description)
+}
+
+// firewalldRichRule returns a firewalld rich rule for a rule
+func firewalldRichRule(rule firewallRule) string {
+	var parts []string
+	parts = append(parts, "rule")
+	if rule.Source != "" {
+		family := "ipv4"
+		if ip, _, err := net.ParseCIDR(rule.Source); err == nil && ip.To4() == nil {
+			family = "ipv6"
+		}
+		parts = append(parts, fmt.Sprintf(`family="%s"`, family), fmt.Sprintf(`source address="%s"`, rule.Source))
+	}
+	if rule.Port > 0 {
+		parts = append(parts, fmt.Sprintf(`port port="%s" protocol="%s"`, rule.ports("-"), rule.Protocol))
+	} else if rule.Protocol != "" {
+		parts = append(parts, fmt.Sprintf(`protocol value="%s"`, rule.Protocol))
+	}
+	return strings.Join(append(parts, "accept"), " ")
+}
+
+// nftRuleset renders the Galley nftables table. It drops all other incoming traffic,
+// deleting the table first makes applying it idempotent.
+func nftRuleset(rules []firewallRule) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Managed by Galley: host firewall for Kubernetes\n")
+	fmt.Fprintf(&b, "table inet %s {}\n", galleyNftTable)
+	fmt.Fprintf(&b, "delete table inet %s\n\n", galleyNftTable)
+	fmt.Fprintf(&b, "table inet %s {\n", galleyNftTable)
+	fmt.Fprintln(&b, "  chain input {")
+	fmt.Fprintln(&b, "    type filter hook input priority filter; policy drop;")
+	fmt.Fprintln(&b, "    ct state established,related accept")
+	fmt.Fprintln(&b, "    ct state invalid drop")
+	fmt.Fprintln(&b, `    iif "lo" accept`)
+	fmt.Fprintln(&b, "    meta l4proto { icmp, ipv6-icmp } accept")
+	for _, rule := range rules {
+		var match []string
+		if rule.Source != "" {
+			family := "ip"
+			if ip, _, err := net.ParseCIDR(rule.Source); err == nil && ip.To4() == nil {
+				family = "ip6"
+			}
+			match = append(match, family+" saddr "+rule.Source)
+		}
+		if rule.Port > 0 {
+			match = append(match, rule.Protocol+" dport "+rule.ports("-"))
+		} else if rule.Protocol != "" {
+			match = append(match, "meta l4proto "+rule.Protocol)
+		}
+		fmt.Fprintf(&b, "    %s accept comment %s\n", strings.Join(match, " "), strconv.Quote(rule.Description))
+	}
+	fmt.Fprintln(&b, "  }")
+	fmt.Fprintln(&b, "}")
+	return b.String()
+}
+
+// nftFirewallUnit loads the Galley nftables ruleset at boot
+func nftFirewallUnit() string {
+	return fmt.Sprintf(`[Unit]
+Description=Galley host firewall
+Wants=network-pre.target
+Before=network-pre.target
+After=nftables.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/usr/sbin/nft -f %s
+ExecStop=/usr/sbin/nft delete table inet %s
+
+[Install]
+WantedBy=multi-user.target
+`, galleyNftRulesetFile, galleyNftTable)
+}
+
+// firewallApplyCommands returns the commands that apply the rules with ufw or firewalld
+func firewallApplyCommands(backend string, rules []firewallRule) [][]string {
+	var commands [][]string
+	switch backend {
+	case firewallBackendUfw:
+		commands = append(commands, []string{"ufw", "default", "deny", "incoming"})
+		for _, rule := range rules {
+			commands = append(commands, append([]string{"ufw", "allow"}, ufwRuleArgs(rule)...))
+		}
+		commands = append(commands, []string{"ufw", "--force", "enable"})
+	case firewallBackendFwd:
+		for _, rule := range rules {
+			commands = append(commands, []string{"firewall-cmd", "--permanent", "--add-rich-rule=" + firewalldRichRule(rule)})
+		}
+		commands = append(commands, []string{"firewall-cmd", "--reload"})
+	}
+	return commands
+}
+
+// firewallRemoveCommands returns the commands that remove the rules from ufw or firewalld
+func firewallRemoveCommands(backend string, rules []firewallRule) [][]string {
+	var commands [][]string
+	switch backend {
+	case firewallBackendUfw:
+		for _, rule := range rules {
+			commands = append(commands, append([]string{"ufw", "delete", "allow"}, ufwRuleArgs(rule)...))
+		}
+	case firewallBackendFwd:
+		for _, rule := range rules {
+			commands = append(commands, []string{"firewall-cmd", "--permanent", "--remove-rich-rule=" + firewalldRichRule(rule)})
+		}
+		commands = append(commands, []string{"firewall-cmd", "--reload"})
+	}
+	return commands
+}
+
+func runNodeFirewallApply(cobraCmd *cobra.Command, args []string) error {
+	nodeType, err := firewallNodeType()
+	if err != nil {
+		return err
+	}
+	backend, err := firewallBackend()
+	if err != nil {
+		return err
+	}
+
+	opts := firewallOptions{NodeType: nodeType, SSHPort: sshdPort()}
+	if opts.SSHSources, err = parseFirewallSources("ssh-source", flagFirewallSSHSources); err != nil {
+		return err
+	}
+	if opts.ClusterSources, err = parseFirewallSources("cluster-source", flagFirewallClusterSources); err != nil {
+		return err
+	}
+	if opts.NodePortSources, err = parseFirewallSources("nodeport-source", flagFirewallNodePortSources); err != nil {
+		return err
+	}
+	opts.PodCIDR, opts.ServiceCIDR, opts.CNI = readK0sNetwork(k0sConfigFile)
+	if cplb, err := readControlPlaneLoadBalancing(k0sConfigFile); err == nil && cplb != nil {
+		opts.CPLB = true
+	}
+	opts.ControllerSources = opts.ClusterSources
+	if len(flagFirewallControllerSources) > 0 {
+		if opts.ControllerSources, err = parseFirewallSources("controller-source", flagFirewallControllerSources); err != nil {
+			return err
+		}
+	}
+
+	rules := firewallRules(opts)
+
+	if err := checkSSHLockout(rules, currentSSHConnection()); err != nil {
+		if !flagFirewallForce {
+			return err
+		}
+		fmt.Printf("⚠️  %v, continuing because of --force\n", err)
+	}
+
+	previous, err := loadFirewallState()
+	if err != nil {
+		return fmt.Errorf("failed to read firewall state: %w", err)
+	}
+
+	// Rules of an earlier apply that are no longer wanted
+	var stale []firewallRule
+	if previous != nil && previous.Backend == backend && backend != firewallBackendNft {
+		stale = staleFirewallRules(previous.Rules, rules)
+	}
+
+	// Remember the ufw default policy of the first apply, so reset can restore it
+	ufwDefaultIncoming := ""
+	if backend == firewallBackendUfw {
+		if previous != nil && previous.Backend == backend && previous.UfwDefaultIncoming != "" {
+			ufwDefaultIncoming = previous.UfwDefaultIncoming
+		} else if content, err := sysExec.ReadFile(ufwDefaultsFile); err == nil {
+			ufwDefaultIncoming = ufwDefaultIncomingPolicy(string(content))
+		}
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Printf("Firewall (%s, %s)\n", backend, nodeType)
+	fmt.Println(strings.Repeat("=", 70))
+
+	ctx := cobraCmd.Context()
+	if backend == firewallBackendNft {
+		if err := applyNftFirewall(ctx, rules); err != nil {
+			return err
+		}
+	} else {
+		for _, command := range append(firewallRemoveCommands(backend, stale), firewallApplyCommands(backend, rules)...) {
+			if err := runCommandWithContext(ctx, command[0], command[1:]...); err != nil {
+				return fmt.Errorf("failed to apply firewall rules: %w", err)
+			}
+		}
+	}
+
+	state := firewallState{Backend: backend, NodeType: nodeType, Rules: rules, AppliedAt: time.Now().UTC(), UfwDefaultIncoming: ufwDefaultIncoming}
+	if err := saveFirewallState(&state); err != nil {
+		return fmt.Errorf("failed to save firewall state: %w", err)
+	}
+
+	logAction("Applied firewall ruleset", map[string]string{
+		"backend":   backend,
+		"node_type": nodeType,
+		"rules":     strconv.Itoa(len(rules)),
+	})
+	fmt.Printf("✓ Applied %d firewall rules with %s for a %s node\n", len(rules), backend, nodeType)
+	return nil
+}
+
+func applyNftFirewall(ctx context.Context, rules []firewallRule) error {
+	if err := writeSystemFile(galleyNftRulesetFile, nftRuleset(rules), "Galley nftables firewall ruleset"); err != nil {
+		return err
+	}
+	// Check the ruleset before loading it
+	if err := runCommandWithContext(ctx, "nft", "--check", "-f", galleyNftRulesetFile); err != nil {
+		return fmt.Errorf("invalid nftables ruleset: %w", err)
+	}
+
+	unitPath := filepath.Join("/etc/systemd/system", galleyFirewallUnit)
+	if err := writeSystemFile(unitPath, nftFirewallUnit(), "Load the Galley firewall at boot"); err != nil {
+		return err
+	}
+	if err := runCommandWithContext(ctx, "systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := runCommandWithContext(ctx, "systemctl", "enable", galleyFirewallUnit); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", galleyFirewallUnit, err)
+	}
+	if err := runCommandWithContext(ctx, "systemctl", "restart", galleyFirewallUnit); err != nil {
+		return fmt.Errorf("failed to start %s: %w", galleyFirewallUnit, err)
+	}
+	logServiceChange(galleyFirewallUnit, "enabled")
+	return nil
+}
+
+func runNodeFirewallReset(cobraCmd *cobra.Command, args []string) error {
+	state, err := loadFirewallState()
+	if err != nil {
+		return fmt.Errorf("failed to read firewall state: %w", err)
+	}
+	if state == nil {
+		fmt.Println("No Galley firewall rules applied")
+		return nil
+	}
+
+	ctx := cobraCmd.Context()
+	if state.Backend == firewallBackendNft {
+		if err := runCommandWithContext(ctx, "systemctl", "disable", "--now", galleyFirewallUnit); err != nil {
+			return fmt.Errorf("failed to stop %s: %w", galleyFirewallUnit, err)
+		}
+		logServiceChange(galleyFirewallUnit, "disabled")
+		for _, path := range []string{galleyNftRulesetFile, filepath.Join("/etc/systemd/system", galleyFirewallUnit)} {
+			if err := sysExec.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			logFileWrite(path, "Removed Galley firewall")
+		}
+		_ = runCommandWithContext(ctx, "systemctl", "daemon-reload")
+	} else {
+		for _, command := range firewallRemoveCommands(state.Backend, state.Rules) {
+			// A rule removed by hand should not stop the reset
+			if err := runCommandWithContext(ctx, command[0], command[1:]...); err != nil {
+				fmt.Printf("⚠️  Warning: %v\n", err)
+			}
+		}
+		if state.Backend == firewallBackendUfw && state.UfwDefaultIncoming != "" {
+			if err := runCommandWithContext(ctx, "ufw", "default", state.UfwDefaultIncoming, "incoming"); err != nil {
+				return fmt.Errorf("failed to restore the ufw default incoming policy: %w", err)
+			}
+		}
+	}
+
+	if err := sysExec.Remove(galleyFirewallFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove firewall state: %w", err)
+	}
+
+	logAction("Reset firewall ruleset", map[string]string{
+		"backend": state.Backend,
+	})
+	fmt.Printf("✓ Removed the Galley firewall rules from %s\n", state.Backend)
+	return nil
+}
+
+func runNodeFirewallStatus(cobraCmd *cobra.Command, args []string) error {
+	if flagFirewallOutput != "text" && flagFirewallOutput != "json" {
+		return fmt.Errorf("invalid output format %q, expected text or json", flagFirewallOutput)
+	}
+
+	state, err := loadFirewallState()
+	if err != nil {
+		return fmt.Errorf("failed to read firewall state: %w", err)
+	}
+
+	backend := ""
+	if state != nil && flagFirewallBackend == "" {
+		backend = state.Backend
+	} else if backend, err = firewallBackend(); err != nil {
+		backend = ""
+	}
+
+	status := struct {
+		Backend  string         `json:"backend"`
+		Active   bool           `json:"active"`
+		NodeType string         `json:"nodeType,omitempty"`
+		Rules    []firewallRule `json:"rules"`
+		SSHSafe  *bool          `json:"sshSessionAllowed,omitempty"`
+	}{Backend: backend, Active: firewallActive(backend), Rules: []firewallRule{}}
+	if state != nil {
+		status.NodeType = state.NodeType
+		status.Rules = state.Rules
+		if connection := currentSSHConnection(); connection != "" {
+			safe := checkSSHLockout(state.Rules, connection) == nil
+			status.SSHSafe = &safe
+		}
+	}
+
+	if flagFirewallOutput == "json" {
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if backend == "" {
+		fmt.Println("✗ No supported firewall found (ufw, firewalld or nftables)")
+	} else if status.Active {
+		fmt.Printf("✓ Firewall: %s (active)\n", backend)
+	} else {
+		fmt.Printf("⚠️  Firewall: %s (inactive)\n", backend)
+	}
+
+	if state == nil {
+		fmt.Println("⚠️  No Galley firewall rules applied")
+		fmt.Println("💡 Apply them with: galley node firewall apply --dry-run")
+		return nil
+	}
+
+	fmt.Printf("Galley rules for a %s node, applied %s:\n\n", state.NodeType, state.AppliedAt.Local().Format("2006-01-02 15:04"))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROTO\tPORT\tSOURCE\tDESCRIPTION")
+	for _, rule := range state.Rules {
+		protocol, port, source := rule.Protocol, rule.ports("-"), rule.Source
+		if protocol == "" {
+			protocol, port = "all", "all"
+		} else if rule.Port == 0 {
+			port = "-"
+		}
+		if source == "" {
+			source = "any"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", protocol, port, source, rule.Description)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if status.SSHSafe != nil && !*status.SSHSafe {
+		fmt.Println("\n⚠️  The Galley rules do not allow your current SSH session")
+	}
+	return nil
+}
+
+// firewallActive reports whether the firewall backend is filtering traffic
+func firewallActive(backend string) bool {
+	switch backend {
+	case firewallBackendUfw:
+		output, err := exec.Command("ufw", "status").Output()
+		return err == nil && strings.Contains(string(output), "Status: active")
+	case firewallBackendFwd:
+		return exec.Command("firewall-cmd", "--state").Run() == nil
+	case firewallBackendNft:
+		return exec.Command("nft", "list", "table", "inet", galleyNftTable).Run() == nil
+	}
+	return false
+}
+
+// ufwDefaultIncomingPolicy returns the default incoming policy (allow, deny or
+// reject) from /etc/default/ufw, or an empty string when it is not set
+func ufwDefaultIncomingPolicy(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "DEFAULT_INPUT_POLICY=")
+		if !ok {
+			continue
+		}
+		switch strings.Trim(value, `"'`) {
+		case "ACCEPT":
+			return "allow"
+		case "DROP":
+			return "deny"
+		case "REJECT":
+			return "reject"
+		}
+	}
+	return ""
+}
+
+// staleFirewallRules returns the previous rules that are not in the new ruleset
+func staleFirewallRules(previous, current []firewallRule) []firewallRule {
+	wanted := map[firewallRule]bool{}
+	for _, rule := range current {
+		wanted[rule] = true
+	}
+	var stale []firewallRule
+	for _, rule := range previous {
+		if !wanted[rule] {
+			stale = append(stale, rule)
+		}
+	}
+	return stale
+}
+
+func loadFirewallState() (*firewallState, error) {
+	data, err := sysExec.ReadFile(galleyFirewallFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var state firewallState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func saveFirewallState(state *firewallState) error {
+	if err := sysExec.MkdirAll(galleyStateDir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return sysExec.WriteFile(galleyFirewallFile, data, 0644)
+}
+
+// shellJoin formats a command for display, quoting arguments with spaces
+func shellJoin(command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		if strings.ContainsAny(arg, " \t'\"") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
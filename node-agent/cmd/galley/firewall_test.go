@@ -0,0 +1,229 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func testFirewallOptions(nodeType string) firewallOptions {
+	return firewallOptions{
+		NodeType:        nodeType,
+		SSHPort:         22,
+		SSHSources:      []string{""},
+		ClusterSources:  []string{"10.0.0.0/8"},
+		NodePortSources: []string{""},
+		PodCIDR:         defaultPodCIDR,
+		ServiceCIDR:     defaultServiceCIDR,
+		CNI:             "kuberouter",
+	}
+}
+
+func TestFirewallRules(t *testing.T) {
+	tests := []struct {
+		nodeType string
+		open     []int
+		closed   []int
+	}{
+		{"controller", []int{22, 2380, 6443, 8132, 9443}, []int{179, 10250, 30080}},
+		{"worker", []int{22, 179, 10250, 30080}, []int{2380, 6443, 9443}},
+		{"controller+worker", []int{22, 2380, 6443, 8132, 9443, 179, 10250, 30080}, []int{8080}},
+	}
+
+	node := []byte{10, 1, 2, 3}
+	for _, tt := range tests {
+		t.Run(tt.nodeType, func(t *testing.T) {
+			rules := firewallRules(testFirewallOptions(tt.nodeType))
+			allowed := func(port int) bool {
+				for _, rule := range rules {
+					if rule.Source != defaultPodCIDR && rule.Source != defaultServiceCIDR && rule.allows(node, "tcp", port) {
+						return true
+					}
+				}
+				return false
+			}
+			for _, port := range tt.open {
+				if !allowed(port) {
+					t.Errorf("port %d should be open on a %s", port, tt.nodeType)
+				}
+			}
+			for _, port := range tt.closed {
+				if allowed(port) {
+					t.Errorf("port %d should be closed on a %s", port, tt.nodeType)
+				}
+			}
+		})
+	}
+}
+
+func TestFirewallRulesClusterSources(t *testing.T) {
+	rules := firewallRules(testFirewallOptions("controller"))
+	outside := []byte{203, 0, 113, 7}
+	for _, rule := range rules {
+		if rule.Port == 6443 && rule.allows(outside, "tcp", 6443) {
+			t.Errorf("the Kubernetes API should only accept the cluster sources, got %+v", rule)
+		}
+	}
+}
+
+func TestFirewallRulesCalico(t *testing.T) {
+	opts := testFirewallOptions("worker")
+	opts.CNI = "calico"
+	var vxlan bool
+	for _, rule := range firewallRules(opts) {
+		if rule.Protocol == "udp" && rule.Port == 4789 {
+			vxlan = true
+		}
+	}
+	if !vxlan {
+		t.Error("calico workers should allow VXLAN on 4789/udp")
+	}
+}
+
+func TestFirewallRulesVRRP(t *testing.T) {
+	vrrpRules := func(opts firewallOptions) []firewallRule {
+		var rules []firewallRule
+		for _, rule := range firewallRules(opts) {
+			if rule.Protocol == vrrpProtocol {
+				rules = append(rules, rule)
+			}
+		}
+		return rules
+	}
+
+	opts := testFirewallOptions("controller")
+	if rules := vrrpRules(opts); len(rules) != 0 {
+		t.Errorf("VRRP should only be allowed with control plane load balancing, got %v", rules)
+	}
+
+	opts.CPLB = true
+	opts.ControllerSources = []string{"10.0.0.11/32", "10.0.0.12/32"}
+	rules := vrrpRules(opts)
+	if len(rules) != 2 || rules[0].Source != "10.0.0.11/32" || rules[0].Port != 0 {
+		t.Errorf("VRRP rules = %v, want one per controller source", rules)
+	}
+
+	worker := testFirewallOptions("worker")
+	worker.CPLB = true
+	worker.ControllerSources = opts.ControllerSources
+	if rules := vrrpRules(worker); len(rules) != 0 {
+		t.Errorf("workers run no keepalived, got %v", rules)
+	}
+
+	vrrp := rules[0]
+	if got := strings.Join(ufwRuleArgs(vrrp), " "); got != "proto vrrp from 10.0.0.11/32 to any comment galley: Keepalived VRRP" {
+		t.Errorf("ufw VRRP rule = %s", got)
+	}
+	if got := firewalldRichRule(vrrp); got != `rule family="ipv4" source address="10.0.0.11/32" protocol value="vrrp" accept` {
+		t.Errorf("firewalld VRRP rule = %s", got)
+	}
+	if ruleset := nftRuleset(rules); !strings.Contains(ruleset, `ip saddr 10.0.0.11/32 meta l4proto vrrp accept`) {
+		t.Errorf("nftables ruleset should allow VRRP:\n%s", ruleset)
+	}
+}
+
+func TestUfwDefaultIncomingPolicy(t *testing.T) {
+	tests := map[string]string{
+		"IPV6=yes\nDEFAULT_INPUT_POLICY=\"DROP\"\n":    "deny",
+		"DEFAULT_INPUT_POLICY=\"ACCEPT\"\n":            "allow",
+		"DEFAULT_INPUT_POLICY=REJECT\n":                "reject",
+		"#DEFAULT_INPUT_POLICY=\"ACCEPT\"\nIPV6=yes\n": "",
+	}
+	for content, want := range tests {
+		if got := ufwDefaultIncomingPolicy(content); got != want {
+			t.Errorf("ufwDefaultIncomingPolicy(%q) = %q, want %q", content, got, want)
+		}
+	}
+}
+
+func TestCheckSSHLockout(t *testing.T) {
+	rules := []firewallRule{
+		{Protocol: "tcp", Port: 22, Source: "198.51.100.0/24", Description: "SSH"},
+		{Protocol: "tcp", Port: 30000, EndPort: 32767, Description: "NodePort services"},
+	}
+
+	tests := []struct {
+		name       string
+		connection string
+		wantErr    bool
+	}{
+		{"no ssh session", "", false},
+		{"allowed source", "198.51.100.20 51234 10.0.0.5 22", false},
+		{"blocked source", "203.0.113.9 51234 10.0.0.5 22", true},
+		{"blocked port", "198.51.100.20 51234 10.0.0.5 2222", true},
+		{"port in range", "203.0.113.9 51234 10.0.0.5 31000", false},
+		{"malformed", "garbage", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSSHLockout(rules, tt.connection)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkSSHLockout(%q) error = %v, wantErr %v", tt.connection, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFirewallSources(t *testing.T) {
+	sources, err := parseFirewallSources("ssh-source", []string{"10.0.0.0/8", "any"})
+	if err != nil || len(sources) != 1 || sources[0] != "" {
+		t.Errorf("any should allow every address, got %v, %v", sources, err)
+	}
+	if _, err := parseFirewallSources("ssh-source", []string{"10.0.0.1"}); err == nil {
+		t.Error("an address without prefix length should be rejected")
+	}
+}
+
+func TestFirewallBackendCommands(t *testing.T) {
+	rule := firewallRule{Protocol: "tcp", Port: 30000, EndPort: 32767, Source: "10.0.0.0/8", Description: "NodePort services"}
+
+	ufw := shellJoin(firewallApplyCommands(firewallBackendUfw, []firewallRule{rule})[1])
+	if want := "ufw allow proto tcp from 10.0.0.0/8 to any port 30000:32767 comment 'galley: NodePort services'"; ufw != want {
+		t.Errorf("ufw rule = %s, want %s", ufw, want)
+	}
+
+	all := ufwRuleArgs(firewallRule{Source: defaultPodCIDR, Description: "Cluster networks"})
+	if strings.Contains(strings.Join(all, " "), "port") {
+		t.Errorf("a rule without protocol should allow all ports, got %v", all)
+	}
+
+	rich := firewalldRichRule(rule)
+	if want := `rule family="ipv4" source address="10.0.0.0/8" port port="30000-32767" protocol="tcp" accept`; rich != want {
+		t.Errorf("firewalld rule = %s, want %s", rich, want)
+	}
+
+	remove := firewallRemoveCommands(firewallBackendFwd, []firewallRule{rule})
+	if len(remove) != 2 || remove[0][2] != "--remove-rich-rule="+rich || remove[1][1] != "--reload" {
+		t.Errorf("firewalld remove commands = %v", remove)
+	}
+}
+
+func TestNftRuleset(t *testing.T) {
+	ruleset := nftRuleset([]firewallRule{
+		{Protocol: "tcp", Port: 22, Description: "SSH"},
+		{Protocol: "tcp", Port: 6443, Source: "fc00::/7", Description: "Kubernetes API"},
+		{Source: defaultPodCIDR, Description: "Cluster networks"},
+	})
+
+	for _, want := range []string{
+		"policy drop;",
+		"ct state established,related accept",
+		`tcp dport 22 accept comment "SSH"`,
+		`ip6 saddr fc00::/7 tcp dport 6443 accept`,
+		`ip saddr 10.244.0.0/16 accept comment "Cluster networks"`,
+		"delete table inet galley",
+	} {
+		if !strings.Contains(ruleset, want) {
+			t.Errorf("nftables ruleset should contain %q:\n%s", want, ruleset)
+		}
+	}
+}
+
+func TestStaleFirewallRules(t *testing.T) {
+	keep := firewallRule{Protocol: "tcp", Port: 22, Description: "SSH"}
+	drop := firewallRule{Protocol: "tcp", Port: 2222, Description: "SSH"}
+	stale := staleFirewallRules([]firewallRule{keep, drop}, []firewallRule{keep})
+	if len(stale) != 1 || stale[0] != drop {
+		t.Errorf("staleFirewallRules() = %v", stale)
+	}
+}
@@ -63,13 +63,63 @@ func getGalleyNode(baseURL, token string) (*DataResource[VesselEngineNodeAttribu
 	return &nodeResp.Data, nil
 }
 
-func markGalleyNodeReady(baseURL, vesselEngineNodeId, token string) error {
+// getGalleyEngineNodes fetches all nodes of the vessel engine of a node token from the Galley platform
+func getGalleyEngineNodes(baseURL, token string) ([]DataResource[VesselEngineNodeAttributes], error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("no platform URL configured")
+	}
+
+	url := "https://" + baseURL + "/vessels/engine/nodes"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Accept", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "Galley Node Agent")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var nodesResp VesselEngineNodesResponse
+	if err := json.Unmarshal(body, &nodesResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return nodesResp.Data, nil
+}
+
+// markGalleyNodeReady reports a joined node as ready, together with its resources
+// and the IP address its kubeconfigs point at
+func markGalleyNodeReady(baseURL, vesselEngineNodeId, token, ipAddress string) error {
 	if baseURL == "" {
 		return nil
 	}
 
 	v, _ := mem.VirtualMemory()
-	usage, err := disk.Usage("/")
+	usage, err := disk.Usage(storagePath())
 	if err != nil {
 		_ = fmt.Errorf("failed to get disk usage: %w", err)
 	}
@@ -95,10 +145,16 @@ func markGalleyNodeReady(baseURL, vesselEngineNodeId, token string) error {
 			"storageUsed": usage.Used, // in bytes
 		},
 	}
+	if ipAddress != "" {
+		data["ipAddress"] = ipAddress
+	}
 	jsonBody, err := json.Marshal(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request body: %w", err)
 	}
+	if dryRunPlan("Send PATCH %s (provisioning status ready)", url) {
+		return nil
+	}
 	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
@@ -140,6 +196,275 @@ func markGalleyNodeReady(baseURL, vesselEngineNodeId, token string) error {
 	return nil
 }
 
+// deregisterGalleyNode removes this node from its vessel engine in the Galley platform
+func deregisterGalleyNode(baseURL, vesselEngineNodeId, token string) error {
+	if baseURL == "" {
+		return nil
+	}
+
+	url := "https://" + baseURL + "/vessels/engine/node/" + vesselEngineNodeId
+	if dryRunPlan("Send DELETE %s", url) {
+		return nil
+	}
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "Galley Node Agent")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// reportGalleyCertificates sends the certificate expiry of this node to the Galley platform
+func reportGalleyCertificates(baseURL, token string, certs []certificateInfo) error {
+	if baseURL == "" {
+		return fmt.Errorf("no platform URL configured")
+	}
+
+	resources := []DataResource[VesselEngineNodeCertificateAttributes]{}
+	for _, cert := range certs {
+		resources = append(resources, DataResource[VesselEngineNodeCertificateAttributes]{
+			ID:   cert.Path,
+			Type: "certificates",
+			Attributes: VesselEngineNodeCertificateAttributes{
+				Path:          cert.Path,
+				Subject:       cert.Subject,
+				NotAfter:      cert.NotAfter,
+				DaysRemaining: cert.DaysRemaining,
+				Status:        cert.Status,
+			},
+		})
+	}
+	jsonBody, err := json.Marshal(map[string]interface{}{"data": resources})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	url := "https://" + baseURL + "/vessels/engine/node/certificates"
+	if dryRunPlan("Send PUT %s (%d certificates)", url, len(certs)) {
+		return nil
+	}
+	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Accept", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "Galley Node Agent")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// getGalleyRegistries fetches the registry configurations of the vessel engine from the Galley platform
+func getGalleyRegistries(baseURL, token string) ([]VesselEngineRegistryAttributes, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("no platform URL configured")
+	}
+
+	url := "https://" + baseURL + "/vessels/engine/registries"
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "Galley Node Agent")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var registriesResp VesselEngineRegistriesResponse
+	if err := json.Unmarshal(body, &registriesResp); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	var registries []VesselEngineRegistryAttributes
+	for _, resource := range registriesResp.Data {
+		registries = append(registries, resource.Attributes)
+	}
+	return registries, nil
+}
+
+// putGalleyRegistries replaces the registry configurations of the vessel engine in the Galley platform
+func putGalleyRegistries(baseURL, token string, registries []VesselEngineRegistryAttributes) error {
+	if baseURL == "" {
+		return fmt.Errorf("no platform URL configured")
+	}
+
+	data := VesselEngineRegistriesResponse{Data: []DataResource[VesselEngineRegistryAttributes]{}}
+	for _, registry := range registries {
+		data.Data = append(data.Data, DataResource[VesselEngineRegistryAttributes]{
+			ID:         registry.Host,
+			Type:       "registries",
+			Attributes: registry,
+		})
+	}
+	jsonBody, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	url := "https://" + baseURL + "/vessels/engine/registries"
+	if dryRunPlan("Send PUT %s (%d registries)", url, len(registries)) {
+		return nil
+	}
+	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Accept", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "Galley Node Agent")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// putGalleyJoinTokens replaces the join token records of the vessel engine in the
+// Galley platform, so the web interface shows outstanding invitations
+func putGalleyJoinTokens(baseURL, token string, records []joinTokenRecord) error {
+	if baseURL == "" {
+		return fmt.Errorf("no platform URL configured")
+	}
+
+	resources := []DataResource[joinTokenRecord]{}
+	for _, record := range records {
+		resources = append(resources, DataResource[joinTokenRecord]{
+			ID:         record.ID,
+			Type:       "join-tokens",
+			Attributes: record,
+		})
+	}
+	jsonBody, err := json.Marshal(map[string]interface{}{"data": resources})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	url := "https://" + baseURL + "/vessels/engine/join-tokens"
+	if dryRunPlan("Send PUT %s (%d join tokens)", url, len(records)) {
+		return nil
+	}
+	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Accept", "application/vnd.galley-node-agent.v1+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("User-Agent", "Galley Node Agent")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			log.Printf("failed to close response body: %v", err)
+			return
+		}
+	}(resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// storagePath returns the path whose filesystem holds the cluster data, the k0s
+// data directory when it exists (possibly a dedicated data disk), otherwise /
+func storagePath() string {
+	if _, err := os.Stat(k0sDataDir); err == nil {
+		return k0sDataDir
+	}
+	return "/"
+}
+
 func checkCommands(names ...string) {
 	for _, name := range names {
 		if _, err := exec.LookPath(name); err != nil {
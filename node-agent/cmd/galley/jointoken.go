@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Galley join tokens look like "galley2.<payload>.<signature>", where payload is
+// the base64url encoded JSON claims and signature a signature over
+// "galley2.<payload>" made with the cluster CA key, which only controllers hold.
+// A joining node checks the CA in the embedded k0s token against the CA hash it
+// got out of band (--ca-cert-hash, like kubeadm's --discovery-token-ca-cert-hash)
+// and then verifies the signature with that CA, so nobody holding a token can
+// change its engine, role or expiry and sign it again.
+const (
+	joinTokenVersion = 2
+	joinTokenPrefix  = "galley2."
+	k0sCACertFile    = k0sDataDir + "/pki/ca.crt"
+	k0sCAKeyFile     = k0sDataDir + "/pki/ca.key"
+)
+
+var (
+	errJoinTokenMalformed = errors.New("join token is malformed")
+	errJoinTokenTampered  = errors.New("join token failed its integrity check")
+	errJoinTokenUntrusted = errors.New("join token is not from the expected cluster")
+	errJoinTokenExpired   = errors.New("join token has expired")
+	errJoinTokenWrongRole = errors.New("join token is for a different role")
+)
+
+// joinTokenClaims is the content of a Galley join token
+type joinTokenClaims struct {
+	Version        int    `json:"v"`
+	VesselEngineId string `json:"engine"`
+	Role           string `json:"role"` // worker, controller or controller+worker
+	IssuedAt       int64  `json:"iat"`
+	ExpiresAt      int64  `json:"exp,omitempty"`      // unix seconds, 0 means no expiry
+	WorkerProfiles bool   `json:"profiles,omitempty"` // the controllers define the Galley worker profiles
+	// CPLB holds the keepalived settings an additional controller joins with
+	CPLB *cplbSettings `json:"cplb,omitempty"`
+	// Node is the platform node the token was created for, if any
+	Node *joinTokenNode `json:"node,omitempty"`
+	// PlatformURL is the platform of the controller that created the token, so
+	// nodes of a self-hosted platform report to it without --platform-url
+	PlatformURL string `json:"platform"`
+	// RegistryKey opens the sealed registry passwords of the cluster, Registries
+	// are the registry configurations the node applies before k0s starts
+	RegistryKey string                           `json:"registryKey,omitempty"`
+	Registries  []VesselEngineRegistryAttributes `json:"registries,omitempty"`
+	K0sToken    string                           `json:"k0s"`
+
+	// caFingerprint is the sha256:<hex> hash of the cluster CA that signed the
+	// token. It is not part of the payload, joining nodes get it out of band.
+	caFingerprint string
+}
+
+// joinTokenNode is the name, labels and taints a node registers with, copied from
+// the platform when the token is created so the joining node needs no platform access
+type joinTokenNode struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name,omitempty"`
+	RegionID string            `json:"region,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Taints   []string          `json:"taints,omitempty"`
+}
+
+// expiresAt returns the expiry time of the token, or the zero time if it never expires
+func (c *joinTokenClaims) expiresAt() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// parseJoinTokenExpiry parses an expiry with the same syntax as 'k0s token create
+// --expiry', 0 means the token never expires
+func parseJoinTokenExpiry(expiry string) (time.Duration, error) {
+	if expiry == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(expiry)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token expiry %q: %w", expiry, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("invalid token expiry %q: must not be negative", expiry)
+	}
+	return duration, nil
+}
+
+// newJoinTokenClaims builds the claims for a freshly created k0s join token
+func newJoinTokenClaims(vesselEngineId, role, k0sToken string, expiry time.Duration, now time.Time) *joinTokenClaims {
+	claims := &joinTokenClaims{
+		Version:        joinTokenVersion,
+		VesselEngineId: vesselEngineId,
+		Role:           role,
+		IssuedAt:       now.Unix(),
+		K0sToken:       k0sToken,
+	}
+	if expiry > 0 {
+		claims.ExpiresAt = now.Add(expiry).Unix()
+	}
+	return claims
+}
+
+// joinTokenPlatformURL returns the platform a joining node reports to: the
+// --platform-url flag, or else the platform the token was created on
+func joinTokenPlatformURL(claims *joinTokenClaims) string {
+	if flagPlatformURL == "" && claims.PlatformURL != "" {
+		return claims.PlatformURL
+	}
+	return getPlatformURL()
+}
+
+// joinTokenSigner signs join tokens with the cluster CA key
+type joinTokenSigner struct {
+	key         crypto.Signer
+	ca          *x509.Certificate
+	fingerprint string
+}
+
+// loadJoinTokenSigner loads the cluster CA certificate and its private key
+func loadJoinTokenSigner(certFile, keyFile string) (*joinTokenSigner, error) {
+	caPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA, join tokens can only be created on a controller: %w", err)
+	}
+	ca, err := parseCACertificate(caPEM)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint, err := caFingerprint(caPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA key, join tokens can only be created on a controller: %w", err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cluster CA key: %w", err)
+	}
+
+	signer := &joinTokenSigner{key: key, ca: ca, fingerprint: fingerprint}
+	// Sign and verify once, so a key that doesn't belong to the CA is caught here
+	// and not on the node that tries to join
+	probe := []byte(joinTokenPrefix)
+	signature, err := signer.sign(probe)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJoinTokenSignature(ca, probe, signature); err != nil {
+		return nil, fmt.Errorf("cluster CA key %s does not belong to %s", keyFile, certFile)
+	}
+	return signer, nil
+}
+
+func (s *joinTokenSigner) sign(signed []byte) ([]byte, error) {
+	var signature []byte
+	var err error
+	if _, ok := s.key.Public().(ed25519.PublicKey); ok {
+		signature, err = s.key.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(signed)
+		signature, err = s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign join token: %w", err)
+	}
+	return signature, nil
+}
+
+// verifyJoinTokenSignature checks a signature made by joinTokenSigner
+func verifyJoinTokenSignature(ca *x509.Certificate, signed, signature []byte) error {
+	var algorithm x509.SignatureAlgorithm
+	switch ca.PublicKey.(type) {
+	case *rsa.PublicKey:
+		algorithm = x509.SHA256WithRSA
+	case *ecdsa.PublicKey:
+		algorithm = x509.ECDSAWithSHA256
+	case ed25519.PublicKey:
+		algorithm = x509.PureEd25519
+	default:
+		return fmt.Errorf("unsupported CA key type %T", ca.PublicKey)
+	}
+	return ca.CheckSignature(algorithm, signed, signature)
+}
+
+// encodeJoinToken serialises the claims and signs them into a Galley join token
+func encodeJoinToken(claims *joinTokenClaims, signer *joinTokenSigner) (string, error) {
+	kubeconfig, err := parseK0sJoinToken(claims.K0sToken)
+	if err != nil {
+		return "", err
+	}
+	fingerprint, err := kubeconfig.caFingerprint()
+	if err != nil {
+		return "", err
+	}
+	if fingerprint != signer.fingerprint {
+		return "", fmt.Errorf("k0s token points at CA %s, not at the CA %s of this controller", fingerprint, signer.fingerprint)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode join token: %w", err)
+	}
+
+	signed := joinTokenPrefix + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := signer.sign([]byte(signed))
+	if err != nil {
+		return "", err
+	}
+	claims.caFingerprint = signer.fingerprint
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// decodeJoinToken verifies a Galley join token against the CA hash the operator
+// passed with --ca-cert-hash and returns its claims
+func decodeJoinToken(token, caHash string, now time.Time) (*joinTokenClaims, error) {
+	token = strings.TrimSpace(token)
+	if !strings.HasPrefix(token, joinTokenPrefix) {
+		return nil, fmt.Errorf("%w: unsupported token format, create a new token on a controller", errJoinTokenMalformed)
+	}
+	if caHash == "" {
+		return nil, fmt.Errorf("%w: --ca-cert-hash is required, it is shown with the join command on the controller", errJoinTokenUntrusted)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(token, joinTokenPrefix), ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w: expected payload and signature", errJoinTokenMalformed)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errJoinTokenMalformed, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errJoinTokenMalformed, err)
+	}
+
+	var claims joinTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", errJoinTokenMalformed, err)
+	}
+	if claims.Version != joinTokenVersion {
+		return nil, fmt.Errorf("%w: unsupported token version %d", errJoinTokenMalformed, claims.Version)
+	}
+	if claims.K0sToken == "" || claims.VesselEngineId == "" || claims.Role == "" {
+		return nil, fmt.Errorf("%w: missing engine, role or k0s token", errJoinTokenMalformed)
+	}
+
+	// The CA in the k0s token is only trusted when it matches the pinned hash
+	kubeconfig, err := parseK0sJoinToken(claims.K0sToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errJoinTokenMalformed, err)
+	}
+	caPEM, err := kubeconfig.caCertificate()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errJoinTokenMalformed, err)
+	}
+	fingerprint, err := caFingerprint(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errJoinTokenMalformed, err)
+	}
+	if !strings.EqualFold(fingerprint, caHash) {
+		return nil, fmt.Errorf("%w: controller CA %s does not match --ca-cert-hash %s", errJoinTokenUntrusted, fingerprint, caHash)
+	}
+	ca, err := parseCACertificate(caPEM)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errJoinTokenMalformed, err)
+	}
+	if err := verifyJoinTokenSignature(ca, []byte(joinTokenPrefix+parts[0]), signature); err != nil {
+		return nil, errJoinTokenTampered
+	}
+	claims.caFingerprint = fingerprint
+
+	if expiresAt := claims.expiresAt(); !expiresAt.IsZero() && now.After(expiresAt) {
+		return nil, fmt.Errorf("%w at %s, create a new one on a controller", errJoinTokenExpired, expiresAt.UTC().Format(time.RFC3339))
+	}
+
+	return &claims, nil
+}
+
+// decodeWorkerToken decodes a token created by 'galley worker invite'
+func decodeWorkerToken(token, caHash string) (*joinTokenClaims, error) {
+	claims, err := decodeJoinToken(token, caHash, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Role != "worker" {
+		return nil, fmt.Errorf("%w: expected a worker token but got a %s token, use 'galley controller join' instead", errJoinTokenWrongRole, claims.Role)
+	}
+
+	return claims, nil
+}
+
+// decodeControllerToken decodes a token created by 'galley controller invite'
+func decodeControllerToken(token, caHash string) (*joinTokenClaims, error) {
+	claims, err := decodeJoinToken(token, caHash, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Role != "controller" && claims.Role != "controller+worker" {
+		return nil, fmt.Errorf("%w: expected a controller token but got a %s token, use 'galley worker join' instead", errJoinTokenWrongRole, claims.Role)
+	}
+
+	return claims, nil
+}
+
+// k0sJoinKubeconfig is the kubeconfig embedded in a k0s join token
+type k0sJoinKubeconfig struct {
+	Clusters []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token string `yaml:"token"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// parseK0sJoinToken decodes a k0s join token (base64 of a gzipped kubeconfig)
+func parseK0sJoinToken(token string) (*k0sJoinKubeconfig, error) {
+	compressed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("k0s token is not valid base64: %w", err)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("k0s token is not gzip compressed: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress k0s token: %w", err)
+	}
+
+	var kubeconfig k0sJoinKubeconfig
+	if err := yaml.Unmarshal(data, &kubeconfig); err != nil {
+		return nil, fmt.Errorf("failed to parse k0s token kubeconfig: %w", err)
+	}
+	if len(kubeconfig.Clusters) == 0 {
+		return nil, fmt.Errorf("k0s token kubeconfig has no clusters")
+	}
+
+	return &kubeconfig, nil
+}
+
+// server returns the API server URL the k0s token points at
+func (k *k0sJoinKubeconfig) server() string {
+	return k.Clusters[0].Cluster.Server
+}
+
+// tokenID returns the public ID of the bootstrap token ("<id>.<secret>"), as shown
+// by 'k0s token list'
+func (k *k0sJoinKubeconfig) tokenID() string {
+	if len(k.Users) == 0 {
+		return ""
+	}
+	id, _, found := strings.Cut(k.Users[0].User.Token, ".")
+	if !found {
+		return ""
+	}
+	return id
+}
+
+// caCertificate returns the PEM encoded cluster CA certificate
+func (k *k0sJoinKubeconfig) caCertificate() ([]byte, error) {
+	data := k.Clusters[0].Cluster.CertificateAuthorityData
+	if data == "" {
+		return nil, fmt.Errorf("k0s token kubeconfig has no CA certificate")
+	}
+	caPEM, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("k0s token CA certificate is not valid base64: %w", err)
+	}
+	return caPEM, nil
+}
+
+func (k *k0sJoinKubeconfig) caFingerprint() (string, error) {
+	caPEM, err := k.caCertificate()
+	if err != nil {
+		return "", err
+	}
+	return caFingerprint(caPEM)
+}
+
+// caFingerprint returns "sha256:<hex>" of the DER encoded certificate in caPEM
+func caFingerprint(caPEM []byte) (string, error) {
+	block, _ := pem.Decode(caPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("no PEM certificate found")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return "", fmt.Errorf("invalid CA certificate: %w", err)
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// parseCACertificate parses the first PEM certificate in caPEM
+func parseCACertificate(caPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(caPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CA certificate: %w", err)
+	}
+	return ca, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS#1, PKCS#8 or SEC 1 private key
+func parsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key format %s", block.Type)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCACertificate returns a self-signed PEM encoded CA certificate
+func testCACertificate(t *testing.T) []byte {
+	t.Helper()
+	caPEM, _ := testCA(t)
+	return caPEM
+}
+
+// testCA returns a self-signed PEM encoded CA certificate and its private key
+func testCA(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// testJoinTokenSigner writes a CA to disk and loads it as a join token signer
+func testJoinTokenSigner(t *testing.T, caPEM, keyPEM []byte) *joinTokenSigner {
+	t.Helper()
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	if err := os.WriteFile(certFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := loadJoinTokenSigner(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("loadJoinTokenSigner() error = %v", err)
+	}
+	return signer
+}
+
+// testK0sToken builds a k0s join token (base64 of a gzipped kubeconfig) for server and caPEM
+func testK0sToken(t *testing.T, server string, caPEM []byte) string {
+	t.Helper()
+
+	kubeconfig := `apiVersion: v1
+clusters:
+- cluster:
+    server: ` + server + `
+    certificate-authority-data: ` + base64.StdEncoding.EncodeToString(caPEM) + `
+  name: k0s
+contexts:
+- context:
+    cluster: k0s
+    user: kubelet-bootstrap
+  name: k0s
+current-context: k0s
+kind: Config
+users:
+- name: kubelet-bootstrap
+  user:
+    token: abcdef.0123456789abcdef
+`
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(kubeconfig))
+	writer.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestParseK0sJoinToken(t *testing.T) {
+	caPEM := testCACertificate(t)
+	kubeconfig, err := parseK0sJoinToken(testK0sToken(t, "https://10.0.0.10:6443", caPEM))
+	if err != nil {
+		t.Fatalf("parseK0sJoinToken() error = %v", err)
+	}
+	if kubeconfig.server() != "https://10.0.0.10:6443" {
+		t.Errorf("server() = %s", kubeconfig.server())
+	}
+
+	fingerprint, err := kubeconfig.caFingerprint()
+	if err != nil {
+		t.Fatalf("caFingerprint() error = %v", err)
+	}
+	want, _ := caFingerprint(caPEM)
+	if fingerprint != want || !strings.HasPrefix(fingerprint, "sha256:") {
+		t.Errorf("caFingerprint() = %s, want %s", fingerprint, want)
+	}
+
+	if _, err := parseK0sJoinToken("!!not-base64!!"); err == nil {
+		t.Error("parseK0sJoinToken() should reject invalid base64")
+	}
+}
+
+func TestJoinTokenEncoding(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	caPEM, keyPEM := testCA(t)
+	signer := testJoinTokenSigner(t, caPEM, keyPEM)
+	caHash := signer.fingerprint
+	k0sToken := testK0sToken(t, "https://10.0.0.10:6443", caPEM)
+
+	newToken := func(role string, expiry time.Duration) string {
+		t.Helper()
+		claims := newJoinTokenClaims("engine.with.dots", role, k0sToken, expiry, now)
+		claims.PlatformURL = "https://galley.example.com"
+		token, err := encodeJoinToken(claims, signer)
+		if err != nil {
+			t.Fatalf("encodeJoinToken() error = %v", err)
+		}
+		return token
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		token := newToken("controller+worker", 30*time.Minute)
+		if !strings.HasPrefix(token, joinTokenPrefix) {
+			t.Errorf("token %q should start with %s", token, joinTokenPrefix)
+		}
+
+		claims, err := decodeJoinToken(token, caHash, now.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("decodeJoinToken() error = %v", err)
+		}
+		if claims.VesselEngineId != "engine.with.dots" || claims.Role != "controller+worker" || claims.K0sToken != k0sToken || claims.PlatformURL != "https://galley.example.com" {
+			t.Errorf("decodeJoinToken() = %+v", claims)
+		}
+		if !claims.expiresAt().Equal(now.Add(30 * time.Minute)) {
+			t.Errorf("expiresAt() = %v", claims.expiresAt())
+		}
+		if claims.caFingerprint != caHash {
+			t.Errorf("caFingerprint = %s, want %s", claims.caFingerprint, caHash)
+		}
+	})
+
+	t.Run("no expiry", func(t *testing.T) {
+		claims, err := decodeJoinToken(newToken("worker", 0), caHash, now.Add(365*24*time.Hour))
+		if err != nil {
+			t.Fatalf("decodeJoinToken() error = %v", err)
+		}
+		if !claims.expiresAt().IsZero() {
+			t.Errorf("expiresAt() = %v, want zero", claims.expiresAt())
+		}
+	})
+
+	// Re-signing a changed payload without the CA key, e.g. with a key of its own
+	forge := func(token string, key []byte) string {
+		parts := strings.Split(strings.TrimPrefix(token, joinTokenPrefix), ".")
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		payload = bytes.Replace(payload, []byte(`"role":"worker"`), []byte(`"role":"controller"`), 1)
+		signed := joinTokenPrefix + base64.RawURLEncoding.EncodeToString(payload)
+		if key == nil {
+			return signed + "." + parts[1]
+		}
+		other, err := parsePrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := (&joinTokenSigner{key: other}).sign([]byte(signed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	_, otherKey := testCA(t)
+	otherCA, _ := testCA(t)
+	otherHash, _ := caFingerprint(otherCA)
+
+	tests := []struct {
+		name    string
+		token   string
+		caHash  string
+		at      time.Time
+		wantErr error
+	}{
+		{name: "expired", token: newToken("worker", 10*time.Minute), caHash: caHash, at: now.Add(11 * time.Minute), wantErr: errJoinTokenExpired},
+		{name: "tampered role", token: forge(newToken("worker", 10*time.Minute), nil), caHash: caHash, at: now, wantErr: errJoinTokenTampered},
+		{name: "re-signed with another key", token: forge(newToken("worker", 10*time.Minute), otherKey), caHash: caHash, at: now, wantErr: errJoinTokenTampered},
+		{name: "other CA pinned", token: newToken("worker", 10*time.Minute), caHash: otherHash, at: now, wantErr: errJoinTokenUntrusted},
+		{name: "no CA pinned", token: newToken("worker", 10*time.Minute), at: now, wantErr: errJoinTokenUntrusted},
+		{name: "truncated", token: newToken("worker", 10*time.Minute)[:40], caHash: caHash, at: now, wantErr: errJoinTokenMalformed},
+		{name: "legacy format", token: "galley1.eyJ2IjoxfQ.c2lnbmF0dXJl", caHash: caHash, at: now, wantErr: errJoinTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeJoinToken(tt.token, tt.caHash, tt.at)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("decodeJoinToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("k0s token of another cluster", func(t *testing.T) {
+		claims := newJoinTokenClaims("engine-1", "worker", testK0sToken(t, "https://10.0.0.10:6443", otherCA), 0, now)
+		if _, err := encodeJoinToken(claims, signer); err == nil {
+			t.Error("encodeJoinToken() should refuse a k0s token of another CA")
+		}
+	})
+
+	t.Run("wrong role", func(t *testing.T) {
+		if _, err := decodeWorkerToken(newToken("controller", 0), caHash); !errors.Is(err, errJoinTokenWrongRole) {
+			t.Errorf("decodeWorkerToken() error = %v, want %v", err, errJoinTokenWrongRole)
+		}
+		if _, err := decodeControllerToken(newToken("worker", 0), caHash); !errors.Is(err, errJoinTokenWrongRole) {
+			t.Errorf("decodeControllerToken() error = %v, want %v", err, errJoinTokenWrongRole)
+		}
+	})
+}
+
+func TestJoinTokenPlatformURL(t *testing.T) {
+	oldFlag := flagPlatformURL
+	defer func() { flagPlatformURL = oldFlag }()
+	claims := &joinTokenClaims{PlatformURL: "https://galley.example.com"}
+
+	flagPlatformURL = ""
+	if got := joinTokenPlatformURL(claims); got != claims.PlatformURL {
+		t.Errorf("joinTokenPlatformURL() = %q, want the platform of the token", got)
+	}
+	flagPlatformURL = "https://other.example.com"
+	if got := joinTokenPlatformURL(claims); got != flagPlatformURL {
+		t.Errorf("joinTokenPlatformURL() = %q, want --platform-url", got)
+	}
+}
+
+func TestLoadJoinTokenSigner(t *testing.T) {
+	caPEM, _ := testCA(t)
+	_, otherKey := testCA(t)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	os.WriteFile(certFile, caPEM, 0644)
+	if _, err := loadJoinTokenSigner(certFile, keyFile); err == nil {
+		t.Error("loadJoinTokenSigner() without a CA key should fail")
+	}
+	os.WriteFile(keyFile, otherKey, 0600)
+	if _, err := loadJoinTokenSigner(certFile, keyFile); err == nil || !strings.Contains(err.Error(), "does not belong") {
+		t.Errorf("loadJoinTokenSigner() with another CA key error = %v", err)
+	}
+}
+
+func TestParseJoinTokenExpiry(t *testing.T) {
+	if got, err := parseJoinTokenExpiry("90m"); err != nil || got != 90*time.Minute {
+		t.Errorf("parseJoinTokenExpiry(90m) = %v, %v", got, err)
+	}
+	for _, expiry := range []string{"soon", "-1h"} {
+		if _, err := parseJoinTokenExpiry(expiry); err == nil {
+			t.Errorf("parseJoinTokenExpiry(%q) should fail", expiry)
+		}
+	}
+}
+
+func TestDescribeTokenExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	if got := describeTokenExpiry(now.Add(90*time.Minute), now); !strings.Contains(got, "1h30m") || !strings.Contains(got, "13:30") {
+		t.Errorf("describeTokenExpiry() = %q", got)
+	}
+	if got := describeTokenExpiry(time.Time{}, now); !strings.Contains(got, "does not expire") {
+		t.Errorf("describeTokenExpiry() = %q", got)
+	}
+}
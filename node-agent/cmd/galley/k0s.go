@@ -2,12 +2,13 @@ package main
 
 import (
   "context"
-  "encoding/base64"
+  "errors"
   "fmt"
   "os"
   "os/exec"
   "runtime"
   "strings"
+  "time"
 )
 
 const (
@@ -15,6 +16,8 @@ const (
   k0sBinaryPath    = "/usr/local/bin/k0s"
   k0sConfigDir     = "/etc/k0s"
   k0sConfigFile    = "/etc/k0s/k0s.yaml"
+  k0sTokenFile     = "/etc/k0s/join-token"
+  k0sDataDir       = "/var/lib/k0s"
   downloadBaseURL  = "https://get.galley.run"
 )
 
@@ -45,6 +48,9 @@ func ensureK0sInstalled() error {
   if err := runCommandWithContext(ctx, "sh", "-c", installCmd); err != nil {
     return fmt.Errorf("failed to install k0s: %w", err)
   }
+  if flagDryRun {
+    return nil
+  }
 
   // Verify installation
   if _, err := exec.LookPath("k0s"); err != nil {
@@ -76,10 +82,15 @@ func createK0sConfig() error {
   fmt.Println("Creating k0s configuration...")
 
   // Create k0s config directory
-  if err := os.MkdirAll(k0sConfigDir, 0755); err != nil {
+  if err := sysExec.MkdirAll(k0sConfigDir, 0755); err != nil {
     return fmt.Errorf("failed to create k0s config directory: %w", err)
   }
 
+  // A dry run before k0s is installed can't render the default config
+  if _, err := exec.LookPath("k0s"); err != nil && dryRunPlan("Create %s with the output of: k0s config create", k0sConfigFile) {
+    return nil
+  }
+
   // Generate default config
   execCmd := exec.Command("k0s", "config", "create")
   output, err := execCmd.Output()
@@ -92,7 +103,7 @@ func createK0sConfig() error {
   }
 
   // Write config to file
-  if err := os.WriteFile(k0sConfigFile, output, 0644); err != nil {
+  if err := sysExec.WriteFile(k0sConfigFile, output, 0644); err != nil {
     return fmt.Errorf("failed to write k0s config: %w", err)
   }
 
@@ -102,8 +113,8 @@ func createK0sConfig() error {
 
 // promptEditK0sConfig asks the user if they want to edit the k0s config
 func promptEditK0sConfig() error {
-  // Verify config file exists
-  if _, err := os.Stat(k0sConfigFile); os.IsNotExist(err) {
+  // Verify config file exists, or would exist after a dry run
+  if _, err := sysExec.ReadFile(k0sConfigFile); os.IsNotExist(err) && !flagDryRun {
     return fmt.Errorf("config file does not exist: %s", k0sConfigFile)
   }
 
@@ -133,13 +144,8 @@ func promptEditK0sConfig() error {
 
   fmt.Printf("Opening configuration with %s...\n", editor)
 
-  // Open editor - editors need interactive terminal, so use exec directly
-  execCmd := exec.Command(editor, k0sConfigFile)
-  execCmd.Stdin = os.Stdin
-  execCmd.Stdout = os.Stdout
-  execCmd.Stderr = os.Stderr
-
-  if err := execCmd.Run(); err != nil {
+  // Open editor, editors need an interactive terminal
+  if err := sysExec.Interactive(editor, k0sConfigFile); err != nil {
     return fmt.Errorf("failed to edit configuration: %w", err)
   }
 
@@ -147,11 +153,15 @@ func promptEditK0sConfig() error {
   return nil
 }
 
-// installK0sController installs k0s as a controller or controller+worker
-func installK0sController(nodeType string) error {
+// installK0sController installs k0s as a controller or controller+worker.
+// When joinToken is set, the node joins an existing control plane as an
+// additional controller instead of bootstrapping a new cluster. nodeArgs set the
+// node name, labels and taints of controller+worker nodes.
+func installK0sController(nodeType string, joinToken string, nodeArgs []string) error {
   fmt.Println("\nInstalling k0s controller...")
   logAction("Installing k0s controller", map[string]string{
-    "node_type": nodeType,
+    "node_type":             nodeType,
+    "additional_controller": fmt.Sprintf("%t", joinToken != ""),
   })
 
   ctx := context.Background()
@@ -172,6 +182,24 @@ func installK0sController(nodeType string) error {
     return fmt.Errorf("unsupported node type: %s (expected 'controller' or 'controller+worker')", nodeType)
   }
 
+  if joinToken != "" {
+    if err := writeK0sTokenFile(joinToken); err != nil {
+      return err
+    }
+    args = append(args, "--token-file", k0sTokenFile)
+  }
+  // Node name, labels, taints and reservations only apply to nodes running a kubelet
+  if strings.ToLower(nodeType) == "controller+worker" {
+    args = append(args, nodeArgs...)
+    if k0sConfigHasWorkerProfiles(k0sConfigFile) {
+      profileArgs, err := workerProfileArgs()
+      if err != nil {
+        return err
+      }
+      args = append(args, profileArgs...)
+    }
+  }
+
   // Install k0s with the specified role and config
   if err := runCommandWithContext(ctx, "k0s", args...); err != nil {
     return fmt.Errorf("failed to install k0s controller: %w", err)
@@ -181,18 +209,25 @@ func installK0sController(nodeType string) error {
   return nil
 }
 
-func installK0sWorker(joinToken string) error {
+// installK0sWorker installs k0s as a worker service using the given join token.
+// The token is written to a root-only token file so it never shows up in the
+// process list or the systemd unit. nodeArgs set the node name, labels, taints and
+// worker profile.
+func installK0sWorker(joinToken string, nodeArgs []string) error {
   fmt.Println("\nInstalling k0s worker...")
   logAction("Installing k0s worker", map[string]string{
-    "join_token": joinToken,
+    "token_file": k0sTokenFile,
   })
 
   ctx := context.Background()
 
-  var args []string
-  args = []string{"worker", joinToken}
+  if err := writeK0sTokenFile(joinToken); err != nil {
+    return err
+  }
 
-  // Install k0s with the specified role and config
+  args := append([]string{"install", "worker", "--token-file", k0sTokenFile}, nodeArgs...)
+
+  // Install k0s as a worker service
   if err := runCommandWithContext(ctx, "k0s", args...); err != nil {
     return fmt.Errorf("failed to install k0s worker: %w", err)
   }
@@ -201,84 +236,167 @@ func installK0sWorker(joinToken string) error {
   return nil
 }
 
-// startK0sService starts the k0s systemd service
-func startK0sService() error {
+// writeK0sTokenFile stores the k0s join token with 0600 permissions
+func writeK0sTokenFile(joinToken string) error {
+  if err := sysExec.MkdirAll(k0sConfigDir, 0755); err != nil {
+    return fmt.Errorf("failed to create k0s config directory: %w", err)
+  }
+
+  if err := sysExec.WriteFile(k0sTokenFile, []byte(strings.TrimSpace(joinToken)+"\n"), 0600); err != nil {
+    return fmt.Errorf("failed to write k0s token file: %w", err)
+  }
+  // WriteFile keeps the mode of an existing file, so enforce it explicitly
+  if err := sysExec.Chmod(k0sTokenFile, 0600); err != nil {
+    return fmt.Errorf("failed to restrict k0s token file permissions: %w", err)
+  }
+  logFileWrite(k0sTokenFile, "k0s join token")
+
+  return nil
+}
+
+// k0sServiceName returns the systemd unit k0s installs for the given node type
+func k0sServiceName(nodeType string) string {
+  if strings.ToLower(nodeType) == "worker" {
+    return "k0sworker"
+  }
+  return "k0scontroller"
+}
+
+// startK0sService enables and starts the k0s systemd service for the node type
+// and waits until the node is actually ready
+func startK0sService(nodeType string, readyTimeout time.Duration) error {
   fmt.Println("\nStarting k0s service...")
 
   ctx := context.Background()
+  service := k0sServiceName(nodeType)
 
   // Enable k0s service to start on boot
-  if err := runCommandWithContext(ctx, "systemctl", "enable", "k0scontroller"); err != nil {
+  if err := runCommandWithContext(ctx, "systemctl", "enable", service); err != nil {
     return fmt.Errorf("failed to enable k0s service: %w", err)
   }
+  logServiceChange(service, "enabled")
 
   // Start k0s service
-  if err := runCommandWithContext(ctx, "systemctl", "start", "k0scontroller"); err != nil {
+  if err := runCommandWithContext(ctx, "systemctl", "start", service); err != nil {
     return fmt.Errorf("failed to start k0s service: %w", err)
   }
+  logServiceChange(service, "started")
 
-  // Wait a moment for service to initialize
-  fmt.Println("Waiting for k0s to initialize...")
-  if err := runCommandWithContext(ctx, "sleep", "5"); err != nil {
-    return fmt.Errorf("failed to wait: %w", err)
-  }
-
-  // Check service status
-  if err := runCommandWithContext(ctx, "systemctl", "status", "k0scontroller", "--no-pager"); err != nil {
-    fmt.Println("Warning: k0s service status check failed, but continuing...")
+  // Wait until k0s, the API server and the node report ready
+  if err := waitForK0sReady(nodeType, readyTimeout); err != nil {
+    return fmt.Errorf("k0s did not become ready: %w", err)
   }
 
   fmt.Println("✓ k0s service started successfully")
   return nil
 }
 
-func encodeWorkerToken(vesselEngineId, joinToken string) string {
-  raw := vesselEngineId + ".worker." + joinToken
-  return base64.StdEncoding.EncodeToString([]byte(raw))
-}
+// errDryRunToken is returned instead of a join token during a dry run
+var errDryRunToken = errors.New("a dry run creates no join token")
 
-func decodeWorkerToken(token string) (string, string, error) {
-  payload, _ := base64.StdEncoding.DecodeString(token)
+// generateJoinToken creates a k0s join token for the given k0s role (worker or controller)
+func generateJoinToken(role, expiryTime string) (string, error) {
+  fmt.Printf("\nGenerating %s join token...\n", role)
 
-  parts := strings.Split(string(payload), ".")
+  // Create token
+  output, err := sysExec.Output(context.Background(), "k0s", "token", "create", "--role", role, "--expiry="+expiryTime)
+  if err != nil {
+    return "", fmt.Errorf("failed to generate %s token: %w", role, err)
+  }
+  if flagDryRun {
+    return "", errDryRunToken
+  }
 
-  if len(parts) != 3 {
-    return "", "", fmt.Errorf("token is incorrect")
+  token := strings.TrimSpace(string(output))
+  if token == "" {
+    return "", fmt.Errorf("generated token is empty")
   }
 
-  vesselEngineId := parts[0]
-  nodeType := parts[1]
-  joinToken := parts[2]
+  logAction("Generated k0s join token", map[string]string{
+    "role":   role,
+    "expiry": expiryTime,
+  })
 
-  if nodeType != "worker" {
-    return "", "", fmt.Errorf("join token is not a worker token but a: %s", nodeType)
+  fmt.Printf("✓ %s join token generated\n", strings.ToUpper(role[:1])+role[1:])
+  return token, nil
+}
+
+// createJoinToken generates a k0s join token and wraps it in a Galley join token
+// for the given node type (worker, controller or controller+worker). With a
+// platform token the token record is sent to the Galley platform. A token for a
+// platform node carries the name, labels and taints the node registers with.
+func createJoinToken(vesselEngineId, nodeType, expiryTime, platformToken string, node *joinTokenNode) (string, *joinTokenClaims, error) {
+  role := "worker"
+  if strings.HasPrefix(nodeType, "controller") {
+    role = "controller"
   }
 
-  return vesselEngineId, joinToken, nil
-}
+  // Validate everything before k0s creates a token, so a mistake leaves no
+  // usable token behind
+  expiry, err := parseJoinTokenExpiry(expiryTime)
+  if err != nil {
+    return "", nil, err
+  }
+  var cplb *cplbSettings
+  if role == "controller" {
+    // Additional controllers join the keepalived VIP with the same auth pass
+    if cplb, err = readControlPlaneLoadBalancing(k0sConfigFile); err != nil {
+      return "", nil, fmt.Errorf("failed to read control plane load balancing settings: %w", err)
+    }
+  }
+  var signer *joinTokenSigner
+  var registryKey string
+  var registries []VesselEngineRegistryAttributes
+  if !flagDryRun {
+    if signer, err = loadJoinTokenSigner(k0sCACertFile, k0sCAKeyFile); err != nil {
+      return "", nil, err
+    }
+    // Joining nodes apply the registry configuration of this controller
+    if registryKey, registries, err = joinTokenRegistries(); err != nil {
+      return "", nil, err
+    }
+  }
 
-// generateWorkerToken creates a worker join token with 1 hour expiry
-func generateWorkerToken(expiryTime string) (string, error) {
-  fmt.Println("\nGenerating worker join token...")
+  k0sToken, err := generateJoinToken(role, expiryTime)
+  if err != nil {
+    return "", nil, err
+  }
 
-  // Create token
-  cmd := exec.Command("k0s", "token", "create", "--role", "worker", "--expiry="+expiryTime)
-  output, err := cmd.Output()
+  claims := newJoinTokenClaims(vesselEngineId, nodeType, k0sToken, expiry, time.Now())
+  // Workers only select a worker profile when the controllers define them
+  claims.WorkerProfiles = k0sConfigHasWorkerProfiles(k0sConfigFile)
+  claims.CPLB = cplb
+  claims.Node = node
+  claims.PlatformURL = getPlatformURL()
+  claims.RegistryKey = registryKey
+  claims.Registries = registries
+
+  token, err := encodeJoinToken(claims, signer)
   if err != nil {
-    return "", fmt.Errorf("failed to generate worker token: %w", err)
+    if kubeconfig, parseErr := parseK0sJoinToken(k0sToken); parseErr == nil && kubeconfig.tokenID() != "" {
+      _ = runCommandWithContext(context.Background(), "k0s", "token", "invalidate", kubeconfig.tokenID())
+    }
+    return "", nil, err
   }
 
-  token := strings.TrimSpace(string(output))
-  if token == "" {
-    return "", fmt.Errorf("generated token is empty")
+  if err := recordJoinToken(claims, platformToken); err != nil {
+    fmt.Printf("⚠️  Failed to record join token, it will not show up as created by Galley: %v\n", err)
   }
 
-  fmt.Println("✓ Worker join token generated")
-  return token, nil
+  return token, claims, nil
+}
+
+// describeTokenExpiry returns a human readable note about when a join token expires
+func describeTokenExpiry(expiresAt time.Time, now time.Time) string {
+  if expiresAt.IsZero() {
+    return "This token does not expire, revoke it once your nodes have joined"
+  }
+  return fmt.Sprintf("This token expires at %s (in %s)",
+    expiresAt.UTC().Format("2006-01-02 15:04 MST"), expiresAt.Sub(now).Round(time.Minute))
 }
 
 // displayWorkerJoinInstructions shows instructions for joining a worker node
-func displayWorkerJoinInstructions(vesselEngineId, workerToken string) {
+func displayWorkerJoinInstructions(token string, claims *joinTokenClaims) {
   fmt.Println("\n" + strings.Repeat("=", 70))
   fmt.Println("Add Worker Nodes to Your Cluster")
   fmt.Println(strings.Repeat("=", 70))
@@ -288,12 +406,40 @@ func displayWorkerJoinInstructions(vesselEngineId, workerToken string) {
   fmt.Println("\n  # Step 2: Provision the node with all prerequisites")
   fmt.Println("sudo galley node prepare")
   fmt.Println("\n  # Step 3: Join the cluster")
-  fmt.Printf("  sudo galley worker join %s\n", encodeWorkerToken(vesselEngineId, workerToken))
+  fmt.Printf("  sudo galley worker join --ca-cert-hash %s %s\n", claims.caFingerprint, token)
   fmt.Println("\n" + strings.Repeat("=", 70))
-  fmt.Println("Note: This token expires in 60 minutes")
+  fmt.Println("Note: --ca-cert-hash pins the CA of this cluster, the joining node only trusts")
+  fmt.Println("      tokens signed by it. Take the hash from this output, not from the token.")
+  fmt.Println("Note: " + describeTokenExpiry(claims.expiresAt(), time.Now()))
   fmt.Println("You can prepare nodes ahead of time, then join them when ready.")
   fmt.Println(strings.Repeat("-", 70) + "\n")
   fmt.Println("If your token expires, run `galley worker invite` on the controller node to generate a new one.")
   fmt.Println(strings.Repeat("=", 70) + "\n")
 
 }
+
+// displayControllerJoinInstructions shows instructions for joining an additional controller node
+func displayControllerJoinInstructions(token string, claims *joinTokenClaims) {
+  fmt.Println("\n" + strings.Repeat("=", 70))
+  fmt.Println("Add Controller Nodes to Your Cluster")
+  fmt.Println(strings.Repeat("=", 70))
+  fmt.Println("\nTo add a controller, SSH into the new controller node and run:")
+  fmt.Println("\n  # Step 1: Install the Galley CLI")
+  fmt.Printf("curl -sSf %s | sudo sh\n", downloadBaseURL)
+  fmt.Println("\n  # Step 2: Provision the node with all prerequisites")
+  fmt.Println("sudo galley node prepare")
+  fmt.Println("\n  # Step 3: Join the control plane")
+  fmt.Printf("  sudo galley controller join --ca-cert-hash %s %s\n", claims.caFingerprint, token)
+  fmt.Println("\n" + strings.Repeat("=", 70))
+  fmt.Println("Note: --ca-cert-hash pins the CA of this cluster, the joining node only trusts")
+  fmt.Println("      tokens signed by it. Take the hash from this output, not from the token.")
+  fmt.Println("Note: " + describeTokenExpiry(claims.expiresAt(), time.Now()))
+  fmt.Println("Note: Use an odd number of controllers (3 or 5) to keep etcd quorum.")
+  if claims.CPLB != nil {
+    fmt.Printf("The token carries the control plane load balancing settings (VIP %s).\n", claims.CPLB.VirtualIP)
+  }
+  fmt.Println("Every controller must use the same k0s configuration.")
+  fmt.Println(strings.Repeat("-", 70) + "\n")
+  fmt.Println("If your token expires, run `galley controller invite` on a controller node to generate a new one.")
+  fmt.Println(strings.Repeat("=", 70) + "\n")
+}
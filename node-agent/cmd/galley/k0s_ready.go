@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	k0sReadyPollInterval   = 3 * time.Second
+	k0sReadyProbeTimeout   = 10 * time.Second
+	k0sKubeletKubeconfig   = k0sDataDir + "/kubelet.conf"
+	k0sReadyJournalLines   = 30
+	defaultK0sReadyTimeout = 5 * time.Minute
+)
+
+// readinessCheck is a single condition that must hold before k0s is considered ready.
+// probe returns whether the condition holds and a short status for the progress line.
+type readinessCheck struct {
+	name  string
+	probe func(ctx context.Context) (bool, string)
+}
+
+// waitForK0sReady blocks until k0s is running, the API server is ready (controllers)
+// and the node reports Ready (nodes running a kubelet), or the timeout expires.
+func waitForK0sReady(nodeType string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultK0sReadyTimeout
+	}
+	if dryRunPlan("Wait up to %s for %s to become ready", timeout, k0sServiceName(nodeType)) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	nodeName := getK0sNodeName()
+	checks := k0sReadinessChecks(nodeType, nodeName)
+
+	fmt.Printf("Waiting for k0s to become ready (timeout %s)...\n", timeout)
+	logAction("Waiting for k0s readiness", map[string]string{
+		"node_type": nodeType,
+		"node_name": nodeName,
+		"timeout":   timeout.String(),
+	})
+
+	start := time.Now()
+	for i, check := range checks {
+		if err := pollReadinessCheck(ctx, check, i+1, len(checks), start); err != nil {
+			service := k0sServiceName(nodeType)
+			journal := recentJournalLines(service, k0sReadyJournalLines)
+			logError("k0s readiness: "+check.name, err)
+			if journal != "" {
+				return fmt.Errorf("%w\n\nLast %d journal lines of %s:\n%s", err, k0sReadyJournalLines, service, journal)
+			}
+			return err
+		}
+	}
+
+	fmt.Printf("✓ k0s is ready (took %s)\n", time.Since(start).Round(time.Second))
+	return nil
+}
+
+// k0sReadinessChecks returns the ordered checks that apply to the node type
+func k0sReadinessChecks(nodeType string, nodeName string) []readinessCheck {
+	nodeType = strings.ToLower(nodeType)
+
+	checks := []readinessCheck{
+		{name: "k0s status", probe: probeK0sStatus},
+	}
+
+	if nodeType != "worker" {
+		checks = append(checks, readinessCheck{name: "kube-apiserver /readyz", probe: probeAPIServerReadyz})
+	}
+
+	switch nodeType {
+	case "worker":
+		checks = append(checks, readinessCheck{
+			name: fmt.Sprintf("node %s Ready", nodeName),
+			probe: func(ctx context.Context) (bool, string) {
+				return probeNodeReady(ctx, nodeName, "--kubeconfig", k0sKubeletKubeconfig)
+			},
+		})
+	case "controller+worker", "controller-worker":
+		checks = append(checks, readinessCheck{
+			name: fmt.Sprintf("node %s Ready", nodeName),
+			probe: func(ctx context.Context) (bool, string) {
+				return probeNodeReady(ctx, nodeName)
+			},
+		})
+	}
+
+	return checks
+}
+
+// pollReadinessCheck re-runs a check until it passes or the context expires,
+// keeping a single progress line updated in place.
+func pollReadinessCheck(ctx context.Context, check readinessCheck, index, total int, start time.Time) error {
+	lastStatus := ""
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, k0sReadyProbeTimeout)
+		ok, status := check.probe(probeCtx)
+		cancel()
+
+		if status != "" {
+			lastStatus = status
+		}
+
+		if ok {
+			fmt.Printf("\r\033[K  [%d/%d] ✓ %s\n", index, total, check.name)
+			return nil
+		}
+
+		fmt.Printf("\r\033[K  [%d/%d] ⏳ %s (%s elapsed) %s", index, total, check.name, time.Since(start).Round(time.Second), truncateStatus(lastStatus))
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			if lastStatus == "" {
+				lastStatus = "no response"
+			}
+			return fmt.Errorf("timed out waiting for %s: %s", check.name, lastStatus)
+		case <-time.After(k0sReadyPollInterval):
+		}
+	}
+}
+
+func probeK0sStatus(ctx context.Context) (bool, string) {
+	output, err := exec.CommandContext(ctx, "k0s", "status").CombinedOutput()
+	if err != nil {
+		return false, firstLine(string(output), err)
+	}
+	return true, ""
+}
+
+func probeAPIServerReadyz(ctx context.Context) (bool, string) {
+	output, err := exec.CommandContext(ctx, "k0s", "kubectl", "get", "--raw=/readyz").CombinedOutput()
+	if err != nil {
+		return false, firstLine(string(output), err)
+	}
+	if strings.TrimSpace(string(output)) != "ok" {
+		return false, firstLine(string(output), nil)
+	}
+	return true, ""
+}
+
+func probeNodeReady(ctx context.Context, nodeName string, kubectlArgs ...string) (bool, string) {
+	args := append([]string{"kubectl"}, kubectlArgs...)
+	args = append(args, "get", "node", nodeName, "-o", "json")
+
+	output, err := exec.CommandContext(ctx, "k0s", args...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return false, firstLine(string(exitErr.Stderr), err)
+		}
+		return false, err.Error()
+	}
+
+	return parseNodeReady(output)
+}
+
+// parseNodeReady reads the Ready condition from a `kubectl get node -o json` document
+func parseNodeReady(data []byte) (bool, string) {
+	var node struct {
+		Status struct {
+			Conditions []struct {
+				Type    string `json:"type"`
+				Status  string `json:"status"`
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"conditions"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(data, &node); err != nil {
+		return false, fmt.Sprintf("failed to parse node: %v", err)
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != "Ready" {
+			continue
+		}
+		if condition.Status == "True" {
+			return true, ""
+		}
+		if condition.Message != "" {
+			return false, condition.Message
+		}
+		return false, condition.Reason
+	}
+
+	return false, "node has no Ready condition yet"
+}
+
+// getK0sNodeName returns the Kubernetes node name k0s registers this host with,
+// the platform node name when one was set during join
+func getK0sNodeName() string {
+	if config, err := loadConfig(); err == nil && config.NodeName != "" {
+		return config.NodeName
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(hostname)
+}
+
+// recentJournalLines returns the last lines logged by a systemd unit
+func recentJournalLines(service string, lines int) string {
+	output, err := exec.Command("journalctl", "-u", service, "-n", fmt.Sprintf("%d", lines), "--no-pager").CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(output), "\n")
+}
+
+func firstLine(output string, err error) string {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		if err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+	if idx := strings.Index(output, "\n"); idx != -1 {
+		return output[:idx]
+	}
+	return output
+}
+
+func truncateStatus(status string) string {
+	const maxLen = 60
+	if len(status) > maxLen {
+		return status[:maxLen-3] + "..."
+	}
+	return status
+}
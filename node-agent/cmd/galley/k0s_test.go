@@ -5,6 +5,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestEnsureK0sInstalled(t *testing.T) {
@@ -286,7 +287,7 @@ func TestInstallK0sController(t *testing.T) {
 
 func TestStartK0sService(t *testing.T) {
 	t.Run("validates systemd service name", func(t *testing.T) {
-		serviceName := "k0scontroller"
+		serviceName := k0sServiceName("controller")
 
 		if serviceName == "" {
 			t.Error("k0s service name should not be empty")
@@ -299,6 +300,26 @@ func TestStartK0sService(t *testing.T) {
 	})
 }
 
+func TestK0sServiceName(t *testing.T) {
+	tests := []struct {
+		nodeType string
+		want     string
+	}{
+		{nodeType: "controller", want: "k0scontroller"},
+		{nodeType: "controller+worker", want: "k0scontroller"},
+		{nodeType: "worker", want: "k0sworker"},
+		{nodeType: "Worker", want: "k0sworker"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.nodeType, func(t *testing.T) {
+			if got := k0sServiceName(tt.nodeType); got != tt.want {
+				t.Errorf("k0sServiceName(%q) = %q, want %q", tt.nodeType, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestK0sNodeTypes(t *testing.T) {
 	t.Run("validates supported node types", func(t *testing.T) {
 		supportedTypes := []string{"controller", "controller+worker", "controller-worker"}
@@ -346,12 +367,16 @@ func TestGenerateWorkerToken(t *testing.T) {
 func TestDisplayWorkerJoinInstructions(t *testing.T) {
 	t.Run("validates instruction format", func(t *testing.T) {
 		// Test with a dummy token
-		testToken := "test-token-12345"
-		vesselEngineId := "00D8B226-C47C-46F2-981B-A81BE8EE4213"
+		testToken := "galley1.test-token-12345"
+		claims := &joinTokenClaims{
+			VesselEngineId: "00D8B226-C47C-46F2-981B-A81BE8EE4213",
+			Role:           "worker",
+			ExpiresAt:      time.Now().Add(10 * time.Minute).Unix(),
+		}
 
 		// We can't easily test the actual display output, but we can verify
 		// the function doesn't panic and handles the token correctly
-		displayWorkerJoinInstructions(vesselEngineId, testToken)
+		displayWorkerJoinInstructions(testToken, claims)
 
 		// If we got here without panic, the function works
 		t.Log("displayWorkerJoinInstructions executed successfully")
@@ -388,3 +413,76 @@ func TestDisplayWorkerJoinInstructions(t *testing.T) {
 		}
 	})
 }
+
+func TestParseNodeReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		wantReady  bool
+		wantStatus string
+	}{
+		{
+			name:      "ready node",
+			data:      `{"status":{"conditions":[{"type":"MemoryPressure","status":"False"},{"type":"Ready","status":"True","reason":"KubeletReady"}]}}`,
+			wantReady: true,
+		},
+		{
+			name:       "not ready with message",
+			data:       `{"status":{"conditions":[{"type":"Ready","status":"False","reason":"KubeletNotReady","message":"container runtime network not ready"}]}}`,
+			wantStatus: "container runtime network not ready",
+		},
+		{
+			name:       "not ready without message",
+			data:       `{"status":{"conditions":[{"type":"Ready","status":"Unknown","reason":"NodeStatusUnknown"}]}}`,
+			wantStatus: "NodeStatusUnknown",
+		},
+		{
+			name:       "no conditions yet",
+			data:       `{"status":{}}`,
+			wantStatus: "node has no Ready condition yet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready, status := parseNodeReady([]byte(tt.data))
+			if ready != tt.wantReady {
+				t.Errorf("parseNodeReady() ready = %v, want %v", ready, tt.wantReady)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("parseNodeReady() status = %q, want %q", status, tt.wantStatus)
+			}
+		})
+	}
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		if ready, _ := parseNodeReady([]byte("not json")); ready {
+			t.Error("parseNodeReady() should not report ready for invalid JSON")
+		}
+	})
+}
+
+func TestK0sReadinessChecks(t *testing.T) {
+	tests := []struct {
+		nodeType string
+		want     []string
+	}{
+		{nodeType: "controller", want: []string{"k0s status", "kube-apiserver /readyz"}},
+		{nodeType: "controller+worker", want: []string{"k0s status", "kube-apiserver /readyz", "node node-1 Ready"}},
+		{nodeType: "worker", want: []string{"k0s status", "node node-1 Ready"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.nodeType, func(t *testing.T) {
+			checks := k0sReadinessChecks(tt.nodeType, "node-1")
+			if len(checks) != len(tt.want) {
+				t.Fatalf("k0sReadinessChecks() returned %d checks, want %d", len(checks), len(tt.want))
+			}
+			for i, check := range checks {
+				if check.name != tt.want[i] {
+					t.Errorf("check %d = %q, want %q", i, check.name, tt.want[i])
+				}
+			}
+		})
+	}
+}
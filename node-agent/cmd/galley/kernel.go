@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	galleyModulesLoadFile = "/etc/modules-load.d/galley.conf"
+	galleySysctlFile      = "/etc/sysctl.d/90-galley-k8s.conf"
+	fstabFile             = "/etc/fstab"
+	fstabSwapComment      = "# disabled by galley: "
+)
+
+// kernelModules are required by containerd (overlay) and the CNI (br_netfilter)
+var kernelModules = []string{"overlay", "br_netfilter"}
+
+// kernelSysctls are the sysctl settings Kubernetes networking and busy nodes need
+var kernelSysctls = []struct {
+	Key   string
+	Value string
+}{
+	{"net.ipv4.ip_forward", "1"},
+	{"net.ipv6.conf.all.forwarding", "1"},
+	{"net.bridge.bridge-nf-call-iptables", "1"},
+	{"net.bridge.bridge-nf-call-ip6tables", "1"},
+	{"fs.inotify.max_user_watches", "524288"},
+	{"fs.inotify.max_user_instances", "8192"},
+}
+
+// configureKernelPrerequisites loads the kernel modules, applies the sysctls and
+// disables swap on nodes that run a kubelet, both for the running system and
+// persistently
+func configureKernelPrerequisites(nodeType string) error {
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Println("Kernel Prerequisites")
+	fmt.Println(strings.Repeat("=", 70))
+
+	ctx := context.Background()
+
+	// Kernel modules
+	if err := writeSystemFile(galleyModulesLoadFile, renderModulesLoadConf(), "Load kernel modules for Kubernetes at boot"); err != nil {
+		return err
+	}
+	for _, module := range kernelModules {
+		if err := runCommandWithContext(ctx, "modprobe", module); err != nil {
+			return fmt.Errorf("failed to load kernel module %s: %w", module, err)
+		}
+	}
+	fmt.Printf("✓ Kernel modules loaded: %s\n", strings.Join(kernelModules, ", "))
+
+	// Sysctls, br_netfilter must be loaded before the bridge settings exist
+	if err := writeSystemFile(galleySysctlFile, renderSysctlConf(), "Kernel settings for Kubernetes networking and inotify limits"); err != nil {
+		return err
+	}
+	// -e skips keys the kernel doesn't have, like IPv6 settings when IPv6 is disabled
+	if err := runCommandWithContext(ctx, "sysctl", "-e", "-p", galleySysctlFile); err != nil {
+		return fmt.Errorf("failed to apply sysctl settings: %w", err)
+	}
+	fmt.Println("✓ Kernel settings applied")
+
+	// Swap, kubelet refuses to start with swap enabled by default
+	if nodeRunsKubelet(nodeType) {
+		if err := disableSwap(ctx); err != nil {
+			return err
+		}
+	} else {
+		fmt.Println("✓ Swap left as is, a controller runs no kubelet")
+	}
+
+	// A dry run loaded nothing, so there is nothing to verify
+	if flagDryRun {
+		return nil
+	}
+
+	if problems := verifyKernelPrerequisites(nodeType); len(problems) > 0 {
+		return fmt.Errorf("kernel prerequisites are not in effect: %s", strings.Join(problems, "; "))
+	}
+
+	fmt.Println("✓ Kernel prerequisites verified")
+	logAction("Configured kernel prerequisites", map[string]string{
+		"modules": strings.Join(kernelModules, ","),
+		"sysctl":  galleySysctlFile,
+	})
+	return nil
+}
+
+func disableSwap(ctx context.Context) error {
+	if err := runCommandWithContext(ctx, "swapoff", "-a"); err != nil {
+		return fmt.Errorf("failed to disable swap: %w", err)
+	}
+
+	content, err := sysExec.ReadFile(fstabFile)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", fstabFile, err)
+	}
+
+	updated, disabled := disableSwapInFstab(string(content))
+	if len(disabled) > 0 {
+		if err := writeSystemFile(fstabFile, updated, "Disabled swap entries: "+strings.Join(disabled, ", ")); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("✓ Swap disabled")
+	return nil
+}
+
+// writeSystemFile writes a root-owned configuration file and logs the change
+func writeSystemFile(path, content, description string) error {
+	if err := sysExec.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := sysExec.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	logFileWrite(path, description)
+	return nil
+}
+
+func renderModulesLoadConf() string {
+	return "# Managed by Galley: kernel modules required by Kubernetes\n" + strings.Join(kernelModules, "\n") + "\n"
+}
+
+func renderSysctlConf() string {
+	var b strings.Builder
+	b.WriteString("# Managed by Galley: kernel settings required by Kubernetes\n")
+	for _, sysctl := range kernelSysctls {
+		fmt.Fprintf(&b, "%s = %s\n", sysctl.Key, sysctl.Value)
+	}
+	return b.String()
+}
+
+// disableSwapInFstab comments out active swap entries and returns the new content
+// together with the devices that were disabled
+func disableSwapInFstab(content string) (string, []string) {
+	lines := strings.Split(content, "\n")
+	var disabled []string
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		fields := strings.Fields(trimmed)
+		if len(fields) >= 3 && fields[2] == "swap" {
+			lines[i] = fstabSwapComment + line
+			disabled = append(disabled, fields[0])
+		}
+	}
+	return strings.Join(lines, "\n"), disabled
+}
+
+// sysctlProcPath returns the /proc/sys path of a sysctl key
+func sysctlProcPath(key string) string {
+	return filepath.Join("/proc/sys", strings.ReplaceAll(key, ".", "/"))
+}
+
+// verifyKernelPrerequisites checks the running system and returns what is not in effect
+func verifyKernelPrerequisites(nodeType string) []string {
+	var problems []string
+	for _, module := range kernelModules {
+		if !kernelModuleAvailable(module) {
+			problems = append(problems, "module "+module+" is not loaded")
+		}
+	}
+	for _, sysctl := range kernelSysctls {
+		value, err := os.ReadFile(sysctlProcPath(sysctl.Key))
+		if err != nil {
+			// IPv6 can be disabled on the kernel command line
+			if strings.HasPrefix(sysctl.Key, "net.ipv6.") && os.IsNotExist(err) {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("%s could not be read", sysctl.Key))
+			continue
+		}
+		if strings.TrimSpace(string(value)) != sysctl.Value {
+			problems = append(problems, fmt.Sprintf("%s is %s, expected %s", sysctl.Key, strings.TrimSpace(string(value)), sysctl.Value))
+		}
+	}
+	if swaps, err := os.ReadFile("/proc/swaps"); err == nil {
+		if result := evaluateSwaps(string(swaps), nodeType); result.Status != doctorPass {
+			problems = append(problems, result.Message)
+		}
+	}
+	return problems
+}
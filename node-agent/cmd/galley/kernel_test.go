@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisableSwapInFstab(t *testing.T) {
+	fstab := `# /etc/fstab: static file system information.
+UUID=1234-abcd / ext4 defaults 0 1
+/swap.img	none	swap	sw	0	0
+# /dev/sdb1 none swap sw 0 0
+UUID=5678-efgh none swap defaults 0 0
+`
+
+	updated, disabled := disableSwapInFstab(fstab)
+	if len(disabled) != 2 || disabled[0] != "/swap.img" || disabled[1] != "UUID=5678-efgh" {
+		t.Errorf("disableSwapInFstab() disabled = %v", disabled)
+	}
+	if !strings.Contains(updated, fstabSwapComment+"/swap.img") {
+		t.Errorf("disableSwapInFstab() should comment out the swap file:\n%s", updated)
+	}
+	if !strings.Contains(updated, "\nUUID=1234-abcd / ext4 defaults 0 1\n") {
+		t.Errorf("disableSwapInFstab() should keep other entries:\n%s", updated)
+	}
+
+	again, disabledAgain := disableSwapInFstab(updated)
+	if len(disabledAgain) != 0 || again != updated {
+		t.Error("disableSwapInFstab() should be idempotent")
+	}
+}
+
+func TestKernelConfigFiles(t *testing.T) {
+	modules := renderModulesLoadConf()
+	for _, module := range kernelModules {
+		if !strings.Contains(modules, "\n"+module+"\n") {
+			t.Errorf("modules-load.d config should load %s:\n%s", module, modules)
+		}
+	}
+
+	sysctl := renderSysctlConf()
+	for _, want := range []string{"net.ipv4.ip_forward = 1", "net.bridge.bridge-nf-call-iptables = 1", "fs.inotify.max_user_watches = 524288"} {
+		if !strings.Contains(sysctl, want) {
+			t.Errorf("sysctl config should contain %q:\n%s", want, sysctl)
+		}
+	}
+}
+
+func TestSysctlProcPath(t *testing.T) {
+	if got := sysctlProcPath("net.bridge.bridge-nf-call-iptables"); got != "/proc/sys/net/bridge/bridge-nf-call-iptables" {
+		t.Errorf("sysctlProcPath() = %s", got)
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Kubernetes rejects client certificate requests shorter than 10 minutes
+	minKubeconfigExpiry  = 10 * time.Minute
+	kubeconfigCSRTimeout = 30 * time.Second
+)
+
+// kubeconfigRolePresets maps the --role presets to the ClusterRole they bind
+var kubeconfigRolePresets = map[string]string{
+	"admin": "cluster-admin",
+	"edit":  "edit",
+	"view":  "view",
+}
+
+var (
+	flagKubeconfigServer string
+	flagKubeconfigOutput string
+	flagKubeconfigMerge  bool
+	flagKubeconfigUser   string
+	flagKubeconfigGroups []string
+	flagKubeconfigExpiry time.Duration
+	flagKubeconfigRole   string
+)
+
+var kubeconfigCmd = &cobra.Command{
+	Use:   "kubeconfig",
+	Short: "Create kubeconfig files to access your cluster",
+	Long: `Creates kubeconfig files for your cluster, run on a controller node.
+
+The server address is rewritten to the control plane virtual IP when control plane
+load balancing is enabled, or to the public IP address of this controller.`,
+}
+
+var kubeconfigAdminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Create a cluster admin kubeconfig",
+	Long: `Creates a kubeconfig with full cluster admin access using 'k0s kubeconfig admin'.
+
+Treat the result like a root password: anyone holding it controls the cluster.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		output, err := exec.Command("k0s", "kubeconfig", "admin").Output()
+		if err != nil {
+			return fmt.Errorf("failed to create admin kubeconfig: %w", err)
+		}
+
+		config, err := parseKubeconfig(output)
+		if err != nil {
+			return err
+		}
+
+		name, err := galleyKubeconfigName("admin")
+		if err != nil {
+			return err
+		}
+		server, err := resolveKubeconfigServer(flagKubeconfigServer)
+		if err != nil {
+			return err
+		}
+		if err := config.normalize(name, server); err != nil {
+			return err
+		}
+
+		logAction("Created admin kubeconfig", map[string]string{
+			"context": name,
+			"server":  server,
+		})
+		return writeKubeconfig(config, name)
+	},
+}
+
+var kubeconfigCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a kubeconfig for a user with an expiring client certificate",
+	Long: `Creates a kubeconfig for a user, signed by the cluster CA through the Kubernetes
+certificate signing request API, so the certificate expires after --expiry.
+
+With --role a ClusterRoleBinding from a preset is applied for the user:
+  admin  full cluster admin (cluster-admin)
+  edit   read/write access to most namespaced resources (edit)
+  view   read-only access to most namespaced resources (view)`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		if flagKubeconfigUser == "" {
+			return fmt.Errorf("--user is required")
+		}
+		if flagKubeconfigExpiry < minKubeconfigExpiry {
+			return fmt.Errorf("--expiry must be at least %s", minKubeconfigExpiry)
+		}
+		if flagKubeconfigRole != "" {
+			if _, ok := kubeconfigRolePresets[flagKubeconfigRole]; !ok {
+				return fmt.Errorf("unknown role preset %q, expected admin, edit or view", flagKubeconfigRole)
+			}
+		}
+
+		name, err := galleyKubeconfigName(flagKubeconfigUser)
+		if err != nil {
+			return err
+		}
+		server, err := resolveKubeconfigServer(flagKubeconfigServer)
+		if err != nil {
+			return err
+		}
+
+		// The certificate only exists once the cluster signed it
+		if dryRunPlan("Create kubeconfig %s for user %s (groups %v, expiry %s, role %q) with server %s",
+			name, flagKubeconfigUser, flagKubeconfigGroups, flagKubeconfigExpiry, flagKubeconfigRole, server) {
+			return nil
+		}
+
+		caPEM, err := os.ReadFile(k0sCACertFile)
+		if err != nil {
+			return fmt.Errorf("failed to read cluster CA, is this a controller node? %w", err)
+		}
+
+		ctx := cobraCmd.Context()
+		certPEM, keyPEM, err := issueClientCertificate(ctx, flagKubeconfigUser, flagKubeconfigGroups, flagKubeconfigExpiry)
+		if err != nil {
+			return err
+		}
+
+		if flagKubeconfigRole != "" {
+			binding, err := clusterRoleBindingManifest(flagKubeconfigUser, flagKubeconfigRole)
+			if err != nil {
+				return err
+			}
+			if err := k0sKubectlApply(ctx, binding); err != nil {
+				return fmt.Errorf("failed to apply ClusterRoleBinding: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "✓ Bound user %s to ClusterRole %s\n", flagKubeconfigUser, kubeconfigRolePresets[flagKubeconfigRole])
+		}
+
+		config := newClientKubeconfig(name, server, caPEM, certPEM, keyPEM)
+
+		logAction("Created user kubeconfig", map[string]string{
+			"user":    flagKubeconfigUser,
+			"groups":  strings.Join(flagKubeconfigGroups, ","),
+			"expiry":  flagKubeconfigExpiry.String(),
+			"role":    flagKubeconfigRole,
+			"context": name,
+		})
+		return writeKubeconfig(config, name)
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{kubeconfigAdminCmd, kubeconfigCreateCmd} {
+		cmd.Flags().StringVar(&flagKubeconfigServer, "server", "", "API server address to use instead of the VIP or public IP")
+		cmd.Flags().StringVarP(&flagKubeconfigOutput, "output", "o", "", "Write the kubeconfig to this file instead of stdout")
+		cmd.Flags().BoolVar(&flagKubeconfigMerge, "merge", false, "Merge the kubeconfig into ~/.kube/config")
+	}
+
+	kubeconfigCreateCmd.Flags().StringVar(&flagKubeconfigUser, "user", "", "User name (certificate common name)")
+	kubeconfigCreateCmd.Flags().StringSliceVar(&flagKubeconfigGroups, "group", nil, "Group of the user, can be repeated")
+	kubeconfigCreateCmd.Flags().DurationVar(&flagKubeconfigExpiry, "expiry", 30*24*time.Hour, "Client certificate lifetime")
+	kubeconfigCreateCmd.Flags().StringVar(&flagKubeconfigRole, "role", "", "Apply a ClusterRoleBinding preset: admin, edit or view")
+
+	kubeconfigCmd.AddCommand(kubeconfigAdminCmd)
+	kubeconfigCmd.AddCommand(kubeconfigCreateCmd)
+}
+
+// kubeconfigFile is a kubeconfig document. Cluster and user entries are kept as
+// generic maps so merging into an existing file preserves fields Galley doesn't know.
+type kubeconfigFile struct {
+	APIVersion     string                 `yaml:"apiVersion"`
+	Kind           string                 `yaml:"kind"`
+	Clusters       []kubeconfigNamedEntry `yaml:"clusters"`
+	Contexts       []kubeconfigNamedEntry `yaml:"contexts"`
+	Users          []kubeconfigNamedEntry `yaml:"users"`
+	CurrentContext string                 `yaml:"current-context"`
+	Extra          map[string]interface{} `yaml:",inline"`
+}
+
+type kubeconfigNamedEntry struct {
+	Name    string                 `yaml:"name"`
+	Cluster map[string]interface{} `yaml:"cluster,omitempty"`
+	Context map[string]interface{} `yaml:"context,omitempty"`
+	User    map[string]interface{} `yaml:"user,omitempty"`
+}
+
+func parseKubeconfig(data []byte) (*kubeconfigFile, error) {
+	var config kubeconfigFile
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	return &config, nil
+}
+
+// normalize renames the single cluster, context and user of a generated kubeconfig
+// to name and points the cluster at server, so it can be merged without clashes
+func (c *kubeconfigFile) normalize(name, server string) error {
+	if len(c.Clusters) != 1 || len(c.Users) != 1 {
+		return fmt.Errorf("expected a kubeconfig with one cluster and one user")
+	}
+
+	c.Clusters[0].Name = name
+	if c.Clusters[0].Cluster == nil {
+		c.Clusters[0].Cluster = map[string]interface{}{}
+	}
+	if server != "" {
+		c.Clusters[0].Cluster["server"] = server
+	}
+	c.Users[0].Name = name
+	c.Contexts = []kubeconfigNamedEntry{{
+		Name:    name,
+		Context: map[string]interface{}{"cluster": name, "user": name},
+	}}
+	c.CurrentContext = name
+	return nil
+}
+
+// merge adds or replaces the entries of other, keeping everything else in c
+func (c *kubeconfigFile) merge(other *kubeconfigFile) {
+	if c.APIVersion == "" {
+		c.APIVersion = "v1"
+	}
+	if c.Kind == "" {
+		c.Kind = "Config"
+	}
+	c.Clusters = mergeKubeconfigEntries(c.Clusters, other.Clusters)
+	c.Contexts = mergeKubeconfigEntries(c.Contexts, other.Contexts)
+	c.Users = mergeKubeconfigEntries(c.Users, other.Users)
+	if c.CurrentContext == "" {
+		c.CurrentContext = other.CurrentContext
+	}
+}
+
+func mergeKubeconfigEntries(existing, added []kubeconfigNamedEntry) []kubeconfigNamedEntry {
+	for _, entry := range added {
+		replaced := false
+		for i := range existing {
+			if existing[i].Name == entry.Name {
+				existing[i] = entry
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			existing = append(existing, entry)
+		}
+	}
+	return existing
+}
+
+func newClientKubeconfig(name, server string, caPEM, certPEM, keyPEM []byte) *kubeconfigFile {
+	return &kubeconfigFile{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters: []kubeconfigNamedEntry{{
+			Name: name,
+			Cluster: map[string]interface{}{
+				"server":                     server,
+				"certificate-authority-data": base64.StdEncoding.EncodeToString(caPEM),
+			},
+		}},
+		Contexts: []kubeconfigNamedEntry{{
+			Name:    name,
+			Context: map[string]interface{}{"cluster": name, "user": name},
+		}},
+		Users: []kubeconfigNamedEntry{{
+			Name: name,
+			User: map[string]interface{}{
+				"client-certificate-data": base64.StdEncoding.EncodeToString(certPEM),
+				"client-key-data":         base64.StdEncoding.EncodeToString(keyPEM),
+			},
+		}},
+		CurrentContext: name,
+	}
+}
+
+// galleyKubeconfigName returns the context name for a user, e.g. galley-00d8b226-admin
+func galleyKubeconfigName(user string) (string, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load Galley config: %w", err)
+	}
+	return kubeconfigName(config.VesselEngineId, user), nil
+}
+
+func kubeconfigName(vesselEngineId, user string) string {
+	engine := strings.ToLower(vesselEngineId)
+	if i := strings.Index(engine, "-"); i > 0 {
+		engine = engine[:i]
+	}
+	if engine == "" {
+		return "galley-" + user
+	}
+	return "galley-" + engine + "-" + user
+}
+
+// resolveKubeconfigServer returns the API server URL for kubeconfigs: the explicit
+// server, the control plane VIP or the public IP address of this controller
+func resolveKubeconfigServer(server string) (string, error) {
+	if server == "" {
+		if cplb, err := readControlPlaneLoadBalancing(k0sConfigFile); err == nil && cplb != nil {
+			server = cplb.address()
+		}
+	}
+	if server == "" {
+		config, err := loadConfig()
+		if err != nil {
+			return "", fmt.Errorf("failed to load Galley config: %w", err)
+		}
+		server = config.IPAddress
+	}
+	if server == "" {
+		return "", fmt.Errorf("could not determine the API server address, set it with --server")
+	}
+	return kubeAPIServerURL(server), nil
+}
+
+// kubeAPIServerURL turns an address, host:port or URL into an API server URL
+func kubeAPIServerURL(server string) string {
+	if strings.Contains(server, "://") {
+		return server
+	}
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return "https://" + server
+	}
+	return (&url.URL{Scheme: "https", Host: net.JoinHostPort(server, "6443")}).String()
+}
+
+// writeKubeconfig prints the kubeconfig, writes it to --output or merges it into ~/.kube/config
+func writeKubeconfig(config *kubeconfigFile, name string) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal kubeconfig: %w", err)
+	}
+
+	if flagKubeconfigOutput != "" {
+		addSecretFile(flagKubeconfigOutput)
+		if err := sysExec.WriteFile(flagKubeconfigOutput, data, 0600); err != nil {
+			return fmt.Errorf("failed to write kubeconfig: %w", err)
+		}
+		logFileWrite(flagKubeconfigOutput, "Wrote kubeconfig "+name)
+		fmt.Fprintf(os.Stderr, "✓ Kubeconfig written to %s\n", flagKubeconfigOutput)
+	}
+
+	if flagKubeconfigMerge {
+		path, err := mergeIntoUserKubeconfig(config)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "✓ Kubeconfig merged into %s as context %s\n", path, name)
+		fmt.Fprintf(os.Stderr, "💡 Switch to it with: kubectl config use-context %s\n", name)
+	}
+
+	if flagKubeconfigOutput == "" && !flagKubeconfigMerge {
+		fmt.Print(string(data))
+	}
+	return nil
+}
+
+// mergeIntoUserKubeconfig merges config into ~/.kube/config of the real user
+func mergeIntoUserKubeconfig(config *kubeconfigFile) (string, error) {
+	home, err := getRealUserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(home, ".kube", "config")
+
+	existing := &kubeconfigFile{}
+	if data, err := sysExec.ReadFile(path); err == nil {
+		if existing, err = parseKubeconfig(data); err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	existing.merge(config)
+	data, err := yaml.Marshal(existing)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal kubeconfig: %w", err)
+	}
+
+	if err := sysExec.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	addSecretFile(path)
+	if err := sysExec.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	chownToSudoUser(filepath.Dir(path))
+	chownToSudoUser(path)
+	logFileWrite(path, "Merged Galley kubeconfig")
+
+	return path, nil
+}
+
+// chownToSudoUser gives files created under sudo back to the invoking user
+func chownToSudoUser(path string) {
+	uid, errUID := strconv.Atoi(os.Getenv("SUDO_UID"))
+	gid, errGID := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if errUID != nil || errGID != nil {
+		return
+	}
+	if err := sysExec.Chown(path, uid, gid); err != nil {
+		logError("chown "+path, err)
+	}
+}
+
+// issueClientCertificate creates a key pair and has the cluster CA sign it through
+// a CertificateSigningRequest, returning the PEM encoded certificate and key
+func issueClientCertificate(ctx context.Context, user string, groups []string, expiry time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: user, Organization: groups},
+	}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	csrName := fmt.Sprintf("galley-%s-%d", kubernetesUserName(user), time.Now().Unix())
+	manifest, err := certificateSigningRequestManifest(csrName, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}), expiry)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := k0sKubectlApply(ctx, manifest); err != nil {
+		return nil, nil, fmt.Errorf("failed to submit certificate signing request: %w", err)
+	}
+	defer exec.Command("k0s", "kubectl", "delete", "csr", csrName, "--ignore-not-found").Run()
+
+	// Output goes to stderr, stdout is reserved for the kubeconfig
+	approveArgs := []string{"kubectl", "certificate", "approve", csrName}
+	logCommand("k0s", approveArgs)
+	if output, err := exec.CommandContext(ctx, "k0s", approveArgs...).CombinedOutput(); err != nil {
+		return nil, nil, fmt.Errorf("failed to approve certificate signing request: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	deadline := time.Now().Add(kubeconfigCSRTimeout)
+	for {
+		output, err := exec.CommandContext(ctx, "k0s", "kubectl", "get", "csr", csrName, "-o", "jsonpath={.status.certificate}").Output()
+		if err == nil && len(bytes.TrimSpace(output)) > 0 {
+			certPEM, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(output)))
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to decode signed certificate: %w", err)
+			}
+			return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, fmt.Errorf("certificate signing request %s was not signed within %s", csrName, kubeconfigCSRTimeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func certificateSigningRequestManifest(name string, csrPEM []byte, expiry time.Duration) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "certificates.k8s.io/v1",
+		"kind":       "CertificateSigningRequest",
+		"metadata": map[string]interface{}{
+			"name":   name,
+			"labels": map[string]string{"app.kubernetes.io/managed-by": "galley"},
+		},
+		"spec": map[string]interface{}{
+			"request":           base64.StdEncoding.EncodeToString(csrPEM),
+			"signerName":        "kubernetes.io/kube-apiserver-client",
+			"expirationSeconds": int64(expiry.Seconds()),
+			"usages":            []string{"client auth"},
+		},
+	})
+}
+
+func clusterRoleBindingManifest(user, preset string) ([]byte, error) {
+	clusterRole, ok := kubeconfigRolePresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown role preset %q", preset)
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRoleBinding",
+		"metadata": map[string]interface{}{
+			"name":   "galley-" + kubernetesUserName(user) + "-" + preset,
+			"labels": map[string]string{"app.kubernetes.io/managed-by": "galley"},
+		},
+		"subjects": []map[string]string{
+			{"apiGroup": "rbac.authorization.k8s.io", "kind": "User", "name": user},
+		},
+		"roleRef": map[string]string{
+			"apiGroup": "rbac.authorization.k8s.io",
+			"kind":     "ClusterRole",
+			"name":     clusterRole,
+		},
+	})
+}
+
+// kubernetesUserName turns a user name into a DNS-1123 part of an object name, e.g.
+// alice@example.com into alice-example.com-<hash>. A name with replaced characters
+// gets a hash of the user, so alice_ops and alice-ops don't share a binding.
+func kubernetesUserName(user string) string {
+	name := kubernetesNodeName(user)
+	// Leave room for the galley- prefix and the suffixes of the object names
+	if len(name) > 200 {
+		name = strings.Trim(name[:200], "-.")
+	}
+	if name != strings.ToLower(user) {
+		sum := sha256.Sum256([]byte(user))
+		name = strings.TrimLeft(name+"-"+hex.EncodeToString(sum[:4]), "-.")
+	}
+	return name
+}
+
+// k0sKubectlApply applies a manifest with the admin credentials of this controller
+func k0sKubectlApply(ctx context.Context, manifest []byte) error {
+	return sysExec.RunWithInput(ctx, string(manifest), "k0s", "kubectl", "apply", "-f", "-")
+}
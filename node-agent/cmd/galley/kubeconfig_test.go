@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestKubeAPIServerURL(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{server: "203.0.113.10", want: "https://203.0.113.10:6443"},
+		{server: "203.0.113.10:7443", want: "https://203.0.113.10:7443"},
+		{server: "2001:db8::1", want: "https://[2001:db8::1]:6443"},
+		{server: "https://k8s.example.com", want: "https://k8s.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.server, func(t *testing.T) {
+			if got := kubeAPIServerURL(tt.server); got != tt.want {
+				t.Errorf("kubeAPIServerURL(%q) = %s, want %s", tt.server, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubeconfigName(t *testing.T) {
+	if got := kubeconfigName("00D8B226-C47C-46F2-981B-A81BE8EE4213", "admin"); got != "galley-00d8b226-admin" {
+		t.Errorf("kubeconfigName() = %s", got)
+	}
+	if got := kubeconfigName("", "alice"); got != "galley-alice" {
+		t.Errorf("kubeconfigName() = %s", got)
+	}
+}
+
+func TestKubeconfigNormalizeAndMerge(t *testing.T) {
+	admin, err := parseKubeconfig([]byte(`apiVersion: v1
+clusters:
+- cluster:
+    server: https://localhost:6443
+    certificate-authority-data: Q0E=
+  name: local
+contexts:
+- context:
+    cluster: local
+    user: user
+  name: Default
+current-context: Default
+kind: Config
+users:
+- name: user
+  user:
+    client-certificate-data: Q0VSVA==
+    client-key-data: S0VZ
+`))
+	if err != nil {
+		t.Fatalf("parseKubeconfig() error = %v", err)
+	}
+
+	if err := admin.normalize("galley-engine-admin", "https://10.0.0.100:6443"); err != nil {
+		t.Fatalf("normalize() error = %v", err)
+	}
+	if admin.Clusters[0].Cluster["server"] != "https://10.0.0.100:6443" || admin.Clusters[0].Cluster["certificate-authority-data"] != "Q0E=" {
+		t.Errorf("normalize() cluster = %v", admin.Clusters[0].Cluster)
+	}
+	if admin.CurrentContext != "galley-engine-admin" || admin.Contexts[0].Context["user"] != "galley-engine-admin" {
+		t.Errorf("normalize() contexts = %+v", admin.Contexts)
+	}
+
+	existing, err := parseKubeconfig([]byte(`apiVersion: v1
+kind: Config
+preferences: {}
+clusters:
+- name: other
+  cluster:
+    server: https://other:6443
+    extensions:
+    - name: client.authentication.k8s.io/exec
+contexts:
+- name: other
+  context: {cluster: other, user: other}
+- name: galley-engine-admin
+  context: {cluster: stale, user: stale}
+users:
+- name: other
+  user:
+    exec: {command: aws}
+current-context: other
+`))
+	if err != nil {
+		t.Fatalf("parseKubeconfig() error = %v", err)
+	}
+
+	existing.merge(admin)
+	if len(existing.Clusters) != 2 || len(existing.Contexts) != 2 || len(existing.Users) != 2 {
+		t.Errorf("merge() = %d clusters, %d contexts, %d users", len(existing.Clusters), len(existing.Contexts), len(existing.Users))
+	}
+	if existing.CurrentContext != "other" {
+		t.Errorf("merge() should keep the current context, got %s", existing.CurrentContext)
+	}
+	if existing.Contexts[1].Context["cluster"] != "galley-engine-admin" {
+		t.Errorf("merge() should replace the stale context, got %v", existing.Contexts[1].Context)
+	}
+
+	out, err := yaml.Marshal(existing)
+	if err != nil {
+		t.Fatalf("yaml.Marshal() error = %v", err)
+	}
+	for _, want := range []string{"preferences: {}", "exec:", "extensions:"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("merged kubeconfig lost %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestKubernetesUserName(t *testing.T) {
+	// A DNS-1123 subdomain: lowercase alphanumerics, '-' and '.', alphanumeric at both ends
+	valid := regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+	for _, user := range []string{"alice", "Alice", "alice@example.com", "alice_ops", "_ops_", "Jöhn Doe", strings.Repeat("a", 300)} {
+		name := kubernetesUserName(user)
+		if !valid.MatchString(name) || len("galley-"+name+"-admin") > 253 {
+			t.Errorf("kubernetesUserName(%q) = %q, not a valid object name", user, name)
+		}
+	}
+
+	if got := kubernetesUserName("Alice"); got != "alice" {
+		t.Errorf("kubernetesUserName(Alice) = %q, want alice", got)
+	}
+	if got := kubernetesUserName("alice@example.com"); !strings.HasPrefix(got, "alice-example.com-") {
+		t.Errorf("kubernetesUserName(alice@example.com) = %q", got)
+	}
+	if kubernetesUserName("alice_ops") == kubernetesUserName("alice-ops") {
+		t.Error("alice_ops and alice-ops should not share an object name")
+	}
+
+	data, err := clusterRoleBindingManifest("alice@example.com", "view")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"name":"galley-`+kubernetesUserName("alice@example.com")+`-view"`) || !strings.Contains(string(data), `"name":"alice@example.com"`) {
+		t.Errorf("clusterRoleBindingManifest() = %s, want a valid name and the user as subject", data)
+	}
+}
+
+func TestKubeconfigManifests(t *testing.T) {
+	t.Run("certificate signing request", func(t *testing.T) {
+		data, err := certificateSigningRequestManifest("galley-alice-1", []byte("CSR"), 24*time.Hour)
+		if err != nil {
+			t.Fatalf("certificateSigningRequestManifest() error = %v", err)
+		}
+		var csr struct {
+			Spec struct {
+				SignerName        string   `json:"signerName"`
+				ExpirationSeconds int64    `json:"expirationSeconds"`
+				Usages            []string `json:"usages"`
+			} `json:"spec"`
+		}
+		if err := json.Unmarshal(data, &csr); err != nil {
+			t.Fatalf("invalid manifest: %v", err)
+		}
+		if csr.Spec.SignerName != "kubernetes.io/kube-apiserver-client" || csr.Spec.ExpirationSeconds != 86400 || csr.Spec.Usages[0] != "client auth" {
+			t.Errorf("certificateSigningRequestManifest() = %s", data)
+		}
+	})
+
+	t.Run("role presets", func(t *testing.T) {
+		for preset, clusterRole := range kubeconfigRolePresets {
+			data, err := clusterRoleBindingManifest("Alice", preset)
+			if err != nil {
+				t.Fatalf("clusterRoleBindingManifest(%s) error = %v", preset, err)
+			}
+			if !strings.Contains(string(data), `"name":"`+clusterRole+`"`) || !strings.Contains(string(data), `"name":"galley-alice-`+preset+`"`) {
+				t.Errorf("clusterRoleBindingManifest(%s) = %s", preset, data)
+			}
+		}
+		if _, err := clusterRoleBindingManifest("alice", "root"); err == nil {
+			t.Error("clusterRoleBindingManifest() should reject unknown presets")
+		}
+	})
+}
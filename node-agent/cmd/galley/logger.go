@@ -21,6 +21,11 @@ func initLogger() error {
 
 // logAction logs an action to the galley log file
 func logAction(action string, details map[string]string) {
+	// A dry run changes nothing, so there is nothing to log
+	if flagDryRun {
+		return
+	}
+
 	// Try to initialize logger, but don't fail if we can't
 	if err := initLogger(); err != nil {
 		// Silently fail - we don't want to break functionality if logging fails
@@ -2,10 +2,12 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -19,6 +21,10 @@ var nodeCmd = &cobra.Command{
 
 var (
 	flagNodePrepareSkipOSUpdate bool
+	flagNodePrepareListSteps    bool
+	flagNodePrepareOnly         []string
+	flagNodePrepareSkip         []string
+	flagNodePrepareRedo         []string
 )
 
 var nodePrepareCmd = &cobra.Command{
@@ -26,21 +32,39 @@ var nodePrepareCmd = &cobra.Command{
 	Short: "Prepare this node for Galley (OS updates, k0s installation)",
 	Long: `Prepares this node to become part of a Galley cluster by:
   - Updating the OS and configuring automatic security updates
+  - Loading kernel modules, applying sysctls and disabling swap
+  - Formatting and mounting a dedicated data disk at /var/lib/k0s (--data-disk)
   - Installing k0s
   - Creating and configuring k0s
+  - Applying recommended server hardening
   - Rebooting if necessary
 
+Each step has a stable ID. Steps that are done, or already in place on this
+node, are not repeated. Use --list-steps to see them, --only and --skip to
+select steps, and --redo to run a done step again.
+
 After preparation, use 'galley controller join <token>' to connect to your cluster.`,
 	RunE: runNodePrepare,
 }
 
 func init() {
 	nodePrepareCmd.Flags().BoolVar(&flagNodePrepareSkipOSUpdate, "skip-os-update", false, "Skip OS update step")
+	nodePrepareCmd.Flags().StringVar(&flagDataDisk, "data-disk", "", "Empty disk to format and mount at "+k0sDataDir+", e.g. /dev/nvme0n1")
+	nodePrepareCmd.Flags().StringVar(&flagDataDiskFilesystem, "data-disk-fs", "ext4", "Filesystem for the data disk: ext4 or xfs")
+	nodePrepareCmd.Flags().BoolVar(&flagDataDiskForce, "data-disk-force", false, "Erase the data disk even if it has partitions or a filesystem")
+	nodePrepareCmd.Flags().BoolVar(&flagNodePrepareListSteps, "list-steps", false, "List the preparation steps and their status")
+	nodePrepareCmd.Flags().StringSliceVar(&flagNodePrepareOnly, "only", nil, "Run only these steps (repeatable, comma separated)")
+	nodePrepareCmd.Flags().StringSliceVar(&flagNodePrepareSkip, "skip", nil, "Skip these steps (repeatable, comma separated)")
+	nodePrepareCmd.Flags().StringSliceVar(&flagNodePrepareRedo, "redo", nil, "Run these steps again, even if they are done (repeatable, comma separated)")
 	nodeCmd.AddCommand(nodePrepareCmd)
 }
 
 func runNodePrepare(cmd *cobra.Command, args []string) error {
-	logAction("Starting node preparation", nil)
+	steps := prepareSteps()
+	selection := prepareSelection{Only: flagNodePrepareOnly, Skip: flagNodePrepareSkip, Redo: flagNodePrepareRedo}
+	if err := selection.validate(steps); err != nil {
+		return err
+	}
 
 	// Load progress to check what's already done
 	progress, err := loadProgress()
@@ -48,79 +72,34 @@ func runNodePrepare(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load progress: %w", err)
 	}
 
+	if flagNodePrepareListSteps {
+		printPrepareSteps(steps, progress, selection)
+		return nil
+	}
+
+	logAction("Starting node preparation", nil)
+
 	// Show resume message if resuming
 	if len(progress.CompletedSteps) > 0 {
 		fmt.Println("\n" + strings.Repeat("=", 70))
 		fmt.Println("Resuming node preparation...")
 		fmt.Println("Already completed:")
-		// Show completed steps in order
-		orderedSteps := []string{stepOSUpdate, stepSSHConfig, stepK0sInstall, stepK0sConfig, stepServerHardening}
-		for _, step := range orderedSteps {
-			if progress.CompletedSteps[step] {
-				fmt.Printf("  ✓ %s\n", step)
+		for _, step := range steps {
+			if progress.isComplete(step.ID) {
+				fmt.Printf("  ✓ %s\n", step.Description)
 			}
 		}
 		fmt.Println(strings.Repeat("=", 70))
 	}
 
-	// Only update OS if not skipped and not already done
-	if !flagNodePrepareSkipOSUpdate && !progress.isComplete(stepOSUpdate) {
-		if err := promptOSUpdate(progress); err != nil {
-			return err
-		}
-	}
-
-	// Configure SSH security after OS update
-	if !progress.isComplete(stepSSHConfig) {
-		if err := configureSSHSecurity(); err != nil {
-			return fmt.Errorf("failed to configure SSH security: %w", err)
-		}
-		if err := progress.markComplete(stepSSHConfig); err != nil {
-			return fmt.Errorf("failed to mark SSH config complete: %w", err)
-		}
+	if err := runPrepareSteps(steps, progress, selection); err != nil {
+		return err
 	}
 
 	if flagDryRun {
-		fmt.Println("[dry-run] Would prepare node (install k0s, create config)")
 		return nil
 	}
 
-	// Ensure k0s is installed
-	if !progress.isComplete(stepK0sInstall) {
-		if err := ensureK0sInstalled(); err != nil {
-			return fmt.Errorf("failed to ensure k0s is installed: %w", err)
-		}
-		if err := progress.markComplete(stepK0sInstall); err != nil {
-			return fmt.Errorf("failed to mark k0s install complete: %w", err)
-		}
-	}
-
-	// Create k0s config
-	if !progress.isComplete(stepK0sConfig) {
-		if err := createK0sConfig(); err != nil {
-			return fmt.Errorf("failed to create k0s config: %w", err)
-		}
-
-		// Prompt to edit config
-		if err := promptEditK0sConfig(); err != nil {
-			return fmt.Errorf("failed to edit k0s config: %w", err)
-		}
-
-		if err := progress.markComplete(stepK0sConfig); err != nil {
-			return fmt.Errorf("failed to mark k0s config complete: %w", err)
-		}
-	}
-
-	// Final security configuration
-	if !progress.isComplete(stepServerHardening) {
-		if err := performServerHardening(); err != nil {
-			return fmt.Errorf("failed to perform final security configuration: %w", err)
-		}
-		if err := progress.markComplete(stepServerHardening); err != nil {
-			return fmt.Errorf("failed to mark final security configuration complete: %w", err)
-		}
-	}
-
 	// Get k0s version to show user
 	version, err := getK0sVersion()
 	if err != nil {
@@ -274,33 +253,77 @@ func promptDisableSSHRootLogin() error {
 }
 
 func isSSHRootLoginEnabled() (bool, error) {
-	content, err := os.ReadFile("/etc/ssh/sshd_config")
+	content, err := readEffectiveSSHDConfig()
 	if err != nil {
 		return false, err
 	}
+	return sshRootLoginEnabled(content), nil
+}
 
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		// Skip comments
-		if strings.HasPrefix(line, "#") {
+// sshRootLoginEnabled reports whether an sshd configuration allows root to log in
+func sshRootLoginEnabled(content string) bool {
+	switch strings.ToLower(sshdConfigValue(content, "PermitRootLogin")) {
+	case "", "yes", "prohibit-password", "without-password":
+		// Default is usually yes in most distributions
+		return true
+	}
+	return false
+}
+
+// readEffectiveSSHDConfig returns the configuration sshd runs with. 'sshd -T' prints
+// it with every Include applied, without root or sshd the Include lines of
+// /etc/ssh/sshd_config are followed instead, e.g. a cloud-init drop-in in
+// /etc/ssh/sshd_config.d that enables password logins.
+func readEffectiveSSHDConfig() (string, error) {
+	content, err := sysExec.ReadFile("/etc/ssh/sshd_config")
+	if err != nil {
+		return "", err
+	}
+	if output, err := exec.Command("sshd", "-T").Output(); err == nil && len(output) > 0 {
+		return string(output), nil
+	}
+	return expandSSHDConfig(string(content), "/etc/ssh", sysExec.ReadFile, filepath.Glob, 0), nil
+}
+
+// expandSSHDConfig replaces the Include lines of an sshd configuration with the
+// files they match, in order, so the first value of a directive is the one sshd
+// uses. Match blocks only apply to some connections and are left out.
+func expandSSHDConfig(content, dir string, read func(string) ([]byte, error), glob func(string) ([]string, error), depth int) string {
+	var b strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && strings.EqualFold(fields[0], "Match") {
+			break
+		}
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "Include") {
+			b.WriteString(line + "\n")
 			continue
 		}
-		// Check for PermitRootLogin directive
-		if strings.HasPrefix(line, "PermitRootLogin") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				value := strings.ToLower(parts[1])
-				return value == "yes" || value == "prohibit-password" || value == "without-password", nil
+		// sshd limits the Include depth as well
+		if depth >= 16 {
+			continue
+		}
+		for _, pattern := range fields[1:] {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(dir, pattern)
+			}
+			files, err := glob(pattern)
+			if err != nil {
+				continue
+			}
+			sort.Strings(files)
+			for _, file := range files {
+				if included, err := read(file); err == nil {
+					b.WriteString(expandSSHDConfig(string(included), dir, read, glob, depth+1))
+				}
 			}
 		}
 	}
-	// Default is usually yes in most distributions
-	return true, nil
+	return b.String()
 }
 
 func disableSSHRootLogin() error {
-	content, err := os.ReadFile("/etc/ssh/sshd_config")
+	content, err := sysExec.ReadFile("/etc/ssh/sshd_config")
 	if err != nil {
 		return err
 	}
@@ -327,17 +350,14 @@ func disableSSHRootLogin() error {
 
 	if modified {
 		newContent := strings.Join(lines, "\n")
-		if err := os.WriteFile("/etc/ssh/sshd_config", []byte(newContent), 0600); err != nil {
+		if err := sysExec.WriteFile("/etc/ssh/sshd_config", []byte(newContent), 0600); err != nil {
 			return err
 		}
 		logFileWrite("/etc/ssh/sshd_config", "Disabled SSH root login")
 
 		// Validate the config before restarting
 		fmt.Println("Validating SSH configuration...")
-		validateCmd := exec.Command("sshd", "-t")
-		validateCmd.Stdout = os.Stdout
-		validateCmd.Stderr = os.Stderr
-		if err := validateCmd.Run(); err != nil {
+		if err := runCommandWithContext(context.Background(), "sshd", "-t"); err != nil {
 			return fmt.Errorf("SSH configuration validation failed: %w\nPlease check /etc/ssh/sshd_config", err)
 		}
 		fmt.Println("✓ SSH configuration is valid")
@@ -395,28 +415,18 @@ func checkPasswordAuthentication() error {
 }
 
 func isPasswordAuthenticationEnabled() (bool, error) {
-	content, err := os.ReadFile("/etc/ssh/sshd_config")
+	content, err := readEffectiveSSHDConfig()
 	if err != nil {
 		return false, err
 	}
+	return sshPasswordAuthenticationEnabled(content), nil
+}
 
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		// Skip comments
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		// Check for PasswordAuthentication directive
-		if strings.HasPrefix(line, "PasswordAuthentication") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				return strings.ToLower(parts[1]) == "yes", nil
-			}
-		}
-	}
+// sshPasswordAuthenticationEnabled reports whether an sshd configuration allows password logins
+func sshPasswordAuthenticationEnabled(content string) bool {
+	value := strings.ToLower(sshdConfigValue(content, "PasswordAuthentication"))
 	// Default is usually yes
-	return true, nil
+	return value == "" || value == "yes"
 }
 
 func openAuthorizedKeys() error {
@@ -429,13 +439,13 @@ func openAuthorizedKeys() error {
 	authorizedKeysPath := filepath.Join(sshDir, "authorized_keys")
 
 	// Create .ssh directory if it doesn't exist
-	if err := os.MkdirAll(sshDir, 0700); err != nil {
+	if err := sysExec.MkdirAll(sshDir, 0700); err != nil {
 		return err
 	}
 
 	// Create authorized_keys file if it doesn't exist
 	if _, err := os.Stat(authorizedKeysPath); os.IsNotExist(err) {
-		if err := os.WriteFile(authorizedKeysPath, []byte(""), 0600); err != nil {
+		if err := sysExec.WriteFile(authorizedKeysPath, []byte(""), 0600); err != nil {
 			return err
 		}
 	}
@@ -463,12 +473,7 @@ func openAuthorizedKeys() error {
 	fmt.Println("Press Enter to continue...")
 	bufio.NewReader(os.Stdin).ReadString('\n')
 
-	cmd := exec.Command(editor, authorizedKeysPath)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
+	if err := sysExec.Interactive(editor, authorizedKeysPath); err != nil {
 		return err
 	}
 
@@ -593,7 +598,7 @@ func promptDisablePasswordAuth() error {
 
 func disablePasswordAuthentication() error {
 	// Read current sshd_config
-	content, err := os.ReadFile("/etc/ssh/sshd_config")
+	content, err := sysExec.ReadFile("/etc/ssh/sshd_config")
 	if err != nil {
 		return err
 	}
@@ -621,16 +626,14 @@ func disablePasswordAuthentication() error {
 
 	if modified {
 		newContent := strings.Join(lines, "\n")
-		if err := os.WriteFile("/etc/ssh/sshd_config", []byte(newContent), 0644); err != nil {
+		if err := sysExec.WriteFile("/etc/ssh/sshd_config", []byte(newContent), 0644); err != nil {
 			return err
 		}
 
 		// Restart SSH service
-		cmd := exec.Command("systemctl", "restart", "sshd")
-		if err := cmd.Run(); err != nil {
+		if err := runCommandWithContext(context.Background(), "systemctl", "restart", "sshd"); err != nil {
 			// Try alternative service name
-			cmd = exec.Command("systemctl", "restart", "ssh")
-			if err := cmd.Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), "systemctl", "restart", "ssh"); err != nil {
 				return fmt.Errorf("failed to restart SSH service: %w", err)
 			}
 		}
@@ -642,18 +645,7 @@ func disablePasswordAuthentication() error {
 func checkFTPServices() error {
 	fmt.Println("\nChecking for FTP services...")
 
-	// Check for common FTP services
-	ftpServices := []string{"vsftpd", "proftpd", "pure-ftpd"}
-	foundServices := []string{}
-
-	for _, service := range ftpServices {
-		cmd := exec.Command("systemctl", "is-active", service)
-		output, err := cmd.Output()
-		if err == nil && strings.TrimSpace(string(output)) == "active" {
-			foundServices = append(foundServices, service)
-		}
-	}
-
+	foundServices := activeFTPServices()
 	if len(foundServices) == 0 {
 		fmt.Println("✓ No active FTP services found")
 		return nil
@@ -676,13 +668,11 @@ func checkFTPServices() error {
 
 	if response == "y" || response == "yes" {
 		for _, service := range foundServices {
-			cmd := exec.Command("systemctl", "stop", service)
-			if err := cmd.Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), "systemctl", "stop", service); err != nil {
 				fmt.Printf("⚠️  Failed to stop %s: %v\n", service, err)
 				continue
 			}
-			cmd = exec.Command("systemctl", "disable", service)
-			if err := cmd.Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), "systemctl", "disable", service); err != nil {
 				fmt.Printf("⚠️  Failed to disable %s: %v\n", service, err)
 				continue
 			}
@@ -696,71 +686,47 @@ func checkFTPServices() error {
 	return nil
 }
 
-func scanOpenPorts() error {
-	fmt.Println("\nScanning for open ports...")
-
-	// Use ss command to list listening ports
-	cmd := exec.Command("ss", "-tuln")
-	output, err := cmd.Output()
-	if err != nil {
-		// Try netstat as fallback
-		cmd = exec.Command("netstat", "-tuln")
-		output, err = cmd.Output()
-		if err != nil {
-			fmt.Println("⚠️  Could not scan ports (ss/netstat not available)")
-			return nil
+// activeFTPServices returns the common FTP services that are running
+func activeFTPServices() []string {
+	var active []string
+	for _, service := range []string{"vsftpd", "proftpd", "pure-ftpd"} {
+		output, err := exec.Command("systemctl", "is-active", service).Output()
+		if err == nil && strings.TrimSpace(string(output)) == "active" {
+			active = append(active, service)
 		}
 	}
+	return active
+}
 
-	// Parse output to find listening ports
-	lines := strings.Split(string(output), "\n")
-	openPorts := make(map[string]bool)
-
-	for _, line := range lines {
-		if strings.Contains(line, "LISTEN") || strings.Contains(line, "UNCONN") {
-			fields := strings.Fields(line)
-			if len(fields) >= 5 {
-				// Extract port from address:port format
-				localAddr := fields[4]
-				if idx := strings.LastIndex(localAddr, ":"); idx != -1 {
-					port := localAddr[idx+1:]
-					openPorts[port] = true
-				}
-			}
-		}
-	}
+func scanOpenPorts() error {
+	fmt.Println("\nScanning for open ports...")
 
-	// Known safe/expected ports
-	expectedPorts := map[string]string{
-		"22":    "SSH",
-		"53":    "DNS",
-		"68":    "DHCP client",
-		"6443":  "Kubernetes API",
-		"8132":  "konnectivity",
-		"9443":  "k0s API",
-		"10250": "kubelet",
+	nodeType := ""
+	if config, err := loadConfig(); err == nil {
+		nodeType = config.NodeType
 	}
 
-	unexpectedPorts := []string{}
-	for port := range openPorts {
-		if _, expected := expectedPorts[port]; !expected && port != "*" && port != "0.0.0.0" {
-			unexpectedPorts = append(unexpectedPorts, port)
-		}
+	sockets, err := socketInventory(nodeType)
+	if err != nil {
+		fmt.Printf("⚠️  Could not scan ports: %v\n", err)
+		return nil
 	}
 
-	if len(unexpectedPorts) > 0 {
+	unexpected := unexpectedSockets(sockets)
+	if len(unexpected) > 0 {
 		fmt.Println("\n⚠️  WARNING: Unexpected open ports detected:")
-		for _, port := range unexpectedPorts {
-			fmt.Printf("  - Port %s\n", port)
+		for _, socket := range unexpected {
+			fmt.Printf("  - %s %s:%d (%s) by %s\n", socket.Protocol, socket.Address, socket.Port, socket.Scope, socketProcess(socket))
 		}
 		fmt.Println("\nReview these ports and ensure they're necessary for your setup.")
-		fmt.Println("Consider using a firewall (ufw/iptables) to restrict access.")
+		fmt.Println("Restrict access with the host firewall: galley node firewall apply --dry-run")
 	} else {
 		fmt.Println("✓ No unexpected open ports detected")
 	}
 
 	logAction("Scanned open ports", map[string]string{
-		"open_ports_count": fmt.Sprintf("%d", len(openPorts)),
+		"open_ports_count":       fmt.Sprintf("%d", len(sockets)),
+		"unexpected_ports_count": fmt.Sprintf("%d", len(unexpected)),
 	})
 
 	return nil
@@ -863,18 +829,10 @@ func restartSSHService() error {
 		}
 
 		// Service exists, try to reload first (less disruptive)
-		reloadCmd := exec.Command("systemctl", "reload", service)
-		reloadCmd.Stdout = os.Stdout
-		reloadCmd.Stderr = os.Stderr
-
-		if err := reloadCmd.Run(); err != nil {
+		if err := runCommandWithContext(context.Background(), "systemctl", "reload", service); err != nil {
 			// If reload fails, try restart
 			fmt.Printf("⚠️  Reload failed, trying restart...\n")
-			restartCmd := exec.Command("systemctl", "restart", service)
-			restartCmd.Stdout = os.Stdout
-			restartCmd.Stderr = os.Stderr
-
-			if err := restartCmd.Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), "systemctl", "restart", service); err != nil {
 				// Get more details about the failure
 				statusCmd := exec.Command("systemctl", "status", service)
 				statusOutput, _ := statusCmd.CombinedOutput()
@@ -946,38 +904,34 @@ func installSSH() error {
 
 		fmt.Printf("Detected %s package manager\n", installer.name)
 
-		var installCmd *exec.Cmd
+		var installCmd []string
 		switch installer.name {
 		case "apt (Debian/Ubuntu)":
-			installCmd = exec.Command("apt-get", "install", "-y", installer.packages[0])
+			installCmd = []string{"apt-get", "install", "-y", installer.packages[0]}
 		case "dnf (Fedora/RHEL 8+)":
-			installCmd = exec.Command("dnf", "install", "-y", installer.packages[0])
+			installCmd = []string{"dnf", "install", "-y", installer.packages[0]}
 		case "yum (RHEL/CentOS 7)":
-			installCmd = exec.Command("yum", "install", "-y", installer.packages[0])
+			installCmd = []string{"yum", "install", "-y", installer.packages[0]}
 		case "zypper (openSUSE/SLES)":
-			installCmd = exec.Command("zypper", "install", "-y", installer.packages[0])
+			installCmd = []string{"zypper", "install", "-y", installer.packages[0]}
 		case "pacman (Arch Linux)":
-			installCmd = exec.Command("pacman", "-S", "--noconfirm", installer.packages[0])
+			installCmd = []string{"pacman", "-S", "--noconfirm", installer.packages[0]}
 		case "apk (Alpine Linux)":
-			installCmd = exec.Command("apk", "add", installer.packages[0])
+			installCmd = []string{"apk", "add", installer.packages[0]}
 		}
 
 		if installCmd != nil {
-			installCmd.Stdout = os.Stdout
-			installCmd.Stderr = os.Stderr
-			fmt.Printf("Running: %s\n", strings.Join(installCmd.Args, " "))
-
-			if err := installCmd.Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), installCmd[0], installCmd[1:]...); err != nil {
 				return fmt.Errorf("failed to install SSH: %w", err)
 			}
 
 			// Enable and start SSH service
-			if err := exec.Command("systemctl", "enable", installer.service).Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), "systemctl", "enable", installer.service); err != nil {
 				return fmt.Errorf("failed to enable SSH service: %w", err)
 			}
 			logServiceChange(installer.service, "enabled")
 
-			if err := exec.Command("systemctl", "start", installer.service).Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), "systemctl", "start", installer.service); err != nil {
 				return fmt.Errorf("failed to start SSH service: %w", err)
 			}
 			logServiceChange(installer.service, "started")
@@ -1044,40 +998,36 @@ func installSecurityTools() error {
 
 		fmt.Printf("Detected %s package manager\n", inst.name)
 
-		var installCmd *exec.Cmd
+		var installCmd []string
 		switch inst.name {
 		case "apt (Debian/Ubuntu)":
-			installCmd = exec.Command("apt-get", "install", "-y", "fail2ban", "htop")
+			installCmd = []string{"apt-get", "install", "-y", "fail2ban", "htop"}
 		case "dnf (Fedora/RHEL 8+)":
-			installCmd = exec.Command("dnf", "install", "-y", "fail2ban", "htop")
+			installCmd = []string{"dnf", "install", "-y", "fail2ban", "htop"}
 		case "yum (RHEL/CentOS 7)":
-			installCmd = exec.Command("yum", "install", "-y", "fail2ban", "htop")
+			installCmd = []string{"yum", "install", "-y", "fail2ban", "htop"}
 		case "zypper (openSUSE/SLES)":
-			installCmd = exec.Command("zypper", "install", "-y", "fail2ban", "htop")
+			installCmd = []string{"zypper", "install", "-y", "fail2ban", "htop"}
 		case "pacman (Arch Linux)":
-			installCmd = exec.Command("pacman", "-S", "--noconfirm", "fail2ban", "htop")
+			installCmd = []string{"pacman", "-S", "--noconfirm", "fail2ban", "htop"}
 		case "apk (Alpine Linux)":
-			installCmd = exec.Command("apk", "add", "fail2ban", "htop")
+			installCmd = []string{"apk", "add", "fail2ban", "htop"}
 		}
 
 		if installCmd != nil {
-			installCmd.Stdout = os.Stdout
-			installCmd.Stderr = os.Stderr
-			fmt.Printf("Running: %s\n", strings.Join(installCmd.Args, " "))
-
-			if err := installCmd.Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), installCmd[0], installCmd[1:]...); err != nil {
 				fmt.Printf("⚠️  Failed to install security tools: %v\n", err)
 				return err
 			}
 
 			// Enable and start fail2ban service
-			if err := exec.Command("systemctl", "enable", "fail2ban").Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), "systemctl", "enable", "fail2ban"); err != nil {
 				fmt.Printf("⚠️  Failed to enable fail2ban service: %v\n", err)
 			} else {
 				logServiceChange("fail2ban", "enabled")
 			}
 
-			if err := exec.Command("systemctl", "start", "fail2ban").Run(); err != nil {
+			if err := runCommandWithContext(context.Background(), "systemctl", "start", "fail2ban"); err != nil {
 				fmt.Printf("⚠️  Failed to start fail2ban service: %v\n", err)
 			} else {
 				logServiceChange("fail2ban", "started")
@@ -1113,22 +1063,32 @@ func enforcePasswordPolicy() error {
 	return nil
 }
 
+// loginDefsPolicy is the password aging and length policy for /etc/login.defs
+var loginDefsPolicy = map[string]string{
+	"PASS_MAX_DAYS": "90", // Password expires after 90 days
+	"PASS_MIN_DAYS": "1",  // Minimum days before password can be changed
+	"PASS_MIN_LEN":  "14", // Minimum password length
+	"PASS_WARN_AGE": "7",  // Warning days before password expiration
+}
+
+// pamPasswordFiles are the PAM files with the password rules, per distribution
+var pamPasswordFiles = []string{
+	"/etc/pam.d/common-password", // Debian/Ubuntu
+	"/etc/pam.d/system-auth",     // RHEL/CentOS
+	"/etc/pam.d/password-auth",   // RHEL/CentOS
+}
+
 func configureLoginDefs() error {
 	loginDefsPath := "/etc/login.defs"
 
 	// Read current login.defs
-	content, err := os.ReadFile(loginDefsPath)
+	content, err := sysExec.ReadFile(loginDefsPath)
 	if err != nil {
 		return err
 	}
 
 	lines := strings.Split(string(content), "\n")
-	settings := map[string]string{
-		"PASS_MAX_DAYS": "90", // Password expires after 90 days
-		"PASS_MIN_DAYS": "1",  // Minimum days before password can be changed
-		"PASS_MIN_LEN":  "14", // Minimum password length
-		"PASS_WARN_AGE": "7",  // Warning days before password expiration
-	}
+	settings := loginDefsPolicy
 
 	modified := false
 	foundSettings := make(map[string]bool)
@@ -1163,7 +1123,7 @@ func configureLoginDefs() error {
 
 	if modified {
 		newContent := strings.Join(lines, "\n")
-		if err := os.WriteFile(loginDefsPath, []byte(newContent), 0644); err != nil {
+		if err := sysExec.WriteFile(loginDefsPath, []byte(newContent), 0644); err != nil {
 			return err
 		}
 		logFileWrite(loginDefsPath, "Configured password expiration and length policies")
@@ -1177,14 +1137,8 @@ func configureLoginDefs() error {
 
 func configurePAMPasswordQuality() error {
 	// Try common PAM password files
-	pamFiles := []string{
-		"/etc/pam.d/common-password", // Debian/Ubuntu
-		"/etc/pam.d/system-auth",     // RHEL/CentOS
-		"/etc/pam.d/password-auth",   // RHEL/CentOS
-	}
-
 	var pamFile string
-	for _, file := range pamFiles {
+	for _, file := range pamPasswordFiles {
 		if _, err := os.Stat(file); err == nil {
 			pamFile = file
 			break
@@ -1196,7 +1150,7 @@ func configurePAMPasswordQuality() error {
 		return nil
 	}
 
-	content, err := os.ReadFile(pamFile)
+	content, err := sysExec.ReadFile(pamFile)
 	if err != nil {
 		return err
 	}
@@ -1243,7 +1197,7 @@ func configurePAMPasswordQuality() error {
 
 	if modified {
 		newContent := strings.Join(lines, "\n")
-		if err := os.WriteFile(pamFile, []byte(newContent), 0644); err != nil {
+		if err := sysExec.WriteFile(pamFile, []byte(newContent), 0644); err != nil {
 			return err
 		}
 		logFileWrite(pamFile, "Configured PAM password quality requirements")
@@ -1259,16 +1213,12 @@ func configurePAMPasswordQuality() error {
 
 func promptLockRootUser() error {
 	// Check if root account is already locked
-	cmd := exec.Command("passwd", "-S", "root")
-	output, err := cmd.Output()
+	locked, err := isRootAccountLocked()
 	if err != nil {
 		fmt.Printf("⚠️  Could not check root account status: %v\n", err)
 		return nil
 	}
-
-	status := string(output)
-	// Status format: "root L" (L = locked), "root P" (P = password set)
-	if strings.Contains(status, " L ") {
+	if locked {
 		fmt.Println("✓ Root user account is already locked")
 		return nil
 	}
@@ -1308,8 +1258,7 @@ func promptLockRootUser() error {
 		}
 
 		// Lock the root account
-		lockCmd := exec.Command("passwd", "-l", "root")
-		if err := lockCmd.Run(); err != nil {
+		if err := runCommandWithContext(context.Background(), "passwd", "-l", "root"); err != nil {
 			return fmt.Errorf("failed to lock root account: %w", err)
 		}
 
@@ -1322,6 +1271,21 @@ func promptLockRootUser() error {
 	return nil
 }
 
+func isRootAccountLocked() (bool, error) {
+	output, err := exec.Command("passwd", "-S", "root").Output()
+	if err != nil {
+		return false, err
+	}
+	return passwdStatusLocked(string(output)), nil
+}
+
+// passwdStatusLocked reports whether 'passwd -S' shows a locked account, the
+// status format is "root L ..." (L = locked) or "root P ..." (P = password set)
+func passwdStatusLocked(status string) bool {
+	fields := strings.Fields(status)
+	return len(fields) >= 2 && (fields[1] == "L" || fields[1] == "LK")
+}
+
 func setMultiUserTarget() error {
 	fmt.Println("\nSetting default systemd target to multi-user...")
 
@@ -1343,11 +1307,7 @@ func setMultiUserTarget() error {
 	fmt.Println("Setting to multi-user.target (console mode, no GUI)...")
 
 	// Set default target to multi-user
-	setCmd := exec.Command("systemctl", "set-default", "multi-user.target")
-	setCmd.Stdout = os.Stdout
-	setCmd.Stderr = os.Stderr
-
-	if err := setCmd.Run(); err != nil {
+	if err := runCommandWithContext(context.Background(), "systemctl", "set-default", "multi-user.target"); err != nil {
 		return fmt.Errorf("failed to set default target: %w", err)
 	}
 
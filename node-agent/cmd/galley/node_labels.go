@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	nodeIDLabel             = "galley.run/node-id"
+	nodeRegionLabel         = "topology.kubernetes.io/region"
+	nodeEngineLabel         = "galley.run/vessel-engine-id"
+	managedLabelsAnnotation = "galley.run/managed-labels"
+	managedTaintsAnnotation = "galley.run/managed-taints"
+	maxLabelNameLength      = 63
+)
+
+var (
+	labelNameRegexp   = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelPrefixRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	nodeNameInvalid   = regexp.MustCompile(`[^a-z0-9.-]+`)
+)
+
+// kubeletLabels are the kubernetes.io labels the NodeRestriction admission plugin
+// lets a kubelet set on its own node, other labels in those namespaces can only
+// be set by a controller
+var kubeletLabels = map[string]bool{
+	"kubernetes.io/hostname":                   true,
+	"kubernetes.io/arch":                       true,
+	"kubernetes.io/os":                         true,
+	"beta.kubernetes.io/arch":                  true,
+	"beta.kubernetes.io/os":                    true,
+	"beta.kubernetes.io/instance-type":         true,
+	"failure-domain.beta.kubernetes.io/region": true,
+	"failure-domain.beta.kubernetes.io/zone":   true,
+	"topology.kubernetes.io/region":            true,
+	"topology.kubernetes.io/zone":              true,
+}
+
+var flagNodeLabelsToken string
+var flagNodeLabelsNode string
+
+var nodeLabelsCmd = &cobra.Command{
+	Use:   "labels",
+	Short: "Manage the Kubernetes labels and taints of nodes",
+}
+
+var nodeLabelsSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Apply the labels and taints of a platform node to its Kubernetes node",
+	Long: `Reconciles the Kubernetes labels and taints of a node with its attributes in
+the Galley platform, after they were changed in the web interface.
+
+Run this on a controller with the node token of the node to update. Labels and
+taints Galley applied before that are no longer on the platform are removed,
+labels and taints added by others are left alone.`,
+	Args: cobra.ExactArgs(0),
+	RunE: runNodeLabelsSync,
+}
+
+func init() {
+	nodeLabelsSyncCmd.Flags().StringVar(&flagNodeLabelsToken, "token", "", "Node token from the Galley web interface of the node to sync")
+	nodeLabelsSyncCmd.Flags().StringVar(&flagNodeLabelsNode, "node", "", "Kubernetes node name (default: the platform node name)")
+
+	nodeLabelsCmd.AddCommand(nodeLabelsSyncCmd)
+	nodeCmd.AddCommand(nodeLabelsCmd)
+}
+
+func runNodeLabelsSync(cobraCmd *cobra.Command, args []string) error {
+	if flagNodeLabelsToken == "" {
+		return fmt.Errorf("--token is required to read the node from the platform")
+	}
+
+	meta, err := fetchNodeMetadata(getPlatformURL(), flagNodeLabelsToken)
+	if err != nil {
+		return err
+	}
+
+	nodeName := flagNodeLabelsNode
+	if nodeName == "" {
+		nodeName = meta.Name
+	}
+	if nodeName == "" {
+		return fmt.Errorf("the platform node has no usable name, use --node to select the Kubernetes node")
+	}
+
+	output, err := exec.CommandContext(cobraCmd.Context(), "k0s", "kubectl", "get", "node", nodeName, "-o", "json").Output()
+	if err != nil {
+		return fmt.Errorf("failed to get Kubernetes node %s (run this on a controller, or use --node): %w", nodeName, err)
+	}
+	var node kubeNode
+	if err := json.Unmarshal(output, &node); err != nil {
+		return fmt.Errorf("failed to parse Kubernetes node %s: %w", nodeName, err)
+	}
+
+	patch, changes := nodeMetadataPatch(node, meta)
+	if patch == nil {
+		fmt.Printf("✓ Node %s is in sync with the platform\n", nodeName)
+		return nil
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if err := runCommandWithContext(cobraCmd.Context(), "k0s", "kubectl", "patch", "node", nodeName, "--type", "merge", "-p", string(data)); err != nil {
+		return fmt.Errorf("failed to update node %s: %w", nodeName, err)
+	}
+
+	logAction("Synced node labels and taints", map[string]string{
+		"node":    nodeName,
+		"changes": strings.Join(changes, "; "),
+	})
+	for _, change := range changes {
+		fmt.Printf("✓ %s\n", change)
+	}
+	return nil
+}
+
+// nodeTaint is a Kubernetes node taint
+type nodeTaint struct {
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	Effect    string `json:"effect"`
+	TimeAdded string `json:"timeAdded,omitempty"`
+}
+
+// String returns the taint in key=value:Effect notation
+func (t nodeTaint) String() string {
+	if t.Value == "" {
+		return t.Key + ":" + t.Effect
+	}
+	return t.Key + "=" + t.Value + ":" + t.Effect
+}
+
+// id identifies a taint, Kubernetes allows one taint per key and effect
+func (t nodeTaint) id() string {
+	return t.Key + ":" + t.Effect
+}
+
+// nodeMetadata is the Kubernetes identity of a platform node
+type nodeMetadata struct {
+	Name   string
+	Labels map[string]string
+	Taints []nodeTaint
+}
+
+// kubeNode holds the parts of a Kubernetes node that labels sync reads
+type kubeNode struct {
+	Metadata struct {
+		Name        string            `json:"name"`
+		Labels      map[string]string `json:"labels"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Taints []nodeTaint `json:"taints"`
+	} `json:"spec"`
+}
+
+// fetchPlatformNode reads the ID and attributes of the node of a platform node token
+func fetchPlatformNode(platformURL, token string) (string, *VesselEngineNodeAttributes, error) {
+	nodeID, err := extractJWTSubject(token)
+	if err != nil {
+		return "", nil, err
+	}
+	node, err := getGalleyNode(platformURL, token)
+	if err != nil {
+		return "", nil, fmt.Errorf("couldn't fetch node details: %w", err)
+	}
+	if node == nil {
+		return "", nil, fmt.Errorf("no platform URL configured")
+	}
+	return nodeID, &node.Attributes, nil
+}
+
+// fetchNodeMetadata reads the node of a platform node token
+func fetchNodeMetadata(platformURL, token string) (*nodeMetadata, error) {
+	nodeID, attrs, err := fetchPlatformNode(platformURL, token)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, warnings := nodeMetadataFromAttributes(nodeID, *attrs)
+	for _, warning := range warnings {
+		fmt.Printf("⚠️  %s\n", warning)
+	}
+	return meta, nil
+}
+
+// fetchJoinTokenNode reads the platform node a join token is created for. The node
+// must belong to the vessel engine of the token.
+func fetchJoinTokenNode(platformURL, token, vesselEngineId string) (*joinTokenNode, error) {
+	nodeID, attrs, err := fetchPlatformNode(platformURL, token)
+	if err != nil {
+		return nil, err
+	}
+	if attrs.VesselEngineID != "" && attrs.VesselEngineID != vesselEngineId {
+		return nil, fmt.Errorf("node %s belongs to vessel engine %s, not to %s", nodeID, attrs.VesselEngineID, vesselEngineId)
+	}
+	return &joinTokenNode{
+		ID:       nodeID,
+		Name:     attrs.Name,
+		RegionID: attrs.VesselEngineRegionID,
+		Labels:   attrs.Labels,
+		Taints:   attrs.Taints,
+	}, nil
+}
+
+// joinNodeMetadata returns the name, labels and taints a joining node registers
+// with: those of the node the join token was created for, else those of the
+// platform node of --node-token, else only Galley's labels for the vessel engine
+// of the token. Every joining node gets at least the vessel engine label.
+func joinNodeMetadata(claims *joinTokenClaims, nodeID string, platformNode *VesselEngineNodeAttributes) *nodeMetadata {
+	var attrs VesselEngineNodeAttributes
+	switch {
+	case claims.Node != nil:
+		nodeID = claims.Node.ID
+		attrs = VesselEngineNodeAttributes{
+			Name:                 claims.Node.Name,
+			VesselEngineRegionID: claims.Node.RegionID,
+			Labels:               claims.Node.Labels,
+			Taints:               claims.Node.Taints,
+		}
+	case platformNode != nil:
+		attrs = *platformNode
+	}
+	// The signed token decides the vessel engine
+	attrs.VesselEngineID = claims.VesselEngineId
+
+	meta, warnings := nodeMetadataFromAttributes(nodeID, attrs)
+	for _, warning := range warnings {
+		fmt.Printf("⚠️  %s\n", warning)
+	}
+	return meta
+}
+
+// nodeMetadataFromAttributes builds the node name, labels and taints of a platform
+// node. Invalid labels and taints from the platform are skipped with a warning.
+func nodeMetadataFromAttributes(nodeID string, attrs VesselEngineNodeAttributes) (*nodeMetadata, []string) {
+	var warnings []string
+	meta := &nodeMetadata{
+		Name:   kubernetesNodeName(attrs.Name),
+		Labels: map[string]string{},
+	}
+	if attrs.Name != "" && meta.Name == "" {
+		warnings = append(warnings, fmt.Sprintf("platform node name %q can't be used as a Kubernetes node name, keeping the host name", attrs.Name))
+	}
+
+	for key, value := range attrs.Labels {
+		if err := validateLabel(key, value); err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping label %s: %v", key, err))
+			continue
+		}
+		meta.Labels[key] = value
+	}
+
+	// Galley's own labels take precedence over labels from the platform
+	own := map[string]string{
+		nodeIDLabel:     nodeID,
+		nodeEngineLabel: attrs.VesselEngineID,
+		nodeRegionLabel: attrs.VesselEngineRegionID,
+	}
+	for key, value := range own {
+		if value == "" {
+			continue
+		}
+		if err := validateLabel(key, value); err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping label %s: %v", key, err))
+			continue
+		}
+		meta.Labels[key] = value
+	}
+
+	seen := map[string]bool{}
+	for _, value := range attrs.Taints {
+		taint, err := parseNodeTaint(value)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skipping taint %s: %v", value, err))
+			continue
+		}
+		if seen[taint.id()] {
+			continue
+		}
+		seen[taint.id()] = true
+		meta.Taints = append(meta.Taints, taint)
+	}
+	sort.Slice(meta.Taints, func(i, j int) bool { return meta.Taints[i].id() < meta.Taints[j].id() })
+
+	sort.Strings(warnings)
+	return meta, warnings
+}
+
+// parseNodeTaint parses a taint in key=value:Effect or key:Effect notation
+func parseNodeTaint(value string) (nodeTaint, error) {
+	i := strings.LastIndex(value, ":")
+	if i < 0 {
+		return nodeTaint{}, fmt.Errorf("expected key=value:Effect")
+	}
+	taint := nodeTaint{Key: value[:i], Effect: value[i+1:]}
+	if key, val, ok := strings.Cut(taint.Key, "="); ok {
+		taint.Key, taint.Value = key, val
+	}
+
+	switch taint.Effect {
+	case "NoSchedule", "PreferNoSchedule", "NoExecute":
+	default:
+		return nodeTaint{}, fmt.Errorf("invalid effect %q, expected NoSchedule, PreferNoSchedule or NoExecute", taint.Effect)
+	}
+	if err := validateLabel(taint.Key, taint.Value); err != nil {
+		return nodeTaint{}, err
+	}
+	return taint, nil
+}
+
+// validateLabel checks a label key and value against the Kubernetes syntax,
+// taint keys and values follow the same rules
+func validateLabel(key, value string) error {
+	name := key
+	if prefix, rest, ok := strings.Cut(key, "/"); ok {
+		if len(prefix) > 253 || !labelPrefixRegexp.MatchString(prefix) {
+			return fmt.Errorf("invalid key prefix %q", prefix)
+		}
+		name = rest
+	}
+	if len(name) > maxLabelNameLength || !labelNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	if value != "" && (len(value) > maxLabelNameLength || !labelNameRegexp.MatchString(value)) {
+		return fmt.Errorf("invalid value %q", value)
+	}
+	return nil
+}
+
+// kubernetesNodeName turns a platform node name into a valid Kubernetes node name,
+// or returns an empty string when nothing usable is left
+func kubernetesNodeName(name string) string {
+	name = nodeNameInvalid.ReplaceAllString(strings.ToLower(strings.TrimSpace(name)), "-")
+	if len(name) > 253 {
+		name = name[:253]
+	}
+	return strings.Trim(name, "-.")
+}
+
+// kubeletCanSetLabel reports whether a kubelet may set the label on its own node
+func kubeletCanSetLabel(key string) bool {
+	namespace, _, ok := strings.Cut(key, "/")
+	if !ok {
+		return true
+	}
+	restricted := namespace == "kubernetes.io" || strings.HasSuffix(namespace, ".kubernetes.io") ||
+		namespace == "k8s.io" || strings.HasSuffix(namespace, ".k8s.io")
+	if !restricted {
+		return true
+	}
+	for _, allowed := range []string{"kubelet.kubernetes.io", "node.kubernetes.io"} {
+		if namespace == allowed || strings.HasSuffix(namespace, "."+allowed) {
+			return true
+		}
+	}
+	return kubeletLabels[key]
+}
+
+// k0sNodeArgs returns the 'k0s install' flags that register the node with its name,
+// labels and taints, and the labels only a controller can set
+func k0sNodeArgs(meta *nodeMetadata) ([]string, []string) {
+	var args, labels, deferred []string
+	for key, value := range meta.Labels {
+		if kubeletCanSetLabel(key) {
+			labels = append(labels, key+"="+value)
+		} else {
+			deferred = append(deferred, key)
+		}
+	}
+	sort.Strings(labels)
+	sort.Strings(deferred)
+
+	if len(labels) > 0 {
+		args = append(args, "--labels="+strings.Join(labels, ","))
+	}
+	if len(meta.Taints) > 0 {
+		var taints []string
+		for _, taint := range meta.Taints {
+			taints = append(taints, taint.String())
+		}
+		args = append(args, "--taints="+strings.Join(taints, ","))
+	}
+	if meta.Name != "" {
+		args = append(args, "--kubelet-extra-args=--hostname-override="+meta.Name)
+	}
+	return args, deferred
+}
+
+// joinNodeArgs returns the 'k0s install' flags for a joining node and stores its
+// Kubernetes node name in the config. Controller-only nodes have no kubelet.
+func joinNodeArgs(config *Config, nodeType string, meta *nodeMetadata) ([]string, error) {
+	if meta == nil || nodeType == "controller" {
+		return nil, nil
+	}
+
+	args, deferred := k0sNodeArgs(meta)
+	if err := setConfigValue(config, "node_name", meta.Name); err != nil {
+		return nil, fmt.Errorf("failed to save node_name %w in Galley config", err)
+	}
+
+	fmt.Printf("✓ Node will register as %s with %d label(s) and %d taint(s)\n", getNodeNameOrHostname(meta.Name), len(meta.Labels)-len(deferred), len(meta.Taints))
+	if len(deferred) > 0 {
+		fmt.Printf("💡 Labels %s can only be set by a controller, apply them after the join with:\n", strings.Join(deferred, ", "))
+		fmt.Println("   galley node labels sync --token <node token>")
+	}
+	logAction("Prepared node labels and taints", map[string]string{
+		"node_name": meta.Name,
+		"args":      strings.Join(args, " "),
+	})
+	return args, nil
+}
+
+func getNodeNameOrHostname(name string) string {
+	if name != "" {
+		return name
+	}
+	return getK0sNodeName()
+}
+
+// nodeMetadataPatch returns a merge patch that reconciles the node with meta, or nil
+// when it is in sync, together with a description of the changes. The labels and
+// taints Galley manages are tracked in annotations, so removed ones can be cleaned up.
+func nodeMetadataPatch(node kubeNode, meta *nodeMetadata) (map[string]interface{}, []string) {
+	var changes []string
+	labels := map[string]interface{}{}
+	annotations := map[string]interface{}{}
+
+	keys := make([]string, 0, len(meta.Labels))
+	for key := range meta.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := meta.Labels[key]
+		if current, ok := node.Metadata.Labels[key]; !ok || current != value {
+			labels[key] = value
+			changes = append(changes, fmt.Sprintf("set label %s=%s", key, value))
+		}
+	}
+	for _, key := range splitAnnotationList(node.Metadata.Annotations[managedLabelsAnnotation]) {
+		if _, wanted := meta.Labels[key]; wanted {
+			continue
+		}
+		if _, ok := node.Metadata.Labels[key]; ok {
+			labels[key] = nil
+			changes = append(changes, "remove label "+key)
+		}
+	}
+
+	// Keep taints set by others, replace the ones Galley manages
+	previous := map[string]bool{}
+	for _, id := range splitAnnotationList(node.Metadata.Annotations[managedTaintsAnnotation]) {
+		previous[id] = true
+	}
+	desired := map[string]nodeTaint{}
+	var taintIDs []string
+	for _, taint := range meta.Taints {
+		desired[taint.id()] = taint
+		taintIDs = append(taintIDs, taint.id())
+	}
+
+	var taints []nodeTaint
+	taintsChanged := false
+	for _, taint := range node.Spec.Taints {
+		want, isDesired := desired[taint.id()]
+		switch {
+		case isDesired:
+			if want.Value != taint.Value {
+				changes = append(changes, "set taint "+want.String())
+				taintsChanged = true
+			}
+			// Keep the position and time added of existing taints
+			taint.Value = want.Value
+			taints = append(taints, taint)
+			delete(desired, taint.id())
+		case previous[taint.id()]:
+			changes = append(changes, "remove taint "+taint.String())
+			taintsChanged = true
+		default:
+			taints = append(taints, taint)
+		}
+	}
+	for _, taint := range meta.Taints {
+		if _, missing := desired[taint.id()]; missing {
+			taints = append(taints, taint)
+			changes = append(changes, "set taint "+taint.String())
+			taintsChanged = true
+		}
+	}
+
+	if managed := strings.Join(keys, ","); node.Metadata.Annotations[managedLabelsAnnotation] != managed {
+		annotations[managedLabelsAnnotation] = managed
+	}
+	sort.Strings(taintIDs)
+	if managed := strings.Join(taintIDs, ","); node.Metadata.Annotations[managedTaintsAnnotation] != managed {
+		annotations[managedTaintsAnnotation] = managed
+	}
+
+	if len(labels) == 0 && len(annotations) == 0 && !taintsChanged {
+		return nil, nil
+	}
+
+	metadata := map[string]interface{}{}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	patch := map[string]interface{}{"metadata": metadata}
+	if taintsChanged {
+		if taints == nil {
+			taints = []nodeTaint{}
+		}
+		patch["spec"] = map[string]interface{}{"taints": taints}
+	}
+	if len(changes) == 0 {
+		changes = append(changes, "record the labels and taints managed by Galley")
+	}
+	return patch, changes
+}
+
+func splitAnnotationList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNodeMetadataFromAttributes(t *testing.T) {
+	meta, warnings := nodeMetadataFromAttributes("node-1", VesselEngineNodeAttributes{
+		Name:                 "Web Node 1",
+		VesselEngineID:       "engine-1",
+		VesselEngineRegionID: "eu-west",
+		Labels: map[string]string{
+			"tier":               "frontend",
+			nodeIDLabel:          "spoofed",
+			"invalid label":      "x",
+			"node-role.k8s.io/x": "",
+		},
+		Taints: []string{"dedicated=web:NoSchedule", "gpu:NoExecute", "broken", "dedicated=other:NoSchedule"},
+	})
+
+	if meta.Name != "web-node-1" {
+		t.Errorf("Name = %q, want web-node-1", meta.Name)
+	}
+	wantLabels := map[string]string{
+		"tier":               "frontend",
+		"node-role.k8s.io/x": "",
+		nodeIDLabel:          "node-1",
+		nodeEngineLabel:      "engine-1",
+		nodeRegionLabel:      "eu-west",
+	}
+	if !reflect.DeepEqual(meta.Labels, wantLabels) {
+		t.Errorf("Labels = %v, want %v", meta.Labels, wantLabels)
+	}
+	if len(meta.Taints) != 2 || meta.Taints[0].String() != "dedicated=web:NoSchedule" || meta.Taints[1].String() != "gpu:NoExecute" {
+		t.Errorf("Taints = %v", meta.Taints)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("warnings = %v, want one for the invalid label and one for the invalid taint", warnings)
+	}
+}
+
+func TestJoinNodeMetadata(t *testing.T) {
+	platformNode := &VesselEngineNodeAttributes{Name: "platform", VesselEngineID: "engine-2", Labels: map[string]string{"tier": "db"}}
+
+	// The node in the token wins over --node-token
+	claims := &joinTokenClaims{
+		VesselEngineId: "engine-1",
+		Node: &joinTokenNode{
+			ID:     "node-1",
+			Name:   "Web 1",
+			Labels: map[string]string{"tier": "frontend"},
+			Taints: []string{"dedicated=web:NoSchedule"},
+		},
+	}
+	meta := joinNodeMetadata(claims, "node-2", platformNode)
+	if meta.Name != "web-1" || meta.Labels["tier"] != "frontend" || meta.Labels[nodeIDLabel] != "node-1" || len(meta.Taints) != 1 {
+		t.Errorf("joinNodeMetadata() with token node = %+v", meta)
+	}
+
+	// The token decides the vessel engine, not the platform node
+	claims.Node = nil
+	meta = joinNodeMetadata(claims, "node-2", platformNode)
+	if meta.Name != "platform" || meta.Labels["tier"] != "db" || meta.Labels[nodeEngineLabel] != "engine-1" {
+		t.Errorf("joinNodeMetadata() with platform node = %+v", meta)
+	}
+
+	// Without a node every join still gets the vessel engine label
+	meta = joinNodeMetadata(claims, "", nil)
+	want := map[string]string{nodeEngineLabel: "engine-1"}
+	if meta.Name != "" || !reflect.DeepEqual(meta.Labels, want) {
+		t.Errorf("joinNodeMetadata() without node = %+v, want labels %v", meta, want)
+	}
+}
+
+func TestParseNodeTaint(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    nodeTaint
+		wantErr bool
+	}{
+		{"dedicated=web:NoSchedule", nodeTaint{Key: "dedicated", Value: "web", Effect: "NoSchedule"}, false},
+		{"galley.run/gpu:PreferNoSchedule", nodeTaint{Key: "galley.run/gpu", Effect: "PreferNoSchedule"}, false},
+		{"dedicated=web", nodeTaint{}, true},
+		{"dedicated=web:Never", nodeTaint{}, true},
+		{"bad key=web:NoSchedule", nodeTaint{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseNodeTaint(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNodeTaint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseNodeTaint() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKubernetesNodeName(t *testing.T) {
+	tests := map[string]string{
+		"worker-1":       "worker-1",
+		"Web Node 1":     "web-node-1",
+		"  db.eu_west  ": "db.eu-west",
+		"---":            "",
+		"":               "",
+	}
+	for name, want := range tests {
+		if got := kubernetesNodeName(name); got != want {
+			t.Errorf("kubernetesNodeName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestKubeletCanSetLabel(t *testing.T) {
+	tests := map[string]bool{
+		"tier":                             true,
+		"galley.run/node-id":               true,
+		"topology.kubernetes.io/region":    true,
+		"node.kubernetes.io/instance-type": true,
+		"node-role.kubernetes.io/worker":   false,
+		"example.k8s.io/x":                 false,
+	}
+	for key, want := range tests {
+		if got := kubeletCanSetLabel(key); got != want {
+			t.Errorf("kubeletCanSetLabel(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestK0sNodeArgs(t *testing.T) {
+	args, deferred := k0sNodeArgs(&nodeMetadata{
+		Name: "web-1",
+		Labels: map[string]string{
+			"tier":                           "frontend",
+			nodeRegionLabel:                  "eu-west",
+			"node-role.kubernetes.io/worker": "",
+		},
+		Taints: []nodeTaint{{Key: "dedicated", Value: "web", Effect: "NoSchedule"}},
+	})
+
+	want := []string{
+		"--labels=tier=frontend,topology.kubernetes.io/region=eu-west",
+		"--taints=dedicated=web:NoSchedule",
+		"--kubelet-extra-args=--hostname-override=web-1",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("k0sNodeArgs() args = %v, want %v", args, want)
+	}
+	if len(deferred) != 1 || deferred[0] != "node-role.kubernetes.io/worker" {
+		t.Errorf("k0sNodeArgs() deferred = %v", deferred)
+	}
+}
+
+func TestNodeMetadataPatch(t *testing.T) {
+	var node kubeNode
+	node.Metadata.Name = "web-1"
+	node.Metadata.Labels = map[string]string{"tier": "backend", "old": "x", "kept": "y"}
+	node.Metadata.Annotations = map[string]string{
+		managedLabelsAnnotation: "old,tier",
+		managedTaintsAnnotation: "legacy:NoSchedule",
+	}
+	node.Spec.Taints = []nodeTaint{
+		{Key: "legacy", Effect: "NoSchedule"},
+		{Key: "node.kubernetes.io/unreachable", Effect: "NoExecute", TimeAdded: "2026-01-01T00:00:00Z"},
+	}
+
+	meta := &nodeMetadata{
+		Labels: map[string]string{"tier": "frontend"},
+		Taints: []nodeTaint{{Key: "dedicated", Value: "web", Effect: "NoSchedule"}},
+	}
+
+	patch, changes := nodeMetadataPatch(node, meta)
+	if patch == nil {
+		t.Fatal("nodeMetadataPatch() should return a patch")
+	}
+
+	metadata := patch["metadata"].(map[string]interface{})
+	labels := metadata["labels"].(map[string]interface{})
+	if labels["tier"] != "frontend" || labels["old"] != nil || len(labels) != 2 {
+		t.Errorf("labels patch = %v", labels)
+	}
+	if _, ok := labels["kept"]; ok {
+		t.Error("labels not managed by Galley should be left alone")
+	}
+
+	taints := patch["spec"].(map[string]interface{})["taints"].([]nodeTaint)
+	wantTaints := []nodeTaint{
+		{Key: "node.kubernetes.io/unreachable", Effect: "NoExecute", TimeAdded: "2026-01-01T00:00:00Z"},
+		{Key: "dedicated", Value: "web", Effect: "NoSchedule"},
+	}
+	if !reflect.DeepEqual(taints, wantTaints) {
+		t.Errorf("taints patch = %v, want %v", taints, wantTaints)
+	}
+
+	annotations := metadata["annotations"].(map[string]interface{})
+	if annotations[managedLabelsAnnotation] != "tier" || annotations[managedTaintsAnnotation] != "dedicated:NoSchedule" {
+		t.Errorf("annotations patch = %v", annotations)
+	}
+
+	joined := strings.Join(changes, "; ")
+	for _, want := range []string{"set label tier=frontend", "remove label old", "remove taint legacy:NoSchedule", "set taint dedicated=web:NoSchedule"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("changes %q should contain %q", joined, want)
+		}
+	}
+
+	// A node in sync needs no patch
+	node.Metadata.Labels = map[string]string{"tier": "frontend"}
+	node.Metadata.Annotations = map[string]string{managedLabelsAnnotation: "tier", managedTaintsAnnotation: "dedicated:NoSchedule"}
+	node.Spec.Taints = wantTaints
+	if patch, _ := nodeMetadataPatch(node, meta); patch != nil {
+		t.Errorf("nodeMetadataPatch() = %v, want nil for a node in sync", patch)
+	}
+}
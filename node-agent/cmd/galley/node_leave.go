@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagNodeLeaveKeepHardening bool
+	flagNodeLeaveForce         bool
+	flagNodeLeaveYes           bool
+	flagNodeLeaveToken         string
+	flagNodeLeaveKubeconfig    string
+	flagNodeLeaveDrainTimeout  time.Duration
+)
+
+// k0sLeavePaths are removed after 'k0s reset' so no state survives a leave
+var k0sLeavePaths = []string{
+	k0sDataDir,
+	"/run/k0s",
+	k0sTokenFile,
+	k0sConfigFile,
+	"/etc/systemd/system/k0scontroller.service",
+	"/etc/systemd/system/k0sworker.service",
+}
+
+var nodeLeaveCmd = &cobra.Command{
+	Use:     "leave",
+	Aliases: []string{"reset"},
+	Short:   "Remove this node from its cluster and uninstall k0s",
+	Long: `Decommissions this node cleanly, the reverse of 'galley node prepare' and join.
+
+This command will:
+  - Cordon and drain the node (workers and controller+worker nodes), a worker
+    needs a controller credential for this, see --kubeconfig
+  - Check etcd quorum and remove this etcd member (controllers)
+  - Stop k0s and run 'k0s reset'
+  - Remove the k0s units, data directories, join token and k0s config, and stop
+    with an error before the next steps when any of them can't be removed
+  - Deregister the node from the Galley platform (with --token)
+  - Clear vessel_engine_id, node_type and node_name from the Galley config
+  - Clear the node preparation progress
+
+Use --keep-hardening to keep the OS, SSH and hardening steps marked as done,
+so only the cluster membership goes away.`,
+	Args: cobra.ExactArgs(0),
+	RunE: runNodeLeave,
+}
+
+func init() {
+	nodeLeaveCmd.Flags().BoolVar(&flagNodeLeaveKeepHardening, "keep-hardening", false, "Keep OS and hardening changes, only remove the cluster membership")
+	nodeLeaveCmd.Flags().BoolVar(&flagNodeLeaveForce, "force", false, "Continue when draining or leaving etcd fails, or when this is the last controller")
+	nodeLeaveCmd.Flags().BoolVarP(&flagNodeLeaveYes, "yes", "y", false, "Do not ask for confirmation")
+	nodeLeaveCmd.Flags().StringVar(&flagNodeLeaveToken, "token", "", "Node token from the Galley web interface, used to deregister the node")
+	nodeLeaveCmd.Flags().StringVar(&flagNodeLeaveKubeconfig, "kubeconfig", "", "Kubeconfig allowed to drain nodes, e.g. from 'galley kubeconfig admin' on a controller (required on workers)")
+	nodeLeaveCmd.Flags().DurationVar(&flagNodeLeaveDrainTimeout, "drain-timeout", 5*time.Minute, "How long to wait for pods to be evicted")
+	nodeCmd.AddCommand(nodeLeaveCmd)
+}
+
+func runNodeLeave(cmd *cobra.Command, args []string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load Galley config: %w", err)
+	}
+
+	nodeType := config.NodeType
+	if nodeType == "" {
+		nodeType = detectInstalledNodeType()
+	}
+	if nodeType == "" && !flagNodeLeaveForce {
+		return fmt.Errorf("this node has not joined a cluster (no node_type in config and no k0s service found), use --force to clean up anyway")
+	}
+
+	nodeName := getK0sNodeName()
+	runsKubelet := nodeType == "worker" || nodeType == "controller+worker"
+	isController := strings.HasPrefix(nodeType, "controller")
+
+	// Check the drain credentials before asking, so a worker doesn't stop halfway
+	drainKubectl, drainErr := drainKubectlArgs(nodeType, flagNodeLeaveKubeconfig)
+	if runsKubelet && drainErr != nil && !flagNodeLeaveForce {
+		return fmt.Errorf("%w\nOr drain it from a controller with 'k0s kubectl drain %s --ignore-daemonsets --delete-emptydir-data' and use --force", drainErr, nodeName)
+	}
+
+	logAction("Starting node leave", map[string]string{
+		"node_type":      nodeType,
+		"node_name":      nodeName,
+		"keep_hardening": fmt.Sprintf("%t", flagNodeLeaveKeepHardening),
+	})
+
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Println("Leave Cluster")
+	fmt.Println(strings.Repeat("=", 70))
+	fmt.Printf("Node:      %s\n", nodeName)
+	fmt.Printf("Node type: %s\n", nodeType)
+	fmt.Println("\nThis removes the node from its cluster and deletes all k0s data on it.")
+
+	if !flagNodeLeaveYes && !flagDryRun {
+		fmt.Print("\nDo you want to continue? [y/N]: ")
+		reader := bufio.NewReader(os.Stdin)
+		response, err := reader.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read input: %w", err)
+		}
+		response = strings.TrimSpace(strings.ToLower(response))
+		if response != "y" && response != "yes" {
+			fmt.Println("Node leave cancelled.")
+			return nil
+		}
+	}
+
+	if runsKubelet {
+		err := drainErr
+		if err == nil {
+			err = drainNode(drainKubectl, nodeName)
+		}
+		if err != nil {
+			if !flagNodeLeaveForce {
+				return fmt.Errorf("failed to drain node: %w\nDrain it from a controller with 'k0s kubectl drain %s --ignore-daemonsets --delete-emptydir-data' or use --force", err, nodeName)
+			}
+			fmt.Printf("⚠️  Warning: failed to drain node, continuing because of --force: %v\n", err)
+		}
+	}
+
+	if isController {
+		if err := leaveEtcd(); err != nil {
+			if !flagNodeLeaveForce {
+				return err
+			}
+			fmt.Printf("⚠️  Warning: %v, continuing because of --force\n", err)
+		}
+	}
+
+	if err := resetK0s(); err != nil {
+		return err
+	}
+
+	if flagNodeLeaveToken != "" {
+		if err := deregisterNode(flagNodeLeaveToken); err != nil {
+			fmt.Printf("⚠️  Warning: failed to deregister node from Galley: %v\n", err)
+			fmt.Println("   You can remove it manually in the Galley web interface.")
+		} else {
+			fmt.Println("✓ Node deregistered from Galley")
+		}
+	} else {
+		fmt.Println("💡 No --token given, remove this node in the Galley web interface.")
+	}
+
+	if err := clearNodeMembership(config); err != nil {
+		return err
+	}
+
+	fmt.Println("\n" + strings.Repeat("=", 70))
+	fmt.Println("✓ Node left the cluster successfully!")
+	fmt.Println(strings.Repeat("=", 70))
+	if flagNodeLeaveKeepHardening {
+		fmt.Println("OS and hardening changes were kept. Run 'galley node prepare' before joining again.")
+	} else {
+		fmt.Println("Run 'galley node prepare' to prepare this node again.")
+	}
+
+	logAction("Node leave completed", map[string]string{
+		"node_type": nodeType,
+		"status":    "success",
+	})
+
+	fmt.Printf("\n💡 View all actions taken: galley logs\n\n")
+	return nil
+}
+
+// detectInstalledNodeType guesses the node type from the installed k0s units
+func detectInstalledNodeType() string {
+	if _, err := os.Stat("/etc/systemd/system/k0sworker.service"); err == nil {
+		return "worker"
+	}
+	if _, err := os.Stat("/etc/systemd/system/k0scontroller.service"); err == nil {
+		return "controller"
+	}
+	return ""
+}
+
+// drainKubectlArgs returns the 'k0s kubectl' arguments that cordon and drain this
+// node. Controllers use their admin credentials. The node authorizer doesn't let a
+// kubelet evict pods, so a worker needs the kubeconfig of a controller or admin.
+func drainKubectlArgs(nodeType, kubeconfig string) (func(args ...string) []string, error) {
+	if kubeconfig == "" && nodeType == "worker" {
+		return nil, fmt.Errorf("draining a worker needs a controller credential, pass --kubeconfig with a kubeconfig from 'galley kubeconfig admin' on a controller")
+	}
+	return func(args ...string) []string {
+		if kubeconfig != "" {
+			return append([]string{"kubectl", "--kubeconfig", kubeconfig}, args...)
+		}
+		return append([]string{"kubectl"}, args...)
+	}, nil
+}
+
+// drainNode cordons the node and evicts its pods
+func drainNode(kubectl func(args ...string) []string, nodeName string) error {
+	fmt.Printf("\nDraining node %s...\n", nodeName)
+	ctx := context.Background()
+
+	if err := runCommandWithContext(ctx, "k0s", kubectl("cordon", nodeName)...); err != nil {
+		return err
+	}
+	logAction("Cordoned node", map[string]string{"node": nodeName})
+
+	drainArgs := kubectl("drain", nodeName,
+		"--ignore-daemonsets",
+		"--delete-emptydir-data",
+		"--timeout="+flagNodeLeaveDrainTimeout.String(),
+	)
+	if err := runCommandWithContext(ctx, "k0s", drainArgs...); err != nil {
+		return err
+	}
+	logAction("Drained node", map[string]string{"node": nodeName})
+
+	fmt.Println("✓ Node drained")
+	return nil
+}
+
+// leaveEtcd removes this controller from the etcd cluster, guarding quorum
+func leaveEtcd() error {
+	fmt.Println("\nChecking etcd membership...")
+
+	output, err := exec.Command("k0s", "etcd", "member-list").Output()
+	if err != nil {
+		// Controllers backed by kine (sqlite) have no etcd members to remove
+		fmt.Println("etcd member list not available (single controller or non-etcd storage), skipping etcd leave")
+		return nil
+	}
+
+	members, err := parseEtcdMembers(output)
+	if err != nil {
+		return err
+	}
+
+	if err := checkEtcdLeaveQuorum(len(members)); err != nil {
+		return err
+	}
+
+	if err := runCommandWithContext(context.Background(), "k0s", "etcd", "leave"); err != nil {
+		return fmt.Errorf("failed to leave etcd cluster: %w", err)
+	}
+
+	logAction("Left etcd cluster", map[string]string{
+		"members_before": fmt.Sprintf("%d", len(members)),
+	})
+	fmt.Printf("✓ Removed from etcd (%d member(s) remaining)\n", len(members)-1)
+	return nil
+}
+
+// checkEtcdLeaveQuorum refuses to remove the last etcd member unless forced
+func checkEtcdLeaveQuorum(members int) error {
+	switch {
+	case members <= 1:
+		if !flagNodeLeaveForce {
+			return fmt.Errorf("this is the last etcd member, leaving destroys the cluster (use --force to continue)")
+		}
+	case members == 2:
+		fmt.Println("⚠️  Only one controller will remain, the control plane will have no fault tolerance.")
+	case members%2 == 1:
+		fmt.Printf("⚠️  %d controllers will remain, use an odd number of controllers for the best fault tolerance.\n", members-1)
+	}
+	return nil
+}
+
+// parseEtcdMembers parses the output of 'k0s etcd member-list'
+func parseEtcdMembers(data []byte) (map[string]string, error) {
+	var result struct {
+		Members map[string]string `json:"members"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse etcd member list: %w", err)
+	}
+	return result.Members, nil
+}
+
+// resetK0s stops and uninstalls k0s, removing its units and data directories
+func resetK0s() error {
+	fmt.Println("\nResetting k0s...")
+	ctx := context.Background()
+
+	if err := runCommandWithContext(ctx, "k0s", "stop"); err != nil {
+		fmt.Printf("⚠️  Warning: k0s stop failed (it may not be running): %v\n", err)
+	}
+
+	if err := runCommandWithContext(ctx, "k0s", "reset"); err != nil {
+		if !flagNodeLeaveForce {
+			return fmt.Errorf("k0s reset failed: %w (use --force to remove the k0s files anyway)", err)
+		}
+		fmt.Printf("⚠️  Warning: k0s reset failed, continuing because of --force: %v\n", err)
+	}
+
+	// Remove every path that can be removed, but don't report a clean leave when
+	// k0s state is left behind
+	var failed []string
+	for _, path := range k0sLeavePaths {
+		if err := sysExec.RemoveAll(path); err != nil {
+			fmt.Printf("✗ Failed to remove %s: %v\n", path, err)
+			failed = append(failed, path)
+			continue
+		}
+		logAction("Removed k0s path", map[string]string{"path": path})
+	}
+
+	if err := runCommandWithContext(ctx, "systemctl", "daemon-reload"); err != nil {
+		fmt.Printf("⚠️  Warning: systemctl daemon-reload failed: %v\n", err)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove k0s files %s, remove them and run 'galley node leave --force' again", strings.Join(failed, ", "))
+	}
+
+	fmt.Println("✓ k0s reset completed")
+	return nil
+}
+
+// deregisterNode removes the node from the Galley platform using its node token
+func deregisterNode(token string) error {
+	vesselEngineNodeId, err := extractJWTSubject(token)
+	if err != nil {
+		return err
+	}
+
+	if err := deregisterGalleyNode(getPlatformURL(), vesselEngineNodeId, token); err != nil {
+		return err
+	}
+
+	logAction("Node deregistered from Galley", map[string]string{
+		"vessel_engine_node_id": vesselEngineNodeId,
+	})
+	return nil
+}
+
+// clearNodeMembership removes the cluster identity from the Galley config and resets
+// the preparation progress, keeping OS and hardening steps with --keep-hardening
+func clearNodeMembership(config *Config) error {
+	if err := setConfigValue(config, "vessel_engine_id", ""); err != nil {
+		return err
+	}
+	if err := setConfigValue(config, "node_type", ""); err != nil {
+		return err
+	}
+	if err := setConfigValue(config, "node_name", ""); err != nil {
+		return err
+	}
+	if err := saveConfig(config); err != nil {
+		return fmt.Errorf("failed to save Galley config: %w", err)
+	}
+	logAction("Cleared cluster membership from Galley config", nil)
+
+	if !flagNodeLeaveKeepHardening {
+		cleanupProgress()
+		logAction("Cleared node preparation progress", nil)
+		return nil
+	}
+
+	progress, err := loadProgress()
+	if err != nil {
+		return fmt.Errorf("failed to load progress: %w", err)
+	}
+	delete(progress.CompletedSteps, stepK0sConfig)
+	if err := progress.save(); err != nil {
+		return fmt.Errorf("failed to save progress: %w", err)
+	}
+	logAction("Cleared k0s steps from node preparation progress", nil)
+	return nil
+}
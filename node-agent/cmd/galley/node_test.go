@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"os"
 	"strings"
 	"testing"
@@ -605,13 +606,9 @@ func TestExpectedPorts(t *testing.T) {
 // TestNodePrepareStepConstants validates step tracking constants
 func TestNodePrepareStepConstants(t *testing.T) {
 	t.Run("validates step constants", func(t *testing.T) {
-		// These should match the constants in the actual code
-		steps := []string{
-			"os-update",
-			"ssh-config",
-			"k0s-install",
-			"k0s-config",
-			"server-hardening",
+		var steps []string
+		for _, step := range prepareSteps() {
+			steps = append(steps, step.ID)
 		}
 
 		for _, step := range steps {
@@ -668,3 +665,83 @@ func TestSSHServiceNames(t *testing.T) {
 		}
 	})
 }
+
+// TestParseEtcdMembers validates parsing of 'k0s etcd member-list' output
+func TestParseEtcdMembers(t *testing.T) {
+	data := `{"members":{"controller-1":"https://10.0.0.11:2380","controller-2":"https://10.0.0.12:2380"}}`
+
+	members, err := parseEtcdMembers([]byte(data))
+	if err != nil {
+		t.Fatalf("parseEtcdMembers() error = %v", err)
+	}
+	if len(members) != 2 {
+		t.Errorf("parseEtcdMembers() returned %d members, want 2", len(members))
+	}
+	if members["controller-2"] != "https://10.0.0.12:2380" {
+		t.Errorf("parseEtcdMembers() controller-2 = %q", members["controller-2"])
+	}
+
+	if _, err := parseEtcdMembers([]byte("not json")); err == nil {
+		t.Error("parseEtcdMembers() should fail on invalid JSON")
+	}
+}
+
+// TestCheckEtcdLeaveQuorum validates that the last etcd member is protected
+func TestCheckEtcdLeaveQuorum(t *testing.T) {
+	defer func() { flagNodeLeaveForce = false }()
+
+	flagNodeLeaveForce = false
+	if err := checkEtcdLeaveQuorum(1); err == nil {
+		t.Error("checkEtcdLeaveQuorum(1) should refuse without --force")
+	}
+	if err := checkEtcdLeaveQuorum(3); err != nil {
+		t.Errorf("checkEtcdLeaveQuorum(3) error = %v", err)
+	}
+
+	flagNodeLeaveForce = true
+	if err := checkEtcdLeaveQuorum(1); err != nil {
+		t.Errorf("checkEtcdLeaveQuorum(1) with --force error = %v", err)
+	}
+}
+
+// TestDrainNodeWorker validates that a worker drains with a controller credential,
+// never with the kubelet credentials the node authorizer denies evictions
+func TestDrainNodeWorker(t *testing.T) {
+	if _, err := drainKubectlArgs("worker", ""); err == nil {
+		t.Error("drainKubectlArgs() of a worker without --kubeconfig should fail")
+	}
+
+	kubectl, err := drainKubectlArgs("worker", "/root/admin.conf")
+	if err != nil {
+		t.Fatalf("drainKubectlArgs() error = %v", err)
+	}
+
+	oldExec := sysExec
+	defer func() { sysExec = oldExec }()
+	var out bytes.Buffer
+	sysExec = newDryRunExecutor(&out)
+
+	if err := drainNode(kubectl, "worker-1"); err != nil {
+		t.Fatalf("drainNode() error = %v", err)
+	}
+	plan := out.String()
+	for _, want := range []string{
+		"Run: k0s kubectl --kubeconfig /root/admin.conf cordon worker-1\n",
+		"Run: k0s kubectl --kubeconfig /root/admin.conf drain worker-1 --ignore-daemonsets",
+	} {
+		if !strings.Contains(plan, want) {
+			t.Errorf("drain plan is missing %q:\n%s", want, plan)
+		}
+	}
+	if strings.Contains(plan, k0sKubeletKubeconfig) {
+		t.Errorf("drain plan uses the kubelet kubeconfig:\n%s", plan)
+	}
+
+	kubectl, err = drainKubectlArgs("controller+worker", "")
+	if err != nil {
+		t.Fatalf("drainKubectlArgs() of a controller+worker error = %v", err)
+	}
+	if got := strings.Join(kubectl("cordon", "cw-1"), " "); got != "kubectl cordon cw-1" {
+		t.Errorf("controller+worker kubectl args = %q", got)
+	}
+}
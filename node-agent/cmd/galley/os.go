@@ -93,13 +93,12 @@ func promptOSUpdate(progress *PrepareProgress) error {
 		if err := updateOS(progress, reader); err != nil {
 			return err
 		}
-		// Mark step complete and set needs reboot flag
 		progress.NeedsReboot = true
-		return progress.markComplete(stepOSUpdate)
+		return nil
 	}
 
 	fmt.Println("\nOS update skipped.")
-	return progress.markComplete(stepOSUpdate)
+	return nil
 }
 
 func updateOS(progress *PrepareProgress, reader *bufio.Reader) error {
@@ -156,7 +155,7 @@ func promptDistUpgrade(ctx context.Context, reader *bufio.Reader) error {
 
 	const cfgPath = "/etc/update-manager/release-upgrades"
 
-	original, err := os.ReadFile(cfgPath)
+	original, err := sysExec.ReadFile(cfgPath)
 	if err == nil {
 		currentPrompt := getReleasePromptFromConfig(string(original))
 
@@ -179,7 +178,7 @@ func promptDistUpgrade(ctx context.Context, reader *bufio.Reader) error {
 			if response == "" || response == "y" || response == "yes" {
 				updated := updateReleaseUpgradesPrompt(string(original), "lts")
 				if updated != string(original) {
-					if err := os.WriteFile(cfgPath, []byte(updated), 0644); err != nil {
+					if err := sysExec.WriteFile(cfgPath, []byte(updated), 0644); err != nil {
 						fmt.Printf("⚠️  Warning: failed to update %s: %v\n", cfgPath, err)
 						// Continue with existing policy, but we will still only *suggest* LTS upgrades
 					} else {
@@ -349,11 +348,18 @@ func loadProgress() (*PrepareProgress, error) {
 		progress.CompletedSteps = make(map[string]bool)
 	}
 
+	// Progress files of older versions use step descriptions as keys
+	if migrateStepIDs(progress.CompletedSteps) {
+		if err := progress.save(); err != nil {
+			return nil, err
+		}
+	}
+
 	return &progress, nil
 }
 
 func (p *PrepareProgress) save() error {
-	if err := os.MkdirAll(galleyStateDir, 0755); err != nil {
+	if err := sysExec.MkdirAll(galleyStateDir, 0755); err != nil {
 		return err
 	}
 
@@ -362,7 +368,7 @@ func (p *PrepareProgress) save() error {
 		return err
 	}
 
-	return os.WriteFile(galleyProgressFile, data, 0644)
+	return sysExec.WriteFile(galleyProgressFile, data, 0644)
 }
 
 func (p *PrepareProgress) markComplete(step string) error {
@@ -393,6 +399,13 @@ func isAvailable(binaries []string) bool {
 
 func runCommandsWithContext(ctx context.Context, commands [][]string) error {
 	for i, cmdArgs := range commands {
+		if flagDryRun {
+			if err := runCommandWithContext(ctx, cmdArgs[0], cmdArgs[1:]...); err != nil {
+				return err
+			}
+			continue
+		}
+
 		start := time.Now()
 		fmt.Printf("[%d/%d] Running: %s %s\n", i+1, len(commands), cmdArgs[0], strings.Join(cmdArgs[1:], " "))
 
@@ -405,20 +418,9 @@ func runCommandsWithContext(ctx context.Context, commands [][]string) error {
 	return nil
 }
 
+// runCommandWithContext runs a command that changes the system, see executor
 func runCommandWithContext(ctx context.Context, name string, args ...string) error {
-	cmd := exec.CommandContext(ctx, name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	// Log the command execution
-	logCommand(name, args)
-
-	if err := cmd.Run(); err != nil {
-		logError(fmt.Sprintf("command: %s %v", name, args), err)
-		return fmt.Errorf("%s failed: %w", name, err)
-	}
-
-	return nil
+	return sysExec.Run(ctx, name, args...)
 }
 
 const (
@@ -429,14 +431,6 @@ const (
 	galleyResumeUnit     = "galley-resume.service"
 )
 
-const (
-	stepOSUpdate        = "Server OS is now up to date."
-	stepSSHConfig       = "SSH configuration is improved and more secure."
-	stepK0sInstall      = "K0s is installed."
-	stepK0sConfig       = "K0s configuration is ready and configured."
-	stepServerHardening = "Recommended server hardening is applied."
-)
-
 func promptRebootAtEnd(progress *PrepareProgress) error {
 	if !progress.NeedsReboot {
 		return nil
@@ -468,10 +462,12 @@ func promptRebootAtEnd(progress *PrepareProgress) error {
 
 		time.Sleep(5 * time.Second)
 
-		cmd := exec.Command("reboot")
-		if err := cmd.Run(); err != nil {
+		if err := runCommandWithContext(context.Background(), "reboot"); err != nil {
 			return fmt.Errorf("failed to reboot: %w", err)
 		}
+		if flagDryRun {
+			return nil
+		}
 
 		// Wait indefinitely for the reboot to take effect
 		fmt.Println("Waiting for system to reboot...")
@@ -489,7 +485,27 @@ func promptRebootAtEnd(progress *PrepareProgress) error {
 }
 
 func cleanupProgress() {
-	os.Remove(galleyProgressFile)
+	_ = sysExec.Remove(galleyProgressFile)
+}
+
+// aptUnattendedUpgradesEnabled reports whether an apt periodic configuration runs
+// unattended-upgrades
+func aptUnattendedUpgradesEnabled(content string) bool {
+	return strings.Contains(content, `APT::Periodic::Unattended-Upgrade "1"`)
+}
+
+// automaticSecurityUpdatesEnabled reports whether unattended-upgrades, dnf-automatic
+// or yum-cron installs security updates, and which one
+func automaticSecurityUpdatesEnabled() (bool, string) {
+	if content, err := os.ReadFile("/etc/apt/apt.conf.d/20auto-upgrades"); err == nil && aptUnattendedUpgradesEnabled(string(content)) {
+		return true, "unattended-upgrades"
+	}
+	for _, unit := range []string{"dnf-automatic.timer", "dnf-automatic-install.timer", "yum-cron"} {
+		if exec.Command("systemctl", "is-enabled", "--quiet", unit).Run() == nil {
+			return true, unit
+		}
+	}
+	return false, ""
 }
 
 func enableUnattendedUpgrades() error {
@@ -502,7 +518,7 @@ APT::Periodic::AutocleanInterval "7";
 APT::Periodic::Unattended-Upgrade "1";
 `
 	configPath := "/etc/apt/apt.conf.d/20auto-upgrades"
-	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+	if err := sysExec.WriteFile(configPath, []byte(config), 0644); err != nil {
 		return fmt.Errorf("failed to write auto-upgrades config: %w", err)
 	}
 	logFileWrite(configPath, "APT automatic updates configuration")
@@ -520,18 +536,18 @@ Unattended-Upgrade::Remove-Unused-Dependencies "true";
 Unattended-Upgrade::Automatic-Reboot "false";
 `
 	unattendedConfigPath := "/etc/apt/apt.conf.d/50unattended-upgrades"
-	if err := os.WriteFile(unattendedConfigPath, []byte(unattendedConfig), 0644); err != nil {
+	if err := sysExec.WriteFile(unattendedConfigPath, []byte(unattendedConfig), 0644); err != nil {
 		return fmt.Errorf("failed to write unattended-upgrades config: %w", err)
 	}
 	logFileWrite(unattendedConfigPath, "Unattended-upgrades security configuration")
 
 	// Enable and start the unattended-upgrades service
-	if err := exec.Command("systemctl", "enable", "unattended-upgrades").Run(); err != nil {
+	if err := runCommandWithContext(context.Background(), "systemctl", "enable", "unattended-upgrades"); err != nil {
 		return fmt.Errorf("failed to enable unattended-upgrades service: %w", err)
 	}
 	logServiceChange("unattended-upgrades", "enabled")
 
-	if err := exec.Command("systemctl", "start", "unattended-upgrades").Run(); err != nil {
+	if err := runCommandWithContext(context.Background(), "systemctl", "start", "unattended-upgrades"); err != nil {
 		return fmt.Errorf("failed to start unattended-upgrades service: %w", err)
 	}
 	logServiceChange("unattended-upgrades", "started")
@@ -557,18 +573,18 @@ email_to = root
 email_host = localhost
 `
 	configPath := "/etc/dnf/automatic.conf"
-	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+	if err := sysExec.WriteFile(configPath, []byte(config), 0644); err != nil {
 		return fmt.Errorf("failed to write dnf-automatic config: %w", err)
 	}
 	logFileWrite(configPath, "DNF automatic security updates configuration")
 
 	// Enable and start dnf-automatic timer
-	if err := exec.Command("systemctl", "enable", "dnf-automatic.timer").Run(); err != nil {
+	if err := runCommandWithContext(context.Background(), "systemctl", "enable", "dnf-automatic.timer"); err != nil {
 		return fmt.Errorf("failed to enable dnf-automatic timer: %w", err)
 	}
 	logServiceChange("dnf-automatic.timer", "enabled")
 
-	if err := exec.Command("systemctl", "start", "dnf-automatic.timer").Run(); err != nil {
+	if err := runCommandWithContext(context.Background(), "systemctl", "start", "dnf-automatic.timer"); err != nil {
 		return fmt.Errorf("failed to start dnf-automatic timer: %w", err)
 	}
 	logServiceChange("dnf-automatic.timer", "started")
@@ -595,18 +611,18 @@ email_to = root
 email_host = localhost
 `
 	configPath := "/etc/yum/yum-cron.conf"
-	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+	if err := sysExec.WriteFile(configPath, []byte(config), 0644); err != nil {
 		return fmt.Errorf("failed to write yum-cron config: %w", err)
 	}
 	logFileWrite(configPath, "YUM cron security updates configuration")
 
 	// Enable and start yum-cron service
-	if err := exec.Command("systemctl", "enable", "yum-cron").Run(); err != nil {
+	if err := runCommandWithContext(context.Background(), "systemctl", "enable", "yum-cron"); err != nil {
 		return fmt.Errorf("failed to enable yum-cron: %w", err)
 	}
 	logServiceChange("yum-cron", "enabled")
 
-	if err := exec.Command("systemctl", "start", "yum-cron").Run(); err != nil {
+	if err := runCommandWithContext(context.Background(), "systemctl", "start", "yum-cron"); err != nil {
 		return fmt.Errorf("failed to start yum-cron: %w", err)
 	}
 	logServiceChange("yum-cron", "started")
@@ -389,3 +389,12 @@ func stringContains(s, substr string) bool {
 	}
 	return false
 }
+
+func TestAptUnattendedUpgradesEnabled(t *testing.T) {
+	if !aptUnattendedUpgradesEnabled("APT::Periodic::Update-Package-Lists \"1\";\nAPT::Periodic::Unattended-Upgrade \"1\";\n") {
+		t.Error("unattended-upgrades should be enabled")
+	}
+	if aptUnattendedUpgradesEnabled("APT::Periodic::Unattended-Upgrade \"0\";\n") {
+		t.Error("unattended-upgrades should be disabled")
+	}
+}
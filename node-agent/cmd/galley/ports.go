@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// Address scopes of a listening socket
+const (
+	socketScopeLoopback = "loopback"
+	socketScopePrivate  = "private"
+	socketScopePublic   = "public"
+)
+
+// Socket states in /proc/net, see include/net/tcp_states.h
+const (
+	procNetStateListen = "0A"
+	procNetStateClose  = "07" // unconnected UDP sockets
+)
+
+var (
+	flagPortsRole   string
+	flagPortsOutput string
+	flagPortsAll    bool
+)
+
+var nodePortsCmd = &cobra.Command{
+	Use:   "ports",
+	Short: "List listening sockets and flag ports this node should not expose",
+	Long: `Lists the TCP and UDP sockets this node listens on, with the process behind
+each socket and whether it is reachable on loopback, private or public addresses.
+
+Sockets on loopback addresses are never reachable from other hosts. All other
+sockets are compared to the ports Galley expects for the node's role, run as root
+to see the processes of all users.`,
+	Example: `  galley node ports
+  galley node ports --role worker -o json`,
+	Args: cobra.ExactArgs(0),
+	RunE: runNodePorts,
+}
+
+func init() {
+	nodePortsCmd.Flags().StringVar(&flagPortsRole, "role", "", "Node role: controller, worker or controller+worker (default: from Galley config)")
+	nodePortsCmd.Flags().StringVarP(&flagPortsOutput, "output", "o", "table", "Output format: table or json")
+	nodePortsCmd.Flags().BoolVar(&flagPortsAll, "all", false, "Also list expected and loopback sockets")
+	nodeCmd.AddCommand(nodePortsCmd)
+}
+
+// listeningSocket is a TCP socket in LISTEN state or an unconnected UDP socket
+type listeningSocket struct {
+	Protocol    string `json:"protocol"`
+	Address     string `json:"address"`
+	Port        int    `json:"port"`
+	Scope       string `json:"scope"`
+	PID         int    `json:"pid,omitempty"`
+	Process     string `json:"process,omitempty"`
+	Expected    bool   `json:"expected"`
+	Description string `json:"description,omitempty"`
+	inode       string
+}
+
+// allowedPort is a port a node of a role is expected to listen on
+type allowedPort struct {
+	Protocol    string
+	Port        int
+	Description string
+}
+
+// expectedPorts returns the ports a node of the role listens on, nodeType can be
+// empty for a node that has not joined a cluster yet
+func expectedPorts(nodeType string, sshPort int) []allowedPort {
+	ports := []allowedPort{
+		{"tcp", sshPort, "SSH"},
+		{"udp", 53, "DNS"},
+		{"tcp", 53, "DNS"},
+		{"udp", 68, "DHCP client"},
+		{"udp", 546, "DHCPv6 client"},
+		{"udp", 123, "NTP"},
+		{"udp", 323, "chrony"},
+	}
+
+	for _, port := range k0sRequiredPorts(nodeType) {
+		ports = append(ports, allowedPort{"tcp", port.Port, port.Description})
+	}
+	if strings.HasPrefix(nodeType, "controller") {
+		ports = append(ports,
+			allowedPort{"tcp", 2379, "etcd clients"},
+			allowedPort{"tcp", 10257, "kube-controller-manager"},
+			allowedPort{"tcp", 10259, "kube-scheduler"},
+		)
+	}
+	if nodeType == "worker" || nodeType == "controller+worker" {
+		ports = append(ports,
+			allowedPort{"tcp", 10249, "kube-proxy metrics"},
+			allowedPort{"tcp", 10256, "kube-proxy health"},
+			allowedPort{"udp", 4789, "VXLAN"},
+		)
+	}
+	return ports
+}
+
+// readListeningSockets reads the listening sockets of the host from /proc/net
+func readListeningSockets(procRoot string) ([]listeningSocket, error) {
+	var sockets []listeningSocket
+	for _, file := range []struct {
+		name     string
+		protocol string
+		state    string
+	}{
+		{"tcp", "tcp", procNetStateListen},
+		{"tcp6", "tcp", procNetStateListen},
+		{"udp", "udp", procNetStateClose},
+		{"udp6", "udp", procNetStateClose},
+	} {
+		content, err := os.ReadFile(filepath.Join(procRoot, "net", file.name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// IPv6 disabled
+				continue
+			}
+			return nil, err
+		}
+		parsed, err := parseProcNet(string(content), file.protocol, file.state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse /proc/net/%s: %w", file.name, err)
+		}
+		sockets = append(sockets, parsed...)
+	}
+	return sockets, nil
+}
+
+// parseProcNet returns the sockets in the given state of a /proc/net/{tcp,udp}[6] table
+func parseProcNet(content, protocol, state string) ([]listeningSocket, error) {
+	var sockets []listeningSocket
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	// Skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+
+		ip, port, err := parseProcNetAddress(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		sockets = append(sockets, listeningSocket{
+			Protocol: protocol,
+			Address:  ip.String(),
+			Port:     port,
+			Scope:    addressScope(ip),
+			inode:    fields[9],
+		})
+	}
+	return sockets, scanner.Err()
+}
+
+// parseProcNetAddress decodes an address like 0100007F:1F90. The address is hex in
+// host byte order per 32-bit word, the port is hex in network byte order.
+func parseProcNetAddress(value string) (net.IP, int, error) {
+	address, portHex, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid address %q", value)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid port in %q", value)
+	}
+	raw, err := hex.DecodeString(address)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address %q", value)
+	}
+
+	// /proc/net is written on little-endian hosts (amd64, arm64), reverse every word
+	ip := make(net.IP, len(raw))
+	for word := 0; word < len(raw); word += 4 {
+		for i := 0; i < 4; i++ {
+			ip[word+i] = raw[word+3-i]
+		}
+	}
+	if v4 := ip.To4(); v4 != nil && len(raw) == net.IPv6len {
+		// IPv4-mapped address of a dual-stack socket
+		ip = v4
+	}
+	return ip, int(port), nil
+}
+
+// addressScope classifies where a socket bound to ip can be reached from. A wildcard
+// address listens on all interfaces, which includes public ones.
+func addressScope(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return socketScopeLoopback
+	case ip.IsPrivate(), ip.IsLinkLocalUnicast():
+		return socketScopePrivate
+	}
+	return socketScopePublic
+}
+
+// socketOwners maps socket inodes to the pid that has the socket open
+func socketOwners(procRoot string) map[string]int {
+	owners := map[string]int{}
+	fdDirs, _ := filepath.Glob(filepath.Join(procRoot, "[0-9]*", "fd"))
+	for _, fdDir := range fdDirs {
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(fdDir)))
+		if err != nil {
+			continue
+		}
+		// Processes of other users can't be read without root
+		entries, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			target, err := os.Readlink(filepath.Join(fdDir, entry.Name()))
+			if err != nil {
+				continue
+			}
+			if inode, ok := strings.CutPrefix(target, "socket:["); ok {
+				inode = strings.TrimSuffix(inode, "]")
+				if _, seen := owners[inode]; !seen {
+					owners[inode] = pid
+				}
+			}
+		}
+	}
+	return owners
+}
+
+// socketInventory returns the listening sockets of the host with their processes,
+// classified against the ports a node of the role is expected to listen on
+func socketInventory(nodeType string) ([]listeningSocket, error) {
+	sockets, err := readListeningSockets("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	owners := socketOwners("/proc")
+	for i := range sockets {
+		if pid, ok := owners[sockets[i].inode]; ok {
+			sockets[i].PID = pid
+			if comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid)); err == nil {
+				sockets[i].Process = strings.TrimSpace(string(comm))
+			}
+		}
+	}
+
+	classifySockets(sockets, expectedPorts(nodeType, sshdPort()))
+	return sockets, nil
+}
+
+// classifySockets marks the sockets that are on loopback or on the allowlist, and sorts
+// them by protocol, port and address
+func classifySockets(sockets []listeningSocket, allowed []allowedPort) {
+	for i := range sockets {
+		socket := &sockets[i]
+		socket.Expected, socket.Description = false, ""
+		if socket.Scope == socketScopeLoopback {
+			socket.Expected = true
+			continue
+		}
+		for _, port := range allowed {
+			if port.Protocol == socket.Protocol && port.Port == socket.Port {
+				socket.Expected = true
+				socket.Description = port.Description
+				break
+			}
+		}
+	}
+
+	sort.Slice(sockets, func(i, j int) bool {
+		a, b := sockets[i], sockets[j]
+		if a.Protocol != b.Protocol {
+			return a.Protocol > b.Protocol
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Address < b.Address
+	})
+}
+
+func unexpectedSockets(sockets []listeningSocket) []listeningSocket {
+	var unexpected []listeningSocket
+	for _, socket := range sockets {
+		if !socket.Expected {
+			unexpected = append(unexpected, socket)
+		}
+	}
+	return unexpected
+}
+
+func portsNodeType() (string, error) {
+	if flagPortsRole != "" {
+		switch flagPortsRole {
+		case "controller", "worker", "controller+worker":
+			return flagPortsRole, nil
+		}
+		return "", fmt.Errorf("invalid role %q, expected controller, worker or controller+worker", flagPortsRole)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load Galley config: %w", err)
+	}
+	return config.NodeType, nil
+}
+
+func runNodePorts(cmd *cobra.Command, args []string) error {
+	if flagPortsOutput != "table" && flagPortsOutput != "json" {
+		return fmt.Errorf("invalid output format %q, expected table or json", flagPortsOutput)
+	}
+
+	nodeType, err := portsNodeType()
+	if err != nil {
+		return err
+	}
+
+	sockets, err := socketInventory(nodeType)
+	if err != nil {
+		return fmt.Errorf("failed to read listening sockets: %w", err)
+	}
+
+	if flagPortsOutput == "json" {
+		if sockets == nil {
+			sockets = []listeningSocket{}
+		}
+		data, err := json.MarshalIndent(sockets, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	unexpected := unexpectedSockets(sockets)
+	shown := unexpected
+	if flagPortsAll {
+		shown = sockets
+	}
+	if len(shown) > 0 {
+		printSocketTable(shown)
+		fmt.Println()
+	}
+
+	role := nodeType
+	if role == "" {
+		role = "not joined"
+	}
+	if len(unexpected) == 0 {
+		fmt.Printf("✓ No unexpected open ports for this node (%s), %d listening sockets\n", role, len(sockets))
+		return nil
+	}
+	fmt.Printf("⚠️  %d unexpected open ports for this node (%s)\n", len(unexpected), role)
+	fmt.Println("💡 Restrict access with the host firewall: galley node firewall apply --dry-run")
+	return nil
+}
+
+func printSocketTable(sockets []listeningSocket) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROTO\tADDRESS\tPORT\tSCOPE\tPROCESS\tSTATUS")
+	for _, socket := range sockets {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", socket.Protocol, socket.Address, socket.Port, socket.Scope, socketProcess(socket), socketStatus(socket))
+	}
+	_ = w.Flush()
+}
+
+func socketProcess(socket listeningSocket) string {
+	if socket.PID == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s (%d)", socket.Process, socket.PID)
+}
+
+func socketStatus(socket listeningSocket) string {
+	switch {
+	case !socket.Expected:
+		return "unexpected"
+	case socket.Description != "":
+		return socket.Description
+	}
+	return "local only"
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseProcNetAddress(t *testing.T) {
+	tests := []struct {
+		value   string
+		address string
+		port    int
+	}{
+		{"0100007F:1F90", "127.0.0.1", 8080},
+		{"00000000:0016", "0.0.0.0", 22},
+		{"0501000A:2A03", "10.0.1.5", 10755},
+		{"00000000000000000000000000000000:1A0B", "::", 6667},
+		{"00000000000000000000000001000000:0050", "::1", 80},
+		{"0000000000000000FFFF00000100007F:0035", "127.0.0.1", 53},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			ip, port, err := parseProcNetAddress(tt.value)
+			if err != nil {
+				t.Fatalf("parseProcNetAddress() error = %v", err)
+			}
+			if ip.String() != tt.address || port != tt.port {
+				t.Errorf("parseProcNetAddress() = %s:%d, want %s:%d", ip, port, tt.address, tt.port)
+			}
+		})
+	}
+
+	if _, _, err := parseProcNetAddress("nothex:0016"); err == nil {
+		t.Error("parseProcNetAddress() should reject invalid addresses")
+	}
+}
+
+func TestReadListeningSockets(t *testing.T) {
+	procRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(procRoot, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"tcp": `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1111 1 0000000000000000 100 0 0 10 0
+   1: 00000000:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2222 1 0000000000000000 100 0 0 10 0
+   2: 0501000A:0016 0701000A:D431 01 00000000:00000000 02:00000000 00000000     0        0 3333 4 0000000000000000 20 4 30 10 -1
+`,
+		"udp": `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  10: 0501000A:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 4444 2 0000000000000000 0
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(procRoot, "net", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sockets, err := readListeningSockets(procRoot)
+	if err != nil {
+		t.Fatalf("readListeningSockets() error = %v", err)
+	}
+	if len(sockets) != 3 {
+		t.Fatalf("readListeningSockets() = %d sockets, want 3 (established connections skipped): %+v", len(sockets), sockets)
+	}
+
+	want := []struct {
+		protocol, address, scope, inode string
+		port                            int
+	}{
+		{"tcp", "127.0.0.1", socketScopeLoopback, "1111", 8080},
+		{"tcp", "0.0.0.0", socketScopePublic, "2222", 22},
+		{"udp", "10.0.1.5", socketScopePrivate, "4444", 68},
+	}
+	for i, w := range want {
+		s := sockets[i]
+		if s.Protocol != w.protocol || s.Address != w.address || s.Scope != w.scope || s.inode != w.inode || s.Port != w.port {
+			t.Errorf("socket %d = %+v, want %+v", i, s, w)
+		}
+	}
+}
+
+func TestClassifySockets(t *testing.T) {
+	sockets := []listeningSocket{
+		{Protocol: "tcp", Address: "0.0.0.0", Port: 10250, Scope: socketScopePublic},
+		{Protocol: "tcp", Address: "0.0.0.0", Port: 8080, Scope: socketScopePublic},
+		{Protocol: "udp", Address: "0.0.0.0", Port: 22, Scope: socketScopePublic},
+		{Protocol: "tcp", Address: "127.0.0.1", Port: 9000, Scope: socketScopeLoopback},
+		{Protocol: "tcp", Address: "10.0.0.5", Port: 22, Scope: socketScopePrivate},
+	}
+
+	classifySockets(sockets, expectedPorts("worker", 22))
+
+	var unexpected []string
+	for _, socket := range unexpectedSockets(sockets) {
+		unexpected = append(unexpected, socket.Protocol+"/"+socket.Address)
+	}
+	if len(unexpected) != 2 || unexpected[0] != "udp/0.0.0.0" || unexpected[1] != "tcp/0.0.0.0" {
+		t.Errorf("unexpected sockets = %v, want udp 22 and tcp 8080", unexpected)
+	}
+
+	classifySockets(sockets, expectedPorts("controller", 22))
+	for _, socket := range sockets {
+		if socket.Port == 10250 && socket.Expected {
+			t.Error("kubelet should not be expected on a controller")
+		}
+	}
+}
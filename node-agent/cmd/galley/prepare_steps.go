@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Stable step IDs, stored in the progress file. Never rename them, add a legacy
+// entry when a step is replaced.
+const (
+	stepOSUpdate        = "os-update"
+	stepSSHConfig       = "ssh-config"
+	stepKernelPrereqs   = "kernel-prereqs"
+	stepDataDisk        = "data-disk"
+	stepK0sInstall      = "k0s-install"
+	stepK0sConfig       = "k0s-config"
+	stepServerHardening = "server-hardening"
+)
+
+// legacyStepIDs maps the step keys of progress files written before step IDs existed
+var legacyStepIDs = map[string]string{
+	"Server OS is now up to date.":                                    stepOSUpdate,
+	"SSH configuration is improved and more secure.":                  stepSSHConfig,
+	"Kernel modules, sysctls and swap are configured for Kubernetes.": stepKernelPrereqs,
+	"Data disk is mounted at /var/lib/k0s.":                           stepDataDisk,
+	"K0s is installed.":                                               stepK0sInstall,
+	"K0s configuration is ready and configured.":                      stepK0sConfig,
+	"Recommended server hardening is applied.":                        stepServerHardening,
+}
+
+// prepareStep is a step of 'galley node prepare'. Check reports whether the system
+// already is in the state Apply creates, so done work is not repeated. Revert undoes
+// Apply before a step is redone.
+type prepareStep struct {
+	ID          string
+	Description string
+	DependsOn   []string
+	// Enabled returns a reason when the step does not apply to this run
+	Enabled func() (bool, string)
+	Check   func() (bool, error)
+	Apply   func(progress *PrepareProgress) error
+	Revert  func() error
+}
+
+// prepareSteps returns the steps of 'galley node prepare' in the order they run
+func prepareSteps() []prepareStep {
+	return []prepareStep{
+		{
+			ID:          stepOSUpdate,
+			Description: "Server OS is up to date",
+			Enabled: func() (bool, string) {
+				return !flagNodePrepareSkipOSUpdate, "--skip-os-update"
+			},
+			Check: func() (bool, error) {
+				enabled, _ := automaticSecurityUpdatesEnabled()
+				return enabled, nil
+			},
+			Apply: promptOSUpdate,
+		},
+		{
+			ID:          stepSSHConfig,
+			Description: "SSH configuration is improved and more secure",
+			DependsOn:   []string{stepOSUpdate},
+			Check:       sshConfigInPlace,
+			Apply: func(progress *PrepareProgress) error {
+				return configureSSHSecurity()
+			},
+		},
+		{
+			ID:          stepKernelPrereqs,
+			Description: "Kernel modules, sysctls and swap are configured for Kubernetes",
+			Check:       kernelPrerequisitesInEffect,
+			Apply: func(progress *PrepareProgress) error {
+				nodeType := ""
+				if config, err := loadConfig(); err == nil {
+					nodeType = config.NodeType
+				}
+				return configureKernelPrerequisites(nodeType)
+			},
+			Revert: removeKernelPrerequisiteFiles,
+		},
+		{
+			ID:          stepDataDisk,
+			Description: "Data disk is mounted at " + k0sDataDir,
+			Enabled: func() (bool, string) {
+				return flagDataDisk != "", "no --data-disk"
+			},
+			Check: func() (bool, error) {
+				return isMountPoint(k0sDataDir)
+			},
+			Apply: func(progress *PrepareProgress) error {
+				return prepareDataDisk(flagDataDisk, flagDataDiskFilesystem, flagDataDiskForce)
+			},
+		},
+		{
+			ID:          stepK0sInstall,
+			Description: "k0s is installed",
+			DependsOn:   []string{stepKernelPrereqs, stepDataDisk},
+			Check: func() (bool, error) {
+				_, err := exec.LookPath("k0s")
+				return err == nil, nil
+			},
+			Apply: func(progress *PrepareProgress) error {
+				return ensureK0sInstalled()
+			},
+		},
+		{
+			ID:          stepK0sConfig,
+			Description: "k0s configuration is created",
+			DependsOn:   []string{stepK0sInstall},
+			Check: func() (bool, error) {
+				_, err := os.Stat(k0sConfigFile)
+				return err == nil, nil
+			},
+			Apply: func(progress *PrepareProgress) error {
+				if err := createK0sConfig(); err != nil {
+					return err
+				}
+				return promptEditK0sConfig()
+			},
+			Revert: func() error {
+				if err := sysExec.Remove(k0sConfigFile); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				logFileWrite(k0sConfigFile, "Removed k0s configuration to create it again")
+				return nil
+			},
+		},
+		{
+			ID:          stepServerHardening,
+			Description: "Recommended server hardening is applied",
+			DependsOn:   []string{stepSSHConfig},
+			Check:       serverHardeningInPlace,
+			Apply: func(progress *PrepareProgress) error {
+				return performServerHardening()
+			},
+		},
+	}
+}
+
+// prepareSelection is the steps selected with --only, --skip and --redo
+type prepareSelection struct {
+	Only []string
+	Skip []string
+	Redo []string
+}
+
+// validate checks that all selected steps exist
+func (s prepareSelection) validate(steps []prepareStep) error {
+	var ids []string
+	for _, step := range steps {
+		ids = append(ids, step.ID)
+	}
+	for flag, selected := range map[string][]string{"only": s.Only, "skip": s.Skip, "redo": s.Redo} {
+		for _, id := range selected {
+			if !slices.Contains(ids, id) {
+				return fmt.Errorf("unknown step %q in --%s, expected one of: %s", id, flag, strings.Join(ids, ", "))
+			}
+		}
+	}
+	for _, id := range s.Skip {
+		if slices.Contains(s.Only, id) || slices.Contains(s.Redo, id) {
+			return fmt.Errorf("step %s can't be skipped and run at the same time", id)
+		}
+	}
+	return nil
+}
+
+// stepAction is what a prepare run does with a step
+type stepAction int
+
+const (
+	stepRun stepAction = iota
+	stepDone
+	stepSkipped
+)
+
+// planStep decides whether a step runs. A step that was done is skipped unless it
+// is redone, steps not in --only are skipped.
+func (s prepareSelection) planStep(step prepareStep, progress *PrepareProgress) (stepAction, string) {
+	if step.Enabled != nil {
+		if enabled, reason := step.Enabled(); !enabled {
+			return stepSkipped, reason
+		}
+	}
+	if slices.Contains(s.Skip, step.ID) {
+		return stepSkipped, "--skip"
+	}
+	redo := slices.Contains(s.Redo, step.ID)
+	if len(s.Only) > 0 && !slices.Contains(s.Only, step.ID) && !redo {
+		return stepSkipped, "not in --only"
+	}
+	if redo {
+		return stepRun, "--redo"
+	}
+	if progress.isComplete(step.ID) {
+		return stepDone, ""
+	}
+	return stepRun, ""
+}
+
+// checkStepDependencies returns an error when a dependency of the step is not done.
+// Dependencies that are already in place, don't apply to this run or were skipped
+// on purpose count as done.
+func checkStepDependencies(step prepareStep, steps []prepareStep, done map[string]bool, selection prepareSelection) error {
+	for _, dependency := range step.DependsOn {
+		if done[dependency] || slices.Contains(selection.Skip, dependency) {
+			continue
+		}
+		satisfied := false
+		for _, other := range steps {
+			if other.ID != dependency {
+				continue
+			}
+			if other.Enabled != nil {
+				if enabled, _ := other.Enabled(); !enabled {
+					satisfied = true
+				}
+			}
+			if other.Check != nil {
+				if inPlace, err := other.Check(); err == nil && inPlace {
+					satisfied = true
+				}
+			}
+		}
+		if !satisfied {
+			return fmt.Errorf("step %s needs %s, run it with --only %s or skip it with --skip %s", step.ID, dependency, dependency, dependency)
+		}
+	}
+	return nil
+}
+
+// runPrepareSteps runs the selected steps in order and records their progress
+func runPrepareSteps(steps []prepareStep, progress *PrepareProgress, selection prepareSelection) error {
+	// Changes after the steps are recorded under the command again
+	previousStep := changeStep
+	defer func() { changeStep = previousStep }()
+
+	// done holds the steps completed before or during this run, including the
+	// steps a dry run would have run
+	done := map[string]bool{}
+	for id, complete := range progress.CompletedSteps {
+		done[id] = complete
+	}
+
+	for _, step := range steps {
+		action, reason := selection.planStep(step, progress)
+		if action == stepSkipped && reason == "--skip" {
+			fmt.Printf("\nSkipping %s (--skip)\n", step.ID)
+		}
+		if action != stepRun {
+			continue
+		}
+		if err := checkStepDependencies(step, steps, done, selection); err != nil {
+			return err
+		}
+
+		redo := reason == "--redo"
+		if !redo && step.Check != nil {
+			satisfied, err := step.Check()
+			if err != nil {
+				return fmt.Errorf("failed to check step %s: %w", step.ID, err)
+			}
+			if satisfied {
+				fmt.Printf("\n✓ %s (already in place)\n", step.Description)
+				done[step.ID] = true
+				if !flagDryRun {
+					if err := progress.markComplete(step.ID); err != nil {
+						return fmt.Errorf("failed to save progress: %w", err)
+					}
+				}
+				continue
+			}
+		}
+
+		// Record the files the step changes under its ID
+		changeStep = step.ID
+
+		// Steps make their changes through sysExec, so a dry run plans them
+		if redo && step.Revert != nil {
+			if err := step.Revert(); err != nil {
+				return fmt.Errorf("failed to revert step %s: %w", step.ID, err)
+			}
+			logAction("Reverted prepare step", map[string]string{"step": step.ID})
+		}
+
+		if err := step.Apply(progress); err != nil {
+			return fmt.Errorf("step %s failed: %w", step.ID, err)
+		}
+		done[step.ID] = true
+		if flagDryRun {
+			continue
+		}
+		if err := progress.markComplete(step.ID); err != nil {
+			return fmt.Errorf("failed to mark step %s complete: %w", step.ID, err)
+		}
+		logAction("Completed prepare step", map[string]string{"step": step.ID})
+	}
+	return nil
+}
+
+// printPrepareSteps prints the steps with their state for --list-steps
+func printPrepareSteps(steps []prepareStep, progress *PrepareProgress, selection prepareSelection) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tSTATUS\tDEPENDS ON\tDESCRIPTION")
+	for _, step := range steps {
+		action, reason := selection.planStep(step, progress)
+		status := "pending"
+		switch {
+		case action == stepDone:
+			status = "done"
+		case action == stepSkipped:
+			status = "skipped (" + reason + ")"
+		case reason == "--redo":
+			status = "redo"
+		case step.Check != nil:
+			if satisfied, err := step.Check(); err == nil && satisfied {
+				status = "in place"
+			}
+		}
+		dependsOn := strings.Join(step.DependsOn, ",")
+		if dependsOn == "" {
+			dependsOn = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", step.ID, status, dependsOn, step.Description)
+	}
+	_ = w.Flush()
+}
+
+// migrateStepIDs renames legacy step keys of a progress file to step IDs and
+// reports whether anything changed
+func migrateStepIDs(completed map[string]bool) bool {
+	migrated := false
+	for key, done := range completed {
+		id, legacy := legacyStepIDs[key]
+		if !legacy {
+			continue
+		}
+		delete(completed, key)
+		if done {
+			completed[id] = true
+		}
+		migrated = true
+	}
+	return migrated
+}
+
+// kernelPrerequisitesInEffect reports whether the Galley kernel configuration is
+// installed and active
+func kernelPrerequisitesInEffect() (bool, error) {
+	nodeType := ""
+	if config, err := loadConfig(); err == nil {
+		nodeType = config.NodeType
+	}
+
+	for path, want := range map[string]string{
+		galleyModulesLoadFile: renderModulesLoadConf(),
+		galleySysctlFile:      renderSysctlConf(),
+	} {
+		content, err := os.ReadFile(path)
+		if err != nil || string(content) != want {
+			return false, nil
+		}
+	}
+	return len(verifyKernelPrerequisites(nodeType)) == 0, nil
+}
+
+// sshConfigInPlace reports whether root can no longer log in over SSH. Without an
+// SSH server the step is not in place, it offers to install one.
+func sshConfigInPlace() (bool, error) {
+	enabled, err := isSSHRootLoginEnabled()
+	if err != nil {
+		return false, nil
+	}
+	return !enabled, nil
+}
+
+// serverHardeningInPlace reports whether the password policy, SSH key-only logins
+// and fail2ban are in place. Locking root and the other hardening prompts are
+// optional and not checked.
+func serverHardeningInPlace() (bool, error) {
+	loginDefs, err := os.ReadFile("/etc/login.defs")
+	if err != nil || len(loginDefsDeviations(string(loginDefs))) > 0 {
+		return false, nil
+	}
+	if enabled, err := isPasswordAuthenticationEnabled(); err == nil && enabled {
+		return false, nil
+	}
+	return exec.Command("systemctl", "is-active", "--quiet", "fail2ban").Run() == nil, nil
+}
+
+// removeKernelPrerequisiteFiles removes the Galley kernel configuration files, swap
+// stays disabled
+func removeKernelPrerequisiteFiles() error {
+	for _, path := range []string{galleyModulesLoadFile, galleySysctlFile} {
+		if err := sysExec.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		logFileWrite(path, "Removed kernel configuration to apply it again")
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPrepareStepRegistry(t *testing.T) {
+	steps := prepareSteps()
+	seen := map[string]bool{}
+	for _, step := range steps {
+		if seen[step.ID] {
+			t.Errorf("step %s is registered twice", step.ID)
+		}
+		if step.Apply == nil || step.Description == "" {
+			t.Errorf("step %s needs an Apply and a description", step.ID)
+		}
+		for _, dependency := range step.DependsOn {
+			if !seen[dependency] {
+				t.Errorf("step %s depends on %s, which does not run before it", step.ID, dependency)
+			}
+		}
+		seen[step.ID] = true
+	}
+
+	// --only can only run a step when its dependencies can be checked
+	for _, step := range steps {
+		for _, dependency := range step.DependsOn {
+			i := slices.IndexFunc(steps, func(other prepareStep) bool { return other.ID == dependency })
+			if steps[i].Check == nil {
+				t.Errorf("step %s is a dependency of %s but has no Check", dependency, step.ID)
+			}
+		}
+	}
+
+	for legacy, id := range legacyStepIDs {
+		if !seen[id] {
+			t.Errorf("legacy step %q maps to unknown step %s", legacy, id)
+		}
+	}
+}
+
+func TestMigrateStepIDs(t *testing.T) {
+	completed := map[string]bool{
+		"Server OS is now up to date.":               true,
+		"K0s configuration is ready and configured.": true,
+		"K0s is installed.":                          false,
+		stepSSHConfig:                                true,
+	}
+
+	if !migrateStepIDs(completed) {
+		t.Fatal("migrateStepIDs() = false, want legacy keys migrated")
+	}
+	want := map[string]bool{stepOSUpdate: true, stepK0sConfig: true, stepSSHConfig: true}
+	if len(completed) != len(want) {
+		t.Errorf("migrated steps = %v, want %v", completed, want)
+	}
+	for id := range want {
+		if !completed[id] {
+			t.Errorf("step %s should be complete after migration", id)
+		}
+	}
+
+	if migrateStepIDs(completed) {
+		t.Error("migrateStepIDs() should not change migrated progress")
+	}
+}
+
+func TestPrepareSelectionValidate(t *testing.T) {
+	steps := []prepareStep{{ID: "a"}, {ID: "b"}}
+
+	tests := []struct {
+		name      string
+		selection prepareSelection
+		wantErr   string
+	}{
+		{name: "empty", selection: prepareSelection{}},
+		{name: "known steps", selection: prepareSelection{Only: []string{"a"}, Skip: []string{"b"}}},
+		{name: "unknown step", selection: prepareSelection{Redo: []string{"c"}}, wantErr: `unknown step "c" in --redo`},
+		{name: "skip and only", selection: prepareSelection{Only: []string{"a"}, Skip: []string{"a"}}, wantErr: "can't be skipped"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.selection.validate(steps)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("validate() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRunPrepareSteps(t *testing.T) {
+	oldDryRun, oldStep := flagDryRun, changeStep
+	flagDryRun, changeStep = true, "node prepare"
+	defer func() { flagDryRun, changeStep = oldDryRun, oldStep }()
+
+	var ran, reverted []string
+	step := func(id string, inPlace bool, dependsOn ...string) prepareStep {
+		return prepareStep{
+			ID:        id,
+			DependsOn: dependsOn,
+			Check:     func() (bool, error) { return inPlace, nil },
+			Apply: func(progress *PrepareProgress) error {
+				ran = append(ran, id)
+				return nil
+			},
+			Revert: func() error {
+				reverted = append(reverted, id)
+				return nil
+			},
+		}
+	}
+	disabled := step("disabled", false)
+	disabled.Enabled = func() (bool, string) { return false, "not configured" }
+	steps := []prepareStep{
+		step("base", false),
+		step("in-place", true),
+		disabled,
+		step("app", false, "base", "in-place", "disabled"),
+	}
+
+	tests := []struct {
+		name      string
+		completed []string
+		selection prepareSelection
+		wantRan   []string
+		// Reverts plan their changes in a dry run too
+		wantReverted []string
+		wantErr      string
+	}{
+		{name: "all steps", wantRan: []string{"base", "app"}},
+		{name: "resume", completed: []string{"base"}, wantRan: []string{"app"}},
+		{name: "skip", selection: prepareSelection{Skip: []string{"base"}}, wantRan: []string{"app"}},
+		{name: "only", completed: []string{"base", "app"}, selection: prepareSelection{Only: []string{"base"}}, wantRan: nil},
+		{name: "redo", completed: []string{"base", "in-place", "app"}, selection: prepareSelection{Redo: []string{"in-place"}}, wantRan: []string{"in-place"}, wantReverted: []string{"in-place"}},
+		{name: "missing dependency", selection: prepareSelection{Only: []string{"app"}}, wantErr: "step app needs base"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran, reverted = nil, nil
+			progress := &PrepareProgress{CompletedSteps: map[string]bool{}}
+			for _, id := range tt.completed {
+				progress.CompletedSteps[id] = true
+			}
+
+			err := runPrepareSteps(steps, progress, tt.selection)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("runPrepareSteps() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runPrepareSteps() error = %v", err)
+			}
+			if !slices.Equal(ran, tt.wantRan) {
+				t.Errorf("ran %v, want %v", ran, tt.wantRan)
+			}
+			if !slices.Equal(reverted, tt.wantReverted) {
+				t.Errorf("reverted %v, want %v", reverted, tt.wantReverted)
+			}
+			if len(progress.CompletedSteps) != len(tt.completed) {
+				t.Errorf("a dry run should not record progress, got %v", progress.CompletedSteps)
+			}
+			if changeStep != "node prepare" {
+				t.Errorf("changeStep = %q after the steps, want the command again", changeStep)
+			}
+		})
+	}
+}
@@ -0,0 +1,619 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// k0s imports every *.toml file in its containerd import directory into the
+// containerd config it generates. The registry hosts directory lives next to it,
+// containerd reads hosts.toml files on every pull so changes need no restart.
+const (
+	k0sContainerdImportDir = "/etc/k0s/containerd.d"
+	galleyRegistriesDropIn = k0sContainerdImportDir + "/galley-registries.toml"
+	galleyRegistryHostsDir = k0sContainerdImportDir + "/certs.d"
+	galleyRegistriesFile   = galleyStateDir + "/registries.json"
+	registryHostsHeader    = "# Managed by Galley"
+)
+
+var (
+	flagRegistryMirrors      []string
+	flagRegistryCA           string
+	flagRegistrySkipVerify   bool
+	flagRegistryUsername     string
+	flagRegistryPasswordFile string
+	flagRegistryOutput       string
+	flagRegistryToken        string
+	flagRegistryFromDir      string
+	flagRegistryWatch        bool
+	flagRegistryInterval     time.Duration
+)
+
+var nodeRegistryCmd = &cobra.Command{
+	Use:   "registry",
+	Short: "Manage container registry mirrors and credentials",
+	Long: `Configures containerd on this node to pull through registry mirrors, trust
+private CAs and authenticate against private registries.
+
+Registries are written as containerd hosts.toml files in ` + galleyRegistryHostsDir + `,
+credentials are only readable by root.`,
+}
+
+var nodeRegistryAddCmd = &cobra.Command{
+	Use:   "add <host>",
+	Short: "Add or replace a registry configuration",
+	Long: `Adds a registry configuration for images from <host>, e.g. docker.io or
+harbor.example.com:5000. Adding a host that already exists replaces it.
+
+This command will:
+  - Route pulls through the given mirrors, in order, falling back to the host itself
+  - Trust the CA certificate from --ca for the host and its mirrors
+  - Authenticate with --username and the password in --password-file
+
+Mirror URLs with a path, like a Harbor proxy cache
+https://harbor.example.com/v2/dockerhub, are used as the full API path.`,
+	Example: `  galley node registry add docker.io --mirror https://harbor.example.com/v2/dockerhub
+  galley node registry add harbor.example.com --ca ./harbor-ca.crt --username robot$ci --password-file ./harbor.pass`,
+	Args: cobra.ExactArgs(1),
+	RunE: runNodeRegistryAdd,
+}
+
+var nodeRegistryRemoveCmd = &cobra.Command{
+	Use:   "remove <host>",
+	Short: "Remove a registry configuration",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		host := args[0]
+
+		registries, err := loadRegistryConfigs()
+		if err != nil {
+			return fmt.Errorf("failed to read registry configuration: %w", err)
+		}
+		remaining := removeRegistryConfig(registries, host)
+		if len(remaining) == len(registries) {
+			return fmt.Errorf("no registry configuration for %s", host)
+		}
+
+		if err := applyRegistryConfigs(remaining); err != nil {
+			return err
+		}
+		logAction("Removed registry configuration", map[string]string{
+			"host": host,
+		})
+		fmt.Printf("✓ Registry configuration for %s removed\n", host)
+		return nil
+	},
+}
+
+var nodeRegistryListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the registry configurations of this node",
+	Args:  cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		if flagRegistryOutput != "table" && flagRegistryOutput != "json" {
+			return fmt.Errorf("invalid output format %q, expected table or json", flagRegistryOutput)
+		}
+
+		registries, err := loadRegistryConfigs()
+		if err != nil {
+			return fmt.Errorf("failed to read registry configuration: %w", err)
+		}
+
+		// Never print passwords or full certificates
+		type registryInfo struct {
+			Host       string   `json:"host"`
+			Mirrors    []string `json:"mirrors"`
+			CA         bool     `json:"ca"`
+			SkipVerify bool     `json:"skipVerify"`
+			Username   string   `json:"username,omitempty"`
+		}
+		infos := []registryInfo{}
+		for _, registry := range registries {
+			infos = append(infos, registryInfo{
+				Host:       registry.Host,
+				Mirrors:    append([]string{}, registry.Mirrors...),
+				CA:         registry.CACert != "",
+				SkipVerify: registry.SkipVerify,
+				Username:   registry.Username,
+			})
+		}
+
+		if flagRegistryOutput == "json" {
+			data, err := json.MarshalIndent(infos, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+
+		if len(infos) == 0 {
+			fmt.Println("No registry configurations found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tMIRRORS\tCA\tUSERNAME")
+		for _, info := range infos {
+			mirrors := strings.Join(info.Mirrors, ",")
+			if mirrors == "" {
+				mirrors = "-"
+			}
+			ca := "system"
+			if info.CA {
+				ca = "custom"
+			}
+			if info.SkipVerify {
+				ca = "insecure"
+			}
+			username := info.Username
+			if username == "" {
+				username = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", info.Host, mirrors, ca, username)
+		}
+		return w.Flush()
+	},
+}
+
+var nodeRegistryPushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Roll the registry configuration of this controller out to every node",
+	Long: `Publishes the registry configurations of this controller to every node of the
+cluster. Nodes joining later get them from their join token.
+
+This command will:
+  - Seal the registry passwords with a key derived from the cluster CA key
+  - Store the sealed configurations in the ` + galleyRegistriesSecret + ` Secret, the
+    registry sync DaemonSet of the Galley agent applies it on every node
+  - Upload the sealed configurations to the Galley platform (with --token), for
+    'galley node registry sync --token'
+
+The configurations in the cluster and on the platform are replaced by the ones on
+this controller. Passwords never leave the controller in plain text.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		config, err := loadConfig()
+		if err != nil {
+			return fmt.Errorf("failed to load Galley config: %w", err)
+		}
+		if !strings.HasPrefix(config.NodeType, "controller") {
+			return fmt.Errorf("registry configurations can only be pushed from a controller")
+		}
+
+		registries, err := loadRegistryConfigs()
+		if err != nil {
+			return fmt.Errorf("failed to read registry configuration: %w", err)
+		}
+		key, err := loadRegistryKey()
+		if err != nil {
+			return err
+		}
+		sealed, err := sealRegistryPasswords(registries, key)
+		if err != nil {
+			return fmt.Errorf("failed to seal registry passwords: %w", err)
+		}
+
+		manifest, err := registriesSecretManifest(sealed)
+		if err != nil {
+			return err
+		}
+		if err := k0sKubectlApply(cobraCmd.Context(), manifest); err != nil {
+			return fmt.Errorf("failed to store registry configuration in the cluster: %w", err)
+		}
+		fmt.Printf("✓ Rolled %d registry configuration(s) out to the nodes of the cluster\n", len(registries))
+
+		platformURL := getPlatformURL()
+		if flagRegistryToken != "" {
+			if err := putGalleyRegistries(platformURL, flagRegistryToken, sealed); err != nil {
+				return fmt.Errorf("failed to push registry configuration: %w", err)
+			}
+			fmt.Printf("✓ Pushed %d registry configuration(s) to the platform\n", len(registries))
+		}
+		logAction("Pushed registry configuration", map[string]string{
+			"platform":   fmt.Sprintf("%t", flagRegistryToken != ""),
+			"registries": fmt.Sprintf("%d", len(registries)),
+		})
+		fmt.Println("💡 Nodes apply the configuration within a minute, see 'kubectl -n galley logs daemonset/galley-registry-sync'")
+		return nil
+	},
+}
+
+var nodeRegistrySyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Apply the registry configuration pushed by a controller",
+	Long: `Applies the registry configurations pushed by a controller of this vessel
+engine to this node. Registries that were not pushed are removed.
+
+The configurations are read from the Galley platform (with --token), or from the
+mounted ` + galleyRegistriesSecret + ` Secret (with --from-dir), which is how the registry sync
+DaemonSet of the Galley agent keeps every node up to date. Registry passwords are
+opened with the registry key this node got when it joined.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		if flagRegistryFromDir != "" {
+			if flagRegistryWatch {
+				return watchRegistrySecret(cobraCmd.Context(), flagRegistryFromDir, flagRegistryInterval)
+			}
+			content, err := os.ReadFile(filepath.Join(flagRegistryFromDir, registriesSecretKey))
+			if err != nil {
+				return fmt.Errorf("failed to read registry configuration: %w", err)
+			}
+			return applyRegistrySecret(content)
+		}
+		if flagRegistryWatch {
+			return fmt.Errorf("--watch requires --from-dir")
+		}
+		if flagRegistryToken == "" {
+			return fmt.Errorf("--token or --from-dir is required to sync")
+		}
+
+		platformURL := getPlatformURL()
+		registries, err := getGalleyRegistries(platformURL, flagRegistryToken)
+		if err != nil {
+			return fmt.Errorf("failed to fetch registry configuration: %w", err)
+		}
+		if err := applySealedRegistryConfigs(registries); err != nil {
+			return err
+		}
+		logAction("Synced registry configuration", map[string]string{
+			"platform":   platformURL,
+			"registries": fmt.Sprintf("%d", len(registries)),
+		})
+		fmt.Printf("✓ Applied %d registry configuration(s) from the platform\n", len(registries))
+		return nil
+	},
+}
+
+func init() {
+	nodeRegistryAddCmd.Flags().StringArrayVar(&flagRegistryMirrors, "mirror", nil, "Mirror URL to pull through, can be repeated")
+	nodeRegistryAddCmd.Flags().StringVar(&flagRegistryCA, "ca", "", "PEM file with the CA certificate of the registry and its mirrors")
+	nodeRegistryAddCmd.Flags().BoolVar(&flagRegistrySkipVerify, "skip-verify", false, "Do not verify the TLS certificate of the registry (insecure)")
+	nodeRegistryAddCmd.Flags().StringVar(&flagRegistryUsername, "username", "", "Username for the registry and its mirrors")
+	nodeRegistryAddCmd.Flags().StringVar(&flagRegistryPasswordFile, "password-file", "", "File containing the password for --username")
+	nodeRegistryListCmd.Flags().StringVarP(&flagRegistryOutput, "output", "o", "table", "Output format: table or json")
+	nodeRegistryPushCmd.Flags().StringVar(&flagRegistryToken, "token", "", "Node token from the Galley web interface")
+	nodeRegistrySyncCmd.Flags().StringVar(&flagRegistryToken, "token", "", "Node token from the Galley web interface")
+	nodeRegistrySyncCmd.Flags().StringVar(&flagRegistryFromDir, "from-dir", "", "Directory the "+galleyRegistriesSecret+" Secret is mounted in")
+	nodeRegistrySyncCmd.Flags().BoolVar(&flagRegistryWatch, "watch", false, "Keep running and apply the Secret in --from-dir every time it changes")
+	nodeRegistrySyncCmd.Flags().DurationVar(&flagRegistryInterval, "interval", time.Minute, "How often --watch checks the Secret")
+
+	nodeRegistryCmd.AddCommand(nodeRegistryAddCmd)
+	nodeRegistryCmd.AddCommand(nodeRegistryRemoveCmd)
+	nodeRegistryCmd.AddCommand(nodeRegistryListCmd)
+	nodeRegistryCmd.AddCommand(nodeRegistryPushCmd)
+	nodeRegistryCmd.AddCommand(nodeRegistrySyncCmd)
+	nodeCmd.AddCommand(nodeRegistryCmd)
+}
+
+func runNodeRegistryAdd(cobraCmd *cobra.Command, args []string) error {
+	registry := VesselEngineRegistryAttributes{
+		Host:       args[0],
+		Mirrors:    flagRegistryMirrors,
+		SkipVerify: flagRegistrySkipVerify,
+		Username:   flagRegistryUsername,
+	}
+
+	if flagRegistryCA != "" {
+		caPEM, err := os.ReadFile(flagRegistryCA)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		registry.CACert = string(caPEM)
+	}
+
+	if (flagRegistryUsername == "") != (flagRegistryPasswordFile == "") {
+		return fmt.Errorf("--username and --password-file must be used together")
+	}
+	if flagRegistryPasswordFile != "" {
+		password, err := os.ReadFile(flagRegistryPasswordFile)
+		if err != nil {
+			return fmt.Errorf("failed to read password file: %w", err)
+		}
+		registry.Password = strings.TrimRight(string(password), "\r\n")
+		if registry.Password == "" {
+			return fmt.Errorf("password file %s is empty", flagRegistryPasswordFile)
+		}
+	}
+
+	if err := validateRegistryConfig(registry); err != nil {
+		return err
+	}
+
+	registries, err := loadRegistryConfigs()
+	if err != nil {
+		return fmt.Errorf("failed to read registry configuration: %w", err)
+	}
+	registries = append(removeRegistryConfig(registries, registry.Host), registry)
+
+	if err := applyRegistryConfigs(registries); err != nil {
+		return err
+	}
+
+	logAction("Added registry configuration", map[string]string{
+		"host":     registry.Host,
+		"mirrors":  strings.Join(registry.Mirrors, ","),
+		"ca":       fmt.Sprintf("%t", registry.CACert != ""),
+		"username": registry.Username,
+	})
+	fmt.Printf("✓ Registry configuration for %s written to %s\n", registry.Host, registryHostsPath(registry.Host))
+	return nil
+}
+
+// validateRegistryConfig checks the host, mirror URLs and CA certificate
+func validateRegistryConfig(registry VesselEngineRegistryAttributes) error {
+	host := registry.Host
+	if host == "" || strings.ContainsAny(host, "/\\ ") || strings.Contains(host, "://") || strings.HasPrefix(host, ".") {
+		return fmt.Errorf("invalid registry host %q, expected a host name like harbor.example.com or harbor.example.com:5000", host)
+	}
+	if h, port, err := net.SplitHostPort(host); err == nil && (h == "" || port == "") {
+		return fmt.Errorf("invalid registry host %q", host)
+	}
+
+	for _, mirror := range registry.Mirrors {
+		u, err := url.Parse(mirror)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid mirror URL %q, expected an http(s) URL", mirror)
+		}
+	}
+
+	if registry.CACert != "" {
+		block, _ := pem.Decode([]byte(registry.CACert))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return fmt.Errorf("CA certificate for %s is not a PEM certificate", host)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("CA certificate for %s is invalid: %w", host, err)
+		}
+	}
+
+	if registry.Password != "" && registry.Username == "" {
+		return fmt.Errorf("registry %s has a password but no username", host)
+	}
+	return nil
+}
+
+// removeRegistryConfig returns the configurations without the given host
+func removeRegistryConfig(registries []VesselEngineRegistryAttributes, host string) []VesselEngineRegistryAttributes {
+	var kept []VesselEngineRegistryAttributes
+	for _, registry := range registries {
+		if registry.Host != host {
+			kept = append(kept, registry)
+		}
+	}
+	return kept
+}
+
+func loadRegistryConfigs() ([]VesselEngineRegistryAttributes, error) {
+	data, err := sysExec.ReadFile(galleyRegistriesFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var registries []VesselEngineRegistryAttributes
+	if err := json.Unmarshal(data, &registries); err != nil {
+		return nil, err
+	}
+	return registries, nil
+}
+
+func saveRegistryConfigs(registries []VesselEngineRegistryAttributes) error {
+	if err := sysExec.MkdirAll(galleyStateDir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(registries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Contains registry passwords
+	return sysExec.WriteFile(galleyRegistriesFile, data, 0600)
+}
+
+// applyRegistryConfigs saves the configurations and writes the containerd files,
+// removing hosts Galley configured before that are no longer in the list
+func applyRegistryConfigs(registries []VesselEngineRegistryAttributes) error {
+	sort.Slice(registries, func(i, j int) bool { return registries[i].Host < registries[j].Host })
+
+	if err := saveRegistryConfigs(registries); err != nil {
+		return fmt.Errorf("failed to save registry configuration: %w", err)
+	}
+
+	dropInCreated := false
+	if _, err := os.Stat(galleyRegistriesDropIn); os.IsNotExist(err) {
+		if err := writeSystemFile(galleyRegistriesDropIn, renderRegistriesDropIn(), "Point containerd at the Galley registry hosts directory"); err != nil {
+			return err
+		}
+		dropInCreated = true
+	}
+
+	wanted := map[string]bool{}
+	for _, registry := range registries {
+		if err := writeRegistryHostsDir(registry); err != nil {
+			return err
+		}
+		wanted[registry.Host] = true
+	}
+
+	entries, err := os.ReadDir(galleyRegistryHostsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", galleyRegistryHostsDir, err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || wanted[entry.Name()] {
+			continue
+		}
+		dir := filepath.Join(galleyRegistryHostsDir, entry.Name())
+		// Leave hosts directories that were not written by Galley alone
+		content, err := sysExec.ReadFile(filepath.Join(dir, "hosts.toml"))
+		if err != nil || !strings.HasPrefix(string(content), registryHostsHeader) {
+			continue
+		}
+		if err := sysExec.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
+		logFileWrite(dir, "Removed registry configuration")
+	}
+
+	if dropInCreated {
+		for _, nodeType := range []string{"worker", "controller"} {
+			if isK0sServiceActive(nodeType) {
+				fmt.Printf("💡 Restart k0s once to load the registry configuration: sudo systemctl restart %s\n", k0sServiceName(nodeType))
+			}
+		}
+	}
+	return nil
+}
+
+// writeRegistryHostsDir writes the hosts.toml and CA certificate of one registry
+func writeRegistryHostsDir(registry VesselEngineRegistryAttributes) error {
+	dir := filepath.Join(galleyRegistryHostsDir, registry.Host)
+	if err := sysExec.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	caFile := filepath.Join(dir, "ca.crt")
+	if registry.CACert != "" {
+		if err := sysExec.WriteFile(caFile, []byte(registry.CACert), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", caFile, err)
+		}
+		logFileWrite(caFile, "CA certificate for registry "+registry.Host)
+	} else if err := sysExec.Remove(caFile); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", caFile, err)
+	}
+
+	hostsToml, err := renderRegistryHostsToml(registry)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "hosts.toml")
+	// Contains the registry credentials
+	if err := sysExec.WriteFile(path, []byte(hostsToml), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if flagDryRun {
+		// The plan hides the file because of the credentials, show it redacted
+		fmt.Print(redactRegistryAuth(hostsToml))
+	}
+	if err := sysExec.Chmod(path, 0600); err != nil {
+		return fmt.Errorf("failed to restrict %s: %w", path, err)
+	}
+	logFileWrite(path, "Registry configuration for "+registry.Host)
+	return nil
+}
+
+// renderRegistriesDropIn returns the containerd config that enables the hosts directory.
+// k0s merges the CRI section into its generated config, containerd 2 migrates the
+// version 2 layout on load.
+func renderRegistriesDropIn() string {
+	return registryHostsHeader + ": registry mirrors and credentials\n" +
+		"version = 2\n\n" +
+		"[plugins.\"io.containerd.grpc.v1.cri\".registry]\n" +
+		"  config_path = " + tomlQuote(galleyRegistryHostsDir) + "\n"
+}
+
+// renderRegistryHostsToml renders the containerd hosts.toml of a registry
+func renderRegistryHostsToml(registry VesselEngineRegistryAttributes) (string, error) {
+	if err := validateRegistryConfig(registry); err != nil {
+		return "", err
+	}
+
+	caFile := ""
+	if registry.CACert != "" {
+		caFile = filepath.Join(galleyRegistryHostsDir, registry.Host, "ca.crt")
+	}
+	authorization := ""
+	// Without a password the registry is pulled from anonymously
+	if registry.Username != "" && registry.Password != "" {
+		authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(registry.Username+":"+registry.Password))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: registry configuration for %s\n", registryHostsHeader, registry.Host)
+	fmt.Fprintf(&b, "server = %s\n", tomlQuote(registryServerURL(registry.Host)))
+	writeRegistryHostSettings(&b, "", caFile, registry.SkipVerify, authorization)
+
+	for _, mirror := range registry.Mirrors {
+		u, _ := url.Parse(mirror)
+		table := "host." + tomlQuote(strings.TrimSuffix(mirror, "/"))
+		fmt.Fprintf(&b, "\n[%s]\n", table)
+		fmt.Fprintln(&b, `  capabilities = ["pull", "resolve"]`)
+		if strings.Trim(u.Path, "/") != "" {
+			// The mirror URL already contains the registry API path, e.g. a Harbor proxy cache
+			fmt.Fprintln(&b, "  override_path = true")
+		}
+		writeRegistryHostSettings(&b, table, caFile, registry.SkipVerify, authorization)
+	}
+	return b.String(), nil
+}
+
+// writeRegistryHostSettings writes the TLS and authentication settings of a host
+// table, or of the server itself when table is empty
+func writeRegistryHostSettings(b *strings.Builder, table, caFile string, skipVerify bool, authorization string) {
+	indent := ""
+	if table != "" {
+		indent = "  "
+	}
+	if caFile != "" {
+		fmt.Fprintf(b, "%sca = %s\n", indent, tomlQuote(caFile))
+	}
+	if skipVerify {
+		fmt.Fprintf(b, "%sskip_verify = true\n", indent)
+	}
+	if authorization != "" {
+		headerTable := "header"
+		if table != "" {
+			headerTable = table + ".header"
+		}
+		fmt.Fprintf(b, "\n%s[%s]\n", indent, headerTable)
+		fmt.Fprintf(b, "%s  authorization = %s\n", indent, tomlQuote(authorization))
+	}
+}
+
+// redactRegistryAuth hides credentials in a rendered hosts.toml
+func redactRegistryAuth(hostsToml string) string {
+	lines := strings.Split(hostsToml, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "authorization = ") {
+			lines[i] = line[:strings.Index(line, "=")+1] + ` "<redacted>"`
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// registryServerURL returns the upstream URL of a registry host
+func registryServerURL(host string) string {
+	if host == "docker.io" {
+		return "https://registry-1.docker.io"
+	}
+	return "https://" + host
+}
+
+// registryHostsPath returns the hosts.toml of a registry, containerd looks the
+// directory up by host name including the port
+func registryHostsPath(host string) string {
+	return filepath.Join(galleyRegistryHostsDir, host, "hosts.toml")
+}
+
+// tomlQuote returns a TOML basic string, JSON string escapes are valid TOML
+func tomlQuote(value string) string {
+	out, _ := json.Marshal(value)
+	return string(out)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Registry passwords are sealed with a key derived from the cluster CA key before
+// they leave a controller, so neither the platform nor the galley-registries
+// Secret holds them in plain text. Controllers derive the key, other nodes get it
+// in their join token. The registry sync DaemonSet of the Galley agent manifest
+// applies the Secret on every node.
+const (
+	galleyRegistryKeyFile  = galleyStateDir + "/registry.key"
+	galleyRegistriesSecret = "galley-registries"
+	registriesSecretKey    = "registries.json"
+	registryKeyLabel       = "galley registry credentials"
+)
+
+// deriveRegistryKey returns the registry key of the cluster with the given CA key
+func deriveRegistryKey(caKeyPEM []byte) []byte {
+	mac := hmac.New(sha256.New, caKeyPEM)
+	mac.Write([]byte(registryKeyLabel))
+	return mac.Sum(nil)
+}
+
+// loadRegistryKey returns the registry key of this node: the one it joined with,
+// or else the one derived from the cluster CA key of a controller, which is kept
+// for the registry sync DaemonSet
+func loadRegistryKey() ([]byte, error) {
+	data, err := sysExec.ReadFile(galleyRegistryKeyFile)
+	if err == nil {
+		return decodeRegistryKey(string(data))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read registry key: %w", err)
+	}
+
+	caKey, err := os.ReadFile(k0sCAKeyFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no registry key on this node, join it with a token from 'galley worker invite' or 'galley controller invite'")
+		}
+		return nil, fmt.Errorf("failed to read cluster CA key: %w", err)
+	}
+	key := deriveRegistryKey(caKey)
+	if err := saveRegistryKey(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func saveRegistryKey(key []byte) error {
+	if err := sysExec.MkdirAll(galleyStateDir, 0755); err != nil {
+		return err
+	}
+	if err := sysExec.WriteFile(galleyRegistryKeyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to save registry key: %w", err)
+	}
+	return nil
+}
+
+func decodeRegistryKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != sha256.Size {
+		return nil, fmt.Errorf("registry key is invalid")
+	}
+	return key, nil
+}
+
+func registryCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// registrySealData binds a sealed password to its registry and username
+func registrySealData(registry VesselEngineRegistryAttributes) []byte {
+	return []byte(registry.Host + "\x00" + registry.Username)
+}
+
+// sealRegistryPasswords returns copies of the configurations with every password
+// replaced by its sealed form
+func sealRegistryPasswords(registries []VesselEngineRegistryAttributes, key []byte) ([]VesselEngineRegistryAttributes, error) {
+	gcm, err := registryCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]VesselEngineRegistryAttributes, 0, len(registries))
+	for _, registry := range registries {
+		registry.SealedPassword = ""
+		if registry.Password != "" {
+			nonce := make([]byte, gcm.NonceSize())
+			if _, err := rand.Read(nonce); err != nil {
+				return nil, err
+			}
+			ciphertext := gcm.Seal(nonce, nonce, []byte(registry.Password), registrySealData(registry))
+			registry.SealedPassword = base64.StdEncoding.EncodeToString(ciphertext)
+			registry.Password = ""
+		}
+		sealed = append(sealed, registry)
+	}
+	return sealed, nil
+}
+
+// unsealRegistryPasswords returns copies of the configurations with the sealed
+// passwords opened. Passwords that were not sealed are ignored.
+func unsealRegistryPasswords(registries []VesselEngineRegistryAttributes, key []byte) ([]VesselEngineRegistryAttributes, error) {
+	var gcm cipher.AEAD
+	unsealed := make([]VesselEngineRegistryAttributes, 0, len(registries))
+	for _, registry := range registries {
+		registry.Password = ""
+		if registry.SealedPassword != "" {
+			if gcm == nil {
+				var err error
+				if gcm, err = registryCipher(key); err != nil {
+					return nil, err
+				}
+			}
+			data, err := base64.StdEncoding.DecodeString(registry.SealedPassword)
+			if err != nil || len(data) < gcm.NonceSize() {
+				return nil, fmt.Errorf("sealed password for %s is malformed", registry.Host)
+			}
+			password, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], registrySealData(registry))
+			if err != nil {
+				return nil, fmt.Errorf("sealed password for %s is not from this cluster", registry.Host)
+			}
+			registry.Password = string(password)
+			registry.SealedPassword = ""
+		}
+		unsealed = append(unsealed, registry)
+	}
+	return unsealed, nil
+}
+
+// applySealedRegistryConfigs opens the passwords of configurations from the
+// platform, a join token or the cluster and applies them to this node
+func applySealedRegistryConfigs(registries []VesselEngineRegistryAttributes) error {
+	for _, registry := range registries {
+		if err := validateRegistryConfig(registry); err != nil {
+			return fmt.Errorf("invalid registry configuration: %w", err)
+		}
+	}
+
+	var key []byte
+	if slices.ContainsFunc(registries, func(registry VesselEngineRegistryAttributes) bool { return registry.SealedPassword != "" }) {
+		var err error
+		if key, err = loadRegistryKey(); err != nil {
+			return err
+		}
+	}
+	unsealed, err := unsealRegistryPasswords(registries, key)
+	if err != nil {
+		return err
+	}
+	return applyRegistryConfigs(unsealed)
+}
+
+// joinTokenRegistries returns the registry key and the sealed registry
+// configurations of this controller, for a join token
+func joinTokenRegistries() (string, []VesselEngineRegistryAttributes, error) {
+	key, err := loadRegistryKey()
+	if err != nil {
+		return "", nil, err
+	}
+	local, err := loadRegistryConfigs()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read registry configuration: %w", err)
+	}
+	sealed, err := sealRegistryPasswords(local, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return base64.StdEncoding.EncodeToString(key), sealed, nil
+}
+
+// applyJoinTokenRegistries keeps the registry key of a join token and applies the
+// registry configurations of the controller that created it. It runs before k0s
+// starts, so containerd loads the registry hosts directory without a restart.
+func applyJoinTokenRegistries(claims *joinTokenClaims) error {
+	if claims.RegistryKey == "" {
+		return nil
+	}
+	key, err := decodeRegistryKey(claims.RegistryKey)
+	if err != nil {
+		return err
+	}
+	if err := saveRegistryKey(key); err != nil {
+		return err
+	}
+
+	if err := applySealedRegistryConfigs(claims.Registries); err != nil {
+		return fmt.Errorf("failed to apply the registry configuration of the join token: %w", err)
+	}
+	if len(claims.Registries) > 0 {
+		logAction("Applied registry configuration from join token", map[string]string{
+			"registries": fmt.Sprintf("%d", len(claims.Registries)),
+		})
+		fmt.Printf("✓ Applied %d registry configuration(s) from the join token\n", len(claims.Registries))
+	}
+	return nil
+}
+
+// registriesSecretManifest returns the galley-registries Secret the registry sync
+// DaemonSet applies on every node
+func registriesSecretManifest(sealed []VesselEngineRegistryAttributes) ([]byte, error) {
+	if sealed == nil {
+		sealed = []VesselEngineRegistryAttributes{}
+	}
+	data, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":      galleyRegistriesSecret,
+			"namespace": "galley",
+			"labels":    map[string]string{"app.kubernetes.io/managed-by": "galley"},
+		},
+		"type":       "Opaque",
+		"stringData": map[string]string{registriesSecretKey: string(data)},
+	})
+}
+
+// watchRegistrySecret applies the galley-registries Secret mounted in dir every
+// time it changes, until ctx is done
+func watchRegistrySecret(ctx context.Context, dir string, interval time.Duration) error {
+	path := filepath.Join(dir, registriesSecretKey)
+	applied := ""
+	for {
+		content, err := os.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+			// The Secret is optional, nothing was pushed yet
+		case err != nil:
+			fmt.Printf("⚠️  Failed to read %s: %v\n", path, err)
+		case string(content) != applied:
+			if err := applyRegistrySecret(content); err != nil {
+				fmt.Printf("⚠️  Failed to apply the registry configuration: %v\n", err)
+			} else {
+				applied = string(content)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// applyRegistrySecret applies the content of the galley-registries Secret
+func applyRegistrySecret(content []byte) error {
+	var registries []VesselEngineRegistryAttributes
+	if err := json.Unmarshal(content, &registries); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", registriesSecretKey, err)
+	}
+	if err := applySealedRegistryConfigs(registries); err != nil {
+		return err
+	}
+	logAction("Synced registry configuration", map[string]string{
+		"source":     galleyRegistriesSecret,
+		"registries": fmt.Sprintf("%d", len(registries)),
+	})
+	fmt.Printf("✓ Applied %d registry configuration(s) from the cluster\n", len(registries))
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDeriveRegistryKey(t *testing.T) {
+	_, keyPEM := testCA(t)
+	_, otherPEM := testCA(t)
+
+	key := deriveRegistryKey(keyPEM)
+	if len(key) != 32 {
+		t.Fatalf("deriveRegistryKey() returned %d bytes, want 32", len(key))
+	}
+	if !bytes.Equal(key, deriveRegistryKey(keyPEM)) {
+		t.Error("every controller of a cluster should derive the same key")
+	}
+	if bytes.Equal(key, deriveRegistryKey(otherPEM)) {
+		t.Error("clusters with another CA should derive another key")
+	}
+}
+
+func TestSealRegistryPasswords(t *testing.T) {
+	_, keyPEM := testCA(t)
+	_, otherPEM := testCA(t)
+	key := deriveRegistryKey(keyPEM)
+	registries := []VesselEngineRegistryAttributes{
+		{Host: "harbor.example.com", Username: "robot", Password: "secret"},
+		{Host: "docker.io", Mirrors: []string{"https://harbor.example.com/v2/dockerhub"}},
+	}
+
+	sealed, err := sealRegistryPasswords(registries, key)
+	if err != nil {
+		t.Fatalf("sealRegistryPasswords() error = %v", err)
+	}
+	data, _ := json.Marshal(sealed)
+	if strings.Contains(string(data), "secret") || sealed[0].SealedPassword == "" {
+		t.Errorf("sealRegistryPasswords() = %s, want the password sealed", data)
+	}
+	if registries[0].Password != "secret" {
+		t.Error("sealRegistryPasswords() should not modify the local configurations")
+	}
+
+	unsealed, err := unsealRegistryPasswords(sealed, key)
+	if err != nil {
+		t.Fatalf("unsealRegistryPasswords() error = %v", err)
+	}
+	if unsealed[0].Password != "secret" || unsealed[0].SealedPassword != "" || unsealed[1].Password != "" {
+		t.Errorf("unsealRegistryPasswords() = %+v", unsealed)
+	}
+
+	if _, err := unsealRegistryPasswords(sealed, deriveRegistryKey(otherPEM)); err == nil {
+		t.Error("unsealRegistryPasswords() should refuse a password of another cluster")
+	}
+	moved := append([]VesselEngineRegistryAttributes{}, sealed...)
+	moved[0].Host = "evil.example.com"
+	if _, err := unsealRegistryPasswords(moved, key); err == nil {
+		t.Error("unsealRegistryPasswords() should refuse a password sealed for another registry")
+	}
+
+	// A plain text password from the platform is never used
+	plain, err := unsealRegistryPasswords([]VesselEngineRegistryAttributes{{Host: "quay.io", Username: "robot", Password: "from-platform"}}, nil)
+	if err != nil || plain[0].Password != "" {
+		t.Errorf("unsealRegistryPasswords() = %+v, %v, want the plain text password dropped", plain, err)
+	}
+}
+
+func TestRegistriesSecretManifest(t *testing.T) {
+	sealed := []VesselEngineRegistryAttributes{{Host: "harbor.example.com", Username: "robot", SealedPassword: "c2VhbGVk"}}
+	manifest, err := registriesSecretManifest(sealed)
+	if err != nil {
+		t.Fatalf("registriesSecretManifest() error = %v", err)
+	}
+
+	var secret struct {
+		Kind     string `json:"kind"`
+		Metadata struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		} `json:"metadata"`
+		StringData map[string]string `json:"stringData"`
+	}
+	if err := json.Unmarshal(manifest, &secret); err != nil {
+		t.Fatal(err)
+	}
+	if secret.Kind != "Secret" || secret.Metadata.Name != galleyRegistriesSecret || secret.Metadata.Namespace != "galley" {
+		t.Errorf("registriesSecretManifest() = %s", manifest)
+	}
+	var registries []VesselEngineRegistryAttributes
+	if err := json.Unmarshal([]byte(secret.StringData[registriesSecretKey]), &registries); err != nil || len(registries) != 1 || registries[0].SealedPassword != "c2VhbGVk" {
+		t.Errorf("%s = %q, want the sealed configurations", registriesSecretKey, secret.StringData[registriesSecretKey])
+	}
+
+	empty, err := registriesSecretManifest(nil)
+	if err != nil || !strings.Contains(string(empty), `[]`) {
+		t.Errorf("registriesSecretManifest(nil) = %s, %v, want an empty list", empty, err)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRegistryConfig(t *testing.T) {
+	caPEM := string(testCACertificate(t))
+
+	tests := []struct {
+		name     string
+		registry VesselEngineRegistryAttributes
+		wantErr  bool
+	}{
+		{"host only", VesselEngineRegistryAttributes{Host: "harbor.example.com"}, false},
+		{"host with port", VesselEngineRegistryAttributes{Host: "harbor.example.com:5000"}, false},
+		{"mirror and ca", VesselEngineRegistryAttributes{Host: "docker.io", Mirrors: []string{"https://harbor.example.com/v2/dockerhub"}, CACert: caPEM}, false},
+		{"credentials", VesselEngineRegistryAttributes{Host: "harbor.example.com", Username: "robot", Password: "secret"}, false},
+		{"empty host", VesselEngineRegistryAttributes{}, true},
+		{"host with scheme", VesselEngineRegistryAttributes{Host: "https://harbor.example.com"}, true},
+		{"host with path", VesselEngineRegistryAttributes{Host: "harbor.example.com/library"}, true},
+		{"mirror without scheme", VesselEngineRegistryAttributes{Host: "docker.io", Mirrors: []string{"harbor.example.com"}}, true},
+		{"invalid ca", VesselEngineRegistryAttributes{Host: "harbor.example.com", CACert: "not a certificate"}, true},
+		{"password without username", VesselEngineRegistryAttributes{Host: "harbor.example.com", Password: "secret"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRegistryConfig(tt.registry)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateRegistryConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderRegistryHostsToml(t *testing.T) {
+	hostsToml, err := renderRegistryHostsToml(VesselEngineRegistryAttributes{
+		Host:     "docker.io",
+		Mirrors:  []string{"https://harbor.example.com/v2/dockerhub", "https://mirror.example.com"},
+		CACert:   string(testCACertificate(t)),
+		Username: "robot",
+		Password: "secret",
+	})
+	if err != nil {
+		t.Fatalf("renderRegistryHostsToml() failed: %v", err)
+	}
+
+	wants := []string{
+		registryHostsHeader,
+		`server = "https://registry-1.docker.io"`,
+		`ca = "` + galleyRegistryHostsDir + `/docker.io/ca.crt"`,
+		`[host."https://harbor.example.com/v2/dockerhub"]`,
+		`[host."https://harbor.example.com/v2/dockerhub".header]`,
+		`[host."https://mirror.example.com"]`,
+		// base64 of robot:secret
+		`authorization = "Basic cm9ib3Q6c2VjcmV0"`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(hostsToml, want) {
+			t.Errorf("hosts.toml should contain %q:\n%s", want, hostsToml)
+		}
+	}
+
+	// Only the mirror with an API path overrides the path
+	if strings.Count(hostsToml, "override_path = true") != 1 {
+		t.Errorf("hosts.toml should set override_path once:\n%s", hostsToml)
+	}
+
+	redacted := redactRegistryAuth(hostsToml)
+	if strings.Contains(redacted, "cm9ib3Q6c2VjcmV0") {
+		t.Errorf("redactRegistryAuth() should hide credentials:\n%s", redacted)
+	}
+}
+
+func TestRemoveRegistryConfig(t *testing.T) {
+	registries := []VesselEngineRegistryAttributes{{Host: "docker.io"}, {Host: "harbor.example.com"}}
+
+	remaining := removeRegistryConfig(registries, "docker.io")
+	if len(remaining) != 1 || remaining[0].Host != "harbor.example.com" {
+		t.Errorf("removeRegistryConfig() = %v", remaining)
+	}
+	if len(removeRegistryConfig(registries, "quay.io")) != 2 {
+		t.Error("removeRegistryConfig() should keep all registries for an unknown host")
+	}
+}
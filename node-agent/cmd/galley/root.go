@@ -24,17 +24,24 @@ var (
 )
 
 var rootCmd = &cobra.Command{
-	Use:               "galley",
-	Short:             "Galley Node Agent",
-	Long:              "The Galley Node Agent allows you to manage Galley nodes and setup your Kubernetes cluster.",
-	Version:           Version,
-	PersistentPreRunE: checkForUpdates,
+	Use:     "galley",
+	Short:   "Galley Node Agent",
+	Long:    "The Galley Node Agent allows you to manage Galley nodes and setup your Kubernetes cluster.",
+	Version: Version,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		useDryRunExecutor()
+		changeStep = strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+		return checkForUpdates(cmd, args)
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		printDryRunSummary()
+	},
 }
 
 func init() {
 	rootCmd.PersistentFlags().StringVar(&flagPlatformURL, "platform-url", "", "Use if you need a different Platform API url (overrides config)")
 	rootCmd.PersistentFlags().StringVar(&flagClientURL, "client-url", "", "Use if you need a different Client API url (overrides config)")
-	rootCmd.PersistentFlags().BoolVar(&flagDryRun, "dry-run", false, "Show all steps we will take during a command, without executing them.")
+	rootCmd.PersistentFlags().BoolVar(&flagDryRun, "dry-run", false, "Show the commands, file changes and service changes of a command in order, without changing the system.")
 	rootCmd.PersistentFlags().BoolVar(&flagSkipUpdateCheck, "skip-update-check", false, "Skip checking for updates before running commands.")
 
 	rootCmd.AddCommand(nodeCmd)
@@ -43,6 +50,10 @@ func init() {
 	rootCmd.AddCommand(updateCmd)
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(logsCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(kubeconfigCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(clusterCmd)
 }
 
 // getPlatformURL returns the platform URL from flag, config, or default
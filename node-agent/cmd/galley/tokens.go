@@ -0,0 +1,519 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// galleyJoinTokensFile records the join tokens created through Galley. Only the
+// public token ID is stored, never the secret, so the file is safe to share with
+// the platform.
+const (
+	galleyJoinTokensFile = galleyStateDir + "/join-tokens.json"
+	joinTokenRecordTTL   = 30 * 24 * time.Hour
+)
+
+var (
+	flagTokensOutput        string
+	flagTokensRole          string
+	flagTokensPlatformToken string
+)
+
+var workerTokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "List, describe and revoke join tokens",
+}
+
+var workerTokensListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List outstanding join tokens",
+	Long: `Lists the join tokens known to k0s on this controller, together with the
+tokens created through Galley.
+
+Use --output json for machine-readable output.`,
+	Args: cobra.ExactArgs(0),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		if flagTokensOutput != "table" && flagTokensOutput != "json" {
+			return fmt.Errorf("invalid output format %q, expected table or json", flagTokensOutput)
+		}
+
+		tokens, err := listJoinTokens(flagTokensRole)
+		if err != nil {
+			return err
+		}
+
+		if flagTokensOutput == "json" {
+			data, err := json.MarshalIndent(tokens, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+
+		if len(tokens) == 0 {
+			fmt.Println("No join tokens found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tROLE\tEXPIRES\tSTATUS\tCREATED BY")
+		for _, token := range tokens {
+			expires := "never"
+			if token.ExpiresAt != nil {
+				expires = token.ExpiresAt.UTC().Format(time.RFC3339)
+			}
+			createdBy := "k0s"
+			if token.Galley {
+				createdBy = "galley"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", token.ID, token.Role, expires, token.Status, createdBy)
+		}
+		return w.Flush()
+	},
+}
+
+var workerTokensDescribeCmd = &cobra.Command{
+	Use:   "describe <id>",
+	Short: "Show the details of a join token",
+	Long: `Shows the role, engine, creation and expiry time and status of a join token
+by its ID (see 'galley worker tokens list').
+
+Use --output json for machine-readable output.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		if flagTokensOutput != "table" && flagTokensOutput != "json" {
+			return fmt.Errorf("invalid output format %q, expected table or json", flagTokensOutput)
+		}
+
+		tokens, err := listJoinTokens("")
+		if err != nil {
+			return err
+		}
+		token := findJoinToken(tokens, args[0])
+		if token == nil {
+			return fmt.Errorf("join token %s not found, see 'galley worker tokens list'", args[0])
+		}
+
+		if flagTokensOutput == "json" {
+			data, err := json.MarshalIndent(token, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+
+		formatTime := func(t *time.Time, empty string) string {
+			if t == nil {
+				return empty
+			}
+			return t.UTC().Format(time.RFC3339)
+		}
+		createdBy := "k0s"
+		if token.Galley {
+			createdBy = "galley"
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "ID:\t%s\n", token.ID)
+		fmt.Fprintf(w, "Role:\t%s\n", token.Role)
+		fmt.Fprintf(w, "Node type:\t%s\n", valueOrDash(token.NodeType))
+		fmt.Fprintf(w, "Vessel engine:\t%s\n", valueOrDash(token.VesselEngineId))
+		fmt.Fprintf(w, "Created:\t%s\n", formatTime(token.CreatedAt, "-"))
+		fmt.Fprintf(w, "Expires:\t%s\n", formatTime(token.ExpiresAt, "never"))
+		fmt.Fprintf(w, "Status:\t%s\n", token.Status)
+		if token.RevokedAt != nil {
+			fmt.Fprintf(w, "Revoked:\t%s\n", formatTime(token.RevokedAt, "-"))
+		}
+		fmt.Fprintf(w, "Created by:\t%s\n", createdBy)
+		return w.Flush()
+	},
+}
+
+var workerTokensRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke a join token so it can no longer be used",
+	Long: `Invalidates a join token by its ID (see 'galley worker tokens list').
+
+Nodes that already joined with the token are not affected. With --token the
+revocation is also sent to the Galley platform.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cobraCmd *cobra.Command, args []string) error {
+		id := args[0]
+
+		if err := runCommandWithContext(cobraCmd.Context(), "k0s", "token", "invalidate", id); err != nil {
+			return fmt.Errorf("failed to revoke join token %s: %w", id, err)
+		}
+
+		records, err := loadJoinTokenRecords()
+		if err != nil {
+			return fmt.Errorf("failed to read join token records: %w", err)
+		}
+		if markJoinTokenRevoked(records, id, time.Now()) {
+			if err := saveJoinTokenRecords(records); err != nil {
+				return fmt.Errorf("failed to save join token records: %w", err)
+			}
+			publishJoinTokenRecords(records, flagTokensPlatformToken)
+		}
+
+		logAction("Revoked join token", map[string]string{
+			"id": id,
+		})
+
+		fmt.Printf("✓ Join token %s revoked\n", id)
+		return nil
+	},
+}
+
+func init() {
+	workerTokensListCmd.Flags().StringVarP(&flagTokensOutput, "output", "o", "table", "Output format: table or json")
+	workerTokensListCmd.Flags().StringVar(&flagTokensRole, "role", "", "Only list tokens for this k0s role (worker or controller)")
+	workerTokensDescribeCmd.Flags().StringVarP(&flagTokensOutput, "output", "o", "table", "Output format: table or json")
+	workerTokensRevokeCmd.Flags().StringVar(&flagTokensPlatformToken, "token", "", "Node token from the Galley web interface, sends the revocation to the platform")
+
+	workerTokensCmd.AddCommand(workerTokensListCmd)
+	workerTokensCmd.AddCommand(workerTokensDescribeCmd)
+	workerTokensCmd.AddCommand(workerTokensRevokeCmd)
+	workerCmd.AddCommand(workerTokensCmd)
+}
+
+// joinTokenRecord describes a join token created through Galley
+type joinTokenRecord struct {
+	ID             string     `json:"id"`
+	Role           string     `json:"role"` // k0s role: worker or controller
+	NodeType       string     `json:"nodeType"`
+	VesselEngineId string     `json:"vesselEngineId"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+}
+
+// joinTokenInfo is a row of 'galley worker tokens list'
+type joinTokenInfo struct {
+	ID             string     `json:"id"`
+	Role           string     `json:"role"`
+	NodeType       string     `json:"nodeType,omitempty"`
+	VesselEngineId string     `json:"vesselEngineId,omitempty"`
+	CreatedAt      *time.Time `json:"createdAt,omitempty"`
+	ExpiresAt      *time.Time `json:"expiresAt,omitempty"`
+	RevokedAt      *time.Time `json:"revokedAt,omitempty"`
+	Status         string     `json:"status"` // active, expired or revoked
+	Galley         bool       `json:"galley"`
+}
+
+// listJoinTokens merges the tokens k0s knows with the Galley records
+func listJoinTokens(role string) ([]joinTokenInfo, error) {
+	k0sArgs := []string{"token", "list"}
+	if role != "" {
+		k0sArgs = append(k0sArgs, "--role", role)
+	}
+	output, err := exec.Command("k0s", k0sArgs...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list k0s tokens: %w", err)
+	}
+
+	records, err := loadJoinTokenRecords()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read join token records: %w", err)
+	}
+
+	return mergeJoinTokens(parseK0sTokenList(string(output)), records, role, time.Now()), nil
+}
+
+func findJoinToken(tokens []joinTokenInfo, id string) *joinTokenInfo {
+	for i := range tokens {
+		if tokens[i].ID == id {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+// recordJoinToken stores the ID and expiry of a token created through Galley and
+// sends the records to the platform when a platform token is given
+func recordJoinToken(claims *joinTokenClaims, platformToken string) error {
+	kubeconfig, err := parseK0sJoinToken(claims.K0sToken)
+	if err != nil {
+		return err
+	}
+	id := kubeconfig.tokenID()
+	if id == "" {
+		return fmt.Errorf("k0s token has no bootstrap token ID")
+	}
+
+	records, err := loadJoinTokenRecords()
+	if err != nil {
+		return err
+	}
+
+	role := "worker"
+	if strings.HasPrefix(claims.Role, "controller") {
+		role = "controller"
+	}
+	record := joinTokenRecord{
+		ID:             id,
+		Role:           role,
+		NodeType:       claims.Role,
+		VesselEngineId: claims.VesselEngineId,
+		CreatedAt:      time.Unix(claims.IssuedAt, 0).UTC(),
+	}
+	if expiresAt := claims.expiresAt(); !expiresAt.IsZero() {
+		expiresAt = expiresAt.UTC()
+		record.ExpiresAt = &expiresAt
+	}
+
+	records = append(pruneJoinTokenRecords(records, time.Now()), record)
+	if err := saveJoinTokenRecords(records); err != nil {
+		return err
+	}
+
+	logAction("Recorded join token", map[string]string{
+		"id":   id,
+		"role": claims.Role,
+	})
+
+	publishJoinTokenRecords(records, platformToken)
+	return nil
+}
+
+// publishJoinTokenRecords sends the join token records to the platform, so its web
+// interface shows outstanding invitations and revoked tokens
+func publishJoinTokenRecords(records []joinTokenRecord, platformToken string) {
+	if platformToken == "" {
+		fmt.Println("💡 Pass --token to show join tokens and revocations in the Galley web interface.")
+		return
+	}
+	if err := putGalleyJoinTokens(getPlatformURL(), platformToken, records); err != nil {
+		fmt.Printf("⚠️  Failed to send join tokens to the Galley platform: %v\n", err)
+		return
+	}
+	logAction("Sent join tokens to the Galley platform", map[string]string{
+		"tokens": fmt.Sprintf("%d", len(records)),
+	})
+}
+
+func loadJoinTokenRecords() ([]joinTokenRecord, error) {
+	data, err := sysExec.ReadFile(galleyJoinTokensFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var records []joinTokenRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func saveJoinTokenRecords(records []joinTokenRecord) error {
+	if err := sysExec.MkdirAll(galleyStateDir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return sysExec.WriteFile(galleyJoinTokensFile, data, 0644)
+}
+
+// pruneJoinTokenRecords drops records that expired or were revoked a long time ago
+func pruneJoinTokenRecords(records []joinTokenRecord, now time.Time) []joinTokenRecord {
+	var kept []joinTokenRecord
+	for _, record := range records {
+		if record.ExpiresAt != nil && now.Sub(*record.ExpiresAt) > joinTokenRecordTTL {
+			continue
+		}
+		if record.RevokedAt != nil && now.Sub(*record.RevokedAt) > joinTokenRecordTTL {
+			continue
+		}
+		kept = append(kept, record)
+	}
+	return kept
+}
+
+// markJoinTokenRevoked marks the record with the given ID as revoked and reports whether it was found
+func markJoinTokenRevoked(records []joinTokenRecord, id string, now time.Time) bool {
+	for i := range records {
+		if records[i].ID == id {
+			revokedAt := now.UTC()
+			records[i].RevokedAt = &revokedAt
+			return true
+		}
+	}
+	return false
+}
+
+// parseK0sTokenList parses the table printed by 'k0s token list'. Both the
+// bordered and the plain table layouts are accepted. Cells are found by the
+// positions of the header columns, so extra columns don't shift the expiry.
+func parseK0sTokenList(output string) []joinTokenInfo {
+	var tokens []joinTokenInfo
+	var columns map[string]int
+	var starts []int
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "+") {
+			continue
+		}
+
+		if columns == nil {
+			header := tableCells(line, nil)
+			if len(header) == 0 || !strings.EqualFold(header[0], "ID") {
+				continue
+			}
+			if !strings.Contains(line, "|") {
+				starts = tableColumnStarts(line)
+			}
+			columns = map[string]int{}
+			for i, name := range header {
+				columns[strings.ToUpper(name)] = i
+			}
+			continue
+		}
+
+		cells := tableCells(line, starts)
+		cell := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(cells) {
+				return cells[i]
+			}
+			return ""
+		}
+		if cell("ID") == "" {
+			continue
+		}
+
+		token := joinTokenInfo{
+			ID:     cell("ID"),
+			Role:   cell("ROLE"),
+			Status: "active",
+		}
+		if expiresAt, ok := parseK0sTokenExpiry(cell("EXPIRES AT")); ok {
+			token.ExpiresAt = &expiresAt
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// tableColumnStarts returns where the columns of a plain table header start.
+// Columns are separated by at least two spaces, so "EXPIRES AT" is one column.
+func tableColumnStarts(header string) []int {
+	var starts []int
+	for i := 0; i < len(header); i++ {
+		if header[i] == ' ' {
+			continue
+		}
+		if i == 0 || (i >= 2 && header[i-1] == ' ' && header[i-2] == ' ') {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+// tableCells splits a table row into trimmed cells, on the "|" borders of a
+// bordered table or at the column starts of a plain one
+func tableCells(line string, starts []int) []string {
+	var cells []string
+	if strings.Contains(line, "|") {
+		for _, cell := range strings.Split(strings.Trim(strings.TrimSpace(line), "|"), "|") {
+			cells = append(cells, strings.TrimSpace(cell))
+		}
+		return cells
+	}
+	if starts == nil {
+		starts = tableColumnStarts(line)
+	}
+	for i, start := range starts {
+		if start >= len(line) {
+			cells = append(cells, "")
+			continue
+		}
+		end := len(line)
+		if i+1 < len(starts) && starts[i+1] < len(line) {
+			end = starts[i+1]
+		}
+		cells = append(cells, strings.TrimSpace(line[start:end]))
+	}
+	return cells
+}
+
+func parseK0sTokenExpiry(value string) (time.Time, bool) {
+	layouts := []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05 -0700 MST",
+		"2006-01-02 15:04:05 -0700 -0700",
+		"2006-01-02 15:04:05",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// mergeJoinTokens combines the live k0s tokens with the Galley records, so tokens
+// that were revoked or expired still show up until their record is pruned
+func mergeJoinTokens(live []joinTokenInfo, records []joinTokenRecord, role string, now time.Time) []joinTokenInfo {
+	byID := make(map[string]*joinTokenRecord, len(records))
+	for i := range records {
+		byID[records[i].ID] = &records[i]
+	}
+
+	seen := make(map[string]bool, len(live))
+	tokens := make([]joinTokenInfo, 0, len(live)+len(records))
+	for _, token := range live {
+		seen[token.ID] = true
+		if record, ok := byID[token.ID]; ok {
+			token.Galley = true
+			token.NodeType = record.NodeType
+			token.VesselEngineId = record.VesselEngineId
+			createdAt := record.CreatedAt
+			token.CreatedAt = &createdAt
+		}
+		if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+			token.Status = "expired"
+		}
+		tokens = append(tokens, token)
+	}
+
+	for _, record := range records {
+		if seen[record.ID] || (role != "" && record.Role != role) {
+			continue
+		}
+		status := "expired"
+		if record.RevokedAt != nil {
+			status = "revoked"
+		}
+		createdAt := record.CreatedAt
+		tokens = append(tokens, joinTokenInfo{
+			ID:             record.ID,
+			Role:           record.Role,
+			NodeType:       record.NodeType,
+			VesselEngineId: record.VesselEngineId,
+			CreatedAt:      &createdAt,
+			ExpiresAt:      record.ExpiresAt,
+			RevokedAt:      record.RevokedAt,
+			Status:         status,
+			Galley:         true,
+		})
+	}
+
+	sort.SliceStable(tokens, func(i, j int) bool {
+		return tokens[i].Status == "active" && tokens[j].Status != "active"
+	})
+	return tokens
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseK0sTokenList(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name: "bordered table",
+			output: `+--------+-----------------+-------------------------------+
+|   ID   |      ROLE       |          EXPIRES AT           |
++--------+-----------------+-------------------------------+
+| a1b2c3 | worker          | 2026-10-19T13:00:00Z          |
+| d4e5f6 | controller      | 2026-10-19 14:00:00 +0000 UTC |
++--------+-----------------+-------------------------------+
+`,
+			want: []string{"a1b2c3", "d4e5f6"},
+		},
+		{
+			name: "plain table",
+			output: `ID      ROLE    EXPIRES AT
+a1b2c3  worker  2026-10-19T13:00:00Z
+`,
+			want: []string{"a1b2c3"},
+		},
+		{
+			name:   "no tokens",
+			output: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := parseK0sTokenList(tt.output)
+			if len(tokens) != len(tt.want) {
+				t.Fatalf("parseK0sTokenList() = %+v, want IDs %v", tokens, tt.want)
+			}
+			for i, token := range tokens {
+				if token.ID != tt.want[i] {
+					t.Errorf("token %d ID = %s, want %s", i, token.ID, tt.want[i])
+				}
+				if token.ExpiresAt == nil {
+					t.Errorf("token %s should have an expiry", token.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeJoinTokens(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	live := []joinTokenInfo{
+		{ID: "live01", Role: "worker", ExpiresAt: &future, Status: "active"},
+		{ID: "k0s001", Role: "controller", ExpiresAt: &future, Status: "active"},
+	}
+	records := []joinTokenRecord{
+		{ID: "live01", Role: "worker", NodeType: "worker", ExpiresAt: &future},
+		{ID: "gone01", Role: "worker", NodeType: "worker", ExpiresAt: &past},
+		{ID: "revk01", Role: "controller", NodeType: "controller+worker", ExpiresAt: &future, RevokedAt: &past},
+	}
+
+	tokens := mergeJoinTokens(live, records, "", now)
+	status := map[string]joinTokenInfo{}
+	for _, token := range tokens {
+		status[token.ID] = token
+	}
+
+	if len(tokens) != 4 {
+		t.Fatalf("mergeJoinTokens() = %+v, want 4 tokens", tokens)
+	}
+	if !status["live01"].Galley || status["live01"].Status != "active" {
+		t.Errorf("live01 = %+v, want active Galley token", status["live01"])
+	}
+	if status["k0s001"].Galley {
+		t.Error("k0s001 was not created through Galley")
+	}
+	if status["gone01"].Status != "expired" || status["revk01"].Status != "revoked" {
+		t.Errorf("gone01 = %s, revk01 = %s", status["gone01"].Status, status["revk01"].Status)
+	}
+	if tokens[len(tokens)-1].Status == "active" {
+		t.Error("active tokens should be listed first")
+	}
+
+	if workerOnly := mergeJoinTokens(nil, records, "worker", now); len(workerOnly) != 2 {
+		t.Errorf("mergeJoinTokens(role=worker) = %+v, want 2 tokens", workerOnly)
+	}
+}
+
+func TestJoinTokenRecords(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	old := now.Add(-joinTokenRecordTTL - time.Hour)
+
+	records := []joinTokenRecord{
+		{ID: "keep01"},
+		{ID: "old001", ExpiresAt: &old},
+	}
+
+	kept := pruneJoinTokenRecords(records, now)
+	if len(kept) != 1 || kept[0].ID != "keep01" {
+		t.Errorf("pruneJoinTokenRecords() = %+v", kept)
+	}
+
+	if !markJoinTokenRevoked(kept, "keep01", now) || kept[0].RevokedAt == nil {
+		t.Error("markJoinTokenRevoked() should mark a known token")
+	}
+	if markJoinTokenRevoked(kept, "unknown", now) {
+		t.Error("markJoinTokenRevoked() should report unknown tokens")
+	}
+}
+
+func TestK0sJoinKubeconfigTokenID(t *testing.T) {
+	kubeconfig, err := parseK0sJoinToken(testK0sToken(t, "https://10.0.0.10:6443", testCACertificate(t)))
+	if err != nil {
+		t.Fatalf("parseK0sJoinToken() error = %v", err)
+	}
+	if id := kubeconfig.tokenID(); id != "abcdef" {
+		t.Errorf("tokenID() = %q, want abcdef", id)
+	}
+}
+
+func TestParseK0sTokenListColumns(t *testing.T) {
+	// An extra column after the expiry must not end up in the expiry
+	output := `ID      ROLE        EXPIRES AT            USAGES
+a1b2c3  worker      2026-10-19T13:00:00Z  authentication,signing
+d4e5f6  controller  2026-10-19T14:00:00Z
+`
+	tokens := parseK0sTokenList(output)
+	if len(tokens) != 2 {
+		t.Fatalf("parseK0sTokenList() = %+v, want 2 tokens", tokens)
+	}
+	want := time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)
+	if tokens[0].Role != "worker" || tokens[0].ExpiresAt == nil || !tokens[0].ExpiresAt.Equal(want) {
+		t.Errorf("token a1b2c3 = %+v, want worker expiring at %v", tokens[0], want)
+	}
+	if tokens[1].Role != "controller" || tokens[1].ExpiresAt == nil {
+		t.Errorf("token d4e5f6 = %+v", tokens[1])
+	}
+
+	bordered := `+--------+--------+----------------------+--------+
+|   ID   |  ROLE  |      EXPIRES AT      | USAGES |
++--------+--------+----------------------+--------+
+| a1b2c3 | worker | 2026-10-19T13:00:00Z | auth   |
++--------+--------+----------------------+--------+
+`
+	if tokens := parseK0sTokenList(bordered); len(tokens) != 1 || tokens[0].ExpiresAt == nil || !tokens[0].ExpiresAt.Equal(want) {
+		t.Errorf("parseK0sTokenList(bordered) = %+v", tokens)
+	}
+}
+
+func TestFindJoinToken(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	created := now.Add(-time.Hour)
+	records := []joinTokenRecord{
+		{ID: "revk01", Role: "worker", NodeType: "worker", VesselEngineId: "engine-1", CreatedAt: created, RevokedAt: &now},
+	}
+
+	token := findJoinToken(mergeJoinTokens(nil, records, "", now), "revk01")
+	if token == nil {
+		t.Fatal("findJoinToken() did not find revk01")
+	}
+	if token.Status != "revoked" || token.VesselEngineId != "engine-1" || token.CreatedAt == nil || !token.CreatedAt.Equal(created) || token.RevokedAt == nil {
+		t.Errorf("findJoinToken() = %+v", token)
+	}
+	if findJoinToken(nil, "missing") != nil {
+		t.Error("findJoinToken() of an unknown ID should be nil")
+	}
+}
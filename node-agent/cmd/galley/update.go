@@ -26,9 +26,7 @@ func init() {
 }
 
 func runUpdate(cmd *cobra.Command, args []string) error {
-	// Check if running as root
-	if flagDryRun {
-		fmt.Println("[DRY RUN] Would update galley binary")
+	if dryRunPlan("Download galley %s and replace this binary", flagUpdateVersion) {
 		return nil
 	}
 
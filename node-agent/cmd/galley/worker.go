@@ -1,11 +1,12 @@
 package main
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -16,7 +17,12 @@ var workerCmd = &cobra.Command{
 }
 
 var (
-	flagInviteExpiry string
+	flagInviteExpiry          string
+	flagReadyTimeout          time.Duration
+	flagSkipConnectivityCheck bool
+	flagJoinNodeToken         string
+	flagJoinCACertHash        string
+	flagInviteNodeToken       string
 )
 
 var workerInviteCmd = &cobra.Command{
@@ -29,7 +35,13 @@ Prerequisites:
   - Node should be running k0s and either configured as a controller or worker
 
 This command will:
-  - Generate worker join token with an expiry of 60 minutes`,
+  - Generate a worker join token with the given expiry (default 1h)
+  - Sign the token with the cluster CA and show the CA hash to join with
+  - Include the platform URL, so the worker reports to the same platform
+  - Include the node name, labels and taints of the platform node of --node-token,
+    the token is then meant for that one node
+  - Record the token ID and expiry, see 'galley worker tokens list', and send
+    the records to the Galley platform (with --token)`,
 	Args: cobra.ExactArgs(0),
 	RunE: func(cobraCmd *cobra.Command, args []string) error {
 		config, err := loadConfig()
@@ -37,12 +49,22 @@ This command will:
 			return fmt.Errorf("failed to load Galley config: %w", err)
 		}
 
-		workerToken, err := generateWorkerToken(flagInviteExpiry)
+		var node *joinTokenNode
+		if flagInviteNodeToken != "" {
+			if node, err = fetchJoinTokenNode(getPlatformURL(), flagInviteNodeToken, config.VesselEngineId); err != nil {
+				return err
+			}
+		}
+
+		workerToken, claims, err := createJoinToken(config.VesselEngineId, "worker", flagInviteExpiry, flagTokensPlatformToken, node)
+		if errors.Is(err, errDryRunToken) {
+			return nil
+		}
 		if err != nil {
 			log.Printf("Warning: Failed to generate worker token: %v", err)
 			log.Println("You can manually create a token later with: k0s token create --role worker")
 		} else {
-			displayWorkerJoinInstructions(config.VesselEngineId, workerToken)
+			displayWorkerJoinInstructions(workerToken, claims)
 		}
 
 		return nil
@@ -51,38 +73,52 @@ This command will:
 
 var workerJoinCmd = &cobra.Command{
 	Use:   "join <token>",
-	Short: "Join this node to your cluster as a controller (requires prepared node)",
-	Long: `Connects this prepared node to your Galley cluster as a controller.
+	Short: "Join this node to your cluster as a worker (requires prepared node)",
+	Long: `Connects this prepared node to your Galley cluster as a worker.
 
 Prerequisites:
   - Node must be prepared first with 'galley node prepare'
-  - Token from Galley web interface
+  - Token and CA hash from 'galley worker invite' on a controller
 
 This command will:
-  - Fetch node configuration from Galley platform
-  - Install k0s as a controller
-  - Start the k0s service
-  - Generate worker join tokens`,
+  - Verify the token was signed by the cluster CA pinned with --ca-cert-hash
+  - Use the platform URL from the token, unless --platform-url is set
+  - Run the 'galley doctor' pre-flight checks
+  - Check that the controller is reachable on ports 6443, 8132 and 9443
+  - Store the join token in a root-only token file
+  - Register with the node name, labels and taints from the token, or from the
+    platform (with --node-token), and always with the Galley vessel engine label
+  - Select the worker profile with kubelet resource reservations for this node size
+  - Apply the container registry configuration of the controller from the token
+  - Install k0s as a worker service (k0sworker)
+  - Start the k0s service and wait until the node is Ready
+  - Mark the node as ready in Galley (with --node-token)`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cobraCmd *cobra.Command, args []string) error {
 		token := args[0]
 
-		vesselEngineId, joinToken, err := decodeWorkerToken(token)
+		claims, err := decodeWorkerToken(token, flagJoinCACertHash)
 		if err != nil {
 			return err
 		}
+		vesselEngineId := claims.VesselEngineId
 
 		logAction("Starting worker join", map[string]string{
 			"vessel_engine_id": vesselEngineId,
-			"join_token":       joinToken,
 		})
 
-		platformURL := getPlatformURL()
-
 		config, err := loadConfig()
 		if err != nil {
 			return fmt.Errorf("failed to load Galley config: %w", err)
 		}
+
+		// The token carries the platform of the controller that created it
+		platformURL := joinTokenPlatformURL(claims)
+		if platformURL != getPlatformURL() {
+			if err := setConfigValue(config, "platform_url", platformURL); err != nil {
+				return fmt.Errorf("failed to save platform_url %w in Galley config", err)
+			}
+		}
 		if err := setConfigValue(config, "vessel_engine_id", vesselEngineId); err != nil {
 			return fmt.Errorf("failed to save vessel_engine_id %w in Galley config", err)
 		}
@@ -96,25 +132,58 @@ This command will:
 			"node_type": "worker",
 		})
 
-		if flagDryRun {
-			fmt.Printf("[dry-run] worker join %s, platform=%s\n", token, platformURL)
-			return nil
-		}
-
 		// Verify k0s is installed (should be from node prepare)
 		if _, err := exec.LookPath("k0s"); err != nil {
 			return fmt.Errorf("k0s is not installed. Please run 'galley node prepare' first")
 		}
 
+		if err := runJoinPreflight(cobraCmd.Context(), "worker"); err != nil {
+			return err
+		}
+
+		if !flagSkipConnectivityCheck {
+			if err := verifyControllerConnectivity(cobraCmd.Context(), claims.K0sToken); err != nil {
+				return err
+			}
+		}
+
+		var platformNode *VesselEngineNodeAttributes
+		var nodeID string
+		if flagJoinNodeToken != "" && claims.Node == nil {
+			if nodeID, platformNode, err = fetchPlatformNode(platformURL, flagJoinNodeToken); err != nil {
+				return err
+			}
+		}
+		nodeArgs, err := joinNodeArgs(config, "worker", joinNodeMetadata(claims, nodeID, platformNode))
+		if err != nil {
+			return err
+		}
+
+		if claims.WorkerProfiles {
+			profileArgs, err := workerProfileArgs()
+			if err != nil {
+				return err
+			}
+			nodeArgs = append(nodeArgs, profileArgs...)
+		}
+
+		if err := saveConfig(config); err != nil {
+			return fmt.Errorf("failed to save Galley config: %w", err)
+		}
+
+		if err := applyJoinTokenRegistries(claims); err != nil {
+			return err
+		}
+
 		log.Printf("Joining cluster as: worker")
 
-		// Install k0s controller
-		if err := installK0sWorker(joinToken); err != nil {
-			return fmt.Errorf("failed to install k0s controller: %w", err)
+		// Install k0s worker
+		if err := installK0sWorker(claims.K0sToken, nodeArgs); err != nil {
+			return fmt.Errorf("failed to install k0s worker: %w", err)
 		}
 
 		// Start k0s service
-		if err := startK0sService(); err != nil {
+		if err := startK0sService("worker", flagReadyTimeout); err != nil {
 			return fmt.Errorf("failed to start k0s service: %w", err)
 		}
 
@@ -123,41 +192,33 @@ This command will:
 		fmt.Println("Node type: worker")
 		fmt.Println(strings.Repeat("=", 60))
 
-		logAction("Controller join completed", map[string]string{
+		logAction("Worker join completed", map[string]string{
 			"node_type": "worker",
 			"status":    "success",
 		})
 
-		//if err := markGalleyNodeReady(platformURL, vesselEngineId, token); err != nil {
-		//	return fmt.Errorf("failed to mark node as ready in Galley: %w", err)
-		//}
-
-		//fmt.Println("\n" + strings.Repeat("=", 60))
-		//fmt.Println("✓ Controller marked as ready in Galley")
-		//fmt.Println("\n" + strings.Repeat("=", 60))
-
-		//logAction("Controller marking ready in Galley completed", map[string]string{
-		//	"status": "success",
-		//})
-
-		// Generate worker token and display join instructions
-		//workerToken, err := generateWorkerToken(flagInviteExpiry)
-		//if err != nil {
-		//	log.Printf("Warning: Failed to generate worker token: %v", err)
-		//	log.Println("You can manually create a token later with: k0s token create --role worker")
-		//} else {
-		//	displayWorkerJoinInstructions(vesselEngineId, workerToken)
-		//}
-
-		// TODO: INSTALL/APPLY GALLEY NODE AGENT ON WORKER
-
-		ctx := context.Background()
+		// startK0sService returned once the node is Ready, only now report it
+		if flagJoinNodeToken != "" {
+			if nodeID == "" {
+				if nodeID, err = extractJWTSubject(flagJoinNodeToken); err != nil {
+					return err
+				}
+			}
+			ipAddress := ""
+			if platformNode != nil {
+				ipAddress = platformNode.IPAddress
+			}
+			if err := markGalleyNodeReady(platformURL, nodeID, flagJoinNodeToken, ipAddress); err != nil {
+				return fmt.Errorf("failed to mark node as ready in Galley: %w", err)
+			}
+			fmt.Println("✓ Worker marked as ready in Galley")
+			logAction("Worker marked ready in Galley", map[string]string{
+				"vessel_engine_node_id": nodeID,
+			})
+		}
 
-		//if err := runCommandWithContext(ctx, "k0s", "kubectl", "-n", "galley", "rollout", "restart", "deploy/galley-agent"); err != nil {
-		//	return fmt.Errorf("failed to enable k0s service: %w", err)
-		//}
-		//kubectl --kubeconfig ~/Documents/kube-config-multipass.yaml -n galley rollout restart deploy/galley-agent
-		//kubectl --kubeconfig ~/Documents/kube-config-multipass.yaml -n galley describe pod -l app=galley-agent | sed -n '/Events/,$p'
+		// The Galley agent is deployed by the controllers through the k0s
+		// manifest deployer, see installGalleyAgentManifest
 
 		fmt.Printf("\n💡 View all actions taken by Galley CLI: galley logs\n")
 		fmt.Printf("   Log file location: %s\n\n", getLogPath())
@@ -170,4 +231,11 @@ func init() {
 	workerCmd.AddCommand(workerInviteCmd)
 	workerCmd.AddCommand(workerJoinCmd)
 	workerInviteCmd.Flags().StringVar(&flagInviteExpiry, "expiry", "1h", "K0s token expiry time, e.g. 10m or 1h")
+	workerInviteCmd.Flags().StringVar(&flagTokensPlatformToken, "token", "", "Node token from the Galley web interface, shows the invitation in the platform")
+	workerInviteCmd.Flags().StringVar(&flagInviteNodeToken, "node-token", "", "Node token from the Galley web interface of the node to invite, adds its name, labels and taints to the token")
+	workerJoinCmd.Flags().StringVar(&flagJoinCACertHash, "ca-cert-hash", "", "Hash of the cluster CA (sha256:<hex>) as shown by 'galley worker invite'")
+	workerJoinCmd.Flags().BoolVar(&flagSkipDoctor, "skip-doctor", false, "Continue when pre-flight checks fail")
+	workerJoinCmd.Flags().BoolVar(&flagSkipConnectivityCheck, "skip-connectivity-check", false, "Join without checking that the controller ports are reachable")
+	workerJoinCmd.Flags().StringVar(&flagJoinNodeToken, "node-token", "", "Node token from the Galley web interface, marks the node ready in Galley and sets the node name, labels and taints when the join token has none")
+	workerJoinCmd.Flags().DurationVar(&flagReadyTimeout, "ready-timeout", defaultK0sReadyTimeout, "How long to wait for the node to become Ready")
 }
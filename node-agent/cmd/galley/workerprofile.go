@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strings"
+
+	"github.com/shirou/gopsutil/v4/mem"
+	"gopkg.in/yaml.v3"
+)
+
+// Worker profiles live in the cluster config of the controllers, so every node size
+// needs a profile before a worker of that size joins. Galley defines one profile per
+// CPU and memory size class and each node picks the class it falls in.
+const workerProfilePrefix = "galley-"
+
+var (
+	workerProfileCPUs      = []int{1, 2, 4, 8, 16, 32, 64}
+	workerProfileMemoryGiB = []int{1, 2, 4, 8, 16, 32, 64, 128, 256}
+)
+
+// Reservations for the OS daemons, on top of the tiered reservation for Kubernetes
+const (
+	systemReservedCPUMillis  = 100
+	systemReservedMemoryMiB  = 100
+	systemReservedEphemeral  = "1Gi"
+	evictionMemoryAvailable  = "100Mi"
+	workerProfileMemorySlack = 1.1 // firmware and the kernel hide part of the memory
+)
+
+// workerProfile is a k0s workerProfiles entry
+type workerProfile struct {
+	Name   string              `yaml:"name"`
+	Values kubeletReservations `yaml:"values"`
+}
+
+// kubeletReservations is the part of the kubelet configuration a worker profile sets
+type kubeletReservations struct {
+	SystemReserved map[string]string `yaml:"systemReserved"`
+	KubeReserved   map[string]string `yaml:"kubeReserved"`
+	EvictionHard   map[string]string `yaml:"evictionHard"`
+}
+
+// kubeReservedCPUMillis reserves 6% of the first core, 1% of the second, 0.5% of
+// the next two and 0.25% of every core above four
+func kubeReservedCPUMillis(cores int) int64 {
+	var millis float64
+	for core := 1; core <= cores; core++ {
+		switch {
+		case core == 1:
+			millis += 60
+		case core == 2:
+			millis += 10
+		case core <= 4:
+			millis += 5
+		default:
+			millis += 2.5
+		}
+	}
+	return int64(math.Ceil(millis))
+}
+
+// kubeReservedMemoryMiB reserves 255 MiB on nodes under 1 GiB, otherwise 25% of the
+// first 4 GiB, 20% of the next 4 GiB, 10% of the next 8 GiB, 6% of the next 112 GiB
+// and 2% of everything above 128 GiB
+func kubeReservedMemoryMiB(totalMiB int64) int64 {
+	if totalMiB < 1024 {
+		return 255
+	}
+
+	tiers := []struct {
+		upToMiB  int64
+		fraction float64
+	}{
+		{4 * 1024, 0.25},
+		{8 * 1024, 0.20},
+		{16 * 1024, 0.10},
+		{128 * 1024, 0.06},
+		{math.MaxInt64, 0.02},
+	}
+
+	var reserved float64
+	previous := int64(0)
+	for _, tier := range tiers {
+		if totalMiB <= previous {
+			break
+		}
+		reserved += float64(min(totalMiB, tier.upToMiB)-previous) * tier.fraction
+		previous = tier.upToMiB
+	}
+	return int64(math.Ceil(reserved))
+}
+
+// kubeletReservationsFor returns the reservations and eviction thresholds of a node size
+func kubeletReservationsFor(cores int, memoryGiB int) kubeletReservations {
+	return kubeletReservations{
+		SystemReserved: map[string]string{
+			"cpu":               fmt.Sprintf("%dm", systemReservedCPUMillis),
+			"memory":            fmt.Sprintf("%dMi", systemReservedMemoryMiB),
+			"ephemeral-storage": systemReservedEphemeral,
+		},
+		KubeReserved: map[string]string{
+			"cpu":    fmt.Sprintf("%dm", kubeReservedCPUMillis(cores)),
+			"memory": fmt.Sprintf("%dMi", kubeReservedMemoryMiB(int64(memoryGiB)*1024)),
+		},
+		EvictionHard: map[string]string{
+			"memory.available":  evictionMemoryAvailable,
+			"nodefs.available":  "10%",
+			"nodefs.inodesFree": "5%",
+			"imagefs.available": "15%",
+		},
+	}
+}
+
+// galleyWorkerProfiles returns a worker profile for every size class
+func galleyWorkerProfiles() []workerProfile {
+	var profiles []workerProfile
+	for _, cores := range workerProfileCPUs {
+		for _, memoryGiB := range workerProfileMemoryGiB {
+			profiles = append(profiles, workerProfile{
+				Name:   workerProfileName(cores, memoryGiB),
+				Values: kubeletReservationsFor(cores, memoryGiB),
+			})
+		}
+	}
+	return profiles
+}
+
+func workerProfileName(cores int, memoryGiB int) string {
+	return fmt.Sprintf("%sc%d-m%dg", workerProfilePrefix, cores, memoryGiB)
+}
+
+// workerProfileForNode returns the profile of the size class a node falls in
+func workerProfileForNode(cores int, memoryBytes uint64) string {
+	memoryGiB := float64(memoryBytes) / (1 << 30) * workerProfileMemorySlack
+	return workerProfileName(sizeClass(float64(cores), workerProfileCPUs), sizeClass(memoryGiB, workerProfileMemoryGiB))
+}
+
+// sizeClass returns the largest class not above value, or the smallest class
+func sizeClass(value float64, classes []int) int {
+	class := classes[0]
+	for _, c := range classes {
+		if float64(c) <= value {
+			class = c
+		}
+	}
+	return class
+}
+
+// workerProfileArgs returns the 'k0s install' flags that select the worker profile of this node
+func workerProfileArgs() ([]string, error) {
+	v, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read total memory: %w", err)
+	}
+
+	profile := workerProfileForNode(runtime.NumCPU(), v.Total)
+	fmt.Printf("✓ Kubelet resource reservations: worker profile %s\n", profile)
+	logAction("Selected worker profile", map[string]string{
+		"profile": profile,
+		"cpu":     fmt.Sprintf("%d", runtime.NumCPU()),
+		"memory":  fmt.Sprintf("%d", v.Total),
+	})
+	return []string{"--profile", profile}, nil
+}
+
+// configureWorkerProfiles writes the Galley worker profiles into the k0s config
+func configureWorkerProfiles(configPath string) error {
+	content, err := sysExec.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read k0s config: %w", err)
+	}
+
+	updated, err := applyWorkerProfilesToK0sConfig(content)
+	if err != nil {
+		return err
+	}
+
+	if err := sysExec.WriteFile(configPath, updated, 0644); err != nil {
+		return fmt.Errorf("failed to write k0s config: %w", err)
+	}
+	logFileWrite(configPath, "Added kubelet resource reservation worker profiles")
+
+	fmt.Printf("✓ Kubelet resource reservation profiles added to %s\n", configPath)
+	return nil
+}
+
+// applyWorkerProfilesToK0sConfig replaces the Galley worker profiles in a k0s config,
+// keeping profiles defined by others
+func applyWorkerProfilesToK0sConfig(content []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse k0s config: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("k0s config is empty")
+	}
+
+	spec := yamlMappingChild(doc.Content[0], "spec")
+
+	profiles := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	if existing := yamlChild(spec, "workerProfiles"); existing != nil && existing.Kind == yaml.SequenceNode {
+		for _, profile := range existing.Content {
+			name := yamlChild(profile, "name")
+			if name != nil && strings.HasPrefix(name.Value, workerProfilePrefix) {
+				continue
+			}
+			profiles.Content = append(profiles.Content, profile)
+		}
+	}
+
+	for _, profile := range galleyWorkerProfiles() {
+		var node yaml.Node
+		if err := node.Encode(profile); err != nil {
+			return nil, fmt.Errorf("failed to encode worker profile %s: %w", profile.Name, err)
+		}
+		profiles.Content = append(profiles.Content, &node)
+	}
+	yamlSet(spec, "workerProfiles", profiles)
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal k0s config: %w", err)
+	}
+	return out, nil
+}
+
+// k0sConfigHasWorkerProfiles reports whether a k0s config defines the Galley worker profiles
+func k0sConfigHasWorkerProfiles(configPath string) bool {
+	content, err := sysExec.ReadFile(configPath)
+	if err != nil {
+		return false
+	}
+
+	var config struct {
+		Spec struct {
+			WorkerProfiles []struct {
+				Name string `yaml:"name"`
+			} `yaml:"workerProfiles"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return false
+	}
+
+	for _, profile := range config.Spec.WorkerProfiles {
+		if strings.HasPrefix(profile.Name, workerProfilePrefix) {
+			return true
+		}
+	}
+	return false
+}
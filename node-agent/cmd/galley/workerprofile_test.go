@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestKubeReservedMemoryMiB(t *testing.T) {
+	tests := []struct {
+		totalMiB int64
+		want     int64
+	}{
+		{512, 255},
+		{1024, 256},
+		{4 * 1024, 1024},
+		{8 * 1024, 1844},
+		{16 * 1024, 2663},
+		{128 * 1024, 9544},
+		{256 * 1024, 12166},
+	}
+
+	for _, tt := range tests {
+		if got := kubeReservedMemoryMiB(tt.totalMiB); got != tt.want {
+			t.Errorf("kubeReservedMemoryMiB(%d) = %d, want %d", tt.totalMiB, got, tt.want)
+		}
+	}
+}
+
+func TestKubeReservedCPUMillis(t *testing.T) {
+	tests := map[int]int64{1: 60, 2: 70, 4: 80, 8: 90, 64: 230}
+	for cores, want := range tests {
+		if got := kubeReservedCPUMillis(cores); got != want {
+			t.Errorf("kubeReservedCPUMillis(%d) = %d, want %d", cores, got, want)
+		}
+	}
+}
+
+func TestWorkerProfileForNode(t *testing.T) {
+	const gib = 1 << 30
+	tests := []struct {
+		cores  int
+		memory uint64
+		want   string
+	}{
+		{4, 156 * gib / 10, "galley-c4-m16g"},
+		{6, 38 * gib / 10, "galley-c4-m4g"},
+		{1, gib / 2, "galley-c1-m1g"},
+		{256, 1024 * gib, "galley-c64-m256g"},
+	}
+
+	for _, tt := range tests {
+		if got := workerProfileForNode(tt.cores, tt.memory); got != tt.want {
+			t.Errorf("workerProfileForNode(%d, %d) = %s, want %s", tt.cores, tt.memory, got, tt.want)
+		}
+	}
+}
+
+func TestApplyWorkerProfilesToK0sConfig(t *testing.T) {
+	config := `apiVersion: k0s.k0sproject.io/v1beta1
+kind: ClusterConfig
+spec:
+  workerProfiles:
+    - name: custom
+      values:
+        maxPods: 50
+    - name: galley-c1-m1g
+      values:
+        stale: true
+`
+
+	updated, err := applyWorkerProfilesToK0sConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("applyWorkerProfilesToK0sConfig() failed: %v", err)
+	}
+	// Applying twice gives the same config
+	again, err := applyWorkerProfilesToK0sConfig(updated)
+	if err != nil || string(again) != string(updated) {
+		t.Errorf("applyWorkerProfilesToK0sConfig() should be idempotent")
+	}
+
+	var parsed struct {
+		Spec struct {
+			WorkerProfiles []workerProfile `yaml:"workerProfiles"`
+		} `yaml:"spec"`
+	}
+	if err := yaml.Unmarshal(updated, &parsed); err != nil {
+		t.Fatalf("failed to parse updated config: %v", err)
+	}
+
+	profiles := parsed.Spec.WorkerProfiles
+	if want := 1 + len(workerProfileCPUs)*len(workerProfileMemoryGiB); len(profiles) != want {
+		t.Fatalf("got %d worker profiles, want %d", len(profiles), want)
+	}
+	if profiles[0].Name != "custom" {
+		t.Errorf("custom worker profile should be kept, got %s first", profiles[0].Name)
+	}
+	if strings.Contains(string(updated), "stale") {
+		t.Error("existing Galley worker profiles should be replaced")
+	}
+	for _, profile := range profiles {
+		if profile.Name == "galley-c4-m16g" && profile.Values.KubeReserved["memory"] != "2663Mi" {
+			t.Errorf("galley-c4-m16g kubeReserved = %v", profile.Values.KubeReserved)
+		}
+	}
+}